@@ -0,0 +1,139 @@
+// Package apikey issues scoped API keys for integrations that cannot use
+// bearer tokens. Only a hash of each key is stored.
+package apikey
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidKey = errors.New("invalid, expired or revoked API key")
+
+// A key reads "bfk_<prefix>_<secret>". The prefix identifies the key for
+// lookup and in listings; the secret is only ever shown when issued.
+const (
+	keyTag      = "bfk"
+	prefixBytes = 6
+	secretBytes = 32
+)
+
+// MaxNameLength is the longest key name accepted.
+const MaxNameLength = 255
+
+// DefaultRotationOverlap is how long a rotated key keeps working alongside
+// its replacement when the rotation does not say.
+const DefaultRotationOverlap = 24 * time.Hour
+
+// APIKey is an issued key. Key holds the plaintext only in the response that
+// issued it.
+type APIKey struct {
+	ID           int64          `json:"id" db:"id"`
+	Name         string         `json:"name" db:"name"`
+	Prefix       string         `json:"prefix" db:"prefix"`
+	Hash         string         `json:"-" db:"key_hash"`
+	Scopes       pq.StringArray `json:"scopes" db:"scopes" swaggertype:"array,string"`
+	CreatedBy    string         `json:"created_by" db:"created_by"`
+	RotatedFrom  *int64         `json:"rotated_from,omitempty" db:"rotated_from"`
+	CreatedAt    time.Time      `json:"created_at" db:"created_at"`
+	ExpiresAt    *time.Time     `json:"expires_at,omitempty" db:"expires_at"`
+	RevokedAt    *time.Time     `json:"revoked_at,omitempty" db:"revoked_at"`
+	LastUsedAt   *time.Time     `json:"last_used_at,omitempty" db:"last_used_at"`
+	RequestCount int64          `json:"request_count" db:"request_count"`
+	Key          string         `json:"key,omitempty" db:"-"`
+	TenantID     int64          `json:"-" db:"tenant_id"`
+}
+
+// Active reports whether the key authenticates requests at now.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// Subject is the principal subject of requests made with the key.
+func (k *APIKey) Subject() string {
+	return fmt.Sprintf("api-key:%d", k.ID)
+}
+
+// Generate returns a new plaintext key with its prefix and hash.
+func Generate() (key, prefix, hash string, err error) {
+	raw := make([]byte, prefixBytes+secretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(raw[:prefixBytes])
+	key = keyTag + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(raw[prefixBytes:])
+	return key, prefix, Hash(key), nil
+}
+
+// Hash returns the stored form of key. Keys carry 256 random bits, so a
+// fast hash is enough to make the stored form useless to a reader.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParsePrefix returns the prefix of key, or false when key is not shaped
+// like an issued key.
+func ParsePrefix(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != keyTag || len(parts[1]) != 2*prefixBytes || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// IssueRequest is the body of a request for a new key.
+type IssueRequest struct {
+	Name      string     `json:"name" example:"partner-sync"`
+	Scopes    []string   `json:"scopes" example:"books:read"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (r *IssueRequest) Validate(now time.Time) error {
+	r.Name = strings.TrimSpace(r.Name)
+
+	var v helper.Validator
+	v.Check(r.Name != "", "name", helper.CodeRequired, "name is required")
+	v.Check(utf8.RuneCountInString(r.Name) <= MaxNameLength, "name", helper.CodeTooLong,
+		fmt.Sprintf("name must be at most %d characters", MaxNameLength))
+	if len(r.Scopes) == 0 {
+		v.Add("scopes", helper.CodeRequired, "at least one scope is required")
+	}
+	for _, scope := range r.Scopes {
+		v.Check(rbac.ValidScope(scope), "scopes", helper.CodeInvalid,
+			fmt.Sprintf("unknown scope %q, must be one of %s", scope, strings.Join(rbac.Scopes, ", ")))
+	}
+	if r.ExpiresAt != nil {
+		v.Check(r.ExpiresAt.After(now), "expires_at", helper.CodeOutOfRange, "expires_at must be in the future")
+	}
+	return v.Err()
+}
+
+// RotateRequest is the body of a request to rotate a key. Overlap is a Go
+// duration such as "1h" for which the old key keeps working; it defaults to
+// DefaultRotationOverlap and may be "0s" to retire the old key at once.
+type RotateRequest struct {
+	Overlap string `json:"overlap,omitempty" example:"24h"`
+}
+
+// OverlapDuration validates and returns r.Overlap.
+func (r RotateRequest) OverlapDuration() (time.Duration, error) {
+	if r.Overlap == "" {
+		return DefaultRotationOverlap, nil
+	}
+	d, err := time.ParseDuration(r.Overlap)
+	if err != nil || d < 0 {
+		return 0, helper.NewErrValidation("overlap", helper.CodeInvalid, "overlap must be a non-negative duration such as 24h")
+	}
+	return d, nil
+}
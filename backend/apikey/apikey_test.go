@@ -0,0 +1,79 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, "bfk_"+prefix+"_") {
+		t.Fatalf("key %q does not carry its prefix %q", key, prefix)
+	}
+	if got, ok := ParsePrefix(key); !ok || got != prefix {
+		t.Fatalf("ParsePrefix(%q) = %q, %v", key, got, ok)
+	}
+	if hash != Hash(key) || len(hash) != 64 || strings.Contains(hash, key) {
+		t.Fatalf("unexpected hash %q", hash)
+	}
+
+	other, _, _, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Fatal("expected distinct keys")
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	for _, key := range []string{"", "bfk", "bfk_0123456789ab", "bfk_0123456789ab_", "xyz_0123456789ab_secret", "bfk_0123_secret"} {
+		if _, ok := ParsePrefix(key); ok {
+			t.Errorf("ParsePrefix(%q) accepted a malformed key", key)
+		}
+	}
+	if prefix, ok := ParsePrefix("bfk_0123456789ab_se_cr-et"); !ok || prefix != "0123456789ab" {
+		t.Errorf("expected secrets containing underscores to parse, got %q %v", prefix, ok)
+	}
+}
+
+func TestIssueRequestValidate(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	cases := []struct {
+		name string
+		req  IssueRequest
+		ok   bool
+	}{
+		{"valid", IssueRequest{Name: " partner ", Scopes: []string{"books:read", "urls:process"}}, true},
+		{"missing name", IssueRequest{Scopes: []string{"books:read"}}, false},
+		{"no scopes", IssueRequest{Name: "partner"}, false},
+		{"unknown scope", IssueRequest{Name: "partner", Scopes: []string{"books:admin"}}, false},
+		{"expired", IssueRequest{Name: "partner", Scopes: []string{"books:read"}, ExpiresAt: &past}, false},
+	}
+	for _, tc := range cases {
+		err := tc.req.Validate(now)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+}
+
+func TestOverlapDuration(t *testing.T) {
+	if d, err := (RotateRequest{}).OverlapDuration(); err != nil || d != DefaultRotationOverlap {
+		t.Fatalf("expected the default overlap, got %v %v", d, err)
+	}
+	if d, err := (RotateRequest{Overlap: "0s"}).OverlapDuration(); err != nil || d != 0 {
+		t.Fatalf("expected no overlap, got %v %v", d, err)
+	}
+	for _, overlap := range []string{"-1h", "soon"} {
+		if _, err := (RotateRequest{Overlap: overlap}).OverlapDuration(); err == nil {
+			t.Errorf("expected %q to be rejected", overlap)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"byfood-interview/apikey"
+	"byfood-interview/helper"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type APIKeyService interface {
+	Issue(ctx context.Context, req *apikey.IssueRequest) (*apikey.APIKey, error)
+	GetAll(ctx context.Context) ([]apikey.APIKey, error)
+	Revoke(ctx context.Context, id int64) (*apikey.APIKey, error)
+	Rotate(ctx context.Context, id int64, req apikey.RotateRequest) (*apikey.APIKey, error)
+}
+
+type Handler struct {
+	Service APIKeyService
+}
+
+// IssueAPIKey godoc
+// @Summary Issue an API key
+// @Description Issue a key with scopes (books:read, books:write, urls:process) and an optional expiry. The key is only returned by this call. Admins only.
+// @Tags api-keys
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param request body apikey.IssueRequest true "Key name, scopes and expiry"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=apikey.APIKey}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/api-keys [post]
+// IssueAPIKey handles issuing an API key
+func (h *Handler) IssueAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request apikey.IssueRequest
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.Issue(r.Context(), &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		// The response carries the secret; keep it out of every cache,
+		// the idempotency store included.
+		w.Header().Set("Cache-Control", "no-store")
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// GetAPIKeys godoc
+// @Summary List API keys
+// @Description List issued keys with their scopes, expiry, revocation, last use and request count. Usage is written periodically, so it may lag. Admins only.
+// @Tags api-keys
+// @Produce json,xml,application/msgpack,text/csv
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=[]apikey.APIKey}
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/api-keys [get]
+// GetAPIKeys handles listing API keys
+func (h *Handler) GetAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := h.Service.GetAll(r.Context())
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Stop a key from authenticating at once. Admins only.
+// @Tags api-keys
+// @Produce json,xml,application/msgpack
+// @Param id path int true "API key ID"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=apikey.APIKey}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/api-keys/{id} [delete]
+// RevokeAPIKey handles revoking an API key
+func (h *Handler) RevokeAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid API key ID"), nil)
+			return
+		}
+
+		data, err := h.Service.Revoke(r.Context(), id)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate an API key
+// @Description Issue a replacement key with the same name, scopes and expiry. The old key keeps working for the overlap (default 24h), then expires. The new key is only returned by this call. Admins only.
+// @Tags api-keys
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param id path int true "API key ID"
+// @Param request body apikey.RotateRequest false "Overlap during which both keys work"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=apikey.APIKey}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 409 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/api-keys/{id}/rotate [post]
+// RotateAPIKey handles rotating an API key
+func (h *Handler) RotateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid API key ID"), nil)
+			return
+		}
+
+		// The body is optional.
+		var request apikey.RotateRequest
+		if r.ContentLength != 0 {
+			if err := helper.DecodeJSON(r.Body, &request); err != nil {
+				helper.WriteResponse(w, r, err, nil)
+				return
+			}
+		}
+
+		data, err := h.Service.Rotate(r.Context(), id, request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"byfood-interview/apikey"
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/rbac"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, data *apikey.APIKey) (*apikey.APIKey, error)
+	GetByIDForUpdate(ctx context.Context, id int64) (*apikey.APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*apikey.APIKey, error)
+	GetAll(ctx context.Context) ([]apikey.APIKey, error)
+	Revoke(ctx context.Context, id int64) (*apikey.APIKey, error)
+	ExpireBy(ctx context.Context, id int64, at time.Time) error
+	AddUsage(ctx context.Context, id, count int64, lastUsed time.Time) error
+}
+
+// usage is the requests made with a key since the last flush.
+type usage struct {
+	count    int64
+	lastUsed time.Time
+}
+
+// APIKey issues, rotates and revokes API keys and authenticates requests
+// made with them. Usage is counted in memory and written by FlushUsage.
+type APIKey struct {
+	APIKeyRepository APIKeyRepository
+	Transactor       internalDb.TxRunner
+	// Authorizer guards the key management operations.
+	Authorizer rbac.Authorizer
+
+	mu    sync.Mutex
+	usage map[int64]*usage
+	now   func() time.Time
+}
+
+func (s *APIKey) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *APIKey) authorize(ctx context.Context) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	return s.Authorizer.Authorize(ctx, rbac.ActionManageKeys)
+}
+
+// Issue creates a key. The returned key carries the plaintext, which is not
+// stored and cannot be shown again.
+func (s *APIKey) Issue(ctx context.Context, req *apikey.IssueRequest) (*apikey.APIKey, error) {
+	log := log.Ctx(ctx).With().Str("service", "api_key").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := req.Validate(s.clock()); err != nil {
+		return nil, err
+	}
+
+	created, err := s.create(ctx, &apikey.APIKey{Name: req.Name, Scopes: req.Scopes, ExpiresAt: req.ExpiresAt})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to issue API key")
+		return nil, err
+	}
+	log.Info().Int64("api_key_id", created.ID).Msg("API key issued")
+	return created, nil
+}
+
+// create stores a new key with the fields of data and a fresh secret.
+func (s *APIKey) create(ctx context.Context, data *apikey.APIKey) (*apikey.APIKey, error) {
+	key, prefix, hash, err := apikey.Generate()
+	if err != nil {
+		return nil, err
+	}
+	data.Prefix, data.Hash = prefix, hash
+	if principal := auth.PrincipalFrom(ctx); principal != nil {
+		data.CreatedBy = principal.Subject
+	}
+
+	created, err := s.APIKeyRepository.Create(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	created.Key = key
+	return created, nil
+}
+
+func (s *APIKey) GetAll(ctx context.Context) ([]apikey.APIKey, error) {
+	log := log.Ctx(ctx).With().Str("service", "api_key").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.APIKeyRepository.GetAll(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get API keys")
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke stops the key from authenticating at once.
+func (s *APIKey) Revoke(ctx context.Context, id int64) (*apikey.APIKey, error) {
+	log := log.Ctx(ctx).With().Str("service", "api_key").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.APIKeyRepository.Revoke(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrNotFound("API key not found or already revoked")
+		}
+		log.Error().Err(err).Msg("failed to revoke API key")
+		return nil, err
+	}
+	log.Info().Int64("api_key_id", id).Msg("API key revoked")
+	return revoked, nil
+}
+
+// Rotate issues a replacement for the key with the same name, scopes and
+// expiry. The old key keeps working for overlap, so clients can switch over
+// without downtime, then expires.
+func (s *APIKey) Rotate(ctx context.Context, id int64, req apikey.RotateRequest) (*apikey.APIKey, error) {
+	log := log.Ctx(ctx).With().Str("service", "api_key").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	overlap, err := req.OverlapDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	var replacement *apikey.APIKey
+	err = internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		old, err := s.APIKeyRepository.GetByIDForUpdate(ctx, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return helper.NewErrNotFound("API key not found")
+			}
+			return err
+		}
+		now := s.clock()
+		if !old.Active(now) {
+			return helper.NewErrConflict("revoked or expired API keys cannot be rotated")
+		}
+
+		replacement, err = s.create(ctx, &apikey.APIKey{
+			Name:        old.Name,
+			Scopes:      old.Scopes,
+			ExpiresAt:   old.ExpiresAt,
+			RotatedFrom: &old.ID,
+		})
+		if err != nil {
+			return err
+		}
+		return s.APIKeyRepository.ExpireBy(ctx, old.ID, now.Add(overlap))
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to rotate API key")
+		return nil, err
+	}
+	log.Info().Int64("api_key_id", id).Int64("replacement_id", replacement.ID).Msg("API key rotated")
+	return replacement, nil
+}
+
+// Authenticate returns the principal of key, limited to its scopes and bound
+// to its tenant, or apikey.ErrInvalidKey when the key is unknown, expired or revoked.
+func (s *APIKey) Authenticate(ctx context.Context, key string) (*auth.Principal, error) {
+	prefix, ok := apikey.ParsePrefix(key)
+	if !ok {
+		return nil, apikey.ErrInvalidKey
+	}
+
+	data, err := s.APIKeyRepository.GetByPrefix(ctx, prefix)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, apikey.ErrInvalidKey
+		}
+		log.Ctx(ctx).Error().Err(err).Str("service", "api_key").Msg("failed to look up API key")
+		return nil, err
+	}
+	now := s.clock()
+	if subtle.ConstantTimeCompare([]byte(apikey.Hash(key)), []byte(data.Hash)) != 1 || !data.Active(now) {
+		return nil, apikey.ErrInvalidKey
+	}
+
+	s.recordUsage(data.ID, now)
+
+	scopes := append([]string{}, data.Scopes...)
+	return &auth.Principal{
+		Subject:  data.Subject(),
+		Claims:   map[string]interface{}{"api_key_id": data.ID, "name": data.Name},
+		Scopes:   scopes,
+		TenantID: data.TenantID,
+	}, nil
+}
+
+func (s *APIKey) recordUsage(id int64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addUsage(id, 1, at)
+}
+
+// addUsage counts requests made with a key. s.mu must be held.
+func (s *APIKey) addUsage(id, count int64, lastUsed time.Time) {
+	if s.usage == nil {
+		s.usage = make(map[int64]*usage)
+	}
+	u, ok := s.usage[id]
+	if !ok {
+		u = &usage{}
+		s.usage[id] = u
+	}
+	u.count += count
+	if lastUsed.After(u.lastUsed) {
+		u.lastUsed = lastUsed
+	}
+}
+
+// FlushUsage writes the usage counted since the last flush. Usage that
+// fails to be written is kept for the next flush.
+func (s *APIKey) FlushUsage(ctx context.Context) error {
+	s.mu.Lock()
+	pending := s.usage
+	s.usage = nil
+	s.mu.Unlock()
+
+	var firstErr error
+	for id, u := range pending {
+		if err := s.APIKeyRepository.AddUsage(ctx, id, u.count, u.lastUsed); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			s.mu.Lock()
+			s.addUsage(id, u.count, u.lastUsed)
+			s.mu.Unlock()
+		}
+	}
+	return firstErr
+}
+
+// RunUsageFlusher flushes usage every interval until ctx is cancelled, and
+// once more then so counts are not lost on shutdown.
+func (s *APIKey) RunUsageFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.FlushUsage(context.WithoutCancel(ctx)); err != nil {
+				log.Error().Err(err).Msg("failed to flush API key usage on shutdown")
+			}
+			return
+		case <-ticker.C:
+			if err := s.FlushUsage(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to flush API key usage")
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"byfood-interview/apikey"
+	"byfood-interview/helper"
+	"byfood-interview/internal/testutil"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// memoryKeys is an in-memory APIKeyRepository.
+type memoryKeys struct {
+	keys     []*apikey.APIKey
+	failSync bool
+}
+
+func (m *memoryKeys) Create(ctx context.Context, data *apikey.APIKey) (*apikey.APIKey, error) {
+	created := *data
+	created.ID = int64(len(m.keys) + 1)
+	m.keys = append(m.keys, &created)
+	copied := created
+	return &copied, nil
+}
+
+func (m *memoryKeys) GetByIDForUpdate(ctx context.Context, id int64) (*apikey.APIKey, error) {
+	return testutil.Find(m.keys, func(k *apikey.APIKey) bool { return k.ID == id })
+}
+
+func (m *memoryKeys) GetByPrefix(ctx context.Context, prefix string) (*apikey.APIKey, error) {
+	return testutil.Find(m.keys, func(k *apikey.APIKey) bool { return k.Prefix == prefix })
+}
+
+func (m *memoryKeys) GetAll(ctx context.Context) ([]apikey.APIKey, error) {
+	var all []apikey.APIKey
+	for _, k := range m.keys {
+		all = append(all, *k)
+	}
+	return all, nil
+}
+
+func (m *memoryKeys) Revoke(ctx context.Context, id int64) (*apikey.APIKey, error) {
+	for _, k := range m.keys {
+		if k.ID == id && k.RevokedAt == nil {
+			now := time.Now()
+			k.RevokedAt = &now
+			copied := *k
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryKeys) ExpireBy(ctx context.Context, id int64, at time.Time) error {
+	for _, k := range m.keys {
+		if k.ID == id && (k.ExpiresAt == nil || at.Before(*k.ExpiresAt)) {
+			k.ExpiresAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *memoryKeys) AddUsage(ctx context.Context, id, count int64, lastUsed time.Time) error {
+	if m.failSync {
+		return errors.New("database unavailable")
+	}
+	for _, k := range m.keys {
+		if k.ID == id {
+			k.RequestCount += count
+			k.LastUsedAt = &lastUsed
+		}
+	}
+	return nil
+}
+
+func newService() (*APIKey, *memoryKeys, *testutil.Clock) {
+	repo := &memoryKeys{}
+	c := testutil.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	return &APIKey{APIKeyRepository: repo, now: c.Now}, repo, c
+}
+
+func TestAuthenticate(t *testing.T) {
+	service, _, c := newService()
+	ctx := context.Background()
+
+	expiresAt := c.Now().Add(time.Hour)
+	issued, err := service.Issue(ctx, &apikey.IssueRequest{Name: "partner", Scopes: []string{"books:read"}, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issued.Key == "" || issued.Hash == issued.Key {
+		t.Fatalf("expected the plaintext key once and only its hash stored, got %+v", issued)
+	}
+
+	principal, err := service.Authenticate(ctx, issued.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Subject != issued.Subject() || len(principal.Scopes) != 1 || principal.Scopes[0] != "books:read" {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+
+	prefix, _ := apikey.ParsePrefix(issued.Key)
+	for name, key := range map[string]string{
+		"malformed":      "not-a-key",
+		"unknown prefix": "bfk_000000000000_secret",
+		"wrong secret":   "bfk_" + prefix + "_secret",
+	} {
+		if _, err := service.Authenticate(ctx, key); !errors.Is(err, apikey.ErrInvalidKey) {
+			t.Errorf("%s: expected ErrInvalidKey, got %v", name, err)
+		}
+	}
+
+	c.Set(expiresAt)
+	if _, err := service.Authenticate(ctx, issued.Key); !errors.Is(err, apikey.ErrInvalidKey) {
+		t.Fatalf("expected an expired key to be refused, got %v", err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	service, _, _ := newService()
+	ctx := context.Background()
+
+	issued, err := service.Issue(ctx, &apikey.IssueRequest{Name: "partner", Scopes: []string{"books:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Revoke(ctx, issued.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Authenticate(ctx, issued.Key); !errors.Is(err, apikey.ErrInvalidKey) {
+		t.Fatalf("expected a revoked key to be refused, got %v", err)
+	}
+	if _, err := service.Revoke(ctx, issued.ID); helper.StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected 404 revoking twice, got %v", err)
+	}
+	if _, err := service.Rotate(ctx, issued.ID, apikey.RotateRequest{}); helper.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 rotating a revoked key, got %v", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	service, _, c := newService()
+	ctx := context.Background()
+
+	old, err := service.Issue(ctx, &apikey.IssueRequest{Name: "partner", Scopes: []string{"books:read", "urls:process"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacement, err := service.Rotate(ctx, old.ID, apikey.RotateRequest{Overlap: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replacement.Key == old.Key || replacement.Name != old.Name || len(replacement.Scopes) != 2 ||
+		replacement.RotatedFrom == nil || *replacement.RotatedFrom != old.ID {
+		t.Fatalf("unexpected replacement %+v", replacement)
+	}
+
+	for _, key := range []string{old.Key, replacement.Key} {
+		if _, err := service.Authenticate(ctx, key); err != nil {
+			t.Fatalf("expected both keys to work during the overlap, got %v", err)
+		}
+	}
+
+	c.Advance(time.Hour)
+	if _, err := service.Authenticate(ctx, old.Key); !errors.Is(err, apikey.ErrInvalidKey) {
+		t.Fatalf("expected the old key to expire after the overlap, got %v", err)
+	}
+	if _, err := service.Authenticate(ctx, replacement.Key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushUsage(t *testing.T) {
+	service, repo, c := newService()
+	ctx := context.Background()
+
+	issued, err := service.Issue(ctx, &apikey.IssueRequest{Name: "partner", Scopes: []string{"books:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c.Advance(time.Minute)
+		if _, err := service.Authenticate(ctx, issued.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo.failSync = true
+	if err := service.FlushUsage(ctx); err == nil {
+		t.Fatal("expected the failed flush to be reported")
+	}
+	repo.failSync = false
+	if _, err := service.Authenticate(ctx, issued.Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.FlushUsage(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := repo.keys[0]
+	if stored.RequestCount != 4 || stored.LastUsedAt == nil || !stored.LastUsedAt.Equal(c.Now()) {
+		t.Fatalf("expected usage kept across the failed flush, got %d requests last used %v", stored.RequestCount, stored.LastUsedAt)
+	}
+	if err := service.FlushUsage(ctx); err != nil || stored.RequestCount != 4 {
+		t.Fatalf("expected an idle flush to write nothing, got %d (%v)", stored.RequestCount, err)
+	}
+}
@@ -0,0 +1,134 @@
+package stores
+
+import (
+	"byfood-interview/apikey"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/tenant"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const apiKeyColumns = "id, name, prefix, key_hash, scopes, created_by, rotated_from, created_at, expires_at, revoked_at, last_used_at, request_count, tenant_id"
+
+// APIKey stores API keys. Keys are managed within the tenant on the context
+// of each call; looking one up by its prefix to authenticate spans every
+// tenant, and the key then binds the request to its own.
+type APIKey struct {
+	db *sqlx.DB
+}
+
+func NewAPIKey(db *sqlx.DB) *APIKey {
+	return &APIKey{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *APIKey) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+func (s *APIKey) Create(ctx context.Context, data *apikey.APIKey) (*apikey.APIKey, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var created apikey.APIKey
+	query := `INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, rotated_from, expires_at, tenant_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ` + apiKeyColumns
+	err = s.conn(ctx).GetContext(ctx, &created, query,
+		data.Name, data.Prefix, data.Hash, data.Scopes, data.CreatedBy, data.RotatedFrom, data.ExpiresAt, tenantID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to insert API key")
+		return nil, err
+	}
+	return &created, nil
+}
+
+// GetByIDForUpdate returns the key with its row locked until the surrounding
+// transaction ends. It must be called inside a transaction.
+func (s *APIKey) GetByIDForUpdate(ctx context.Context, id int64) (*apikey.APIKey, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var data apikey.APIKey
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE id = $1 AND tenant_id = $2 FOR UPDATE"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, id, tenantID); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *APIKey) GetByPrefix(ctx context.Context, prefix string) (*apikey.APIKey, error) {
+	var data apikey.APIKey
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE prefix = $1"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, prefix); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *APIKey) GetAll(ctx context.Context) ([]apikey.APIKey, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []apikey.APIKey{}
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE tenant_id = $1 ORDER BY id"
+	if err := s.conn(ctx).SelectContext(ctx, &keys, query, tenantID); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Revoke marks the key revoked. It returns sql.ErrNoRows when there is no
+// such key or it was already revoked.
+func (s *APIKey) Revoke(ctx context.Context, id int64) (*apikey.APIKey, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var data apikey.APIKey
+	query := "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL RETURNING " + apiKeyColumns
+	if err := s.conn(ctx).GetContext(ctx, &data, query, id, tenantID); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// ExpireBy moves the expiry of the key to at, unless it already expires
+// sooner.
+func (s *APIKey) ExpireBy(ctx context.Context, id int64, at time.Time) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $2), $2) WHERE id = $1 AND tenant_id = $3"
+	if _, err := s.conn(ctx).ExecContext(ctx, query, id, at, tenantID); err != nil {
+		log.Error().Err(err).Msg("failed to set API key expiry")
+		return err
+	}
+	return nil
+}
+
+// AddUsage adds count requests to the key's total and moves its last use
+// forward to lastUsed.
+func (s *APIKey) AddUsage(ctx context.Context, id, count int64, lastUsed time.Time) error {
+	query := `UPDATE api_keys SET
+		request_count = request_count + $2,
+		last_used_at = GREATEST(COALESCE(last_used_at, $3), $3)
+	WHERE id = $1`
+	if _, err := s.conn(ctx).ExecContext(ctx, query, id, count, lastUsed); err != nil {
+		log.Error().Err(err).Msg("failed to record API key usage")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Package auth authenticates API requests with JWT bearer tokens and
+// describes the access each route requires.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrMissingToken     = errors.New("authentication required")
+	ErrInvalidToken     = errors.New("invalid or expired token")
+	ErrInsufficientAuth = errors.New("token lacks a required claim")
+)
+
+// Principal is the authenticated caller of a request: the subject of its
+// token and all of the token's claims, or an API key.
+type Principal struct {
+	Subject string
+	Claims  map[string]interface{}
+	// Scopes, when not nil, limits the principal to the operations these
+	// scopes grant, as for API keys.
+	Scopes []string
+	// TenantID, when not zero, binds the principal to that tenant, as for
+	// API keys: it may not act for any other.
+	TenantID int64
+}
+
+// HasClaim reports whether the claim name holds value: it equals it, is an
+// array containing it, or is a space-delimited string such as an OAuth
+// scope containing it.
+func (p *Principal) HasClaim(name, value string) bool {
+	if p == nil {
+		return false
+	}
+	switch claim := p.Claims[name].(type) {
+	case string:
+		if claim == value {
+			return true
+		}
+		for _, part := range strings.Fields(claim) {
+			if part == value {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range claim {
+			if s, ok := item.(string); ok && s == value {
+				return true
+			}
+		}
+	case []string:
+		for _, s := range claim {
+			if s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal on ctx, or nil for an anonymous
+// request.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Access is what a route requires of its caller.
+type Access struct {
+	// Authenticated requires a valid token.
+	Authenticated bool
+	// Claims must all be held by the token; see Principal.HasClaim.
+	Claims []Claim
+}
+
+// Claim is a claim name and a value it must hold.
+type Claim struct {
+	Name  string
+	Value string
+}
+
+// Anonymous routes accept any caller.
+var Anonymous = Access{}
+
+// Authenticated routes require a valid token.
+var Authenticated = Access{Authenticated: true}
+
+// RequireClaim returns the access of routes that require a valid token
+// holding value in the claim name.
+func RequireClaim(name, value string) Access {
+	return Access{Authenticated: true, Claims: []Claim{{Name: name, Value: value}}}
+}
+
+// Check returns ErrMissingToken when a requires a caller and p is nil, and
+// ErrInsufficientAuth when p lacks a required claim.
+func (a Access) Check(p *Principal) error {
+	if !a.Authenticated {
+		return nil
+	}
+	if p == nil {
+		return ErrMissingToken
+	}
+	for _, claim := range a.Claims {
+		if !p.HasClaim(claim.Name, claim.Value) {
+			return ErrInsufficientAuth
+		}
+	}
+	return nil
+}
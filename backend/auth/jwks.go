@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultJWKSRefreshInterval = 15 * time.Minute
+	// DefaultJWKSMinRefreshInterval limits how often a token with an unknown
+	// key ID can make the set be fetched again.
+	DefaultJWKSMinRefreshInterval = time.Minute
+
+	maxJWKSSize = 1 << 20
+)
+
+// JWKS is a JSON Web Key Set read from a URL or a file. Its keys are cached
+// and fetched again once RefreshInterval has passed, or sooner when a token
+// names a key ID the set does not have, so rotated keys are picked up
+// without a restart. When a fetch fails the cached keys stay in use.
+type JWKS struct {
+	// Location is an http(s) URL or a file path.
+	Location           string
+	RefreshInterval    time.Duration
+	MinRefreshInterval time.Duration
+	Client             *http.Client
+
+	mu        sync.Mutex
+	keys      []Key
+	fetchedAt time.Time
+	now       func() time.Time
+}
+
+// NewJWKS returns the key set at location with the default intervals.
+func NewJWKS(location string) *JWKS {
+	return &JWKS{
+		Location:           location,
+		RefreshInterval:    DefaultJWKSRefreshInterval,
+		MinRefreshInterval: DefaultJWKSMinRefreshInterval,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (j *JWKS) Keys(ctx context.Context, kid, alg string) ([]Key, error) {
+	j.mu.Lock()
+	now := j.clock()
+	age := now.Sub(j.fetchedAt)
+	stale := j.fetchedAt.IsZero() || age >= j.RefreshInterval
+	unknown := kid != "" && !hasKID(j.keys, kid) && age >= j.MinRefreshInterval
+	refresh := stale || unknown
+	if refresh && j.keys != nil {
+		// Claim the refresh, so other requests keep using the cached keys
+		// while it is fetched rather than fetching it too.
+		j.fetchedAt = now
+	}
+	cached := j.keys
+	j.mu.Unlock()
+
+	if !refresh {
+		return selectKeys(cached, kid, alg), nil
+	}
+
+	// The set is fetched without holding the lock, so a slow or unreachable
+	// server does not hold up every other request.
+	keys, err := j.fetch(ctx)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// Failed fetches count too, so an unreachable set is not retried on
+	// every request.
+	j.fetchedAt = now
+	if err != nil {
+		if j.keys == nil {
+			return nil, err
+		}
+		log.Ctx(ctx).Warn().Err(err).Str("jwks", j.Location).Msg("failed to refresh JWKS, using cached keys")
+	} else {
+		j.keys = keys
+	}
+	return selectKeys(j.keys, kid, alg), nil
+}
+
+func (j *JWKS) clock() time.Time {
+	if j.now != nil {
+		return j.now()
+	}
+	return time.Now()
+}
+
+func (j *JWKS) fetch(ctx context.Context) ([]Key, error) {
+	var data []byte
+	if strings.HasPrefix(j.Location, "http://") || strings.HasPrefix(j.Location, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.Location, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		client := j.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetch JWKS: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch JWKS: unexpected status %d", resp.StatusCode)
+		}
+		data, err = io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+		if err != nil {
+			return nil, fmt.Errorf("read JWKS: %w", err)
+		}
+	} else {
+		var err error
+		data, err = os.ReadFile(j.Location)
+		if err != nil {
+			return nil, fmt.Errorf("read JWKS: %w", err)
+		}
+	}
+	return ParseJWKS(data)
+}
+
+// jwk is a JSON Web Key (RFC 7517) of type RSA, EC or oct.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS reads the signature keys of a JSON Web Key Set. Keys of types
+// or curves that cannot verify HS256, RS256 or ES256 are skipped, and so are
+// malformed keys, which are logged, so one bad key does not take down the
+// others of the set.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	var keys []Key
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", k.Kid).Msg("skipping invalid JWKS key")
+			continue
+		}
+		if key == nil || (k.Alg != "" && k.Alg != key.Alg) {
+			continue
+		}
+		keys = append(keys, *key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable signature keys")
+	}
+	return keys, nil
+}
+
+// key returns the verification key k describes, or nil when it is not one
+// this package uses.
+func (k jwk) key() (*Key, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent out of range")
+		}
+		key, err := publicKey(k.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())})
+		return &key, err
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if _, err := public.ECDH(); err != nil {
+			return nil, errors.New("EC point is not on P-256")
+		}
+		key, err := publicKey(k.Kid, public)
+		return &key, err
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("invalid symmetric key")
+		}
+		return &Key{KID: k.Kid, Alg: AlgHS256, Key: secret}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
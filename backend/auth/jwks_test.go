@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "alg": AlgRS256, "use": "sig",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func jwksJSON(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJWKSFile(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	data := jwksJSON(t,
+		rsaJWK("rsa-1", &rsaKey.PublicKey),
+		ecJWK("ec-1", &ecKey.PublicKey),
+		map[string]string{"kty": "oct", "kid": "hmac-1", "k": b64(secret)},
+		map[string]string{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": b64([]byte("ignored"))},
+	)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &Verifier{Keys: NewJWKS(path)}
+	for name, token := range map[string]string{
+		"RS256": sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims()),
+		"ES256": sign(t, jwt.SigningMethodES256, ecKey, "ec-1", validClaims()),
+		"HS256": sign(t, jwt.SigningMethodHS256, secret, "hmac-1", validClaims()),
+	} {
+		if _, err := verifier.Verify(context.Background(), token); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// An RS256 key advertised for another algorithm is not used.
+	mislabelled := rsaJWK("rsa-1", &rsaKey.PublicKey)
+	mislabelled["alg"] = "PS256"
+	if _, err := ParseJWKS(jwksJSON(t, mislabelled)); err == nil {
+		t.Fatalf("expected a set with no usable keys to be rejected")
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	body := jwksJSON(t, rsaJWK("old", &oldKey.PublicKey))
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	jwks := NewJWKS(server.URL)
+	jwks.now = func() time.Time { return now }
+	verifier := &Verifier{Keys: jwks}
+
+	fetched := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+	verify := func(key *rsa.PrivateKey, kid string) error {
+		_, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, key, kid, validClaims()))
+		return err
+	}
+
+	if err := verify(oldKey, "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(oldKey, "old"); err != nil || fetched() != 1 {
+		t.Fatalf("expected cached keys, got %v after %d fetches", err, fetched())
+	}
+
+	mu.Lock()
+	body = jwksJSON(t, rsaJWK("old", &oldKey.PublicKey), rsaJWK("new", &newKey.PublicKey))
+	mu.Unlock()
+
+	// A new kid right after a fetch waits out the minimum interval.
+	if err := verify(newKey, "new"); err == nil || fetched() != 1 {
+		t.Fatalf("expected the unknown kid to be rejected without a fetch, got %v after %d fetches", err, fetched())
+	}
+
+	now = now.Add(DefaultJWKSMinRefreshInterval)
+	if err := verify(newKey, "new"); err != nil || fetched() != 2 {
+		t.Fatalf("expected the rotated key after a refetch, got %v after %d fetches", err, fetched())
+	}
+
+	// Once the old key is dropped it stops verifying at the next refresh, and
+	// an unreachable set keeps serving the cached keys.
+	mu.Lock()
+	body = jwksJSON(t, rsaJWK("new", &newKey.PublicKey))
+	mu.Unlock()
+	now = now.Add(DefaultJWKSRefreshInterval)
+	if err := verify(oldKey, "old"); err == nil {
+		t.Fatalf("expected the retired key to be rejected")
+	}
+
+	server.Close()
+	now = now.Add(DefaultJWKSRefreshInterval)
+	if err := verify(newKey, "new"); err != nil {
+		t.Fatalf("expected cached keys while the set is unreachable, got %v", err)
+	}
+}
+
+func TestJWKSSkipsInvalidKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := rsaJWK("broken", &key.PublicKey)
+	broken["n"] = "!"
+
+	keys, err := ParseJWKS(jwksJSON(t, broken, rsaJWK("good", &key.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].KID != "good" {
+		t.Fatalf("expected only the good key, got %+v", keys)
+	}
+}
+
+func TestJWKSRefreshDoesNotBlock(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var block chan struct{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		wait := block
+		mu.Unlock()
+		if wait != nil {
+			<-wait
+		}
+		w.Write(jwksJSON(t, rsaJWK("key", &key.PublicKey)))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	jwks := NewJWKS(server.URL)
+	jwks.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	if _, err := jwks.Keys(context.Background(), "key", AlgRS256); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stale set is refreshed by one request while the others keep using
+	// the cached keys.
+	mu.Lock()
+	block = make(chan struct{})
+	now = now.Add(DefaultJWKSRefreshInterval)
+	mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		jwks.Keys(context.Background(), "key", AlgRS256)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		jwks.mu.Lock()
+		claimed := jwks.fetchedAt.Equal(now)
+		jwks.mu.Unlock()
+		if claimed {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("refresh was not started")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	keys, err := jwks.Keys(context.Background(), "key", AlgRS256)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected the cached key during the refresh, got %v, %v", keys, err)
+	}
+	close(block)
+	<-done
+}
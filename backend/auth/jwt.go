@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Verifier validates JWT bearer tokens signed with HS256, RS256 or ES256.
+type Verifier struct {
+	Keys KeySource
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew allowed when checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// Verify checks the signature and registered claims of token and returns
+// its principal. Every failure is reported as ErrInvalidToken wrapping the
+// cause, which is for logs rather than clients.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{AlgHS256, AlgRS256, AlgES256}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.Leeway),
+		jwt.WithIssuedAt(),
+	}
+	if v.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.Issuer))
+	}
+	if v.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		alg := t.Method.Alg()
+		kid, _ := t.Header["kid"].(string)
+		keys, err := v.Keys.Keys(ctx, kid, alg)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) == 0 {
+			return nil, errors.New("no key for token")
+		}
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			set.Keys = append(set.Keys, key.Key)
+		}
+		return set, nil
+	}, opts...)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidToken, err)
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, errors.Join(ErrInvalidToken, errors.New("token has no subject"))
+	}
+	return &Principal{Subject: subject, Claims: claims}, nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://issuer.test",
+		"aud":   "books-api",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"scope": "books:read books:admin",
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pemData := []byte{}
+	for _, public := range []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey} {
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemData = append(pemData, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	publicKeys, err := ParsePublicKeys(pemData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := &Verifier{
+		Keys:     append(StaticKeys{{Alg: AlgHS256, Key: secret}}, publicKeys...),
+		Issuer:   "https://issuer.test",
+		Audience: "books-api",
+	}
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExp := validClaims()
+	delete(noExp, "exp")
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "other"
+	noSubject := validClaims()
+	delete(noSubject, "sub")
+
+	cases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"HS256", sign(t, jwt.SigningMethodHS256, secret, "", validClaims()), true},
+		{"RS256", sign(t, jwt.SigningMethodRS256, rsaKey, "", validClaims()), true},
+		{"ES256", sign(t, jwt.SigningMethodES256, ecKey, "", validClaims()), true},
+		{"unknown RSA key", sign(t, jwt.SigningMethodRS256, otherRSA, "", validClaims()), false},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, []byte("another secret of enough length!"), "", validClaims()), false},
+		{"RSA public key as HMAC secret", sign(t, jwt.SigningMethodHS256, pemData, "", validClaims()), false},
+		{"HS512", sign(t, jwt.SigningMethodHS512, secret, "", validClaims()), false},
+		{"none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims()), false},
+		{"expired", sign(t, jwt.SigningMethodHS256, secret, "", expired), false},
+		{"no expiry", sign(t, jwt.SigningMethodHS256, secret, "", noExp), false},
+		{"wrong audience", sign(t, jwt.SigningMethodHS256, secret, "", wrongAudience), false},
+		{"no subject", sign(t, jwt.SigningMethodHS256, secret, "", noSubject), false},
+		{"garbage", "not.a.token", false},
+	}
+	for _, tc := range cases {
+		principal, err := verifier.Verify(context.Background(), tc.token)
+		if !tc.valid {
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("%s: expected ErrInvalidToken, got %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if principal.Subject != "user-1" || !principal.HasClaim("scope", "books:admin") {
+			t.Errorf("%s: unexpected principal %+v", tc.name, principal)
+		}
+	}
+}
+
+func TestAccessCheck(t *testing.T) {
+	principal := &Principal{Subject: "user-1", Claims: map[string]interface{}{
+		"scope": "books:read books:write",
+		"roles": []interface{}{"editor"},
+	}}
+
+	cases := []struct {
+		access    Access
+		principal *Principal
+		want      error
+	}{
+		{Anonymous, nil, nil},
+		{Authenticated, nil, ErrMissingToken},
+		{Authenticated, principal, nil},
+		{RequireClaim("scope", "books:write"), principal, nil},
+		{RequireClaim("scope", "books"), principal, ErrInsufficientAuth},
+		{RequireClaim("roles", "editor"), principal, nil},
+		{RequireClaim("roles", "admin"), principal, ErrInsufficientAuth},
+		{RequireClaim("roles", "editor"), nil, ErrMissingToken},
+	}
+	for i, tc := range cases {
+		if err := tc.access.Check(tc.principal); err != tc.want {
+			t.Errorf("case %d: got %v want %v", i, err, tc.want)
+		}
+	}
+}
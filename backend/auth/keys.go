@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Signing algorithms accepted in tokens.
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// Key is a verification key. Alg binds it to the one algorithm it may
+// verify, so an RSA public key can never be used as an HMAC secret; KID is
+// empty for keys that match tokens without a key ID.
+type Key struct {
+	KID string
+	Alg string
+	// Key is a []byte secret for HS256, an *rsa.PublicKey for RS256 and an
+	// *ecdsa.PublicKey on P-256 for ES256.
+	Key interface{}
+}
+
+// KeySource looks up the keys that may verify a token signed with alg and,
+// when the token names one, the key ID kid.
+type KeySource interface {
+	Keys(ctx context.Context, kid, alg string) ([]Key, error)
+}
+
+// StaticKeys is a fixed set of keys, such as those from configuration.
+type StaticKeys []Key
+
+// Keys returns the keys for alg whose KID is kid, or all keys for alg when
+// kid is empty or no key has it.
+func (s StaticKeys) Keys(ctx context.Context, kid, alg string) ([]Key, error) {
+	return selectKeys(s, kid, alg), nil
+}
+
+func selectKeys(keys []Key, kid, alg string) []Key {
+	var byAlg, byKID []Key
+	for _, key := range keys {
+		if key.Alg != alg {
+			continue
+		}
+		byAlg = append(byAlg, key)
+		if kid != "" && key.KID == kid {
+			byKID = append(byKID, key)
+		}
+	}
+	if len(byKID) > 0 {
+		return byKID
+	}
+	return byAlg
+}
+
+// hasKID reports whether keys include one with the ID kid.
+func hasKID(keys []Key, kid string) bool {
+	for _, key := range keys {
+		if key.KID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// MultiSource looks keys up in every source, in order.
+type MultiSource []KeySource
+
+func (m MultiSource) Keys(ctx context.Context, kid, alg string) ([]Key, error) {
+	var keys []Key
+	var errs []error
+	for _, source := range m {
+		found, err := source.Keys(ctx, kid, alg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		keys = append(keys, found...)
+	}
+	if len(keys) == 0 && len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return keys, nil
+}
+
+// ParsePublicKeys reads the RSA and P-256 ECDSA public keys in PEM data,
+// either PKIX public keys or certificates, as RS256 and ES256 keys.
+func ParsePublicKeys(data []byte) ([]Key, error) {
+	var keys []Key
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var public interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse public key: %w", err)
+			}
+			public = parsed
+		case "RSA PUBLIC KEY":
+			parsed, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse RSA public key: %w", err)
+			}
+			public = parsed
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse certificate: %w", err)
+			}
+			public = cert.PublicKey
+		default:
+			continue
+		}
+
+		key, err := publicKey("", public)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found in PEM data")
+	}
+	return keys, nil
+}
+
+// publicKey wraps an RSA or P-256 ECDSA public key with the algorithm it
+// verifies.
+func publicKey(kid string, public interface{}) (Key, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return Key{}, fmt.Errorf("RSA key %q is shorter than 2048 bits", kid)
+		}
+		return Key{KID: kid, Alg: AlgRS256, Key: k}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return Key{}, fmt.Errorf("ECDSA key %q is not on P-256", kid)
+		}
+		return Key{KID: kid, Alg: AlgES256, Key: k}, nil
+	default:
+		return Key{}, fmt.Errorf("unsupported public key type %T", public)
+	}
+}
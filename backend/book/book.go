@@ -1,8 +1,12 @@
 package book
 
 import (
+	"byfood-interview/helper"
 	"errors"
+	"fmt"
+	"sort"
 	"time"
+	"unicode/utf8"
 )
 
 var (
@@ -11,27 +15,241 @@ var (
 	ErrTitleRequired         = errors.New("title is required")
 	ErrAuthorRequired        = errors.New("author is required")
 	ErrPublishedYearRequired = errors.New("published year is required")
+	ErrMergeSourceRequired   = errors.New("source_id is required")
+	ErrMergeIntoSelf         = errors.New("a book cannot be merged into itself")
 )
 
 type Book struct {
-	ID            int64      `json:"id" db:"id"`
-	Title         string     `json:"title" db:"title"`
-	Author        string     `json:"author" db:"author"`
-	PublishedYear int        `json:"published_year" db:"published_year"`
-	CreatedAt     time.Time  `json:"-" db:"created_at"`
-	UpdatedAt     time.Time  `json:"-" db:"updated_at"`
-	DeletedAt     *time.Time `json:"-" db:"deleted_at"`
+	ID            int64      `json:"id" xml:"id" db:"id"`
+	Title         string     `json:"title" xml:"title" db:"title"`
+	Author        string     `json:"author" xml:"author" db:"author"`
+	PublishedYear int        `json:"published_year" xml:"published_year" db:"published_year"`
+	CreatedAt     time.Time  `json:"created_at" xml:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" xml:"updated_at" db:"updated_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty" db:"deleted_at"`
+	MergedInto    *int64     `json:"merged_into,omitempty" xml:"merged_into,omitempty" db:"merged_into"`
+	TenantID      int64      `json:"-" xml:"-" db:"tenant_id"`
 }
 
+// MaxTextLength is the longest title or author, in characters, the books
+// table stores.
+const MaxTextLength = 255
+
+// Validate reports every problem with a book to be created as a
+// *helper.ErrValidation.
 func (b *Book) Validate() error {
-	if b.Title == "" {
-		return ErrTitleRequired
+	var v helper.Validator
+	v.Check(b.Title != "", "title", helper.CodeRequired, ErrTitleRequired.Error())
+	v.Check(b.Author != "", "author", helper.CodeRequired, ErrAuthorRequired.Error())
+	v.Check(b.PublishedYear > 0, "published_year", helper.CodeRequired, ErrPublishedYearRequired.Error())
+	b.validateLengths(&v)
+	return v.Err()
+}
+
+// ValidateUpdate is Validate for an update, where empty fields keep their
+// stored values.
+func (b *Book) ValidateUpdate() error {
+	var v helper.Validator
+	v.Check(b.PublishedYear >= 0, "published_year", helper.CodeOutOfRange, "published year must not be negative")
+	b.validateLengths(&v)
+	return v.Err()
+}
+
+func (b *Book) validateLengths(v *helper.Validator) {
+	v.Check(utf8.RuneCountInString(b.Title) <= MaxTextLength, "title", helper.CodeTooLong,
+		fmt.Sprintf("title must be at most %d characters", MaxTextLength))
+	v.Check(utf8.RuneCountInString(b.Author) <= MaxTextLength, "author", helper.CodeTooLong,
+		fmt.Sprintf("author must be at most %d characters", MaxTextLength))
+}
+
+// DuplicateCandidate is a pair of live books that are likely the same record.
+type DuplicateCandidate struct {
+	Book      Book    `json:"book" db:"book"`
+	Duplicate Book    `json:"duplicate" db:"duplicate"`
+	Score     float64 `json:"score" db:"score"`
+}
+
+// DuplicateQuery tunes duplicate detection. Threshold is the minimum average
+// trigram similarity of title and author, YearWindow the maximum distance
+// between published years.
+type DuplicateQuery struct {
+	Threshold  float64
+	YearWindow int
+	Limit      int
+}
+
+// Merge field sources accepted in MergeRequest.Keep.
+const (
+	MergeKeepTarget = "target"
+	MergeKeepSource = "source"
+)
+
+// MergeRequest folds the book SourceID into the target book. Keep chooses,
+// per field (title, author, published_year), whether the surviving value
+// comes from the target (the default) or the source.
+type MergeRequest struct {
+	SourceID int64             `json:"source_id"`
+	Keep     map[string]string `json:"keep,omitempty"`
+}
+
+// Validate reports every problem with the request as a
+// *helper.ErrValidation.
+func (r *MergeRequest) Validate(targetID int64) error {
+	var v helper.Validator
+	if r.SourceID <= 0 {
+		v.Add("source_id", helper.CodeRequired, ErrMergeSourceRequired.Error())
+	} else if r.SourceID == targetID {
+		v.Add("source_id", helper.CodeInvalid, ErrMergeIntoSelf.Error())
+	}
+
+	fields := make([]string, 0, len(r.Keep))
+	for field := range r.Keep {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		switch field {
+		case "title", "author", "published_year":
+		default:
+			v.Add("keep."+field, helper.CodeUnknown, fmt.Sprintf("keep: unknown field %q", field))
+			continue
+		}
+		from := r.Keep[field]
+		v.Check(from == MergeKeepTarget || from == MergeKeepSource, "keep."+field, helper.CodeInvalid,
+			fmt.Sprintf("keep.%s must be one of: %s, %s", field, MergeKeepTarget, MergeKeepSource))
+	}
+	return v.Err()
+}
+
+// Apply copies the fields chosen from source onto target.
+func (r *MergeRequest) Apply(target, source *Book) {
+	if r.Keep["title"] == MergeKeepSource {
+		target.Title = source.Title
 	}
-	if b.Author == "" {
-		return ErrAuthorRequired
+	if r.Keep["author"] == MergeKeepSource {
+		target.Author = source.Author
 	}
-	if b.PublishedYear <= 0 {
-		return ErrPublishedYearRequired
+	if r.Keep["published_year"] == MergeKeepSource {
+		target.PublishedYear = source.PublishedYear
 	}
-	return nil
+}
+
+// MergedError reports that a requested book was merged into another one.
+type MergedError struct {
+	SurvivorID int64
+}
+
+func (e *MergedError) Error() string {
+	return fmt.Sprintf("book was merged into book %d", e.SurvivorID)
+}
+
+// ScoredBook is a book matched by a search with its similarity to the query.
+type ScoredBook struct {
+	Book
+	Score float64 `json:"score" db:"score"`
+}
+
+// SearchResult holds the books matching a fuzzy search. DidYouMean is set to
+// the closest known title or author when nothing matched.
+type SearchResult struct {
+	Query      string       `json:"query"`
+	Results    []ScoredBook `json:"results"`
+	DidYouMean string       `json:"did_you_mean,omitempty"`
+}
+
+// Facets that can be computed for list and search results, and used as
+// filters.
+const (
+	FacetAuthor        = "author"
+	FacetDecade        = "decade"
+	FacetPublishedYear = "published_year"
+)
+
+// Filter narrows list and search results to the selected facet values.
+// Values of one facet are alternatives; different facets must all match.
+// The time bounds are exclusive and left open when zero.
+type Filter struct {
+	Authors        []string
+	Decades        []int
+	PublishedYears []int
+
+	CreatedAfter  time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+}
+
+func (f *Filter) Validate() error {
+	var v helper.Validator
+	f.validate(&v)
+	return v.Err()
+}
+
+func (f *Filter) validate(v *helper.Validator) {
+	for _, decade := range f.Decades {
+		v.Check(decade%10 == 0, FacetDecade, helper.CodeInvalid, fmt.Sprintf("decade must be a multiple of 10, got %d", decade))
+	}
+	v.Check(f.UpdatedAfter.IsZero() || f.UpdatedBefore.IsZero() || f.UpdatedAfter.Before(f.UpdatedBefore),
+		"updated_after", helper.CodeOutOfRange, "updated_after must be before updated_before")
+}
+
+// ListQuery selects books by Filter and names the facets to count over the
+// selection, keeping at most FacetLimit buckets per facet.
+type ListQuery struct {
+	Filter     Filter
+	Facets     []string
+	FacetLimit int
+	// Fields, when set, limits the columns read for each book.
+	Fields Fields
+}
+
+func (q *ListQuery) Validate() error {
+	var v helper.Validator
+	q.Filter.validate(&v)
+	for _, facet := range q.Facets {
+		switch facet {
+		case FacetAuthor, FacetDecade, FacetPublishedYear:
+		default:
+			v.Add("facets", helper.CodeUnknown, fmt.Sprintf("facets: unknown facet %q", facet))
+		}
+	}
+	return v.Err()
+}
+
+// FacetBucket is one value of a facet with the number of books having it.
+type FacetBucket struct {
+	Value string `json:"value" db:"value"`
+	Count int64  `json:"count" db:"count"`
+}
+
+// Facets maps a facet name to its buckets, largest first. The counts of a
+// facet ignore that facet's own selection, so clients can offer the other
+// values of a facet next to the selected one.
+type Facets map[string][]FacetBucket
+
+// ListMeta accompanies list and search results.
+type ListMeta struct {
+	Facets Facets `json:"facets,omitempty"`
+	// Missing lists the requested IDs of a batch get that are not live
+	// books, in request order.
+	Missing []int64 `json:"missing,omitempty"`
+}
+
+// SimilarQuery restricts similar book recommendations to a range of
+// published years. Zero bounds are open.
+type SimilarQuery struct {
+	YearFrom int
+	YearTo   int
+	Limit    int
+}
+
+func (q *SimilarQuery) Validate() error {
+	var v helper.Validator
+	v.Check(q.YearFrom == 0 || q.YearTo == 0 || q.YearFrom <= q.YearTo,
+		"year_from", helper.CodeOutOfRange, "year_from must not be after year_to")
+	return v.Err()
+}
+
+// Includes reports whether a book published in year is within the range.
+func (q *SimilarQuery) Includes(year int) bool {
+	return (q.YearFrom == 0 || year >= q.YearFrom) && (q.YearTo == 0 || year <= q.YearTo)
 }
@@ -0,0 +1,79 @@
+package book
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// Change is the latest state of a book since a sync token: the book itself,
+// or a tombstone when it was deleted or merged into another book.
+type Change struct {
+	ID         int64  `json:"id"`
+	Deleted    bool   `json:"deleted"`
+	Book       *Book  `json:"book,omitempty"`
+	MergedInto *int64 `json:"merged_into,omitempty"`
+	// Seq is the position of the change in the change sequence.
+	Seq int64 `json:"-"`
+}
+
+// ChangeSet is one page of changes. Clients store NextToken and pass it as
+// since on their next sync; while HasMore is set it leads to the next page.
+// A change may be delivered more than once, so clients should apply changes
+// as upserts and deletes by ID.
+type ChangeSet struct {
+	Changes   []Change `json:"changes"`
+	NextToken string   `json:"next_token"`
+	HasMore   bool     `json:"has_more"`
+}
+
+// SyncToken is the decoded form of the opaque token handed to clients.
+// Since is the transaction ID horizon of the previous sync: every change
+// written by an older transaction has already been delivered. Within a
+// paged sync, AfterSeq is the last change returned and Horizon the
+// transaction ID horizon the sync will move Since to once done.
+type SyncToken struct {
+	Since    int64
+	AfterSeq int64
+	Horizon  int64
+}
+
+// IsZero reports whether t is the token of a first, full sync.
+func (t SyncToken) IsZero() bool {
+	return t == SyncToken{}
+}
+
+func (t SyncToken) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("v1.%d.%d.%d", t.Since, t.AfterSeq, t.Horizon)))
+}
+
+// ParseSyncToken decodes a token produced by SyncToken.String. The empty
+// string is the zero token.
+func ParseSyncToken(s string) (SyncToken, error) {
+	var t SyncToken
+	if s == "" {
+		return t, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return t, ErrInvalidSyncToken
+	}
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 4 || parts[0] != "v1" {
+		return t, ErrInvalidSyncToken
+	}
+	for i, field := range []*int64{&t.Since, &t.AfterSeq, &t.Horizon} {
+		if *field, err = strconv.ParseInt(parts[i+1], 10, 64); err != nil || *field < 0 {
+			return SyncToken{}, ErrInvalidSyncToken
+		}
+	}
+	if (t.AfterSeq > 0) != (t.Horizon > 0) {
+		return SyncToken{}, ErrInvalidSyncToken
+	}
+	return t, nil
+}
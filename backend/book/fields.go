@@ -0,0 +1,118 @@
+package book
+
+import (
+	"byfood-interview/helper"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// fieldColumns maps the JSON fields of a Book that can be selected with
+// ?fields= to their columns.
+var fieldColumns = map[string]string{
+	"id":             "id",
+	"title":          "title",
+	"author":         "author",
+	"published_year": "published_year",
+	"created_at":     "created_at",
+	"updated_at":     "updated_at",
+	"deleted_at":     "deleted_at",
+	"merged_into":    "merged_into",
+}
+
+// Fields is a sparse fieldset: the JSON fields of a Book to return. The id
+// is always included. A nil Fields selects every field.
+type Fields []string
+
+// ParseFields parses a comma-separated list of fields. An empty s selects
+// every field.
+func ParseFields(s string) (Fields, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	return NewFields(strings.Split(s, ","))
+}
+
+// NewFields validates names and returns them as Fields, id first and
+// without duplicates. No names selects every field.
+func NewFields(names []string) (Fields, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	fields := Fields{"id"}
+	seen := map[string]bool{"id": true}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, ok := fieldColumns[name]; !ok {
+			return nil, helper.NewErrValidation("fields", helper.CodeUnknown, fmt.Sprintf("fields: unknown field %q", name))
+		}
+		seen[name] = true
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// With returns fs also selecting names, for reading fields needed besides
+// those returned. A nil fs already selects every field and is returned as
+// is.
+func (fs Fields) With(names ...string) Fields {
+	if fs == nil {
+		return nil
+	}
+	with := append(Fields{}, fs...)
+	for _, name := range names {
+		if !slices.Contains(with, name) {
+			with = append(with, name)
+		}
+	}
+	return with
+}
+
+// Columns returns the columns to select for fs, or nil when fs selects
+// every field.
+func (fs Fields) Columns() []string {
+	if fs == nil {
+		return nil
+	}
+	columns := make([]string, len(fs))
+	for i, name := range fs {
+		columns[i] = fieldColumns[name]
+	}
+	return columns
+}
+
+// Project returns the fields of b selected by fs, keyed by their JSON names.
+func (fs Fields) Project(b *Book) map[string]interface{} {
+	out := make(map[string]interface{}, len(fs))
+	for _, name := range fs {
+		switch name {
+		case "id":
+			out[name] = b.ID
+		case "title":
+			out[name] = b.Title
+		case "author":
+			out[name] = b.Author
+		case "published_year":
+			out[name] = b.PublishedYear
+		case "created_at":
+			out[name] = b.CreatedAt
+		case "updated_at":
+			out[name] = b.UpdatedAt
+		case "deleted_at":
+			out[name] = b.DeletedAt
+		case "merged_into":
+			out[name] = b.MergedInto
+		}
+	}
+	return out
+}
+
+// BatchGet fetches many books by ID in one request. See Fields.
+type BatchGet struct {
+	IDs    []int64  `json:"ids"`
+	Fields []string `json:"fields,omitempty"`
+}
@@ -3,20 +3,33 @@ package handler
 import (
 	"byfood-interview/book"
 	"byfood-interview/helper"
+	"byfood-interview/internal/search"
 	"context"
-	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 type BookService interface {
 	Create(ctx context.Context, bookData *book.Book) (*book.Book, error)
-	GetByID(ctx context.Context, id int64) (*book.Book, error)
-	GetAll(ctx context.Context) ([]book.Book, error)
+	GetByID(ctx context.Context, id int64, fields book.Fields) (*book.Book, error)
+	GetAll(ctx context.Context, q book.ListQuery) ([]book.Book, *book.ListMeta, error)
+	GetByIDs(ctx context.Context, ids []int64, fields book.Fields) ([]book.Book, *book.ListMeta, error)
 	Update(ctx context.Context, bookData *book.Book) (*book.Book, error)
 	Delete(ctx context.Context, id int64) error
+	FindDuplicates(ctx context.Context, q book.DuplicateQuery) ([]book.DuplicateCandidate, error)
+	Merge(ctx context.Context, targetID int64, req *book.MergeRequest) (*book.Book, error)
+	Search(ctx context.Context, query string, limit int, q book.ListQuery) (*book.SearchResult, *book.ListMeta, error)
+	Suggest(ctx context.Context, field, prefix string, limit int) ([]search.Suggestion, error)
+	Similar(ctx context.Context, id int64, q book.SimilarQuery) ([]book.ScoredBook, error)
+	Stats(ctx context.Context, q book.StatsQuery) (*book.Stats, error)
+	Changes(ctx context.Context, since string, limit int) (*book.ChangeSet, error)
 }
 
 type Handler struct {
@@ -25,31 +38,39 @@ type Handler struct {
 
 // CreateBook godoc
 // @Summary Create a new book
-// @Description Create a new book with title, author, and published year
+// @Description Create a new book with title, author, and published year. Requires the editor role when authentication is enabled, or an API key with the books:write scope.
 // @Tags books
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
 // @Param book body book.Book true "Book data (without id)"
+// @Param Idempotency-Key header string false "Key making retries of this request safe"
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Success 200 {object} helper.Response{}
-// @Failure 400 {object} helper.Response{errors=string}
-// @Failure 500 {object} helper.Response{errors=string}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 415 {object} helper.Response
+// @Failure 409 {object} helper.Response
+// @Failure 422 {object} helper.Response
+// @Failure 500 {object} helper.Response
 // @Router /api/v1/books [post]
 // CreateBook handles the creation of a new book
 func (h *Handler) CreateBook() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var request book.Book
-		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			helper.WriteResponse(w, helper.NewErrBadRequest("invalid JSON body"), nil)
+		if err := helper.Decode(r, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
 		data, err := h.Service.Create(r.Context(), &request)
 		if err != nil {
-			helper.WriteResponse(w, err, nil)
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
-		helper.WriteResponse(w, nil, data)
+		helper.WriteResponse(w, r, nil, data)
 	}
 }
 
@@ -57,12 +78,16 @@ func (h *Handler) CreateBook() http.HandlerFunc {
 // @Summary Get a book by ID
 // @Description Get a book by its ID
 // @Tags books
-// @Produce json
+// @Produce json,xml,application/msgpack
 // @Param id path int true "Book ID"
+// @Param fields query string false "Comma-separated fields to return, e.g. id,title; id is always included"
+// @Param If-Modified-Since header string false "Only return the book if it changed after this HTTP date"
 // @Success 200 {object} helper.Response{data=book.Book}
-// @Failure 400 {object} helper.Response{errors=string}
-// @Failure 404 {object} helper.Response{errors=string}
-// @Failure 500 {object} helper.Response{errors=string}
+// @Success 302 "Book was merged; Location points to the surviving book, with the same query"
+// @Success 304 "Book not modified since If-Modified-Since"
+// @Failure 400 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
 // @Router /api/v1/books/{id} [get]
 // GetBookByID handles fetching a book by its ID
 func (h *Handler) GetBookByID() http.HandlerFunc {
@@ -72,58 +97,258 @@ func (h *Handler) GetBookByID() http.HandlerFunc {
 		idStr := rs["id"]
 		idInt, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			helper.WriteResponse(w, helper.NewErrBadRequest("invalid book ID"), nil)
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid book ID"), nil)
+			return
+		}
+
+		fields, err := book.ParseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
-		bookData, err := h.Service.GetByID(r.Context(), idInt)
+		// updated_at is read for the conditional GET even when not returned.
+		bookData, err := h.Service.GetByID(r.Context(), idInt, fields.With("updated_at"))
 		if err != nil {
-			helper.WriteResponse(w, err, nil)
+			var merged *book.MergedError
+			if errors.As(err, &merged) {
+				// Not permanent, since the survivor may itself be merged
+				// or deleted later.
+				location := path.Join(path.Dir(r.URL.Path), strconv.FormatInt(merged.SurvivorID, 10))
+				if r.URL.RawQuery != "" {
+					location += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, location, http.StatusFound)
+				return
+			}
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
 		if bookData == nil {
-			helper.WriteResponse(w, nil, "Book not found")
+			helper.WriteResponse(w, r, nil, "Book not found")
+			return
+		}
+
+		if notModified(w, r, bookData.UpdatedAt) {
 			return
 		}
 
-		helper.WriteResponse(w, nil, bookData)
+		if fields != nil {
+			helper.WriteResponse(w, r, nil, fields.Project(bookData))
+			return
+		}
+		helper.WriteResponse(w, r, nil, bookData)
 	}
 }
 
 // GetAllBooks godoc
 // @Summary Get all books
-// @Description Get a list of all books
+// @Description Get a list of all books, optionally filtered by facet values, with facet counts in meta. With ids, get those books in request order instead and list the IDs that are not live books in meta.missing.
 // @Tags books
-// @Produce json
-// @Success 200 {object} helper.Response{data=[]book.Book}
-// @Failure 500 {object} helper.Response{errors=string}
+// @Produce json,xml,application/msgpack,text/csv
+// @Param ids query string false "Comma-separated book IDs to fetch; cannot be combined with filters or facets"
+// @Param fields query string false "Comma-separated fields to return, e.g. id,title; id is always included"
+// @Param author query []string false "Only books by these authors" collectionFormat(multi)
+// @Param decade query []int false "Only books published in these decades, e.g. 1990" collectionFormat(multi)
+// @Param published_year query []int false "Only books published in these years" collectionFormat(multi)
+// @Param created_after query string false "Only books created after this date (2006-01-02) or RFC 3339 time"
+// @Param updated_after query string false "Only books last updated after this date or RFC 3339 time"
+// @Param updated_before query string false "Only books last updated before this date or RFC 3339 time"
+// @Param facets query string false "Comma-separated facets to count: author, decade, published_year"
+// @Param facet_limit query int false "Maximum number of buckets per facet (default 10)"
+// @Success 200 {object} helper.Response{data=[]book.Book,meta=book.ListMeta}
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
 // @Router /api/v1/books [get]
 // GetAllBooks handles fetching all books
 func (h *Handler) GetAllBooks() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		books, err := h.Service.GetAll(r.Context())
+		values := r.URL.Query()
+
+		fields, err := book.ParseFields(values.Get("fields"))
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		if _, ok := values["ids"]; ok {
+			for name := range values {
+				if name != "ids" && name != "fields" {
+					helper.WriteResponse(w, r, helper.NewErrValidation("ids", helper.CodeInvalid, "ids cannot be combined with "+name), nil)
+					return
+				}
+			}
+			ids, err := parseIDs(values["ids"])
+			if err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("ids", helper.CodeInvalid, "invalid ids"), nil)
+				return
+			}
+			h.writeBatch(w, r, ids, fields)
+			return
+		}
+
+		q, err := parseListQuery(values)
 		if err != nil {
-			helper.WriteResponse(w, err, nil)
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
+		q.Fields = fields
 
-		helper.WriteResponse(w, nil, books)
+		books, meta, err := h.Service.GetAll(r.Context(), q)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponseWithMeta(w, r, nil, sparse(books, fields), meta)
+	}
+}
+
+// BatchGetBooks godoc
+// @Summary Get many books by ID
+// @Description Get books by ID in request order, for lists too long for GET /books?ids=. The IDs that are not live books are listed in meta.missing.
+// @Tags books
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param request body book.BatchGet true "IDs and optional fields; id is always included"
+// @Success 200 {object} helper.Response{data=[]book.Book,meta=book.ListMeta}
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/books/batch [post]
+// BatchGetBooks handles fetching many books by ID
+func (h *Handler) BatchGetBooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request book.BatchGet
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		fields, err := book.NewFields(request.Fields)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		h.writeBatch(w, r, request.IDs, fields)
+	}
+}
+
+func (h *Handler) writeBatch(w http.ResponseWriter, r *http.Request, ids []int64, fields book.Fields) {
+	books, meta, err := h.Service.GetByIDs(r.Context(), ids, fields)
+	if err != nil {
+		helper.WriteResponse(w, r, err, nil)
+		return
+	}
+
+	helper.WriteResponseWithMeta(w, r, nil, sparse(books, fields), meta)
+}
+
+// sparse returns books with only fields, or as they are when fields is nil.
+func sparse(books []book.Book, fields book.Fields) interface{} {
+	if fields == nil {
+		return books
+	}
+	out := make([]map[string]interface{}, len(books))
+	for i := range books {
+		out[i] = fields.Project(&books[i])
+	}
+	return out
+}
+
+// parseIDs reads comma-separated IDs from one or more query values.
+func parseIDs(values []string) ([]int64, error) {
+	var ids []int64
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
 	}
+	return ids, nil
+}
+
+// parseListQuery reads facet filters, time bounds and requested facets from
+// the query string. Filters may be repeated to select several values.
+func parseListQuery(values url.Values) (book.ListQuery, error) {
+	var q book.ListQuery
+	var v helper.Validator
+	var err error
+
+	q.Filter.Authors = values[book.FacetAuthor]
+	if q.Filter.Decades, err = parseInts(values[book.FacetDecade]); err != nil {
+		v.Add(book.FacetDecade, helper.CodeInvalid, "invalid decade")
+	}
+	if q.Filter.PublishedYears, err = parseInts(values[book.FacetPublishedYear]); err != nil {
+		v.Add(book.FacetPublishedYear, helper.CodeInvalid, "invalid published_year")
+	}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"created_after", &q.Filter.CreatedAfter},
+		{"updated_after", &q.Filter.UpdatedAfter},
+		{"updated_before", &q.Filter.UpdatedBefore},
+	} {
+		if value := values.Get(bound.name); value != "" {
+			if *bound.t, err = helper.ParseTime(value); err != nil {
+				v.Add(bound.name, helper.CodeInvalid, "invalid "+bound.name)
+			}
+		}
+	}
+
+	if value := values.Get("facets"); value != "" {
+		for _, facet := range strings.Split(value, ",") {
+			if facet = strings.TrimSpace(facet); facet != "" {
+				q.Facets = append(q.Facets, facet)
+			}
+		}
+	}
+	if value := values.Get("facet_limit"); value != "" {
+		if q.FacetLimit, err = strconv.Atoi(value); err != nil {
+			v.Add("facet_limit", helper.CodeInvalid, "invalid facet_limit")
+		}
+	}
+
+	return q, v.Err()
+}
+
+func parseInts(values []string) ([]int, error) {
+	var ints []int
+	for _, v := range values {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
 }
 
 // UpdateBook godoc
 // @Summary Update a book by ID
-// @Description Update a book's details by its ID
+// @Description Update a book's details by its ID. Requires the editor role when authentication is enabled, or an API key with the books:write scope.
 // @Tags books
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
 // @Param id path int true "Book ID"
 // @Param book body book.Book true "Updated book data (with ID)"
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Success 200 {object} helper.Response{}
-// @Failure 400 {object} helper.Response{errors=string}
-// @Failure 404 {object} helper.Response{errors=string}
-// @Failure 500 {object} helper.Response{errors=string}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 415 {object} helper.Response
+// @Failure 500 {object} helper.Response
 // @Router /api/v1/books/{id} [put]
 // UpdateBook handles updating a book's details
 func (h *Handler) UpdateBook() http.HandlerFunc {
@@ -132,12 +357,12 @@ func (h *Handler) UpdateBook() http.HandlerFunc {
 		idStr := rs["id"]
 		idInt, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			helper.WriteResponse(w, err, nil)
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid book ID"), nil)
 			return
 		}
 		var request book.Book
-		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			helper.WriteResponse(w, err, nil)
+		if err := helper.Decode(r, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
@@ -145,24 +370,27 @@ func (h *Handler) UpdateBook() http.HandlerFunc {
 
 		data, err := h.Service.Update(r.Context(), &request)
 		if err != nil {
-			helper.WriteResponse(w, err, nil)
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
-		helper.WriteResponse(w, nil, data)
+		helper.WriteResponse(w, r, nil, data)
 	}
 }
 
 // DeleteBook godoc
 // @Summary Delete a book by ID
-// @Description Delete a book by its ID
+// @Description Delete a book by its ID. Requires the admin role when authentication is enabled.
 // @Tags books
-// @Produce json
+// @Produce json,xml,application/msgpack
 // @Param id path int true "Book ID"
+// @Security BearerAuth
 // @Success 200 {object} helper.Response{}
-// @Failure 400 {object} helper.Response{errors=string}
-// @Failure 404 {object} helper.Response{errors=string}
-// @Failure 500 {object} helper.Response{errors=string}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
 // @Router /api/v1/books/{id} [delete]
 // DeleteBook handles deleting a book by its ID
 func (h *Handler) DeleteBook() http.HandlerFunc {
@@ -170,21 +398,384 @@ func (h *Handler) DeleteBook() http.HandlerFunc {
 		rs := mux.Vars(r)
 		id := rs["id"]
 		if id == "" {
-			helper.WriteResponse(w, helper.NewErrBadRequest("id is required"), nil)
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeRequired, "id is required"), nil)
 			return
 		}
 
 		idInt, err := strconv.ParseInt(id, 10, 64)
 		if err != nil {
-			helper.WriteResponse(w, err, nil)
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid book ID"), nil)
 			return
 		}
 
 		if err := h.Service.Delete(r.Context(), idInt); err != nil {
-			helper.WriteResponse(w, err, nil)
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, "Book deleted successfully")
+	}
+}
+
+// GetDuplicateBooks godoc
+// @Summary List likely duplicate books
+// @Description List pairs of books with similar normalized titles and authors and close published years, best match first
+// @Tags books
+// @Produce json,xml,application/msgpack,text/csv
+// @Param threshold query number false "Minimum similarity between 0 and 1 (default 0.5)"
+// @Param year_window query int false "Maximum difference between published years (default 1)"
+// @Param limit query int false "Maximum number of pairs (default 50)"
+// @Success 200 {object} helper.Response{data=[]book.DuplicateCandidate}
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/books/duplicates [get]
+// GetDuplicateBooks handles listing duplicate candidates
+func (h *Handler) GetDuplicateBooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var q book.DuplicateQuery
+		var err error
+
+		values := r.URL.Query()
+		if v := values.Get("threshold"); v != "" {
+			if q.Threshold, err = strconv.ParseFloat(v, 64); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("threshold", helper.CodeInvalid, "invalid threshold"), nil)
+				return
+			}
+		}
+		if v := values.Get("year_window"); v != "" {
+			if q.YearWindow, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("year_window", helper.CodeInvalid, "invalid year_window"), nil)
+				return
+			}
+		}
+		if v := values.Get("limit"); v != "" {
+			if q.Limit, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("limit", helper.CodeInvalid, "invalid limit"), nil)
+				return
+			}
+		}
+
+		candidates, err := h.Service.FindDuplicates(r.Context(), q)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, candidates)
+	}
+}
+
+// MergeBook godoc
+// @Summary Merge a book into another
+// @Description Fold the source book into the book in the path, keeping the chosen field values and soft-deleting the source. Requires the admin role when authentication is enabled.
+// @Tags books
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param id path int true "Surviving book ID"
+// @Param request body book.MergeRequest true "Merge request"
+// @Param Idempotency-Key header string false "Key making retries of this request safe"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=book.Book}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 409 {object} helper.Response
+// @Failure 422 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/books/{id}/merge [post]
+// MergeBook handles merging a duplicate book into another
+func (h *Handler) MergeBook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rs := mux.Vars(r)
+		idInt, err := strconv.ParseInt(rs["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid book ID"), nil)
+			return
+		}
+
+		var request book.MergeRequest
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.Merge(r.Context(), idInt, &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// SearchBooks godoc
+// @Summary Search books
+// @Description Typo-tolerant search on title and author. Results carry a similarity score; when nothing matches, did_you_mean suggests the closest known title or author
+// @Tags books
+// @Produce json,xml,application/msgpack
+// @Param q query string true "Search text"
+// @Param limit query int false "Maximum number of results (default 20)"
+// @Param author query []string false "Only books by these authors" collectionFormat(multi)
+// @Param decade query []int false "Only books published in these decades, e.g. 1990" collectionFormat(multi)
+// @Param published_year query []int false "Only books published in these years" collectionFormat(multi)
+// @Param created_after query string false "Only books created after this date (2006-01-02) or RFC 3339 time"
+// @Param updated_after query string false "Only books last updated after this date or RFC 3339 time"
+// @Param updated_before query string false "Only books last updated before this date or RFC 3339 time"
+// @Param facets query string false "Comma-separated facets to count over all matches: author, decade, published_year"
+// @Param facet_limit query int false "Maximum number of buckets per facet (default 10)"
+// @Param fields query string false "Comma-separated fields of each book to return, e.g. id,title; id and score are always included"
+// @Success 200 {object} helper.Response{data=book.SearchResult,meta=book.ListMeta}
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/books/search [get]
+// SearchBooks handles fuzzy searching books
+func (h *Handler) SearchBooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+
+		var limit int
+		if v := values.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("limit", helper.CodeInvalid, "invalid limit"), nil)
+				return
+			}
+		}
+
+		q, err := parseListQuery(values)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+		if q.Fields, err = book.ParseFields(values.Get("fields")); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		result, meta, err := h.Service.Search(r.Context(), values.Get("q"), limit, q)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponseWithMeta(w, r, nil, sparseSearch(result, q.Fields), meta)
+	}
+}
+
+// sparseSearchResult is a book.SearchResult whose books carry only the
+// fields asked for, and their score.
+type sparseSearchResult struct {
+	Query      string                   `json:"query"`
+	Results    []map[string]interface{} `json:"results"`
+	DidYouMean string                   `json:"did_you_mean,omitempty"`
+}
+
+// sparseSearch returns result with only fields of each book, or as it is
+// when fields is nil.
+func sparseSearch(result *book.SearchResult, fields book.Fields) interface{} {
+	if fields == nil {
+		return result
+	}
+	results := make([]map[string]interface{}, len(result.Results))
+	for i := range result.Results {
+		results[i] = fields.Project(&result.Results[i].Book)
+		results[i]["score"] = result.Results[i].Score
+	}
+	return sparseSearchResult{Query: result.Query, Results: results, DidYouMean: result.DidYouMean}
+}
+
+// SuggestBooks godoc
+// @Summary Suggest titles or authors
+// @Description Complete a prefix with book titles (most recently updated first) or authors (most books first) for typeahead inputs
+// @Tags books
+// @Produce json,xml,application/msgpack,text/csv
+// @Param prefix query string true "Text typed so far"
+// @Param field query string false "Field to complete: title or author (default title)"
+// @Param limit query int false "Maximum number of suggestions (default 10)"
+// @Success 200 {object} helper.Response{data=[]search.Suggestion}
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/books/suggest [get]
+// SuggestBooks handles typeahead suggestions
+func (h *Handler) SuggestBooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+
+		var limit int
+		if v := values.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("limit", helper.CodeInvalid, "invalid limit"), nil)
+				return
+			}
+		}
+
+		suggestions, err := h.Service.Suggest(r.Context(), values.Get("field"), values.Get("prefix"), limit)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, suggestions)
+	}
+}
+
+// GetSimilarBooks godoc
+// @Summary Get similar books
+// @Description Recommend books with similar titles and authors, ranked by TF-IDF cosine similarity
+// @Tags books
+// @Produce json,xml,application/msgpack,text/csv
+// @Param id path int true "Book ID"
+// @Param year_from query int false "Only books published in or after this year"
+// @Param year_to query int false "Only books published in or before this year"
+// @Param limit query int false "Maximum number of books (default 10)"
+// @Success 200 {object} helper.Response{data=[]book.ScoredBook}
+// @Failure 400 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/books/{id}/similar [get]
+// GetSimilarBooks handles similar book recommendations
+func (h *Handler) GetSimilarBooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rs := mux.Vars(r)
+		idInt, err := strconv.ParseInt(rs["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid book ID"), nil)
+			return
+		}
+
+		var q book.SimilarQuery
+		values := r.URL.Query()
+		if v := values.Get("year_from"); v != "" {
+			if q.YearFrom, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("year_from", helper.CodeInvalid, "invalid year_from"), nil)
+				return
+			}
+		}
+		if v := values.Get("year_to"); v != "" {
+			if q.YearTo, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("year_to", helper.CodeInvalid, "invalid year_to"), nil)
+				return
+			}
+		}
+		if v := values.Get("limit"); v != "" {
+			if q.Limit, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("limit", helper.CodeInvalid, "invalid limit"), nil)
+				return
+			}
+		}
+
+		books, err := h.Service.Similar(r.Context(), idInt, q)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, books)
+	}
+}
+
+// GetBookStats godoc
+// @Summary Get catalog statistics
+// @Description Get the number of live and deleted books, histograms by published year and decade, the top authors, and books added and deleted per day, week or month (UTC)
+// @Tags books
+// @Produce json,xml,application/msgpack
+// @Param interval query string false "Timeline bucket: day, week or month (default month)"
+// @Param from query string false "Start of the timeline, as a date (2006-01-02) or RFC 3339 time (default 30 days, 12 weeks or 12 months before to)"
+// @Param to query string false "End of the timeline, as a date or RFC 3339 time (default now)"
+// @Param top_authors query int false "Number of top authors (default 10)"
+// @Success 200 {object} helper.Response{data=book.Stats}
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/stats/books [get]
+// GetBookStats handles catalog statistics
+func (h *Handler) GetBookStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		q := book.StatsQuery{Interval: values.Get("interval")}
+
+		var err error
+		if v := values.Get("from"); v != "" {
+			if q.From, err = helper.ParseTime(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("from", helper.CodeInvalid, "invalid from"), nil)
+				return
+			}
+		}
+		if v := values.Get("to"); v != "" {
+			if q.To, err = helper.ParseTime(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("to", helper.CodeInvalid, "invalid to"), nil)
+				return
+			}
+		}
+		if v := values.Get("top_authors"); v != "" {
+			if q.TopAuthors, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("top_authors", helper.CodeInvalid, "invalid top_authors"), nil)
+				return
+			}
+		}
+
+		stats, err := h.Service.Stats(r.Context(), q)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, stats)
+	}
+}
+
+// notModified sets Last-Modified to modifiedAt and, when the request's
+// If-Modified-Since is not older, answers 304 Not Modified and returns true.
+// HTTP dates have second precision, so modifiedAt is truncated to seconds.
+func notModified(w http.ResponseWriter, r *http.Request, modifiedAt time.Time) bool {
+	if modifiedAt.IsZero() {
+		return false
+	}
+	modifiedAt = modifiedAt.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", modifiedAt.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modifiedAt.After(since) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// GetBookChanges godoc
+// @Summary Sync book changes
+// @Description Get the books created, updated or deleted since a sync token, in pages. Start without since for a full sync of the live books, then pass next_token on every later call. Deleted and merged books are returned as tombstones. A change may be returned more than once.
+// @Tags books
+// @Produce json,xml,application/msgpack
+// @Param since query string false "Sync token from a previous response"
+// @Param limit query int false "Maximum number of changes per page (default 500)"
+// @Success 200 {object} helper.Response{data=book.ChangeSet}
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/books/changes [get]
+// GetBookChanges handles incremental sync
+func (h *Handler) GetBookChanges() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+
+		var limit int
+		if v := values.Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("limit", helper.CodeInvalid, "invalid limit"), nil)
+				return
+			}
+		}
+
+		changes, err := h.Service.Changes(r.Context(), values.Get("since"), limit)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
-		helper.WriteResponse(w, nil, "Book deleted successfully")
+		helper.WriteResponse(w, r, nil, changes)
 	}
 }
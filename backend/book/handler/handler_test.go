@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/helper"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// unusedService panics when a handler calls the service.
+type unusedService struct {
+	BookService
+}
+
+func newRouter(h *Handler) *mux.Router {
+	router := mux.NewRouter()
+	router.Handle("/books/{id}", h.UpdateBook()).Methods(http.MethodPut)
+	router.Handle("/books/{id}", h.DeleteBook()).Methods(http.MethodDelete)
+	return router
+}
+
+func TestInvalidBookID(t *testing.T) {
+	router := newRouter(&Handler{Service: unusedService{}})
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		r := httptest.NewRequest(method, "/books/abc", strings.NewReader(`{"title": "Dune", "author": "Frank Herbert", "published_year": 1965}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d: %s", method, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Errors []helper.FieldError `json:"errors"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if len(resp.Errors) != 1 || resp.Errors[0].Field != "id" || resp.Errors[0].Code != helper.CodeInvalid {
+			t.Fatalf("%s: expected an invalid id field error, got %s", method, w.Body.String())
+		}
+	}
+}
+
+// searchService records the query of the search it serves.
+type searchService struct {
+	unusedService
+	query book.ListQuery
+}
+
+func (s *searchService) Search(ctx context.Context, query string, limit int, q book.ListQuery) (*book.SearchResult, *book.ListMeta, error) {
+	s.query = q
+	return &book.SearchResult{Query: query, Results: []book.ScoredBook{
+		{Book: book.Book{ID: 1, Title: "Dune", Author: "Frank Herbert"}, Score: 0.9},
+	}}, &book.ListMeta{}, nil
+}
+
+func TestSearchBooksFields(t *testing.T) {
+	service := &searchService{}
+	h := &Handler{Service: service}
+
+	r := httptest.NewRequest(http.MethodGet, "/books/search?q=dune&fields=title", nil)
+	w := httptest.NewRecorder()
+	h.SearchBooks().ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if want := (book.Fields{"id", "title"}); !slices.Equal(service.query.Fields, want) {
+		t.Errorf("expected the service to read %v, got %v", want, service.query.Fields)
+	}
+	var resp struct {
+		Data struct {
+			Results []map[string]interface{} `json:"results"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data.Results) != 1 || len(resp.Data.Results[0]) != 3 || resp.Data.Results[0]["score"] != 0.9 {
+		t.Errorf("expected only id, title and score, got %s", w.Body.String())
+	}
+}
+
+// mergedService reports every book as merged into survivor.
+type mergedService struct {
+	unusedService
+	survivor int64
+}
+
+func (s mergedService) GetByID(ctx context.Context, id int64, fields book.Fields) (*book.Book, error) {
+	return nil, &book.MergedError{SurvivorID: s.survivor}
+}
+
+func TestGetMergedBookRedirects(t *testing.T) {
+	router := mux.NewRouter()
+	router.Handle("/books/{id}", (&Handler{Service: mergedService{survivor: 7}}).GetBookByID())
+
+	r := httptest.NewRequest(http.MethodGet, "/books/3?fields=title,author", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected 302, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "/books/7?fields=title,author" {
+		t.Errorf("expected the survivor with the same query, got %q", location)
+	}
+}
@@ -3,117 +3,677 @@ package services
 import (
 	"byfood-interview/book"
 	"byfood-interview/helper"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/internal/search"
+	"byfood-interview/rbac"
+	"byfood-interview/tenant"
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
 type BookRepository interface {
-	Create(ctx context.Context, bookData *book.Book) (id int64, err error)
-	GetByID(ctx context.Context, id int64) (*book.Book, error)
-	GetAll(ctx context.Context) ([]book.Book, error)
-	Update(ctx context.Context, bookData *book.Book) error
-	Delete(ctx context.Context, id int64) error
+	Create(ctx context.Context, bookData *book.Book) (*book.Book, error)
+	GetByID(ctx context.Context, id int64, fields book.Fields) (*book.Book, error)
+	GetByIDForUpdate(ctx context.Context, id int64) (*book.Book, error)
+	GetByIDs(ctx context.Context, ids []int64, fields book.Fields) ([]book.Book, error)
+	GetAll(ctx context.Context, f book.Filter, fields book.Fields) ([]book.Book, error)
+	Update(ctx context.Context, bookData *book.Book) (*book.Book, error)
+	Delete(ctx context.Context, id int64) (*book.Book, error)
+	GetMergedInto(ctx context.Context, id int64) (int64, error)
+	FindDuplicates(ctx context.Context, q book.DuplicateQuery) ([]book.DuplicateCandidate, error)
+	MergeInto(ctx context.Context, sourceID, targetID int64) (*book.Book, error)
+	Search(ctx context.Context, query string, f book.Filter, threshold float64, limit int, fields book.Fields) ([]book.ScoredBook, error)
+	Facets(ctx context.Context, f book.Filter, query string, facets []string, limit int) (book.Facets, error)
+	ChangedSince(ctx context.Context, f book.Filter, query string, since, until int64, threshold float64) ([]book.Book, error)
+	Suggest(ctx context.Context, query string, threshold float64) (string, error)
+	BackfillSearchTokens(ctx context.Context, batchSize int) (int, error)
+	EachLive(ctx context.Context, fn func(*book.Book) error) error
+	Stats(ctx context.Context, q book.StatsQuery, materialized bool) (*book.Stats, error)
+	RefreshStats(ctx context.Context) (time.Time, error)
+	SyncHorizon(ctx context.Context) (int64, error)
+	Changes(ctx context.Context, since, afterSeq int64, limit int) ([]book.Change, error)
+}
+
+// Defaults and bounds for duplicate detection.
+const (
+	DefaultDuplicateThreshold  = 0.5
+	DefaultDuplicateYearWindow = 1
+	DefaultDuplicateLimit      = 50
+	MaxDuplicateLimit          = 500
+)
+
+// Defaults and bounds for fuzzy search.
+const (
+	DefaultSearchMatchThreshold      = 0.45
+	DefaultSearchSuggestionThreshold = 0.25
+	DefaultSearchLimit               = 20
+	MaxSearchLimit                   = 100
+
+	searchBackfillBatchSize = 500
+
+	DefaultSuggestLimit = 10
+
+	DefaultFacetLimit = 10
+	MaxFacetLimit     = 100
+
+	DefaultSimilarLimit = 10
+	MaxSimilarLimit     = 50
+
+	DefaultStatsTopAuthors = 10
+	MaxStatsTopAuthors     = 100
+	MaxStatsBuckets        = 1000
+
+	DefaultChangesLimit = 500
+	MaxChangesLimit     = 1000
+
+	MaxBatchGetIDs = 1000
+)
+
+// SearchConfig holds the trigram similarity thresholds used by Search. Zero
+// values fall back to the defaults.
+type SearchConfig struct {
+	// MatchThreshold is the minimum word similarity for a book to match.
+	MatchThreshold float64
+	// SuggestionThreshold is the minimum similarity for a "did you mean"
+	// suggestion when nothing matched.
+	SuggestionThreshold float64
+}
+
+func (c SearchConfig) matchThreshold() float64 {
+	if c.MatchThreshold == 0 {
+		return DefaultSearchMatchThreshold
+	}
+	return c.MatchThreshold
 }
 
 type Book struct {
 	BookRepository BookRepository
+	Transactor     internalDb.TxRunner
+	SearchConfig   SearchConfig
+	// SuggestIndex, when set, serves Suggest and is kept current on writes.
+	SuggestIndex *SuggestIndex
+	// SimilarIndex, when set, serves Similar. See RefreshSimilarIndex.
+	SimilarIndex *SimilarIndex
+	// MaterializedStats serves Stats from materialized views instead of
+	// aggregating the books table on every request. See RefreshStats.
+	MaterializedStats bool
+	// Authorizer, when set, is consulted before each operation called on
+	// behalf of a client.
+	Authorizer rbac.Authorizer
+}
+
+// authorize checks action with the configured Authorizer, allowing it when
+// none is set.
+func (s *Book) authorize(ctx context.Context, action rbac.Action) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	return s.Authorizer.Authorize(ctx, action)
 }
 
 func (s *Book) Create(ctx context.Context, bookData *book.Book) (*book.Book, error) {
 	log := log.Ctx(ctx).With().Str("service", "book").Logger()
 
+	if err := s.authorize(ctx, rbac.ActionCreateBook); err != nil {
+		return nil, err
+	}
+
 	if err := bookData.Validate(); err != nil {
 		log.Error().Err(err).Msg("invalid book data")
-		return nil, helper.NewErrBadRequest(err.Error())
+		return nil, err
 	}
 
-	id, err := s.BookRepository.Create(ctx, bookData)
+	created, err := s.BookRepository.Create(ctx, bookData)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to create book")
 		return nil, err
 	}
 
-	bookData.ID = id
-	return bookData, nil
+	if s.SuggestIndex != nil {
+		s.SuggestIndex.Put(created)
+	}
+
+	return created, nil
 }
 
-func (s *Book) GetByID(ctx context.Context, id int64) (*book.Book, error) {
+// GetByID returns the live book id with only fields read, or every field
+// when fields is nil.
+func (s *Book) GetByID(ctx context.Context, id int64, fields book.Fields) (*book.Book, error) {
 	log := log.Ctx(ctx).With().Str("service", "book").Logger()
 
-	data, err := s.BookRepository.GetByID(ctx, id)
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, err
+	}
+
+	data, err := s.BookRepository.GetByID(ctx, id, fields)
 
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get book by ID")
 		if err == sql.ErrNoRows {
-			return nil, helper.NewErrNotFound("book not found")
+			return nil, s.notFoundOrMerged(ctx, id)
 		}
+		log.Error().Err(err).Msg("failed to get book by ID")
 		return nil, err
 	}
 
 	return data, nil
 }
 
-func (s *Book) GetAll(ctx context.Context) ([]book.Book, error) {
+// notFoundOrMerged explains why id is missing: a *book.MergedError when it
+// was merged into another book, a not-found error otherwise.
+func (s *Book) notFoundOrMerged(ctx context.Context, id int64) error {
+	survivorID, err := s.BookRepository.GetMergedInto(ctx, id)
+	if err == nil {
+		return &book.MergedError{SurvivorID: survivorID}
+	}
+	if err != sql.ErrNoRows {
+		log.Ctx(ctx).Error().Err(err).Str("service", "book").Msg("failed to look up merged book")
+		return err
+	}
+	return helper.NewErrNotFound("book not found")
+}
+
+// GetAll returns the live books matching q.Filter, with the facets named in
+// q counted over them. The meta is nil when no facets were requested.
+func (s *Book) GetAll(ctx context.Context, q book.ListQuery) ([]book.Book, *book.ListMeta, error) {
 	log := log.Ctx(ctx).With().Str("service", "book").Logger()
 
-	books, err := s.BookRepository.GetAll(ctx)
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateListQuery(&q); err != nil {
+		return nil, nil, err
+	}
+
+	books, err := s.BookRepository.GetAll(ctx, q.Filter, q.Fields)
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get all books")
-		return nil, err
+		return nil, nil, err
 	}
 
-	return books, nil
+	meta, err := s.listMeta(ctx, q, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return books, meta, nil
 }
 
-func (s *Book) Update(ctx context.Context, bookData *book.Book) (*book.Book, error) {
+// GetByIDs returns the live books among ids in request order, each once,
+// with only fields read. The IDs that are not live books are reported in the
+// meta's Missing; the meta is nil when none are.
+func (s *Book) GetByIDs(ctx context.Context, ids []int64, fields book.Fields) ([]book.Book, *book.ListMeta, error) {
 	log := log.Ctx(ctx).With().Str("service", "book").Logger()
 
-	bookExisting, err := s.BookRepository.GetByID(ctx, bookData.ID)
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, nil, err
+	}
+
+	if len(ids) == 0 || len(ids) > MaxBatchGetIDs {
+		return nil, nil, helper.NewErrValidation("ids", helper.CodeOutOfRange, fmt.Sprintf("ids must list between 1 and %d IDs", MaxBatchGetIDs))
+	}
+	unique := make([]int64, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, nil, helper.NewErrValidation("ids", helper.CodeInvalid, fmt.Sprintf("invalid book ID %d", id))
+		}
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	found, err := s.BookRepository.GetByIDs(ctx, unique, fields)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get existing book for update")
-		if err == sql.ErrNoRows {
-			return nil, helper.NewErrNotFound("book not found")
+		log.Error().Err(err).Msg("failed to get books by IDs")
+		return nil, nil, err
+	}
+
+	byID := make(map[int64]book.Book, len(found))
+	for _, b := range found {
+		byID[b.ID] = b
+	}
+	books := make([]book.Book, 0, len(found))
+	var missing []int64
+	for _, id := range unique {
+		b, ok := byID[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
 		}
+		books = append(books, b)
+	}
 
-		return nil, err
+	if len(missing) == 0 {
+		return books, nil, nil
 	}
+	return books, &book.ListMeta{Missing: missing}, nil
+}
 
-	if bookData.Title != "" {
-		bookExisting.Title = bookData.Title
+func validateListQuery(q *book.ListQuery) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	if q.FacetLimit == 0 {
+		q.FacetLimit = DefaultFacetLimit
 	}
-	if bookData.Author != "" {
-		bookExisting.Author = bookData.Author
+	if q.FacetLimit < 0 || q.FacetLimit > MaxFacetLimit {
+		return helper.NewErrValidation("facet_limit", helper.CodeOutOfRange, fmt.Sprintf("facet_limit must be between 1 and %d", MaxFacetLimit))
+	}
+	return nil
+}
+
+// listMeta counts the facets requested in q over the books matching its
+// filter and the search query, if any.
+func (s *Book) listMeta(ctx context.Context, q book.ListQuery, query string) (*book.ListMeta, error) {
+	if len(q.Facets) == 0 {
+		return nil, nil
+	}
+
+	facets, err := s.BookRepository.Facets(ctx, q.Filter, query, q.Facets, q.FacetLimit)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("service", "book").Msg("failed to count facets")
+		return nil, err
+	}
+
+	return &book.ListMeta{Facets: facets}, nil
+}
+
+func (s *Book) Update(ctx context.Context, bookData *book.Book) (*book.Book, error) {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionUpdateBook); err != nil {
+		return nil, err
 	}
-	if bookData.PublishedYear != 0 {
-		bookExisting.PublishedYear = bookData.PublishedYear
+
+	if err := bookData.ValidateUpdate(); err != nil {
+		log.Error().Err(err).Msg("invalid book data")
+		return nil, err
 	}
 
-	if err := s.BookRepository.Update(ctx, bookExisting); err != nil {
+	updated, err := s.BookRepository.Update(ctx, bookData)
+	if err != nil {
 		log.Error().Err(err).Msg("failed to update book")
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrNotFound("book not found")
+		}
 		return nil, err
 	}
 
-	return bookExisting, nil
+	if s.SuggestIndex != nil {
+		s.SuggestIndex.Put(updated)
+	}
+
+	return updated, nil
 }
 
 func (s *Book) Delete(ctx context.Context, id int64) error {
 	log := log.Ctx(ctx).With().Str("service", "book").Logger()
 
-	_, err := s.BookRepository.GetByID(ctx, id)
+	if err := s.authorize(ctx, rbac.ActionDeleteBook); err != nil {
+		return err
+	}
+
+	deleted, err := s.BookRepository.Delete(ctx, id)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get existing book for deletion")
+		log.Error().Err(err).Msg("failed to delete book")
 		if err == sql.ErrNoRows {
 			return helper.NewErrNotFound("book not found")
 		}
 		return err
 	}
 
-	if err := s.BookRepository.Delete(ctx, id); err != nil {
-		log.Error().Err(err).Msg("failed to delete book")
+	if s.SuggestIndex != nil {
+		s.SuggestIndex.Remove(deleted.TenantID, id)
+	}
+
+	return nil
+}
+
+func (s *Book) FindDuplicates(ctx context.Context, q book.DuplicateQuery) ([]book.DuplicateCandidate, error) {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, err
+	}
+
+	if q.Threshold == 0 {
+		q.Threshold = DefaultDuplicateThreshold
+	}
+	if q.YearWindow == 0 {
+		q.YearWindow = DefaultDuplicateYearWindow
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultDuplicateLimit
+	}
+	if q.Threshold < 0 || q.Threshold > 1 {
+		return nil, helper.NewErrValidation("threshold", helper.CodeOutOfRange, "threshold must be between 0 and 1")
+	}
+	if q.YearWindow < 0 {
+		return nil, helper.NewErrValidation("year_window", helper.CodeOutOfRange, "year_window must not be negative")
+	}
+	if q.Limit < 0 || q.Limit > MaxDuplicateLimit {
+		return nil, helper.NewErrValidation("limit", helper.CodeOutOfRange, fmt.Sprintf("limit must be between 1 and %d", MaxDuplicateLimit))
+	}
+
+	candidates, err := s.BookRepository.FindDuplicates(ctx, q)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to find duplicate books")
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+// Merge folds the book req.SourceID into targetID: the target keeps the
+// field values chosen in req, and the source is soft-deleted with a pointer
+// to the target. Both rows are locked for the duration of the merge.
+func (s *Book) Merge(ctx context.Context, targetID int64, req *book.MergeRequest) (*book.Book, error) {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionMergeBooks); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(targetID); err != nil {
+		return nil, err
+	}
+
+	var survivor *book.Book
+	err := internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		// Lock in ID order so concurrent merges of the same pair cannot deadlock.
+		first, second := targetID, req.SourceID
+		if first > second {
+			first, second = second, first
+		}
+		locked := make(map[int64]*book.Book, 2)
+		for _, id := range []int64{first, second} {
+			data, err := s.BookRepository.GetByIDForUpdate(ctx, id)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					return helper.NewErrNotFound(fmt.Sprintf("book %d not found", id))
+				}
+				log.Error().Err(err).Msg("failed to lock book for merge")
+				return err
+			}
+			locked[id] = data
+		}
+
+		target, source := locked[targetID], locked[req.SourceID]
+		req.Apply(target, source)
+
+		if _, err := s.BookRepository.MergeInto(ctx, source.ID, target.ID); err != nil {
+			log.Error().Err(err).Msg("failed to merge book")
+			return err
+		}
+
+		var err error
+		survivor, err = s.BookRepository.Update(ctx, target)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to update surviving book")
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.SuggestIndex != nil {
+		s.SuggestIndex.Remove(survivor.TenantID, req.SourceID)
+		s.SuggestIndex.Put(survivor)
+	}
+
+	return survivor, nil
+}
+
+// Search finds books whose title or author closely matches query, tolerating
+// typos, among the books matching q.Filter. When nothing matches, the result
+// carries the closest known title or author as a "did you mean" suggestion.
+// The facets named in q are counted over every match, not only the returned
+// page.
+func (s *Book) Search(ctx context.Context, query string, limit int, q book.ListQuery) (*book.SearchResult, *book.ListMeta, error) {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, nil, err
+	}
+
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil, helper.NewErrValidation("q", helper.CodeRequired, "q is required")
+	}
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit < 0 || limit > MaxSearchLimit {
+		return nil, nil, helper.NewErrValidation("limit", helper.CodeOutOfRange, fmt.Sprintf("limit must be between 1 and %d", MaxSearchLimit))
+	}
+	if err := validateListQuery(&q); err != nil {
+		return nil, nil, err
+	}
+
+	matchThreshold := s.SearchConfig.matchThreshold()
+	suggestionThreshold := s.SearchConfig.SuggestionThreshold
+	if suggestionThreshold == 0 {
+		suggestionThreshold = DefaultSearchSuggestionThreshold
+	}
+
+	result := &book.SearchResult{Query: query}
+	var meta *book.ListMeta
+	err := internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		var err error
+		result.Results, err = s.BookRepository.Search(ctx, query, q.Filter, matchThreshold, limit, q.Fields)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to search books")
+			return err
+		}
+
+		meta, err = s.listMeta(ctx, q, query)
+		if err != nil {
+			return err
+		}
+
+		if len(result.Results) > 0 {
+			return nil
+		}
+
+		suggestion, err := s.BookRepository.Suggest(ctx, query, suggestionThreshold)
+		if err != nil && err != sql.ErrNoRows {
+			log.Error().Err(err).Msg("failed to suggest search term")
+			return err
+		}
+		result.DidYouMean = suggestion
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, meta, nil
+}
+
+// BackfillSearchTokens indexes every book that has no search tokens yet.
+func (s *Book) BackfillSearchTokens(ctx context.Context) error {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	total := 0
+	for {
+		n, err := s.BookRepository.BackfillSearchTokens(ctx, searchBackfillBatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to backfill search tokens")
+			return err
+		}
+		total += n
+		if n < searchBackfillBatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Info().Int("books", total).Msg("search tokens backfilled")
+	}
+	return nil
+}
+
+// BuildSuggestIndex loads every live book into the suggest index.
+func (s *Book) BuildSuggestIndex(ctx context.Context) error {
+	if s.SuggestIndex == nil {
+		return nil
+	}
+
+	err := s.BookRepository.EachLive(ctx, func(b *book.Book) error {
+		s.SuggestIndex.Put(b)
+		return nil
+	})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("service", "book").Msg("failed to build suggest index")
 		return err
 	}
 
 	return nil
 }
+
+// Suggest completes prefix with titles (most recently updated first) or
+// authors (most books first).
+func (s *Book) Suggest(ctx context.Context, field, prefix string, limit int) ([]search.Suggestion, error) {
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, err
+	}
+
+	if s.SuggestIndex == nil {
+		return nil, helper.NewErrInternalServer("suggestions are not available")
+	}
+
+	if field == "" {
+		field = SuggestFieldTitle
+	}
+	if field != SuggestFieldTitle && field != SuggestFieldAuthor {
+		return nil, helper.NewErrValidation("field", helper.CodeInvalid, "field must be one of: title, author")
+	}
+	if strings.TrimSpace(prefix) == "" {
+		return nil, helper.NewErrValidation("prefix", helper.CodeRequired, "prefix is required")
+	}
+	if limit == 0 {
+		limit = DefaultSuggestLimit
+	}
+	if limit < 0 || limit > search.MaxLookupLimit {
+		return nil, helper.NewErrValidation("limit", helper.CodeOutOfRange, fmt.Sprintf("limit must be between 1 and %d", search.MaxLookupLimit))
+	}
+
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.SuggestIndex.lookup(tenantID, field, prefix, limit), nil
+}
+
+// Similar recommends the live books whose title and author are most similar
+// to the book id, optionally within a range of published years.
+func (s *Book) Similar(ctx context.Context, id int64, q book.SimilarQuery) ([]book.ScoredBook, error) {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, err
+	}
+
+	if s.SimilarIndex == nil {
+		return nil, helper.NewErrInternalServer("similar books are not available")
+	}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultSimilarLimit
+	}
+	if q.Limit < 0 || q.Limit > MaxSimilarLimit {
+		return nil, helper.NewErrValidation("limit", helper.CodeOutOfRange, fmt.Sprintf("limit must be between 1 and %d", MaxSimilarLimit))
+	}
+
+	source, err := s.BookRepository.GetByID(ctx, id, nil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrNotFound("book not found")
+		}
+		log.Error().Err(err).Msg("failed to get book for similar books")
+		return nil, err
+	}
+
+	snapshot := s.SimilarIndex.snapshot.Load()
+	index := snapshot.indexes[source.TenantID]
+	if index == nil {
+		return []book.ScoredBook{}, nil
+	}
+	// Ask for spare candidates: some may have been changed or deleted since
+	// the index was built and are dropped below.
+	matches := index.Similar(similarityTerms(source), 2*q.Limit, func(candidate int64) bool {
+		return candidate != id && q.Includes(snapshot.years[candidate])
+	})
+	if len(matches) == 0 {
+		return []book.ScoredBook{}, nil
+	}
+
+	ids := make([]int64, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	books, err := s.BookRepository.GetByIDs(ctx, ids, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get similar books")
+		return nil, err
+	}
+	byID := make(map[int64]book.Book, len(books))
+	for _, b := range books {
+		byID[b.ID] = b
+	}
+
+	similar := []book.ScoredBook{}
+	for _, m := range matches {
+		b, ok := byID[m.ID]
+		if !ok || !q.Includes(b.PublishedYear) {
+			continue
+		}
+		similar = append(similar, book.ScoredBook{Book: b, Score: m.Score})
+		if len(similar) == q.Limit {
+			break
+		}
+	}
+
+	return similar, nil
+}
+
+// ChangeHorizon returns the transaction ID horizon the changes to books are
+// committed up to, for bounding the windows of MatchingSince.
+func (s *Book) ChangeHorizon(ctx context.Context) (int64, error) {
+	horizon, err := s.BookRepository.SyncHorizon(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("service", "book").Msg("failed to get change horizon")
+		return 0, err
+	}
+	return horizon, nil
+}
+
+// MatchingSince returns the live books matching the search query, if any,
+// and f whose latest change was written by a transaction from the horizon
+// since up to, but not including, the horizon until, oldest change first.
+// Consecutive windows thus report every change once, whenever it commits.
+func (s *Book) MatchingSince(ctx context.Context, query string, f book.Filter, since, until int64) ([]book.Book, error) {
+	var books []book.Book
+	err := internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		var err error
+		books, err = s.BookRepository.ChangedSince(ctx, f, strings.TrimSpace(query), since, until, s.SearchConfig.matchThreshold())
+		return err
+	})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("service", "book").Msg("failed to get matching books")
+		return nil, err
+	}
+	return books, nil
+}
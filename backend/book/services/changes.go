@@ -0,0 +1,60 @@
+package services
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Changes returns the books created, updated or deleted since the sync token
+// since, up to limit per page. An empty since starts a full sync of the live
+// books.
+func (s *Book) Changes(ctx context.Context, since string, limit int) (*book.ChangeSet, error) {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, err
+	}
+
+	token, err := book.ParseSyncToken(since)
+	if err != nil {
+		return nil, helper.NewErrValidation("since", helper.CodeInvalid, err.Error())
+	}
+	if limit == 0 {
+		limit = DefaultChangesLimit
+	}
+	if limit < 0 || limit > MaxChangesLimit {
+		return nil, helper.NewErrValidation("limit", helper.CodeOutOfRange, fmt.Sprintf("limit must be between 1 and %d", MaxChangesLimit))
+	}
+
+	// The horizon is taken before reading so that a transaction still
+	// running, whose changes the read cannot see, is at or after it and
+	// read again by the next sync. A paged sync keeps the horizon of its
+	// first page for the same reason.
+	if token.Horizon == 0 {
+		if token.Horizon, err = s.BookRepository.SyncHorizon(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to start sync")
+			return nil, err
+		}
+	}
+
+	changes, err := s.BookRepository.Changes(ctx, token.Since, token.AfterSeq, limit+1)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get book changes")
+		return nil, err
+	}
+
+	set := &book.ChangeSet{Changes: changes}
+	if len(changes) > limit {
+		set.Changes, set.HasMore = changes[:limit], true
+		set.NextToken = book.SyncToken{Since: token.Since, AfterSeq: changes[limit-1].Seq, Horizon: token.Horizon}.String()
+	} else {
+		set.NextToken = book.SyncToken{Since: token.Horizon}.String()
+	}
+
+	return set, nil
+}
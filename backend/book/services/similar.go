@@ -0,0 +1,104 @@
+package services
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/internal/search"
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SimilarIndex holds a TF-IDF index over the live books of each tenant, so
+// neither the matches nor the term weights of one tenant depend on another's
+// books. It is rebuilt as a whole, periodically, so results may lag writes by
+// one refresh interval; Book.Similar re-reads the matched books so stale
+// entries are never returned.
+type SimilarIndex struct {
+	snapshot atomic.Pointer[similarSnapshot]
+}
+
+// similarSnapshot holds the index of each tenant and the published year of
+// every indexed book.
+type similarSnapshot struct {
+	indexes map[int64]*search.SimilarityIndex
+	years   map[int64]int
+}
+
+func NewSimilarIndex() *SimilarIndex {
+	x := &SimilarIndex{}
+	x.snapshot.Store(&similarSnapshot{indexes: map[int64]*search.SimilarityIndex{}, years: map[int64]int{}})
+	return x
+}
+
+// Len returns the number of books in the current index.
+func (x *SimilarIndex) Len() int {
+	n := 0
+	for _, index := range x.snapshot.Load().indexes {
+		n += index.Len()
+	}
+	return n
+}
+
+// similarityTerms describes a book for the similarity index: the words and
+// CJK bigrams of its title, and those of its author in their own namespace
+// so a shared author weighs differently from a shared title word.
+func similarityTerms(b *book.Book) []string {
+	terms := search.QueryTokens(b.Title)
+	for _, token := range search.QueryTokens(b.Author) {
+		terms = append(terms, "author:"+token)
+	}
+	return terms
+}
+
+// RebuildSimilarIndex replaces the similarity index with one built from
+// every live book.
+func (s *Book) RebuildSimilarIndex(ctx context.Context) error {
+	if s.SimilarIndex == nil {
+		return nil
+	}
+
+	docs := map[int64][]search.Document{}
+	years := map[int64]int{}
+	err := s.BookRepository.EachLive(ctx, func(b *book.Book) error {
+		docs[b.TenantID] = append(docs[b.TenantID], search.Document{ID: b.ID, Terms: similarityTerms(b)})
+		years[b.ID] = b.PublishedYear
+		return nil
+	})
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("service", "book").Msg("failed to rebuild similar index")
+		return err
+	}
+
+	indexes := make(map[int64]*search.SimilarityIndex, len(docs))
+	for tenantID, tenantDocs := range docs {
+		indexes[tenantID] = search.NewSimilarityIndex(tenantDocs)
+	}
+	s.SimilarIndex.snapshot.Store(&similarSnapshot{indexes: indexes, years: years})
+	return nil
+}
+
+// RefreshSimilarIndex rebuilds the similarity index every interval until ctx
+// is done.
+func (s *Book) RefreshSimilarIndex(ctx context.Context, interval time.Duration) {
+	if s.SimilarIndex == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.RebuildSimilarIndex(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to refresh similar index")
+				continue
+			}
+			log.Debug().Int("books", s.SimilarIndex.Len()).Msg("similar index refreshed")
+		}
+	}
+}
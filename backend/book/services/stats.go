@@ -0,0 +1,109 @@
+package services
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultStatsBuckets is the length of the timeline when no start is given.
+var defaultStatsBuckets = map[string]int{
+	book.StatsIntervalDay:   30,
+	book.StatsIntervalWeek:  12,
+	book.StatsIntervalMonth: 12,
+}
+
+// Stats returns the catalog statistics. The timeline defaults to monthly
+// buckets ending now.
+func (s *Book) Stats(ctx context.Context, q book.StatsQuery) (*book.Stats, error) {
+	log := log.Ctx(ctx).With().Str("service", "book").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadBooks); err != nil {
+		return nil, err
+	}
+
+	if q.Interval == "" {
+		q.Interval = book.StatsIntervalMonth
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		q.From = statsStart(q.Interval, q.To)
+	}
+	if q.TopAuthors == 0 {
+		q.TopAuthors = DefaultStatsTopAuthors
+	}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	if q.TopAuthors < 0 || q.TopAuthors > MaxStatsTopAuthors {
+		return nil, helper.NewErrValidation("top_authors", helper.CodeOutOfRange, fmt.Sprintf("top_authors must be between 1 and %d", MaxStatsTopAuthors))
+	}
+	if q.Buckets() > MaxStatsBuckets {
+		return nil, helper.NewErrValidation("from", helper.CodeOutOfRange, fmt.Sprintf("from and to must span at most %d buckets of a %s", MaxStatsBuckets, q.Interval))
+	}
+
+	stats, err := s.BookRepository.Stats(ctx, q, s.MaterializedStats)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get book stats")
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// statsStart returns the start of the default timeline ending at to.
+func statsStart(interval string, to time.Time) time.Time {
+	n := defaultStatsBuckets[interval] - 1
+	to = to.UTC()
+	switch interval {
+	case book.StatsIntervalDay:
+		return to.AddDate(0, 0, -n)
+	case book.StatsIntervalWeek:
+		return to.AddDate(0, 0, -7*n)
+	default:
+		// Step back from the first of the month so short months do not
+		// skip a bucket.
+		first := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return first.AddDate(0, -n, 0)
+	}
+}
+
+// RefreshStats refreshes the statistics materialized views every interval
+// until ctx is done. It does nothing unless MaterializedStats is set.
+func (s *Book) RefreshStats(ctx context.Context, interval time.Duration) {
+	if !s.MaterializedStats {
+		return
+	}
+
+	refresh := func() {
+		refreshedAt, err := s.BookRepository.RefreshStats(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to refresh book stats")
+			return
+		}
+		log.Debug().Time("refreshed_at", refreshedAt).Msg("book stats refreshed")
+	}
+
+	// The views are as old as the last refresh, possibly from a previous
+	// run, so bring them up to date first.
+	refresh()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/internal/search"
+	"strconv"
+	"sync"
+)
+
+// Fields that can be completed by Book.Suggest.
+const (
+	SuggestFieldTitle  = "title"
+	SuggestFieldAuthor = "author"
+)
+
+// SuggestIndex keeps title and author completions for every live book in
+// memory, apart for each tenant. Titles rank by recency of their last
+// update, authors by how many books they have. It only sees writes made
+// through this process, so each replica keeps its own index.
+type SuggestIndex struct {
+	mu      sync.RWMutex
+	tenants map[int64]*tenantSuggestions
+}
+
+type tenantSuggestions struct {
+	titles  *search.PrefixIndex
+	authors *search.PrefixIndex
+}
+
+func NewSuggestIndex() *SuggestIndex {
+	return &SuggestIndex{tenants: map[int64]*tenantSuggestions{}}
+}
+
+// tenant returns the completions of tenantID, creating them when create is
+// set and returning nil otherwise.
+func (x *SuggestIndex) tenant(tenantID int64, create bool) *tenantSuggestions {
+	x.mu.RLock()
+	t := x.tenants[tenantID]
+	x.mu.RUnlock()
+	if t != nil || !create {
+		return t
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if t = x.tenants[tenantID]; t == nil {
+		t = &tenantSuggestions{
+			titles:  search.NewPrefixIndex(search.RankByScore),
+			authors: search.NewPrefixIndex(search.RankByCount),
+		}
+		x.tenants[tenantID] = t
+	}
+	return t
+}
+
+// Put indexes a live book for its tenant, replacing its previous title and
+// author.
+func (x *SuggestIndex) Put(b *book.Book) {
+	t := x.tenant(b.TenantID, true)
+	id := strconv.FormatInt(b.ID, 10)
+	t.titles.Put(id, b.Title, float64(b.UpdatedAt.Unix()))
+	t.authors.Put(id, b.Author, 0)
+}
+
+// Remove drops a book of tenantID that was deleted or merged.
+func (x *SuggestIndex) Remove(tenantID, id int64) {
+	t := x.tenant(tenantID, false)
+	if t == nil {
+		return
+	}
+	key := strconv.FormatInt(id, 10)
+	t.titles.Remove(key)
+	t.authors.Remove(key)
+}
+
+// Len returns the number of indexed books of every tenant.
+func (x *SuggestIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	n := 0
+	for _, t := range x.tenants {
+		n += t.titles.Len()
+	}
+	return n
+}
+
+func (x *SuggestIndex) lookup(tenantID int64, field, prefix string, limit int) []search.Suggestion {
+	t := x.tenant(tenantID, false)
+	if t == nil {
+		return []search.Suggestion{}
+	}
+	if field == SuggestFieldAuthor {
+		return t.authors.Lookup(prefix, limit)
+	}
+	return t.titles.Lookup(prefix, limit)
+}
@@ -0,0 +1,121 @@
+package book
+
+import (
+	"byfood-interview/helper"
+	"fmt"
+	"time"
+)
+
+// Intervals for bucketing additions and deletions over time.
+const (
+	StatsIntervalDay   = "day"
+	StatsIntervalWeek  = "week"
+	StatsIntervalMonth = "month"
+)
+
+// Sources of the catalog statistics.
+const (
+	StatsSourceLive         = "live"
+	StatsSourceMaterialized = "materialized"
+)
+
+// StatsQuery selects the timeline and top authors of the catalog
+// statistics. The timeline covers the buckets of Interval from the one
+// containing From to the one containing To, in UTC.
+type StatsQuery struct {
+	Interval   string
+	From       time.Time
+	To         time.Time
+	TopAuthors int
+}
+
+func (q *StatsQuery) Validate() error {
+	var v helper.Validator
+	switch q.Interval {
+	case StatsIntervalDay, StatsIntervalWeek, StatsIntervalMonth:
+	default:
+		v.Add("interval", helper.CodeInvalid,
+			fmt.Sprintf("interval must be one of: %s, %s, %s", StatsIntervalDay, StatsIntervalWeek, StatsIntervalMonth))
+	}
+	v.Check(!q.From.After(q.To), "from", helper.CodeOutOfRange, "from must not be after to")
+	return v.Err()
+}
+
+// Buckets returns the number of timeline buckets q spans.
+func (q *StatsQuery) Buckets() int {
+	from, to := q.From.UTC(), q.To.UTC()
+	switch q.Interval {
+	case StatsIntervalDay:
+		return int(truncateDay(to).Sub(truncateDay(from))/(24*time.Hour)) + 1
+	case StatsIntervalWeek:
+		return int(truncateWeek(to).Sub(truncateWeek(from))/(7*24*time.Hour)) + 1
+	default:
+		return (to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1
+	}
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// truncateWeek returns the Monday starting the ISO week of t, as Postgres'
+// date_trunc('week', ...) does.
+func truncateWeek(t time.Time) time.Time {
+	return truncateDay(t).AddDate(0, 0, -(int(t.Weekday())+6)%7)
+}
+
+// YearCount is the number of live books published in a year.
+type YearCount struct {
+	Year  int   `json:"year" db:"published_year"`
+	Count int64 `json:"count" db:"count"`
+}
+
+// DecadeCount is the number of live books published in a decade, e.g. 1990.
+type DecadeCount struct {
+	Decade int   `json:"decade"`
+	Count  int64 `json:"count"`
+}
+
+// AuthorCount is the number of live books by an author.
+type AuthorCount struct {
+	Author string `json:"author" db:"author"`
+	Count  int64  `json:"count" db:"count"`
+}
+
+// PeriodCount is the number of books added and deleted in the bucket
+// starting at Period.
+type PeriodCount struct {
+	Period  time.Time `json:"period" db:"period"`
+	Added   int64     `json:"added" db:"added"`
+	Deleted int64     `json:"deleted" db:"deleted"`
+}
+
+// Stats summarizes the catalog. Histograms only count live books; merged
+// books count as deleted.
+type Stats struct {
+	Total           int64         `json:"total" db:"total"`
+	Deleted         int64         `json:"deleted" db:"deleted"`
+	ByPublishedYear []YearCount   `json:"by_published_year"`
+	ByDecade        []DecadeCount `json:"by_decade"`
+	TopAuthors      []AuthorCount `json:"top_authors"`
+	Interval        string        `json:"interval"`
+	Timeline        []PeriodCount `json:"timeline"`
+	// Source tells whether the figures were computed on request or read
+	// from materialized views last refreshed at GeneratedAt.
+	Source      string    `json:"source"`
+	GeneratedAt time.Time `json:"generated_at" db:"generated_at"`
+}
+
+// CountDecades fills ByDecade from ByPublishedYear, which must be sorted by
+// year.
+func (s *Stats) CountDecades() {
+	s.ByDecade = []DecadeCount{}
+	for _, y := range s.ByPublishedYear {
+		decade := y.Year / 10 * 10
+		if n := len(s.ByDecade); n > 0 && s.ByDecade[n-1].Decade == decade {
+			s.ByDecade[n-1].Count += y.Count
+			continue
+		}
+		s.ByDecade = append(s.ByDecade, DecadeCount{Decade: decade, Count: y.Count})
+	}
+}
@@ -2,12 +2,43 @@ package stores
 
 import (
 	"byfood-interview/book"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/internal/search"
+	"byfood-interview/tenant"
 	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 )
 
+// bookColumns lists the columns of a book.Book so reads and writes return
+// the authoritative row, timestamps included.
+const bookColumns = "id, title, author, published_year, created_at, updated_at, deleted_at, merged_into, tenant_id"
+
+// prefixedBookColumns selects bookColumns from table alias as "prefix.column"
+// so sqlx can scan them into a nested book.Book.
+func prefixedBookColumns(alias, prefix string) string {
+	columns := strings.Split(bookColumns, ", ")
+	for i, column := range columns {
+		columns[i] = fmt.Sprintf(`%s.%s AS "%s.%s"`, alias, column, prefix, column)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// selectColumns returns the columns to read for fields: all of bookColumns
+// unless a sparse fieldset is requested.
+func selectColumns(fields book.Fields) string {
+	if columns := fields.Columns(); columns != nil {
+		return strings.Join(columns, ", ")
+	}
+	return bookColumns
+}
+
 type Book struct {
 	db *sqlx.DB
 }
@@ -16,50 +47,338 @@ func NewBook(db *sqlx.DB) *Book {
 	return &Book{db: db}
 }
 
-func (b *Book) GetByID(ctx context.Context, id int64) (*book.Book, error) {
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (b *Book) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, b.db)
+}
+
+// scoped runs fn for the tenant on ctx with row-level security limited to
+// that tenant, on the transaction or tenant connection carried on ctx when
+// there is one. Every query still filters on the tenant itself, so
+// isolation does not hinge on the database role the server runs as.
+func (b *Book) scoped(ctx context.Context, fn func(ctx context.Context, tenantID int64) error) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+	return internalDb.ForTenant(ctx, b.db, tenantID, func(ctx context.Context) error {
+		return fn(ctx, tenantID)
+	})
+}
+
+// scopedTx is scoped for statements that must share a transaction, which
+// is opened unless ctx already carries one.
+func (b *Book) scopedTx(ctx context.Context, fn func(ctx context.Context, tenantID int64) error) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+	return internalDb.WithinTenant(ctx, b.db, tenantID, func(ctx context.Context) error {
+		return fn(ctx, tenantID)
+	})
+}
+
+// GetByID returns the live book id with only the columns of fields read.
+func (b *Book) GetByID(ctx context.Context, id int64, fields book.Fields) (*book.Book, error) {
+	var bookData book.Book
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "SELECT " + selectColumns(fields) + " FROM books WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL"
+		return b.conn(ctx).GetContext(ctx, &bookData, query, id, tenantID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bookData, nil
+}
+
+// GetByIDForUpdate is GetByID with the row locked until the surrounding
+// transaction ends. It must be called inside a transaction.
+func (b *Book) GetByIDForUpdate(ctx context.Context, id int64) (*book.Book, error) {
 	var bookData book.Book
-	query := "SELECT id, title, author, published_year FROM books WHERE id = $1 AND deleted_at IS NULL"
-	err := b.db.GetContext(ctx, &bookData, query, id)
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "SELECT " + bookColumns + " FROM books WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL FOR UPDATE"
+		return b.conn(ctx).GetContext(ctx, &bookData, query, id, tenantID)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &bookData, nil
 }
 
-func (b *Book) GetAll(ctx context.Context) ([]book.Book, error) {
+// GetByIDs returns the live books among ids, in no particular order, with
+// only the columns of fields read.
+func (b *Book) GetByIDs(ctx context.Context, ids []int64, fields book.Fields) ([]book.Book, error) {
+	books := []book.Book{}
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "SELECT " + selectColumns(fields) + " FROM books WHERE id = ANY($1) AND tenant_id = $2 AND deleted_at IS NULL"
+		return b.conn(ctx).SelectContext(ctx, &books, query, pq.Array(ids), tenantID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// GetAll returns the live books matching f, newest first, with only the
+// columns of fields read.
+func (b *Book) GetAll(ctx context.Context, f book.Filter, fields book.Fields) ([]book.Book, error) {
 	var books []book.Book
-	query := "SELECT id, title, author, published_year FROM books WHERE deleted_at IS NULL ORDER BY created_at DESC"
-	err := b.db.SelectContext(ctx, &books, query)
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		where, args := whereClause(tenantID, f, "")
+		query := sqlx.Rebind(sqlx.DOLLAR, "SELECT "+selectColumns(fields)+" FROM books WHERE "+where+" ORDER BY created_at DESC")
+		return b.conn(ctx).SelectContext(ctx, &books, query, args...)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return books, nil
 }
 
-func (b *Book) Create(ctx context.Context, bookData *book.Book) (id int64, err error) {
-	query := "INSERT INTO books (title, author, published_year) VALUES ($1, $2, $3) RETURNING id"
-	err = b.db.QueryRowContext(ctx, query, bookData.Title, bookData.Author, bookData.PublishedYear).Scan(&id)
+func (b *Book) Create(ctx context.Context, bookData *book.Book) (*book.Book, error) {
+	var created book.Book
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "INSERT INTO books (title, author, published_year, title_tokens, author_tokens, tenant_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + bookColumns
+		return b.conn(ctx).GetContext(ctx, &created, query, bookData.Title, bookData.Author, bookData.PublishedYear,
+			pq.Array(search.IndexTokens(bookData.Title)), pq.Array(search.IndexTokens(bookData.Author)), tenantID)
+	})
 	if err != nil {
 		log.Error().Err(err).Msg("failed to insert book")
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// Update applies the non-zero fields of bookData to a live book in a single
+// statement and returns the stored row. It returns sql.ErrNoRows when the
+// book does not exist or has been deleted.
+func (b *Book) Update(ctx context.Context, bookData *book.Book) (*book.Book, error) {
+	var updated book.Book
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := `UPDATE books SET
+			title = COALESCE(NULLIF($1, ''), title),
+			author = COALESCE(NULLIF($2, ''), author),
+			published_year = COALESCE(NULLIF($3, 0), published_year),
+			title_tokens = CASE WHEN $1 = '' THEN title_tokens ELSE $5::TEXT[] END,
+			author_tokens = CASE WHEN $2 = '' THEN author_tokens ELSE $6::TEXT[] END,
+			updated_at = NOW()
+		WHERE id = $4 AND tenant_id = $7 AND deleted_at IS NULL
+		RETURNING ` + bookColumns
+		return b.conn(ctx).GetContext(ctx, &updated, query, bookData.Title, bookData.Author, bookData.PublishedYear, bookData.ID,
+			pq.Array(search.IndexTokens(bookData.Title)), pq.Array(search.IndexTokens(bookData.Author)), tenantID)
+	})
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Error().Err(err).Msg("failed to update book")
+		}
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// Delete soft-deletes a live book and returns the deleted row. It returns
+// sql.ErrNoRows when the book does not exist or is already deleted.
+func (b *Book) Delete(ctx context.Context, id int64) (*book.Book, error) {
+	var deleted book.Book
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "UPDATE books SET deleted_at = NOW() WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL RETURNING " + bookColumns
+		return b.conn(ctx).GetContext(ctx, &deleted, query, id, tenantID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+// GetMergedInto returns the ID of the book that id was merged into. It
+// returns sql.ErrNoRows when id was never merged.
+func (b *Book) GetMergedInto(ctx context.Context, id int64) (int64, error) {
+	var survivorID int64
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "SELECT merged_into FROM books WHERE id = $1 AND tenant_id = $2 AND merged_into IS NOT NULL"
+		return b.conn(ctx).GetContext(ctx, &survivorID, query, id, tenantID)
+	})
+	if err != nil {
 		return 0, err
 	}
+	return survivorID, nil
+}
 
-	return id, nil
+// FindDuplicates returns pairs of live books whose normalized titles and
+// authors are similar and whose published years are close, best match first.
+func (b *Book) FindDuplicates(ctx context.Context, q book.DuplicateQuery) ([]book.DuplicateCandidate, error) {
+	candidates := []book.DuplicateCandidate{}
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := `SELECT * FROM (
+			SELECT ` + prefixedBookColumns("a", "book") + `, ` + prefixedBookColumns("d", "duplicate") + `,
+				(similarity(normalize_book_text(a.title), normalize_book_text(d.title))
+					+ similarity(normalize_book_text(a.author), normalize_book_text(d.author))) / 2 AS score
+			FROM books a
+			JOIN books d ON a.id < d.id
+				AND d.tenant_id = a.tenant_id
+				AND normalize_book_text(a.title) % normalize_book_text(d.title)
+				AND abs(a.published_year - d.published_year) <= $1
+			WHERE a.tenant_id = $4 AND a.deleted_at IS NULL AND d.deleted_at IS NULL
+		) candidates
+		WHERE score >= $2
+		ORDER BY score DESC
+		LIMIT $3`
+		return b.conn(ctx).SelectContext(ctx, &candidates, query, q.YearWindow, q.Threshold, q.Limit, tenantID)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to find duplicate books")
+		return nil, err
+	}
+	return candidates, nil
 }
 
-func (b *Book) Update(ctx context.Context, bookData *book.Book) error {
-	query := "UPDATE books SET title = $1, author = $2, published_year = $3, updated_at = NOW() WHERE id = $4"
-	_, err := b.db.ExecContext(ctx, query, bookData.Title, bookData.Author, bookData.PublishedYear, bookData.ID)
+// MergeInto soft-deletes the live book sourceID, records targetID as its
+// survivor and re-points every row that referenced sourceID to targetID.
+// It returns sql.ErrNoRows when sourceID is not a live book.
+func (b *Book) MergeInto(ctx context.Context, sourceID, targetID int64) (*book.Book, error) {
+	var merged book.Book
+	err := b.scopedTx(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "UPDATE books SET deleted_at = NOW(), merged_into = $2 WHERE id = $1 AND tenant_id = $3 AND deleted_at IS NULL RETURNING " + bookColumns
+		if err := b.conn(ctx).GetContext(ctx, &merged, query, sourceID, targetID, tenantID); err != nil {
+			return err
+		}
+
+		// Books previously merged into the source now resolve to the target.
+		query = "UPDATE books SET merged_into = $2 WHERE merged_into = $1 AND tenant_id = $3"
+		if _, err := b.conn(ctx).ExecContext(ctx, query, sourceID, targetID, tenantID); err != nil {
+			log.Error().Err(err).Msg("failed to re-point merged books")
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		log.Error().Err(err).Msg("failed to update book")
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &merged, nil
+}
+
+// Search returns live books matching query and f, scored by trigram word
+// similarity of the normalized title or author. Books whose search tokens
+// contain every token of the query also match, with a score of 1, so CJK
+// and mixed-script queries find books the trigram match misses. The
+// threshold is applied through pg_trgm.word_similarity_threshold so the
+// trigram indexes are used; the setting lasts until the transaction the
+// search runs in ends. Only the columns of fields are read.
+func (b *Book) Search(ctx context.Context, query string, f book.Filter, threshold float64, limit int, fields book.Fields) ([]book.ScoredBook, error) {
+	results := []book.ScoredBook{}
+	err := b.scopedTx(ctx, func(ctx context.Context, tenantID int64) error {
+		if err := b.setSearchThreshold(ctx, threshold); err != nil {
+			return err
+		}
+
+		score, args := searchScore(query)
+		where, whereArgs := whereClause(tenantID, f, "")
+		condition, conditionArgs := searchCondition(query)
+		args = append(append(append(args, whereArgs...), conditionArgs...), limit)
+
+		statement := sqlx.Rebind(sqlx.DOLLAR, `SELECT `+selectColumns(fields)+`, `+score+` AS score
+		FROM books
+		WHERE `+where+` AND `+condition+`
+		ORDER BY score DESC, id
+		LIMIT ?`)
+		return b.conn(ctx).SelectContext(ctx, &results, statement, args...)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to search books")
+		return nil, err
+	}
+	return results, nil
 }
 
-func (b *Book) Delete(ctx context.Context, id int64) error {
-	query := "UPDATE books SET deleted_at = NOW() WHERE id = $1"
-	_, err := b.db.ExecContext(ctx, query, id)
+// setSearchThreshold sets pg_trgm.word_similarity_threshold for the rest of
+// the current transaction.
+func (b *Book) setSearchThreshold(ctx context.Context, threshold float64) error {
+	_, err := b.conn(ctx).ExecContext(ctx, "SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)", strconv.FormatFloat(threshold, 'f', -1, 64))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to set search threshold")
+	}
 	return err
 }
+
+// Suggest returns the live title or author most similar to query, or
+// sql.ErrNoRows when none reaches threshold.
+func (b *Book) Suggest(ctx context.Context, query string, threshold float64) (string, error) {
+	var suggestion string
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		statement := `SELECT term FROM (
+			SELECT title AS term, similarity(normalize_book_text(title), normalize_book_text($1)) AS score
+			FROM books WHERE tenant_id = $3 AND deleted_at IS NULL
+			UNION ALL
+			SELECT author AS term, similarity(normalize_book_text(author), normalize_book_text($1)) AS score
+			FROM books WHERE tenant_id = $3 AND deleted_at IS NULL
+		) terms
+		WHERE score >= $2
+		ORDER BY score DESC, term
+		LIMIT 1`
+		return b.conn(ctx).GetContext(ctx, &suggestion, statement, query, threshold, tenantID)
+	})
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Error().Err(err).Msg("failed to suggest search term")
+		}
+		return "", err
+	}
+	return suggestion, nil
+}
+
+// BackfillSearchTokens computes search tokens for up to batchSize books of
+// any tenant that have none yet, such as rows written before tokens
+// existed. It returns the number of books updated.
+func (b *Book) BackfillSearchTokens(ctx context.Context, batchSize int) (int, error) {
+	var pending []book.Book
+	err := internalDb.WithinAllTenants(ctx, b.db, func(ctx context.Context) error {
+		query := "SELECT id, title, author FROM books WHERE title_tokens IS NULL OR author_tokens IS NULL ORDER BY id LIMIT $1"
+		if err := b.conn(ctx).SelectContext(ctx, &pending, query, batchSize); err != nil {
+			return err
+		}
+
+		query = "UPDATE books SET title_tokens = $2, author_tokens = $3 WHERE id = $1"
+		for _, p := range pending {
+			_, err := b.conn(ctx).ExecContext(ctx, query, p.ID,
+				pq.Array(search.IndexTokens(p.Title)), pq.Array(search.IndexTokens(p.Author)))
+			if err != nil {
+				log.Error().Err(err).Int64("book_id", p.ID).Msg("failed to backfill search tokens")
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(pending), nil
+}
+
+// EachLive calls fn for every live book of every tenant, streaming rows
+// instead of loading the whole table. It is meant for in-memory indexes,
+// which partition the books by their TenantID.
+func (b *Book) EachLive(ctx context.Context, fn func(*book.Book) error) error {
+	return internalDb.WithinAllTenants(ctx, b.db, func(ctx context.Context) error {
+		rows, err := b.conn(ctx).QueryxContext(ctx, "SELECT "+bookColumns+" FROM books WHERE deleted_at IS NULL")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var bookData book.Book
+			if err := rows.StructScan(&bookData); err != nil {
+				return err
+			}
+			if err := fn(&bookData); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
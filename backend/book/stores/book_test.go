@@ -2,8 +2,12 @@ package stores
 
 import (
 	"byfood-interview/book"
+	internalDb "byfood-interview/internal/db"
 	"byfood-interview/migration"
+	"byfood-interview/tenant"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -24,8 +28,8 @@ func postgresC(ctx context.Context) (testcontainers.Container, error) {
 		ExposedPorts: []string{"5432/tcp"},
 		Env: map[string]string{
 			"POSTGRES_DB":       "book",
-			"POSTGRES_USER":     "book",
-			"POSTGRES_PASSWORD": "book",
+			"POSTGRES_USER":     "postgres",
+			"POSTGRES_PASSWORD": "postgres",
 		},
 		WaitingFor: wait.ForLog("database system is ready to accept connections").WithPollInterval(1 * time.Second),
 	}
@@ -48,19 +52,36 @@ func dbFromContainer(ctx context.Context, container testcontainers.Container) (*
 
 	log.Debug().Msgf("%v %v", host, port.Port())
 
-	dsn := fmt.Sprintf("postgres://book:book@%s:%s/book?sslmode=disable",
-		host,
-		port.Port())
-
-	var db *sqlx.DB
+	var admin *sqlx.DB
 
 	for i := 0; i < 5; i++ {
-		db, err = sqlx.Connect("postgres", dsn)
+		admin, err = sqlx.Connect("postgres", fmt.Sprintf("postgres://postgres:postgres@%s:%s/book?sslmode=disable", host, port.Port()))
 		if err == nil {
 			break
 		}
 		time.Sleep(2 * time.Second)
 	}
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+
+	// The stores run as an ordinary role owning the database, as the server
+	// does, so row-level security binds them.
+	for _, statement := range []string{
+		"CREATE ROLE book LOGIN PASSWORD 'book' NOSUPERUSER NOBYPASSRLS",
+		"ALTER DATABASE book OWNER TO book",
+		"ALTER SCHEMA public OWNER TO book",
+	} {
+		if _, err := admin.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create role: %w", err)
+		}
+	}
+
+	db, err := sqlx.Connect("postgres", fmt.Sprintf("postgres://book:book@%s:%s/book?sslmode=disable", host, port.Port()))
+	if err != nil {
+		return nil, err
+	}
 
 	migration := migration.NewMigration(db)
 	if err := migration.Run("../../migration/file"); err != nil {
@@ -101,8 +122,25 @@ func TestMain(m *testing.M) {
 	os.Exit(exitCode)
 }
 
+// defaultTenant returns a context acting for the default tenant, which owns
+// the books the migrations seed.
+func defaultTenant() context.Context {
+	return tenant.WithTenant(context.Background(), &tenant.Tenant{ID: tenant.DefaultID, Slug: tenant.DefaultSlug})
+}
+
+// newTenant creates a tenant and returns a context acting for it.
+func newTenant(t *testing.T, slug string) context.Context {
+	t.Helper()
+	created := tenant.Tenant{Slug: slug, Name: slug}
+	err := testDB.Get(&created.ID, "INSERT INTO tenants (slug, name) VALUES ($1, $2) RETURNING id", created.Slug, created.Name)
+	if err != nil {
+		t.Fatalf("failed to create tenant %s: %v", slug, err)
+	}
+	return tenant.WithTenant(context.Background(), &created)
+}
+
 func TestNewBook(t *testing.T) {
-	ctx := context.TODO()
+	ctx := defaultTenant()
 
 	bookStore := NewBook(testDB)
 
@@ -112,22 +150,26 @@ func TestNewBook(t *testing.T) {
 		PublishedYear: 2023,
 	}
 
-	id, err := bookStore.Create(ctx, &book)
+	created, err := bookStore.Create(ctx, &book)
 	if err != nil {
 		t.Fatalf("failed to create book: %v", err)
 	}
 
-	if id == 0 {
+	if created.ID == 0 {
 		t.Fatal("expected book ID to be non-zero")
 	}
+
+	if created.CreatedAt.IsZero() {
+		t.Fatal("expected created_at to be returned")
+	}
 }
 
 func TestGetByID(t *testing.T) {
-	ctx := context.TODO()
+	ctx := defaultTenant()
 
 	bookStore := NewBook(testDB)
 
-	book, err := bookStore.GetByID(ctx, 1)
+	book, err := bookStore.GetByID(ctx, 1, nil)
 	if err != nil {
 		t.Fatalf("failed to get book by ID: %v", err)
 	}
@@ -141,12 +183,48 @@ func TestGetByID(t *testing.T) {
 	}
 }
 
+func TestSparseFieldsAreRead(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+	fields := book.Fields{"id", "title"}
+
+	created, err := bookStore.Create(ctx, &book.Book{Title: "The Wind-Up Bird Chronicle", Author: "Haruki Murakami", PublishedYear: 1994})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	fetched, err := bookStore.GetByID(ctx, created.ID, fields)
+	if err != nil {
+		t.Fatalf("failed to get book by ID: %v", err)
+	}
+	if fetched.Title != created.Title || fetched.Author != "" || fetched.PublishedYear != 0 || !fetched.CreatedAt.IsZero() {
+		t.Errorf("expected only id and title read, got %+v", fetched)
+	}
+
+	var results []book.ScoredBook
+	err = internalDb.NewTransactor(testDB).WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		results, err = bookStore.Search(ctx, "Wind-Up Bird", book.Filter{}, 0.45, 10, fields)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to search books: %v", err)
+	}
+	if len(results) == 0 || results[0].ID != created.ID {
+		t.Fatalf("expected to find book %d, got %+v", created.ID, results)
+	}
+	if results[0].Author != "" || results[0].Score <= 0 {
+		t.Errorf("expected only id, title and score read, got %+v", results[0])
+	}
+}
+
 func TestGetAll(t *testing.T) {
-	ctx := context.TODO()
+	ctx := defaultTenant()
 
 	bookStore := NewBook(testDB)
 
-	books, err := bookStore.GetAll(ctx)
+	books, err := bookStore.GetAll(ctx, book.Filter{}, nil)
 	if err != nil {
 		t.Fatalf("failed to get all books: %v", err)
 	}
@@ -157,7 +235,7 @@ func TestGetAll(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	ctx := context.TODO()
+	ctx := defaultTenant()
 
 	bookStore := NewBook(testDB)
 
@@ -168,23 +246,55 @@ func TestUpdate(t *testing.T) {
 		PublishedYear: 2024,
 	}
 
-	err := bookStore.Update(ctx, &book)
+	updated, err := bookStore.Update(ctx, &book)
+	if err != nil {
+		t.Fatalf("failed to update book: %v", err)
+	}
+
+	if updated.Title != "Updated Book" {
+		t.Errorf("expected title %q, got %q", "Updated Book", updated.Title)
+	}
+}
+
+func TestUpdatePartial(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+
+	created, err := bookStore.Create(ctx, &book.Book{Title: "Partial", Author: "Author", PublishedYear: 2020})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	updated, err := bookStore.Update(ctx, &book.Book{ID: created.ID, Author: "New Author"})
 	if err != nil {
 		t.Fatalf("failed to update book: %v", err)
 	}
+
+	if updated.Title != "Partial" || updated.Author != "New Author" || updated.PublishedYear != 2020 {
+		t.Errorf("unexpected book after partial update: %+v", updated)
+	}
+
+	if !updated.UpdatedAt.After(created.UpdatedAt) {
+		t.Error("expected updated_at to advance")
+	}
 }
 
 func TestDelete(t *testing.T) {
-	ctx := context.TODO()
+	ctx := defaultTenant()
 
 	bookStore := NewBook(testDB)
 
-	err := bookStore.Delete(ctx, 1)
+	deleted, err := bookStore.Delete(ctx, 1)
 	if err != nil {
 		t.Fatalf("failed to delete book: %v", err)
 	}
 
-	book, err := bookStore.GetByID(ctx, 1)
+	if deleted.DeletedAt == nil {
+		t.Fatal("expected deleted_at to be set")
+	}
+
+	book, err := bookStore.GetByID(ctx, 1, nil)
 	if err != nil {
 		if err.Error() != "sql: no rows in result set" {
 			t.Fatalf("unexpected error when getting book by ID after deletion: %v", err)
@@ -194,4 +304,696 @@ func TestDelete(t *testing.T) {
 	if book != nil {
 		t.Fatal("expected book to be nil after deletion")
 	}
+
+	if _, err := bookStore.Delete(ctx, 1); err != sql.ErrNoRows {
+		t.Fatalf("expected deleting a deleted book to return sql.ErrNoRows, got %v", err)
+	}
+
+	deleted.Title = "Revived"
+	if _, err := bookStore.Update(ctx, deleted); err != sql.ErrNoRows {
+		t.Fatalf("expected updating a deleted book to return sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestWithinTransactionRollback(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+	transactor := internalDb.NewTransactor(testDB)
+
+	var created *book.Book
+	errAbort := errors.New("abort")
+	err := transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		created, err = bookStore.Create(ctx, &book.Book{Title: "Rolled Back", Author: "Author", PublishedYear: 2020})
+		if err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected abort error, got %v", err)
+	}
+
+	if _, err := bookStore.GetByID(ctx, created.ID, nil); err != sql.ErrNoRows {
+		t.Fatalf("expected book to be rolled back, got %v", err)
+	}
+}
+
+func TestWithinTransactionPanic(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+	transactor := internalDb.NewTransactor(testDB)
+
+	var created *book.Book
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic to be propagated")
+			}
+		}()
+		_ = transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			created, _ = bookStore.Create(ctx, &book.Book{Title: "Panicked", Author: "Author", PublishedYear: 2020})
+			panic("boom")
+		})
+	}()
+
+	if _, err := bookStore.GetByID(ctx, created.ID, nil); err != sql.ErrNoRows {
+		t.Fatalf("expected book to be rolled back, got %v", err)
+	}
+}
+
+func TestWithinTransactionSavepoint(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+	transactor := internalDb.NewTransactor(testDB)
+
+	var outer, inner *book.Book
+	err := transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		outer, err = bookStore.Create(ctx, &book.Book{Title: "Outer", Author: "Author", PublishedYear: 2020})
+		if err != nil {
+			return err
+		}
+
+		innerErr := transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			inner, err = bookStore.Create(ctx, &book.Book{Title: "Inner", Author: "Author", PublishedYear: 2020})
+			if err != nil {
+				return err
+			}
+			return errors.New("abort inner")
+		})
+		if innerErr == nil {
+			t.Fatal("expected inner transaction to fail")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to commit outer transaction: %v", err)
+	}
+
+	if _, err := bookStore.GetByID(ctx, outer.ID, nil); err != nil {
+		t.Fatalf("expected outer book to be committed: %v", err)
+	}
+	if _, err := bookStore.GetByID(ctx, inner.ID, nil); err != sql.ErrNoRows {
+		t.Fatalf("expected inner book to be rolled back, got %v", err)
+	}
+}
+
+func TestGetByIDForUpdate(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+	transactor := internalDb.NewTransactor(testDB)
+
+	created, err := bookStore.Create(ctx, &book.Book{Title: "Locked", Author: "Author", PublishedYear: 2020})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	id := created.ID
+
+	err = transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		data, err := bookStore.GetByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		data.Title = "Locked Updated"
+		_, err = bookStore.Update(ctx, data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to update book in transaction: %v", err)
+	}
+
+	data, err := bookStore.GetByID(ctx, id, nil)
+	if err != nil {
+		t.Fatalf("failed to get book by ID: %v", err)
+	}
+	if data.Title != "Locked Updated" {
+		t.Errorf("expected title %q, got %q", "Locked Updated", data.Title)
+	}
+}
+
+func TestFindDuplicatesAndMergeInto(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+
+	original, err := bookStore.Create(ctx, &book.Book{Title: "The Art of Ramen", Author: "Kenji Tanaka", PublishedYear: 2015})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	duplicate, err := bookStore.Create(ctx, &book.Book{Title: "The Art of Ramen!", Author: "kenji tanaka", PublishedYear: 2016})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	candidates, err := bookStore.FindDuplicates(ctx, book.DuplicateQuery{Threshold: 0.5, YearWindow: 1, Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+
+	found := false
+	for _, c := range candidates {
+		if c.Book.ID == original.ID && c.Duplicate.ID == duplicate.ID {
+			found = true
+			if c.Score < 0.5 {
+				t.Errorf("expected score >= 0.5, got %f", c.Score)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected pair (%d, %d) in duplicate candidates %+v", original.ID, duplicate.ID, candidates)
+	}
+
+	merged, err := bookStore.MergeInto(ctx, duplicate.ID, original.ID)
+	if err != nil {
+		t.Fatalf("failed to merge book: %v", err)
+	}
+	if merged.MergedInto == nil || *merged.MergedInto != original.ID {
+		t.Fatalf("expected merged_into to be %d, got %v", original.ID, merged.MergedInto)
+	}
+
+	survivorID, err := bookStore.GetMergedInto(ctx, duplicate.ID)
+	if err != nil {
+		t.Fatalf("failed to get merged into: %v", err)
+	}
+	if survivorID != original.ID {
+		t.Errorf("expected survivor %d, got %d", original.ID, survivorID)
+	}
+
+	if _, err := bookStore.GetMergedInto(ctx, original.ID); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows for a book that was not merged, got %v", err)
+	}
+}
+
+func TestSearchAndSuggest(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+	transactor := internalDb.NewTransactor(testDB)
+
+	created, err := bookStore.Create(ctx, &book.Book{Title: "Kafka on the Shore", Author: "Haruki Murakami", PublishedYear: 2002})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	var results []book.ScoredBook
+	err = transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		results, err = bookStore.Search(ctx, "murakmi", book.Filter{}, 0.45, 10, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to search books: %v", err)
+	}
+
+	if len(results) == 0 || results[0].ID != created.ID {
+		t.Fatalf("expected misspelled author to find book %d, got %+v", created.ID, results)
+	}
+	if results[0].Score <= 0 || results[0].Score > 1 {
+		t.Errorf("expected score in (0, 1], got %f", results[0].Score)
+	}
+
+	suggestion, err := bookStore.Suggest(ctx, "Kafka on the Shoar", 0.25)
+	if err != nil {
+		t.Fatalf("failed to suggest: %v", err)
+	}
+	if suggestion != "Kafka on the Shore" {
+		t.Errorf("expected suggestion %q, got %q", "Kafka on the Shore", suggestion)
+	}
+
+	if _, err := bookStore.Suggest(ctx, "zzzzqqqq", 0.25); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows for an unrelated term, got %v", err)
+	}
+}
+
+func TestSearchJapanese(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+	transactor := internalDb.NewTransactor(testDB)
+
+	created, err := bookStore.Create(ctx, &book.Book{Title: "ラーメンの歴史 Ramen History", Author: "山田太郎", PublishedYear: 2018})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	for _, query := range []string{"らーめん", "ﾗｰﾒﾝ", "歴史", "山田", "ＲＡＭＥＮ", "ramen 歴史"} {
+		var results []book.ScoredBook
+		err := transactor.WithinTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			results, err = bookStore.Search(ctx, query, book.Filter{}, 0.45, 10, nil)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("query %q: failed to search books: %v", query, err)
+		}
+
+		found := false
+		for _, r := range results {
+			if r.ID == created.ID {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("query %q: expected book %d in %+v", query, created.ID, results)
+		}
+	}
+}
+
+func TestBackfillSearchTokens(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+
+	var id int64
+	err := internalDb.WithinTenant(ctx, testDB, tenant.DefaultID, func(ctx context.Context) error {
+		return internalDb.Conn(ctx, testDB).GetContext(ctx, &id, "INSERT INTO books (title, author, published_year) VALUES ('天ぷら入門', 'Author', 2021) RETURNING id")
+	})
+	if err != nil {
+		t.Fatalf("failed to insert book without tokens: %v", err)
+	}
+
+	n, err := bookStore.BackfillSearchTokens(ctx, 100)
+	if err != nil {
+		t.Fatalf("failed to backfill search tokens: %v", err)
+	}
+	if n == 0 {
+		t.Fatal("expected at least one book to be backfilled")
+	}
+
+	var pending int
+	err = internalDb.WithinAllTenants(ctx, testDB, func(ctx context.Context) error {
+		return internalDb.Conn(ctx, testDB).GetContext(ctx, &pending, "SELECT count(*) FROM books WHERE title_tokens IS NULL")
+	})
+	if err != nil {
+		t.Fatalf("failed to count pending books: %v", err)
+	}
+	if pending != 0 {
+		t.Errorf("expected no pending books, got %d", pending)
+	}
+}
+
+func TestEachLive(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+
+	created, err := bookStore.Create(ctx, &book.Book{Title: "Streamed", Author: "Author", PublishedYear: 2022})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	deleted, err := bookStore.Create(ctx, &book.Book{Title: "Streamed Deleted", Author: "Author", PublishedYear: 2022})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	if _, err := bookStore.Delete(ctx, deleted.ID); err != nil {
+		t.Fatalf("failed to delete book: %v", err)
+	}
+
+	seen := map[int64]bool{}
+	err = bookStore.EachLive(ctx, func(b *book.Book) error {
+		seen[b.ID] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to stream books: %v", err)
+	}
+
+	if !seen[created.ID] {
+		t.Errorf("expected live book %d to be streamed", created.ID)
+	}
+	if seen[deleted.ID] {
+		t.Errorf("expected deleted book %d to be skipped", deleted.ID)
+	}
+}
+
+func TestFiltersAndFacets(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+
+	seed := []book.Book{
+		{Title: "Facet One", Author: "Facet Author A", PublishedYear: 1991},
+		{Title: "Facet Two", Author: "Facet Author A", PublishedYear: 1995},
+		{Title: "Facet Three", Author: "Facet Author B", PublishedYear: 2003},
+	}
+	for i := range seed {
+		if _, err := bookStore.Create(ctx, &seed[i]); err != nil {
+			t.Fatalf("failed to create book: %v", err)
+		}
+	}
+
+	filter := book.Filter{Authors: []string{"Facet Author A"}, Decades: []int{1990}}
+	books, err := bookStore.GetAll(ctx, filter, nil)
+	if err != nil {
+		t.Fatalf("failed to get filtered books: %v", err)
+	}
+	if len(books) != 2 {
+		t.Fatalf("expected 2 books, got %d", len(books))
+	}
+
+	facets, err := bookStore.Facets(ctx, filter, "", []string{book.FacetAuthor, book.FacetDecade}, 10)
+	if err != nil {
+		t.Fatalf("failed to count facets: %v", err)
+	}
+
+	// The author facet ignores the author selection, so B is still offered
+	// with its count within the 1990s.
+	authors := map[string]int64{}
+	for _, bucket := range facets[book.FacetAuthor] {
+		authors[bucket.Value] = bucket.Count
+	}
+	if authors["Facet Author A"] != 2 {
+		t.Errorf("expected 2 books by author A, got %d", authors["Facet Author A"])
+	}
+	if _, ok := authors["Facet Author B"]; ok {
+		t.Errorf("expected author B to be excluded by the decade selection, got %v", authors)
+	}
+
+	decades := map[string]int64{}
+	for _, bucket := range facets[book.FacetDecade] {
+		decades[bucket.Value] = bucket.Count
+	}
+	if decades["1990"] != 2 {
+		t.Errorf("expected 2 books in the 1990s, got %d", decades["1990"])
+	}
+	if _, ok := decades["2000"]; ok {
+		t.Errorf("expected the 2000s to be excluded by the author selection, got %v", decades)
+	}
+}
+
+func TestGetByIDs(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+
+	first, err := bookStore.Create(ctx, &book.Book{Title: "Batch One", Author: "Author", PublishedYear: 2020})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	second, err := bookStore.Create(ctx, &book.Book{Title: "Batch Two", Author: "Author", PublishedYear: 2020})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	if _, err := bookStore.Delete(ctx, second.ID); err != nil {
+		t.Fatalf("failed to delete book: %v", err)
+	}
+
+	books, err := bookStore.GetByIDs(ctx, []int64{first.ID, second.ID, 999999}, nil)
+	if err != nil {
+		t.Fatalf("failed to get books by IDs: %v", err)
+	}
+	if len(books) != 1 || books[0].ID != first.ID {
+		t.Fatalf("expected only book %d, got %+v", first.ID, books)
+	}
+
+	fields, err := book.ParseFields("title")
+	if err != nil {
+		t.Fatalf("failed to parse fields: %v", err)
+	}
+	books, err = bookStore.GetByIDs(ctx, []int64{first.ID}, fields)
+	if err != nil {
+		t.Fatalf("failed to get sparse books by IDs: %v", err)
+	}
+	if len(books) != 1 || books[0].ID != first.ID || books[0].Title != "Batch One" {
+		t.Fatalf("expected id and title of book %d, got %+v", first.ID, books)
+	}
+	if books[0].Author != "" || !books[0].CreatedAt.IsZero() {
+		t.Errorf("expected unselected columns to be left out, got %+v", books[0])
+	}
+}
+
+func TestTimestampsAndTimeFilters(t *testing.T) {
+	ctx := defaultTenant()
+
+	bookStore := NewBook(testDB)
+
+	created, err := bookStore.Create(ctx, &book.Book{Title: "Timestamped", Author: "Clock Author", PublishedYear: 2001})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	if created.CreatedAt.IsZero() || created.UpdatedAt.IsZero() {
+		t.Fatalf("expected timestamps on the created book, got %+v", created)
+	}
+
+	updated, err := bookStore.Update(ctx, &book.Book{ID: created.ID, Title: "Timestamped Again"})
+	if err != nil {
+		t.Fatalf("failed to update book: %v", err)
+	}
+	if !updated.UpdatedAt.After(created.UpdatedAt) {
+		t.Errorf("expected updated_at to move past %v, got %v", created.UpdatedAt, updated.UpdatedAt)
+	}
+	if !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("expected created_at to stay %v, got %v", created.CreatedAt, updated.CreatedAt)
+	}
+
+	fetched, err := bookStore.GetByID(ctx, created.ID, nil)
+	if err != nil {
+		t.Fatalf("failed to get book: %v", err)
+	}
+	if !fetched.UpdatedAt.Equal(updated.UpdatedAt) {
+		t.Errorf("expected GetByID to return updated_at %v, got %v", updated.UpdatedAt, fetched.UpdatedAt)
+	}
+
+	contains := func(f book.Filter) bool {
+		books, err := bookStore.GetAll(ctx, f, nil)
+		if err != nil {
+			t.Fatalf("failed to get filtered books: %v", err)
+		}
+		for _, b := range books {
+			if b.ID == created.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !contains(book.Filter{UpdatedAfter: created.UpdatedAt}) {
+		t.Error("expected the book to be updated after its creation")
+	}
+	if contains(book.Filter{UpdatedBefore: updated.UpdatedAt}) {
+		t.Error("expected the book to be excluded by updated_before")
+	}
+	if contains(book.Filter{CreatedAfter: created.CreatedAt}) {
+		t.Error("expected the book to be excluded by created_after")
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	bookStore := NewBook(testDB)
+	acme, globex := newTenant(t, "store-acme"), newTenant(t, "store-globex")
+
+	mine, err := bookStore.Create(acme, &book.Book{Title: "Isolated", Author: "Author", PublishedYear: 2020})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+	if mine.TenantID != tenant.From(acme).ID {
+		t.Fatalf("expected the book to belong to its tenant, got tenant %d", mine.TenantID)
+	}
+	theirs, err := bookStore.Create(globex, &book.Book{Title: "Isolated", Author: "Author", PublishedYear: 2020})
+	if err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	if _, err := bookStore.GetByID(globex, mine.ID, nil); err != sql.ErrNoRows {
+		t.Errorf("expected another tenant's book to be invisible, got %v", err)
+	}
+	if _, err := bookStore.Update(globex, &book.Book{ID: mine.ID, Title: "Taken"}); err != sql.ErrNoRows {
+		t.Errorf("expected another tenant's book not to update, got %v", err)
+	}
+	if _, err := bookStore.Delete(globex, mine.ID); err != sql.ErrNoRows {
+		t.Errorf("expected another tenant's book not to delete, got %v", err)
+	}
+	if _, err := bookStore.MergeInto(globex, mine.ID, theirs.ID); err == nil {
+		t.Error("expected merging another tenant's book to fail")
+	}
+
+	books, err := bookStore.GetAll(acme, book.Filter{}, nil)
+	if err != nil {
+		t.Fatalf("failed to get all books: %v", err)
+	}
+	if len(books) != 1 || books[0].ID != mine.ID {
+		t.Errorf("expected only the tenant's own book, got %+v", books)
+	}
+
+	duplicates, err := bookStore.FindDuplicates(acme, book.DuplicateQuery{Threshold: 0.5, Limit: 10})
+	if err != nil {
+		t.Fatalf("failed to find duplicates: %v", err)
+	}
+	if len(duplicates) != 0 {
+		t.Errorf("expected books of different tenants not to be duplicates, got %+v", duplicates)
+	}
+
+	if _, err := bookStore.GetAll(context.Background(), book.Filter{}, nil); !errors.Is(err, tenant.ErrNoTenant) {
+		t.Errorf("expected a call without a tenant to fail, got %v", err)
+	}
+}
+
+// TestRowLevelSecurity checks the policies that back the store's own
+// filters on the connection the stores use, which like the server's must not
+// bypass them.
+func TestRowLevelSecurity(t *testing.T) {
+	bypasses, err := internalDb.BypassesRowSecurity(context.Background(), testDB)
+	if err != nil {
+		t.Fatalf("failed to check role: %v", err)
+	}
+	if bypasses {
+		t.Fatal("expected the test role to be bound by row-level security")
+	}
+
+	ctx := newTenant(t, "rls-acme")
+	acme := tenant.From(ctx).ID
+	if _, err := NewBook(testDB).Create(ctx, &book.Book{Title: "Guarded", Author: "Author", PublishedYear: 2020}); err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	// Queries without a tenant filter of their own, as a store forgetting
+	// one would run.
+	count := func(ctx context.Context, where string) int {
+		t.Helper()
+		var n int
+		if err := internalDb.Conn(ctx, testDB).GetContext(ctx, &n, "SELECT count(*) FROM books WHERE "+where); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	within := func(tenantID int64, fn func(ctx context.Context)) {
+		t.Helper()
+		err := internalDb.WithinTenant(context.Background(), testDB, tenantID, func(ctx context.Context) error {
+			fn(ctx)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(context.Background(), "TRUE"); n != 0 {
+		t.Errorf("expected no rows without a tenant, got %d", n)
+	}
+	within(acme, func(ctx context.Context) {
+		if n := count(ctx, "TRUE"); n != 1 {
+			t.Errorf("expected only the tenant's row, got %d", n)
+		}
+	})
+	within(tenant.DefaultID, func(ctx context.Context) {
+		if n := count(ctx, fmt.Sprintf("tenant_id = %d", acme)); n != 0 {
+			t.Errorf("expected no rows of another tenant, got %d", n)
+		}
+	})
+
+	err = internalDb.WithinTenant(context.Background(), testDB, acme, func(ctx context.Context) error {
+		_, err := internalDb.Conn(ctx, testDB).ExecContext(ctx, "INSERT INTO books (title, author, published_year, tenant_id) VALUES ('Smuggled', 'Author', 2020, $1)", tenant.DefaultID)
+		return err
+	})
+	if err == nil {
+		t.Error("expected inserting a row for another tenant to be refused")
+	}
+}
+
+func TestTenantConn(t *testing.T) {
+	// A pool of one connection, so the one the tenant connection returns is
+	// the one read from after it.
+	testDB.SetMaxOpenConns(1)
+	defer testDB.SetMaxOpenConns(0)
+
+	ctx := newTenant(t, "conn-acme")
+	acme := tenant.From(ctx).ID
+	bookStore := NewBook(testDB)
+	if _, err := bookStore.Create(ctx, &book.Book{Title: "Pinned", Author: "Author", PublishedYear: 2020}); err != nil {
+		t.Fatalf("failed to create book: %v", err)
+	}
+
+	ctx, release := internalDb.WithTenantConn(ctx, testDB, acme)
+	if books, err := bookStore.GetAll(ctx, book.Filter{}, nil); err != nil || len(books) != 1 {
+		t.Fatalf("expected the tenant's book, got %v, %v", books, err)
+	}
+
+	// Statements outside the store run on the same connection, scoped to
+	// the tenant, inside a transaction or not.
+	var setting string
+	if err := internalDb.Conn(ctx, testDB).GetContext(ctx, &setting, "SELECT current_setting('app.tenant_id')"); err != nil {
+		t.Fatal(err)
+	}
+	if setting != fmt.Sprint(acme) {
+		t.Errorf("expected the tenant to be set on the connection, got %q", setting)
+	}
+	err := internalDb.NewTransactor(testDB).WithinTransaction(ctx, func(ctx context.Context) error {
+		var n int
+		if err := internalDb.Conn(ctx, testDB).GetContext(ctx, &n, "SELECT count(*) FROM books"); err != nil {
+			return err
+		}
+		if n != 1 {
+			t.Errorf("expected only the tenant's row in a transaction, got %d", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results, err := bookStore.Search(ctx, "Pinned", book.Filter{}, 0.3, 10, nil); err != nil || len(results) != 1 {
+		t.Fatalf("expected the search to find the book, got %v, %v", results, err)
+	}
+
+	release()
+	if err := testDB.Get(&setting, "SELECT COALESCE(current_setting('app.tenant_id', true), '')"); err != nil {
+		t.Fatal(err)
+	}
+	if setting != "" {
+		t.Errorf("expected the tenant to be cleared once released, got %q", setting)
+	}
+}
+
+func TestChangedSinceReportsLateCommits(t *testing.T) {
+	ctx := newTenant(t, "late-commits")
+	bookStore := NewBook(testDB)
+
+	since, err := bookStore.SyncHorizon(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A check made while a write is still uncommitted does not see it, so
+	// the write must fall in the next window however early it started.
+	var first []book.Book
+	err = internalDb.NewTransactor(testDB).WithinTransaction(ctx, func(txCtx context.Context) error {
+		if _, err := bookStore.Create(txCtx, &book.Book{Title: "Late Ramen", Author: "Author", PublishedYear: 2020}); err != nil {
+			return err
+		}
+		until, err := bookStore.SyncHorizon(ctx)
+		if err != nil {
+			return err
+		}
+		first, err = bookStore.ChangedSince(ctx, book.Filter{}, "ramen", since, until, 0.3)
+		since = until
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 0 {
+		t.Fatalf("expected the uncommitted write to be left out, got %+v", first)
+	}
+
+	until, err := bookStore.SyncHorizon(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := bookStore.ChangedSince(ctx, book.Filter{}, "ramen", since, until, 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || second[0].Title != "Late Ramen" {
+		t.Fatalf("expected the late commit in the next window, got %+v", second)
+	}
+
+	third, err := bookStore.ChangedSince(ctx, book.Filter{}, "ramen", until, until, 0.3)
+	if err != nil || len(third) != 0 {
+		t.Fatalf("expected the change reported once, got %+v, %v", third, err)
+	}
 }
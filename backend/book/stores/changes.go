@@ -0,0 +1,57 @@
+package stores
+
+import (
+	"byfood-interview/book"
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SyncHorizon returns the oldest transaction ID that may still be running.
+// Every change written by an older transaction is committed and visible to
+// statements that start after this call, so the horizon is where the next
+// sync can safely resume.
+func (b *Book) SyncHorizon(ctx context.Context) (int64, error) {
+	var horizon int64
+	query := "SELECT pg_snapshot_xmin(pg_current_snapshot())::TEXT::BIGINT"
+	if err := b.conn(ctx).GetContext(ctx, &horizon, query); err != nil {
+		log.Error().Err(err).Msg("failed to get sync horizon")
+		return 0, err
+	}
+	return horizon, nil
+}
+
+// Changes returns up to limit books whose latest change was written by a
+// transaction at or after since and comes after afterSeq in the change
+// sequence, in sequence order. A zero since is a full sync, which leaves out
+// deleted books.
+func (b *Book) Changes(ctx context.Context, since, afterSeq int64, limit int) ([]book.Change, error) {
+	var rows []struct {
+		book.Book
+		ChangeSeq int64 `db:"change_seq"`
+	}
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := `SELECT ` + bookColumns + `, change_seq FROM books
+		WHERE tenant_id = $4 AND change_xid >= $1::BIGINT::TEXT::XID8 AND change_seq > $2 AND ($1::BIGINT > 0 OR deleted_at IS NULL)
+		ORDER BY change_seq
+		LIMIT $3`
+		return b.conn(ctx).SelectContext(ctx, &rows, query, since, afterSeq, limit, tenantID)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get book changes")
+		return nil, err
+	}
+
+	changes := make([]book.Change, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		changes[i] = book.Change{ID: row.ID, Seq: row.ChangeSeq}
+		if row.DeletedAt != nil {
+			changes[i].Deleted = true
+			changes[i].MergedInto = row.MergedInto
+			continue
+		}
+		changes[i].Book = &row.Book
+	}
+	return changes, nil
+}
@@ -0,0 +1,168 @@
+package stores
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/internal/search"
+	"context"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// facetExpressions maps each facet to the SQL expression it groups by.
+var facetExpressions = map[string]string{
+	book.FacetAuthor:        "author",
+	book.FacetDecade:        "(published_year / 10 * 10)::TEXT",
+	book.FacetPublishedYear: "published_year::TEXT",
+}
+
+// whereClause renders the live-book condition of tenantID and f as SQL with
+// ? placeholders. The selection of the facet named skip is left out, which
+// is how the counts of a facet ignore its own selection; time bounds always
+// apply.
+func whereClause(tenantID int64, f book.Filter, skip string) (string, []interface{}) {
+	conditions := []string{"tenant_id = ?", "deleted_at IS NULL"}
+	args := []interface{}{tenantID}
+
+	if len(f.Authors) > 0 && skip != book.FacetAuthor {
+		conditions = append(conditions, "author = ANY(?)")
+		args = append(args, pq.Array(f.Authors))
+	}
+	if len(f.Decades) > 0 && skip != book.FacetDecade {
+		conditions = append(conditions, "published_year / 10 * 10 = ANY(?)")
+		args = append(args, pq.Array(int64s(f.Decades)))
+	}
+	if len(f.PublishedYears) > 0 && skip != book.FacetPublishedYear {
+		conditions = append(conditions, "published_year = ANY(?)")
+		args = append(args, pq.Array(int64s(f.PublishedYears)))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at > ?")
+		args = append(args, f.CreatedAfter)
+	}
+	if !f.UpdatedAfter.IsZero() {
+		conditions = append(conditions, "updated_at > ?")
+		args = append(args, f.UpdatedAfter)
+	}
+	if !f.UpdatedBefore.IsZero() {
+		conditions = append(conditions, "updated_at < ?")
+		args = append(args, f.UpdatedBefore)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func int64s(values []int) []int64 {
+	out := make([]int64, len(values))
+	for i, v := range values {
+		out[i] = int64(v)
+	}
+	return out
+}
+
+// searchCondition matches books whose normalized title or author contains a
+// close match of query, or whose search tokens contain every query token.
+func searchCondition(query string) (string, []interface{}) {
+	normalized, tokens := search.Normalize(query), pq.Array(search.QueryTokens(query))
+	condition := `(normalize_book_text(?) <% normalize_book_text(title)
+		OR normalize_book_text(?) <% normalize_book_text(author)
+		OR (cardinality(?::TEXT[]) > 0 AND (title_tokens || author_tokens) @> ?::TEXT[]))`
+	return condition, []interface{}{normalized, normalized, tokens, tokens}
+}
+
+// searchScore scores a book matched by searchCondition: 1 for a full token
+// match, the trigram word similarity of title or author otherwise.
+func searchScore(query string) (string, []interface{}) {
+	normalized, tokens := search.Normalize(query), pq.Array(search.QueryTokens(query))
+	score := `CASE WHEN cardinality(?::TEXT[]) > 0 AND (title_tokens || author_tokens) @> ?::TEXT[] THEN 1
+		ELSE GREATEST(
+			word_similarity(normalize_book_text(?), normalize_book_text(title)),
+			word_similarity(normalize_book_text(?), normalize_book_text(author))
+		) END`
+	return score, []interface{}{tokens, tokens, normalized, normalized}
+}
+
+// Facets counts the live books matching f, and query when it is not empty,
+// by each of the requested facets, keeping the limit largest buckets of each.
+// With a query it must run in the same transaction as Search so the search
+// threshold applies.
+func (b *Book) Facets(ctx context.Context, f book.Filter, query string, facets []string, limit int) (book.Facets, error) {
+	result := book.Facets{}
+	if len(facets) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		Facet string `db:"facet"`
+		book.FacetBucket
+	}
+	err := b.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		var selects []string
+		var args []interface{}
+		for _, facet := range facets {
+			where, whereArgs := whereClause(tenantID, f, facet)
+			args = append(args, facet)
+			args = append(args, whereArgs...)
+			if query != "" {
+				condition, conditionArgs := searchCondition(query)
+				where += " AND " + condition
+				args = append(args, conditionArgs...)
+			}
+			expression := facetExpressions[facet]
+			selects = append(selects, `SELECT ?::TEXT AS facet, `+expression+` AS value, count(*) AS count
+				FROM books WHERE `+where+` GROUP BY `+expression)
+		}
+		args = append(args, limit)
+
+		statement := sqlx.Rebind(sqlx.DOLLAR, `SELECT facet, value, count FROM (
+			SELECT facet, value, count, row_number() OVER (PARTITION BY facet ORDER BY count DESC, value) AS rank
+			FROM (`+strings.Join(selects, " UNION ALL ")+`) buckets
+		) ranked
+		WHERE rank <= ?
+		ORDER BY facet, count DESC, value`)
+		return b.conn(ctx).SelectContext(ctx, &rows, statement, args...)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count book facets")
+		return nil, err
+	}
+
+	for _, facet := range facets {
+		result[facet] = []book.FacetBucket{}
+	}
+	for _, row := range rows {
+		result[row.Facet] = append(result[row.Facet], row.FacetBucket)
+	}
+	return result, nil
+}
+
+// ChangedSince returns the live books matching f, and query when it is not
+// empty, whose latest change was written by a transaction with an ID from
+// since up to, but not including, until, in change order.
+func (b *Book) ChangedSince(ctx context.Context, f book.Filter, query string, since, until int64, threshold float64) ([]book.Book, error) {
+	books := []book.Book{}
+	err := b.scopedTx(ctx, func(ctx context.Context, tenantID int64) error {
+		where, args := whereClause(tenantID, f, "")
+		if query != "" {
+			if err := b.setSearchThreshold(ctx, threshold); err != nil {
+				return err
+			}
+			condition, conditionArgs := searchCondition(query)
+			where += " AND " + condition
+			args = append(args, conditionArgs...)
+		}
+		args = append(args, since, until)
+
+		statement := sqlx.Rebind(sqlx.DOLLAR, `SELECT `+bookColumns+` FROM books
+		WHERE `+where+` AND change_xid >= ?::BIGINT::TEXT::XID8 AND change_xid < ?::BIGINT::TEXT::XID8
+		ORDER BY change_seq`)
+		return b.conn(ctx).SelectContext(ctx, &books, statement, args...)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get changed books")
+		return nil, err
+	}
+	return books, nil
+}
@@ -0,0 +1,162 @@
+package stores
+
+import (
+	"byfood-interview/book"
+	internalDb "byfood-interview/internal/db"
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// statsViews are the materialized views behind Stats, refreshed by
+// RefreshStats.
+var statsViews = []string{"book_stats_by_year", "book_stats_by_author", "book_stats_daily"}
+
+// statsSource holds the SQL Stats runs against either the books table or the
+// materialized views. The tenant is the last parameter of every query but
+// generatedAt; the views have no row-level security, so the filter on it is
+// all that scopes them.
+type statsSource struct {
+	totals  string
+	years   string
+	authors string
+	// changes selects (at, added, deleted) rows of tenant $4 within the
+	// bounds CTE of timelineQuery.
+	changes string
+	// generatedAt selects when the figures were computed.
+	generatedAt string
+}
+
+var liveStats = statsSource{
+	totals: `SELECT COUNT(*) FILTER (WHERE deleted_at IS NULL) AS total,
+		COUNT(*) FILTER (WHERE deleted_at IS NOT NULL) AS deleted
+	FROM books
+	WHERE tenant_id = $1`,
+	years: `SELECT published_year, COUNT(*) AS count FROM books
+	WHERE tenant_id = $1 AND deleted_at IS NULL
+	GROUP BY published_year
+	ORDER BY published_year`,
+	authors: `SELECT author, COUNT(*) AS count FROM books
+	WHERE tenant_id = $2 AND deleted_at IS NULL
+	GROUP BY author
+	ORDER BY count DESC, author
+	LIMIT $1`,
+	changes: `SELECT created_at AT TIME ZONE 'UTC' AS at, 1 AS added, 0 AS deleted FROM books, bounds
+		WHERE tenant_id = $4 AND created_at >= bounds.start_at AT TIME ZONE 'UTC' AND created_at < bounds.end_at AT TIME ZONE 'UTC'
+		UNION ALL
+		SELECT deleted_at AT TIME ZONE 'UTC', 0, 1 FROM books, bounds
+		WHERE tenant_id = $4 AND deleted_at >= bounds.start_at AT TIME ZONE 'UTC' AND deleted_at < bounds.end_at AT TIME ZONE 'UTC'`,
+	generatedAt: `SELECT NOW()`,
+}
+
+var materializedStats = statsSource{
+	totals: `SELECT
+		(SELECT COALESCE(SUM(count), 0)::BIGINT FROM book_stats_by_year WHERE tenant_id = $1) AS total,
+		(SELECT COALESCE(SUM(deleted), 0)::BIGINT FROM book_stats_daily WHERE tenant_id = $1) AS deleted`,
+	years: `SELECT published_year, count FROM book_stats_by_year WHERE tenant_id = $1 ORDER BY published_year`,
+	authors: `SELECT author, count FROM book_stats_by_author
+	WHERE tenant_id = $2
+	ORDER BY count DESC, author
+	LIMIT $1`,
+	changes: `SELECT day AS at, added, deleted FROM book_stats_daily, bounds
+		WHERE tenant_id = $4 AND day >= bounds.start_at AND day < bounds.end_at`,
+	generatedAt: `SELECT refreshed_at FROM book_stats_refreshed`,
+}
+
+// timelineQuery buckets the changes of a statsSource by $1 (day, week or
+// month) from the bucket containing $2 to the one containing $3, in UTC.
+// Buckets without changes are reported as zero.
+func timelineQuery(changes string) string {
+	return `WITH bounds AS (
+		SELECT date_trunc($1::TEXT, $2::TIMESTAMPTZ AT TIME ZONE 'UTC') AS start_at,
+			date_trunc($1::TEXT, $3::TIMESTAMPTZ AT TIME ZONE 'UTC') + ('1 ' || $1::TEXT)::INTERVAL AS end_at
+	), changes AS (
+		` + changes + `
+	)
+	SELECT period AT TIME ZONE 'UTC' AS period,
+		COALESCE(SUM(changes.added), 0)::BIGINT AS added,
+		COALESCE(SUM(changes.deleted), 0)::BIGINT AS deleted
+	FROM bounds
+	CROSS JOIN LATERAL generate_series(bounds.start_at, bounds.end_at - ('1 ' || $1::TEXT)::INTERVAL, ('1 ' || $1::TEXT)::INTERVAL) AS period
+	LEFT JOIN changes ON date_trunc($1::TEXT, changes.at) = period
+	GROUP BY period
+	ORDER BY period`
+}
+
+// Stats computes the catalog statistics selected by q, from the
+// materialized views when materialized is set and from the books table
+// otherwise.
+func (b *Book) Stats(ctx context.Context, q book.StatsQuery, materialized bool) (*book.Stats, error) {
+	source, sourceName := liveStats, book.StatsSourceLive
+	if materialized {
+		source, sourceName = materializedStats, book.StatsSourceMaterialized
+	}
+
+	stats := book.Stats{Interval: q.Interval, Source: sourceName}
+	err := b.scopedTx(ctx, func(ctx context.Context, tenantID int64) error {
+		if err := b.conn(ctx).GetContext(ctx, &stats, source.totals, tenantID); err != nil {
+			log.Error().Err(err).Msg("failed to count books")
+			return err
+		}
+		if err := b.conn(ctx).GetContext(ctx, &stats.GeneratedAt, source.generatedAt); err != nil {
+			log.Error().Err(err).Msg("failed to get stats generation time")
+			return err
+		}
+
+		stats.ByPublishedYear = []book.YearCount{}
+		if err := b.conn(ctx).SelectContext(ctx, &stats.ByPublishedYear, source.years, tenantID); err != nil {
+			log.Error().Err(err).Msg("failed to count books by published year")
+			return err
+		}
+		stats.CountDecades()
+
+		stats.TopAuthors = []book.AuthorCount{}
+		if err := b.conn(ctx).SelectContext(ctx, &stats.TopAuthors, source.authors, q.TopAuthors, tenantID); err != nil {
+			log.Error().Err(err).Msg("failed to count books by author")
+			return err
+		}
+
+		stats.Timeline = []book.PeriodCount{}
+		if err := b.conn(ctx).SelectContext(ctx, &stats.Timeline, timelineQuery(source.changes), q.Interval, q.From, q.To, tenantID); err != nil {
+			log.Error().Err(err).Msg("failed to count book changes over time")
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range stats.Timeline {
+		stats.Timeline[i].Period = stats.Timeline[i].Period.UTC()
+	}
+	stats.GeneratedAt = stats.GeneratedAt.UTC()
+
+	return &stats, nil
+}
+
+// RefreshStats refreshes the statistics materialized views of every tenant
+// without blocking readers and returns when they were refreshed.
+func (b *Book) RefreshStats(ctx context.Context) (time.Time, error) {
+	var refreshedAt time.Time
+	err := internalDb.WithinAllTenants(ctx, b.db, func(ctx context.Context) error {
+		for _, view := range statsViews {
+			if _, err := b.conn(ctx).ExecContext(ctx, "REFRESH MATERIALIZED VIEW CONCURRENTLY "+view); err != nil {
+				log.Error().Err(err).Str("view", view).Msg("failed to refresh stats view")
+				return err
+			}
+		}
+
+		// book_stats_refreshed is a single row, so a plain refresh is instant.
+		if _, err := b.conn(ctx).ExecContext(ctx, "REFRESH MATERIALIZED VIEW book_stats_refreshed"); err != nil {
+			log.Error().Err(err).Msg("failed to record stats refresh")
+			return err
+		}
+
+		return b.conn(ctx).GetContext(ctx, &refreshedAt, "SELECT refreshed_at FROM book_stats_refreshed")
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return refreshedAt, nil
+}
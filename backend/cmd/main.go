@@ -27,6 +27,16 @@ import (
 
 // @schemes http
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT bearer token, sent as "Bearer <token>".
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key limited to its scopes, also accepted as "Authorization: ApiKey <key>".
+
 func main() {
 	err := configEnv.Load(".env")
 	if err != nil {
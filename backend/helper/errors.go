@@ -1,5 +1,10 @@
 package helper
 
+import (
+	"errors"
+	"time"
+)
+
 type ErrBadRequest struct {
 	Message string
 }
@@ -61,6 +66,80 @@ func NewErrInternalServer(message string) error {
 }
 
 func IsErrNotFound(err error) bool {
-	_, ok := err.(*ErrNotFound)
-	return ok
+	return errors.As(err, new(*ErrNotFound)) || errors.As(err, new(ErrNotFound))
+}
+
+type ErrNotAcceptable struct {
+	Message string
+}
+
+func NewErrNotAcceptable(message string) *ErrNotAcceptable {
+	return &ErrNotAcceptable{Message: message}
+}
+
+func (e ErrNotAcceptable) Error() string {
+	return e.Message
+}
+
+type ErrUnsupportedMediaType struct {
+	Message string
+}
+
+func NewErrUnsupportedMediaType(message string) *ErrUnsupportedMediaType {
+	return &ErrUnsupportedMediaType{Message: message}
+}
+
+func (e ErrUnsupportedMediaType) Error() string {
+	return e.Message
+}
+
+type ErrConflict struct {
+	Message string
+}
+
+func NewErrConflict(message string) *ErrConflict {
+	return &ErrConflict{Message: message}
+}
+
+func (e ErrConflict) Error() string {
+	return e.Message
+}
+
+type ErrUnprocessableEntity struct {
+	Message string
+}
+
+func NewErrUnprocessableEntity(message string) *ErrUnprocessableEntity {
+	return &ErrUnprocessableEntity{Message: message}
+}
+
+func (e ErrUnprocessableEntity) Error() string {
+	return e.Message
+}
+
+type ErrPayloadTooLarge struct {
+	Message string
+}
+
+func NewErrPayloadTooLarge(message string) *ErrPayloadTooLarge {
+	return &ErrPayloadTooLarge{Message: message}
+}
+
+func (e ErrPayloadTooLarge) Error() string {
+	return e.Message
+}
+
+// ErrTooManyRequests is returned when a caller must back off. RetryAfter,
+// when set, is sent as the Retry-After header.
+type ErrTooManyRequests struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func NewErrTooManyRequests(message string, retryAfter time.Duration) *ErrTooManyRequests {
+	return &ErrTooManyRequests{Message: message, RetryAfter: retryAfter}
+}
+
+func (e ErrTooManyRequests) Error() string {
+	return e.Message
 }
@@ -0,0 +1,430 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// More media types the API can respond with and, except CSV, decode.
+const (
+	MediaTypeXML     = "application/xml"
+	MediaTypeCSV     = "text/csv"
+	MediaTypeMsgPack = "application/msgpack"
+)
+
+// formats lists the response media types in order of preference when an
+// Accept header ranks several of them equally.
+var formats = []string{MediaTypeJSON, MediaTypeXML, MediaTypeMsgPack, MediaTypeCSV}
+
+// contentTypes is the Content-Type header sent with each format.
+var contentTypes = map[string]string{
+	MediaTypeJSON:    "application/json",
+	MediaTypeXML:     "application/xml; charset=utf-8",
+	MediaTypeMsgPack: MediaTypeMsgPack,
+	MediaTypeCSV:     "text/csv; charset=utf-8",
+}
+
+// NegotiateFormat returns the response media type r's Accept header prefers,
+// JSON when it has none. CSV is only offered to GET requests, whose list
+// responses it can hold. It returns an *ErrNotAcceptable when no format is
+// acceptable.
+func NegotiateFormat(r *http.Request) (string, error) {
+	header := ""
+	if r != nil {
+		header = r.Header.Get("Accept")
+	}
+	if strings.TrimSpace(header) == "" {
+		return MediaTypeJSON, nil
+	}
+
+	ranges := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, format := range formats {
+		if format == MediaTypeCSV && r.Method != http.MethodGet {
+			continue
+		}
+		q, _ := quality(ranges, format)
+		if format == MediaTypeJSON {
+			// Problem details are JSON, so asking for them accepts JSON.
+			if problemQ, explicit := quality(ranges, MediaTypeProblem); explicit && problemQ > q {
+				q = problemQ
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best == "" {
+		return "", NewErrNotAcceptable(fmt.Sprintf("none of the requested media types is available; supported: %s", strings.Join(formats, ", ")))
+	}
+	return best, nil
+}
+
+// encode writes v to w as mediaType.
+func encode(w io.Writer, mediaType string, v interface{}) error {
+	switch mediaType {
+	case MediaTypeXML:
+		return encodeXML(w, v)
+	case MediaTypeMsgPack:
+		enc := msgpack.NewEncoder(w)
+		enc.SetCustomStructTag("json")
+		return enc.Encode(v)
+	case MediaTypeCSV:
+		return encodeCSV(w, v)
+	default:
+		body, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(body)
+		return err
+	}
+}
+
+// encodeXML writes v as XML with the same element names and order as its
+// JSON encoding, under a <response> root. Array items are <item> elements,
+// and object keys that are not XML names become <entry key="...">.
+func encodeXML(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := jsonToXML(enc, dec, xml.StartElement{Name: xml.Name{Local: "response"}}); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func jsonToXML(enc *xml.Encoder, dec *json.Decoder, start xml.StartElement) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			for dec.More() {
+				keyToken, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := jsonToXML(enc, dec, xmlElement(keyToken.(string))); err != nil {
+					return err
+				}
+			}
+		} else {
+			for dec.More() {
+				if err := jsonToXML(enc, dec, xml.StartElement{Name: xml.Name{Local: "item"}}); err != nil {
+					return err
+				}
+			}
+		}
+		// Consume the closing delimiter.
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(t))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// xmlElement returns the element for an object key.
+func xmlElement(key string) xml.StartElement {
+	if isXMLName(key) {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{
+		Name: xml.Name{Local: "entry"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+	}
+}
+
+func isXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r), r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// errNotList is returned by encodeCSV for data that is not a list.
+var errNotList = errors.New("only lists can be encoded as CSV")
+
+// encodeCSV writes a slice as CSV with a header row. Struct elements give
+// one column per JSON field, nested structs flattened as parent.child;
+// map elements one column per key, id first. Other values in cells are
+// written as JSON.
+func encodeCSV(w io.Writer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return errNotList
+	}
+
+	rows := make([]map[string]string, rv.Len())
+	var columns []string
+	elemType := rv.Type().Elem()
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() == reflect.Struct {
+		columns = structColumns(elemType, "")
+	}
+
+	seen := map[string]bool{}
+	for _, column := range columns {
+		seen[column] = true
+	}
+	var extra []string
+	for i := range rows {
+		rows[i] = map[string]string{}
+		flattenCSV(rows[i], "", rv.Index(i))
+		for column := range rows[i] {
+			if !seen[column] {
+				seen[column] = true
+				extra = append(extra, column)
+			}
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool {
+		if (extra[i] == "id") != (extra[j] == "id") {
+			return extra[i] == "id"
+		}
+		return extra[i] < extra[j]
+	})
+	columns = append(columns, extra...)
+
+	cw := csv.NewWriter(w)
+	if len(columns) > 0 {
+		if err := cw.Write(columns); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			record[i] = row[column]
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonName returns the JSON name of a struct field, or "" when the field is
+// not encoded.
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// flattens reports whether values of t are split into columns.
+func flattens(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// structColumns returns the CSV columns of a struct type.
+func structColumns(t reflect.Type, prefix string) []string {
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" && flattens(field.Type) {
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			columns = append(columns, structColumns(ft, prefix)...)
+			continue
+		}
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		if flattens(field.Type) {
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			columns = append(columns, structColumns(ft, prefix+name+".")...)
+			continue
+		}
+		columns = append(columns, prefix+name)
+	}
+	return columns
+}
+
+// flattenCSV stores the cells of v in row under prefix.
+func flattenCSV(row map[string]string, prefix string, v reflect.Value) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct && v.Type() != timeType:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Anonymous && field.Tag.Get("json") == "" && flattens(field.Type) {
+				flattenCSV(row, prefix, v.Field(i))
+				continue
+			}
+			name := jsonName(field)
+			switch {
+			case name == "":
+			case flattens(field.Type):
+				flattenCSV(row, prefix+name+".", v.Field(i))
+			default:
+				flattenCSV(row, prefix+name, v.Field(i))
+			}
+		}
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && prefix == "":
+		iter := v.MapRange()
+		for iter.Next() {
+			flattenCSV(row, iter.Key().String(), iter.Value())
+		}
+	case prefix == "":
+		row["value"] = csvCell(v)
+	default:
+		row[prefix] = csvCell(v)
+	}
+}
+
+func csvCell(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil() {
+		return ""
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	body, _ := json.Marshal(v.Interface())
+	return string(body)
+}
+
+// MaxBodySize is the largest request body the server reads.
+const MaxBodySize = 1 << 20
+
+// bodyTooLarge returns the error to report when err is a read past
+// MaxBodySize, or nil when it is not.
+func bodyTooLarge(err error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return NewErrPayloadTooLarge(fmt.Sprintf("request body must be at most %d bytes", maxBytes.Limit))
+	}
+	return nil
+}
+
+// ReadBody reads r's body whole, up to MaxBodySize. A larger body is an
+// *ErrPayloadTooLarge; w, when not nil, is told to close the connection
+// rather than read the rest.
+func ReadBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		if tooLarge := bodyTooLarge(err); tooLarge != nil {
+			return nil, tooLarge
+		}
+		return nil, NewErrBadRequest("failed to read request body")
+	}
+	return body, nil
+}
+
+// Decode decodes r's body into dst according to its Content-Type: JSON, the
+// default, XML or MessagePack. Like DecodeJSON, a JSON value of the wrong
+// type is reported as a field error; an unsupported Content-Type is an
+// *ErrUnsupportedMediaType, and a body over MaxBodySize an
+// *ErrPayloadTooLarge.
+func Decode(r *http.Request, dst interface{}) error {
+	body := http.MaxBytesReader(nil, r.Body, MaxBodySize)
+	mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "", MediaTypeJSON:
+		return DecodeJSON(body, dst)
+	case MediaTypeXML, "text/xml":
+		if err := xml.NewDecoder(body).Decode(dst); err != nil {
+			if tooLarge := bodyTooLarge(err); tooLarge != nil {
+				return tooLarge
+			}
+			return NewErrBadRequest("invalid XML body")
+		}
+		return nil
+	case MediaTypeMsgPack, "application/x-msgpack", "application/vnd.msgpack":
+		dec := msgpack.NewDecoder(body)
+		dec.SetCustomStructTag("json")
+		if err := dec.Decode(dst); err != nil {
+			if tooLarge := bodyTooLarge(err); tooLarge != nil {
+				return tooLarge
+			}
+			return NewErrBadRequest("invalid MessagePack body")
+		}
+		return nil
+	default:
+		return NewErrUnsupportedMediaType(fmt.Sprintf("unsupported Content-Type %q; use %s, %s or %s", mediaType, MediaTypeJSON, MediaTypeXML, MediaTypeMsgPack))
+	}
+}
@@ -0,0 +1,230 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type testAuthor struct {
+	Name string `json:"name"`
+}
+
+type testBook struct {
+	ID        int64       `json:"id" xml:"id"`
+	Title     string      `json:"title" xml:"title"`
+	Year      int         `json:"published_year" xml:"published_year"`
+	Author    testAuthor  `json:"author" xml:"-"`
+	Tags      []string    `json:"tags,omitempty" xml:"-"`
+	DeletedAt *time.Time  `json:"deleted_at,omitempty" xml:"-"`
+	Secret    string      `json:"-" xml:"-"`
+	Editor    *testAuthor `json:"editor,omitempty" xml:"-"`
+}
+
+type testScoredBook struct {
+	testBook
+	Score float64 `json:"score"`
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		method string
+		accept string
+		want   string
+	}{
+		{http.MethodGet, "", MediaTypeJSON},
+		{http.MethodGet, "*/*", MediaTypeJSON},
+		{http.MethodGet, "application/xml", MediaTypeXML},
+		{http.MethodGet, "text/xml;q=0.5, application/xml", MediaTypeXML},
+		{http.MethodGet, "application/json;q=0.5, application/msgpack", MediaTypeMsgPack},
+		{http.MethodGet, "text/csv", MediaTypeCSV},
+		{http.MethodGet, "text/*", MediaTypeCSV},
+		{http.MethodGet, "application/*", MediaTypeJSON},
+		{http.MethodGet, "application/problem+json", MediaTypeJSON},
+		{http.MethodGet, "image/png", ""},
+		{http.MethodGet, "application/json;q=0", ""},
+		{http.MethodPost, "text/csv", ""},
+		{http.MethodPost, "text/csv, application/xml;q=0.1", MediaTypeXML},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(tc.method, "/", nil)
+		r.Header.Set("Accept", tc.accept)
+		got, err := NegotiateFormat(r)
+		if tc.want == "" {
+			if StatusCode(err) != http.StatusNotAcceptable {
+				t.Errorf("%s %q: expected 406, got %q, %v", tc.method, tc.accept, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s %q: got %q, %v want %q", tc.method, tc.accept, got, err, tc.want)
+		}
+	}
+}
+
+func TestEncodeCSV(t *testing.T) {
+	deleted := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	books := []testScoredBook{
+		{testBook: testBook{ID: 1, Title: "Dune, Part 1", Year: 1965, Author: testAuthor{Name: "Herbert"}, Tags: []string{"sf"}}, Score: 0.5},
+		{testBook: testBook{ID: 2, Title: "Emma", Year: 1815, DeletedAt: &deleted, Editor: &testAuthor{Name: "Ed"}}, Score: 1},
+	}
+
+	var out bytes.Buffer
+	if err := encodeCSV(&out, books); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,title,published_year,author.name,tags,deleted_at,editor.name,score\n" +
+		"1,\"Dune, Part 1\",1965,Herbert,\"[\"\"sf\"\"]\",,,0.5\n" +
+		"2,Emma,1815,,,2024-01-02T03:04:05Z,Ed,1\n"
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestEncodeCSVMaps(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"title": "Dune", "id": int64(1)},
+		{"title": "Emma", "id": int64(2), "author": "Austen"},
+	}
+
+	var out bytes.Buffer
+	if err := encodeCSV(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,author,title\n1,,Dune\n2,Austen,Emma\n"
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if err := encodeCSV(&out, testBook{}); err != errNotList {
+		t.Fatalf("expected errNotList, got %v", err)
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	resp := Response{
+		Code:    200,
+		Message: "success",
+		Data:    []testBook{{ID: 1, Title: "A & B", Year: 2001}},
+		Meta:    map[string]interface{}{"1990": 3},
+	}
+
+	var out bytes.Buffer
+	if err := encodeXML(&out, resp); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><code>200</code><message>success</message>` +
+		`<data><item><id>1</id><title>A &amp; B</title><published_year>2001</published_year><author><name></name></author></item></data>` +
+		`<meta><entry key="1990">3</entry></meta></response>`
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteResponseFormats(t *testing.T) {
+	data := []testBook{{ID: 1, Title: "Dune", Year: 1965}}
+
+	r := httptest.NewRequest(http.MethodGet, "/books", nil)
+	r.Header.Set("Accept", MediaTypeMsgPack)
+	w := httptest.NewRecorder()
+	WriteResponse(w, r, nil, data)
+	if ct := w.Header().Get("Content-Type"); ct != MediaTypeMsgPack {
+		t.Fatalf("got content type %q", ct)
+	}
+	var decoded struct {
+		Code int        `msgpack:"code"`
+		Data []testBook `msgpack:"data"`
+	}
+	dec := msgpack.NewDecoder(w.Body)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Code != http.StatusOK || len(decoded.Data) != 1 || decoded.Data[0].Title != "Dune" {
+		t.Fatalf("unexpected msgpack body %+v", decoded)
+	}
+
+	r.Header.Set("Accept", MediaTypeCSV)
+	w = httptest.NewRecorder()
+	WriteResponse(w, r, nil, data[0])
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406 for CSV of a single object, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected a JSON error, got %q", ct)
+	}
+
+	r.Header.Set("Accept", MediaTypeXML)
+	w = httptest.NewRecorder()
+	WriteResponse(w, r, NewErrNotFound("book not found"), nil)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "<message>book not found</message>") {
+		t.Fatalf("unexpected XML error %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDecode(t *testing.T) {
+	packed, err := msgpack.Marshal(map[string]interface{}{"title": "Dune", "published_year": 1965})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"", []byte(`{"title": "Dune", "published_year": 1965}`)},
+		{"application/json; charset=utf-8", []byte(`{"title": "Dune", "published_year": 1965}`)},
+		{"application/xml", []byte(`<book><title>Dune</title><published_year>1965</published_year></book>`)},
+		{"text/xml", []byte(`<book><title>Dune</title><published_year>1965</published_year></book>`)},
+		{"application/msgpack", packed},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader(tc.body))
+		r.Header.Set("Content-Type", tc.contentType)
+		var got testBook
+		if err := Decode(r, &got); err != nil {
+			t.Fatalf("%q: %v", tc.contentType, err)
+		}
+		if got.Title != "Dune" || got.Year != 1965 {
+			t.Fatalf("%q: got %+v", tc.contentType, got)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader("title=Dune"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := Decode(r, &testBook{}); StatusCode(err) != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(`{"published_year": "1965"}`))
+	err = Decode(r, &testBook{})
+	var validationErr *ErrValidation
+	if !errors.As(err, &validationErr) || validationErr.Errors[0].Code != CodeInvalidType {
+		t.Fatalf("expected an invalid_type field error, got %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(`{"title": "`+strings.Repeat("x", MaxBodySize)+`"}`))
+	if err := Decode(r, &testBook{}); StatusCode(err) != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %v", err)
+	}
+}
+
+// Keep the JSON encoding byte-for-byte what it was before negotiation.
+func TestWriteResponseJSONUnchanged(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/books", nil)
+	w := httptest.NewRecorder()
+	WriteResponse(w, r, nil, map[string]string{"a": "<b>"})
+
+	want, _ := json.Marshal(Response{Code: 200, Message: "success", Data: map[string]string{"a": "<b>"}})
+	if w.Body.String() != string(want) {
+		t.Fatalf("got %s want %s", w.Body.String(), want)
+	}
+}
@@ -0,0 +1,87 @@
+package helper
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types the API can respond with.
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeProblem = "application/problem+json"
+)
+
+// mediaRange is one entry of an Accept header.
+type mediaRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// parseAccept parses an Accept header. Entries that cannot be parsed are
+// skipped; an invalid quality counts as 0.
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, entry := range strings.Split(header, ",") {
+		params := strings.Split(entry, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || typ == "" || subtype == "" {
+			continue
+		}
+
+		mr := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			mr.q = q
+		}
+		ranges = append(ranges, mr)
+	}
+	return ranges
+}
+
+// quality returns the quality a parsed Accept header gives mediaType, taken
+// from its most specific matching range, and whether any range names it
+// exactly.
+func quality(ranges []mediaRange, mediaType string) (float64, bool) {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := -1
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			s = 2
+		case mr.typ == typ && mr.subtype == "*":
+			s = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q, specificity == 2
+}
+
+// wantsProblem reports whether r asks for application/problem+json errors:
+// it must name the type explicitly and prefer it at least as much as
+// application/json. Anything else gets the Response envelope.
+func wantsProblem(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	ranges := parseAccept(r.Header.Get("Accept"))
+	problemQ, explicit := quality(ranges, MediaTypeProblem)
+	if !explicit || problemQ == 0 {
+		return false
+	}
+	jsonQ, _ := quality(ranges, MediaTypeJSON)
+	return problemQ >= jsonQ
+}
@@ -1,64 +1,192 @@
 package helper
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/rs/zerolog/log"
 )
 
+// Response is the envelope of every JSON response. Errors lists the field
+// errors of a failed validation.
 type Response struct {
-	Code    int         `json:"code"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
-	Errors  string      `json:"errors,omitempty"`
+	Code    int          `json:"code"`
+	Message string       `json:"message"`
+	Data    interface{}  `json:"data,omitempty"`
+	Meta    interface{}  `json:"meta,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
 }
 
-func failResponseWriter(w http.ResponseWriter, err error, errStatusCode int) {
-	w.Header().Set("Content-Type", "application/json")
+// Problem is an RFC 9457 (formerly RFC 7807) problem details body, sent
+// instead of Response to clients that ask for application/problem+json.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+func failResponseWriter(w http.ResponseWriter, r *http.Request, err error, errStatusCode int) {
+	var fieldErrors []FieldError
+	if validationErr := asValidation(err); validationErr != nil {
+		fieldErrors = validationErr.Errors
+	}
+	var tooMany *ErrTooManyRequests
+	if errors.As(err, &tooMany) && tooMany.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+	}
+
+	if wantsProblem(r) {
+		problemResponseWriter(w, r, err, fieldErrors, errStatusCode)
+		return
+	}
 
 	var resp Response
-	w.WriteHeader(errStatusCode)
 	resp.Code = errStatusCode
 	resp.Message = err.Error()
 	resp.Data = nil
+	resp.Errors = fieldErrors
+
+	// Errors are never CSV; they fall back to JSON.
+	mediaType, negotiateErr := NegotiateFormat(r)
+	if negotiateErr != nil || mediaType == MediaTypeCSV {
+		mediaType = MediaTypeJSON
+	}
+	writeEncoded(w, mediaType, resp, errStatusCode)
+}
+
+func problemResponseWriter(w http.ResponseWriter, r *http.Request, err error, fieldErrors []FieldError, statusCode int) {
+	w.Header().Set("Content-Type", MediaTypeProblem)
+
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(statusCode),
+		Status:    statusCode,
+		Detail:    err.Error(),
+		Instance:  r.URL.RequestURI(),
+		RequestID: r.Header.Get("X-Request-Id"),
+		Errors:    fieldErrors,
+	}
+	w.WriteHeader(statusCode)
 
-	responseBytes, _ := json.Marshal(resp)
+	responseBytes, _ := json.Marshal(problem)
 	if _, writeErr := w.Write(responseBytes); writeErr != nil {
 		log.Error().Err(writeErr).Msg("failed to write response")
 	}
 }
 
-func successResponseWriter(w http.ResponseWriter, data interface{}, statusCode int) {
-	w.Header().Set("Content-Type", "application/json")
+func successResponseWriter(w http.ResponseWriter, r *http.Request, data interface{}, meta interface{}, statusCode int) {
+	mediaType, err := NegotiateFormat(r)
+	if err != nil {
+		failResponseWriter(w, r, err, http.StatusNotAcceptable)
+		return
+	}
+
+	// CSV holds the rows of data alone, without the envelope.
+	if mediaType == MediaTypeCSV {
+		var body bytes.Buffer
+		if err := encodeCSV(&body, data); err != nil {
+			failResponseWriter(w, r, NewErrNotAcceptable(err.Error()), http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypes[mediaType])
+		w.Header().Add("Vary", "Accept")
+		w.WriteHeader(statusCode)
+		if _, writeErr := w.Write(body.Bytes()); writeErr != nil {
+			log.Error().Err(writeErr).Msg("failed to write response")
+		}
+		return
+	}
 
 	var resp Response
-	w.WriteHeader(statusCode)
 	resp.Code = statusCode
 	resp.Message = "success"
 	resp.Data = data
+	resp.Meta = meta
 
-	responseBytes, _ := json.Marshal(resp)
-	if _, writeErr := w.Write(responseBytes); writeErr != nil {
+	writeEncoded(w, mediaType, resp, statusCode)
+}
+
+// writeEncoded writes resp as mediaType with statusCode.
+func writeEncoded(w http.ResponseWriter, mediaType string, resp Response, statusCode int) {
+	var body bytes.Buffer
+	if err := encode(&body, mediaType, resp); err != nil {
+		log.Error().Err(err).Str("media_type", mediaType).Msg("failed to encode response")
+		mediaType, statusCode = MediaTypeJSON, http.StatusInternalServerError
+		body.Reset()
+		_ = encode(&body, mediaType, Response{Code: statusCode, Message: "failed to encode response"})
+	}
+
+	w.Header().Set("Content-Type", contentTypes[mediaType])
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(statusCode)
+	if _, writeErr := w.Write(body.Bytes()); writeErr != nil {
 		log.Error().Err(writeErr).Msg("failed to write response")
 	}
 }
 
-func WriteResponse(w http.ResponseWriter, err error, data any) {
-	switch err.(type) {
-	case *ErrForbidden, ErrForbidden:
-		failResponseWriter(w, err, http.StatusForbidden)
-	case *ErrUnauthorized, ErrUnauthorized:
-		failResponseWriter(w, err, http.StatusUnauthorized)
-	case *ErrNotFound, ErrNotFound:
-		failResponseWriter(w, err, http.StatusNotFound)
-	case *ErrBadRequest, ErrBadRequest:
-		failResponseWriter(w, err, http.StatusBadRequest)
-	case *ErrInternalServer, ErrInternalServer:
-		failResponseWriter(w, err, http.StatusInternalServerError)
-	case nil:
-		successResponseWriter(w, data, http.StatusOK)
+func WriteResponse(w http.ResponseWriter, r *http.Request, err error, data any) {
+	WriteResponseWithMeta(w, r, err, data, nil)
+}
+
+// WriteResponseWithMeta is WriteResponse with meta, such as facet counts,
+// set next to data on success. A nil meta is omitted. The body is encoded in
+// the format r's Accept header prefers; see NegotiateFormat. Errors are
+// matched through errors.As, so wrapped errors keep their status, and are
+// written as problem details when r's Accept header prefers them.
+func WriteResponseWithMeta(w http.ResponseWriter, r *http.Request, err error, data any, meta any) {
+	if err == nil {
+		successResponseWriter(w, r, data, meta, http.StatusOK)
+		return
+	}
+	failResponseWriter(w, r, err, StatusCode(err))
+}
+
+// StatusCode returns the HTTP status of err: the status of the first typed
+// error in its chain, 500 when there is none.
+func StatusCode(err error) int {
+	switch {
+	case errors.As(err, new(*ErrForbidden)), errors.As(err, new(ErrForbidden)):
+		return http.StatusForbidden
+	case errors.As(err, new(*ErrUnauthorized)), errors.As(err, new(ErrUnauthorized)):
+		return http.StatusUnauthorized
+	case errors.As(err, new(*ErrNotFound)), errors.As(err, new(ErrNotFound)):
+		return http.StatusNotFound
+	case errors.As(err, new(*ErrBadRequest)), errors.As(err, new(ErrBadRequest)), asValidation(err) != nil:
+		return http.StatusBadRequest
+	case errors.As(err, new(*ErrConflict)), errors.As(err, new(ErrConflict)):
+		return http.StatusConflict
+	case errors.As(err, new(*ErrUnprocessableEntity)), errors.As(err, new(ErrUnprocessableEntity)):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, new(*ErrNotAcceptable)), errors.As(err, new(ErrNotAcceptable)):
+		return http.StatusNotAcceptable
+	case errors.As(err, new(*ErrUnsupportedMediaType)), errors.As(err, new(ErrUnsupportedMediaType)):
+		return http.StatusUnsupportedMediaType
+	case errors.As(err, new(*ErrPayloadTooLarge)), errors.As(err, new(ErrPayloadTooLarge)):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, new(*ErrTooManyRequests)), errors.As(err, new(ErrTooManyRequests)):
+		return http.StatusTooManyRequests
 	default:
-		failResponseWriter(w, err, http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+}
+
+// asValidation returns the validation error in err's chain, or nil.
+func asValidation(err error) *ErrValidation {
+	var validationErr *ErrValidation
+	if errors.As(err, &validationErr) {
+		return validationErr
+	}
+	var value ErrValidation
+	if errors.As(err, &value) {
+		return &value
 	}
+	return nil
 }
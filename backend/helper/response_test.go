@@ -0,0 +1,93 @@
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWantsProblem(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/*", false},
+		{"application/problem+json", true},
+		{"application/problem+json, application/json", true},
+		{"application/json, application/problem+json", true},
+		{"application/json;q=0.9, application/problem+json;q=0.5", false},
+		{"application/json;q=0.5, application/problem+json", true},
+		{"application/problem+json;q=0", false},
+		{"APPLICATION/PROBLEM+JSON", true},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", tc.accept)
+		if got := wantsProblem(r); got != tc.want {
+			t.Errorf("Accept %q: got %v want %v", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestStatusCodeUnwrapsErrors(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("get book: %w", NewErrNotFound("book not found")), http.StatusNotFound},
+		{fmt.Errorf("get book: %w", ErrNotFound{Message: "book not found"}), http.StatusNotFound},
+		{fmt.Errorf("create: %w", NewErrValidation("title", CodeRequired, "title is required")), http.StatusBadRequest},
+		{fmt.Errorf("auth: %w", NewErrUnauthorized("no token")), http.StatusUnauthorized},
+		{fmt.Errorf("auth: %w", NewErrForbidden("no access")), http.StatusForbidden},
+		{fmt.Errorf("login: %w", NewErrTooManyRequests("locked", time.Minute)), http.StatusTooManyRequests},
+		{fmt.Errorf("plain"), http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		if got := StatusCode(tc.err); got != tc.want {
+			t.Errorf("%v: got %d want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestWriteResponseProblem(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/books?x=1", nil)
+	r.Header.Set("Accept", MediaTypeProblem)
+	r.Header.Set("X-Request-Id", "abc")
+	w := httptest.NewRecorder()
+
+	WriteResponse(w, r, fmt.Errorf("create: %w", NewErrValidation("title", CodeRequired, "title is required")), nil)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != MediaTypeProblem {
+		t.Fatalf("got content type %q", ct)
+	}
+	var problem Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Title != "Bad Request" || problem.Status != http.StatusBadRequest || problem.Instance != "/api/v1/books?x=1" || problem.RequestID != "abc" {
+		t.Fatalf("unexpected problem %+v", problem)
+	}
+	if len(problem.Errors) != 1 || problem.Errors[0].Field != "title" {
+		t.Fatalf("unexpected field errors %+v", problem.Errors)
+	}
+}
+
+func TestWriteResponseRetryAfter(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+	w := httptest.NewRecorder()
+
+	WriteResponse(w, r, NewErrTooManyRequests("account locked", 1500*time.Millisecond), nil)
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("got %d with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
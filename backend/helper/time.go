@@ -0,0 +1,12 @@
+package helper
+
+import "time"
+
+// ParseTime reads a time from a query parameter: an RFC 3339 time or a date,
+// taken as midnight UTC.
+func ParseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, v)
+}
@@ -0,0 +1,107 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Codes of field errors, stable for clients to switch on.
+const (
+	CodeRequired    = "required"
+	CodeInvalid     = "invalid"
+	CodeTooShort    = "too_short"
+	CodeTooLong     = "too_long"
+	CodeOutOfRange  = "out_of_range"
+	CodeUnknown     = "unknown"
+	CodeInvalidType = "invalid_type"
+)
+
+// FieldError is one problem with a request field. Field is a path such as
+// "title" or "keep.author".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ErrValidation is a bad request carrying every field error found.
+type ErrValidation struct {
+	Errors []FieldError
+}
+
+// NewErrValidation returns a validation error for a single field.
+func NewErrValidation(field, code, message string) *ErrValidation {
+	return &ErrValidation{Errors: []FieldError{{Field: field, Code: code, Message: message}}}
+}
+
+func (e ErrValidation) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		messages[i] = fe.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator collects field errors so a request reports all of its problems
+// at once. The zero value is ready to use.
+type Validator struct {
+	errors []FieldError
+}
+
+// Add records a field error.
+func (v *Validator) Add(field, code, message string) {
+	v.errors = append(v.errors, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Check records a field error unless ok.
+func (v *Validator) Check(ok bool, field, code, message string) {
+	if !ok {
+		v.Add(field, code, message)
+	}
+}
+
+// Err returns the collected errors as an *ErrValidation, or nil when there
+// are none.
+func (v *Validator) Err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ErrValidation{Errors: v.errors}
+}
+
+// DecodeJSON decodes a JSON request body into dst. A value of the wrong type
+// is reported as a field error on its path; any other decoding problem as a
+// bad request.
+func DecodeJSON(r io.Reader, dst interface{}) error {
+	err := json.NewDecoder(r).Decode(dst)
+	if err == nil {
+		return nil
+	}
+	if tooLarge := bodyTooLarge(err); tooLarge != nil {
+		return tooLarge
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return NewErrValidation(typeErr.Field, CodeInvalidType, typeErr.Field+" must be a "+jsonType(typeErr.Type.Kind().String()))
+	}
+	return NewErrBadRequest("invalid JSON body")
+}
+
+// jsonType names a Go kind the way a JSON client would.
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "map", kind == "struct":
+		return "object"
+	default:
+		return kind
+	}
+}
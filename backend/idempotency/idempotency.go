@@ -0,0 +1,75 @@
+// Package idempotency stores the responses of requests sent with an
+// Idempotency-Key header so that retries replay them instead of repeating
+// the request.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrKeyReused = errors.New("Idempotency-Key was already used with a different request")
+	ErrInFlight  = errors.New("a request with this Idempotency-Key is still being processed")
+)
+
+// MaxKeyLength is the longest Idempotency-Key accepted.
+const MaxKeyLength = 255
+
+// Header is a stored set of response headers. It is stored as JSON.
+type Header http.Header
+
+func (h Header) Value() (driver.Value, error) {
+	return json.Marshal(h)
+}
+
+func (h *Header) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, h)
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	case nil:
+		*h = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into idempotency headers", src)
+	}
+}
+
+// Record is a request made with an Idempotency-Key and, once it completed,
+// its response. Keys are scoped to the method and path they were sent to.
+type Record struct {
+	Key         string     `db:"key"`
+	Method      string     `db:"method"`
+	Path        string     `db:"path"`
+	Fingerprint string     `db:"fingerprint"`
+	StatusCode  *int       `db:"status_code"`
+	Header      Header     `db:"headers"`
+	Body        []byte     `db:"body"`
+	CreatedAt   time.Time  `db:"created_at"`
+	CompletedAt *time.Time `db:"completed_at"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+}
+
+// Completed reports whether r holds a response to replay.
+func (r *Record) Completed() bool {
+	return r.CompletedAt != nil && r.StatusCode != nil
+}
+
+// Fingerprint identifies a request by the subject of the principal sending
+// it, empty for anonymous callers, and its method, URI, content type and
+// body, so a key sent again with a different request, or by someone else,
+// can be told apart from a retry.
+func Fingerprint(subject, method, requestURI, contentType string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n", subject, method, requestURI, contentType)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
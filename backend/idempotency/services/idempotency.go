@@ -0,0 +1,125 @@
+package services
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/idempotency"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DefaultTTL         = 24 * time.Hour
+	DefaultLockTimeout = time.Minute
+	// acquireAttempts bounds the retries of Begin when a key it found held
+	// expires or is purged before it can be read.
+	acquireAttempts = 3
+)
+
+type IdempotencyRepository interface {
+	Acquire(ctx context.Context, rec *idempotency.Record, lockTimeout time.Duration) (*idempotency.Record, error)
+	Get(ctx context.Context, key, method, path string) (*idempotency.Record, error)
+	Complete(ctx context.Context, rec *idempotency.Record, ttl time.Duration) error
+	Release(ctx context.Context, rec *idempotency.Record) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// Idempotency coordinates requests sent with an Idempotency-Key. TTL is how
+// long a response is replayed, LockTimeout how long a request may hold its
+// key before it is presumed dead and a retry may run.
+type Idempotency struct {
+	IdempotencyRepository IdempotencyRepository
+	TTL                   time.Duration
+	LockTimeout           time.Duration
+}
+
+func (s *Idempotency) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return DefaultTTL
+}
+
+func (s *Idempotency) lockTimeout() time.Duration {
+	if s.LockTimeout > 0 {
+		return s.LockTimeout
+	}
+	return DefaultLockTimeout
+}
+
+// Begin claims req's key. It returns the acquired record and a nil replay
+// when the request should run, or the stored record to replay when the
+// request already completed. A key used with a different request is a 422,
+// a key held by a request still running a 409.
+func (s *Idempotency) Begin(ctx context.Context, req *idempotency.Record) (acquired, replay *idempotency.Record, err error) {
+	log := log.Ctx(ctx).With().Str("service", "idempotency").Logger()
+
+	if req.Key == "" || len(req.Key) > idempotency.MaxKeyLength {
+		return nil, nil, helper.NewErrValidation("Idempotency-Key", helper.CodeInvalid, "Idempotency-Key must be between 1 and 255 characters")
+	}
+
+	for attempt := 0; attempt < acquireAttempts; attempt++ {
+		acquired, err := s.IdempotencyRepository.Acquire(ctx, req, s.lockTimeout())
+		if err == nil {
+			return acquired, nil, nil
+		}
+		if err != sql.ErrNoRows {
+			log.Error().Err(err).Msg("failed to acquire idempotency key")
+			return nil, nil, err
+		}
+
+		existing, err := s.IdempotencyRepository.Get(ctx, req.Key, req.Method, req.Path)
+		if err == sql.ErrNoRows {
+			// It expired since Acquire looked; try to take it over.
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get idempotency key")
+			return nil, nil, err
+		}
+
+		switch {
+		case existing.Fingerprint != req.Fingerprint:
+			return nil, nil, helper.NewErrUnprocessableEntity(idempotency.ErrKeyReused.Error())
+		case !existing.Completed():
+			return nil, nil, helper.NewErrConflict(idempotency.ErrInFlight.Error())
+		default:
+			return nil, existing, nil
+		}
+	}
+	return nil, nil, helper.NewErrConflict(idempotency.ErrInFlight.Error())
+}
+
+// Complete stores the response of an acquired request for replay.
+func (s *Idempotency) Complete(ctx context.Context, rec *idempotency.Record) error {
+	return s.IdempotencyRepository.Complete(ctx, rec, s.ttl())
+}
+
+// Release frees an acquired key without storing a response, so a retry runs
+// the request again.
+func (s *Idempotency) Release(ctx context.Context, rec *idempotency.Record) error {
+	return s.IdempotencyRepository.Release(ctx, rec)
+}
+
+// PurgeExpired deletes expired idempotency records every interval until ctx
+// is cancelled.
+func (s *Idempotency) PurgeExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.IdempotencyRepository.DeleteExpired(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to purge expired idempotency keys")
+				continue
+			}
+			log.Debug().Int64("deleted", deleted).Msg("expired idempotency keys purged")
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package stores
+
+import (
+	"byfood-interview/idempotency"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/tenant"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const recordColumns = "key, method, path, fingerprint, status_code, headers, body, created_at, completed_at, expires_at"
+
+// Idempotency stores idempotency keys under the tenant on the context of
+// each call, so tenants never see each other's responses even when they
+// pick the same key.
+type Idempotency struct {
+	db *sqlx.DB
+}
+
+func NewIdempotency(db *sqlx.DB) *Idempotency {
+	return &Idempotency{db: db}
+}
+
+func (s *Idempotency) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+// Acquire claims rec's key for a request in flight until lockTimeout from
+// now. It takes over a row whose expiry has passed, and returns sql.ErrNoRows
+// when the key is held by a live row; concurrent callers for one key are
+// serialized by the primary key, so at most one of them acquires it.
+func (s *Idempotency) Acquire(ctx context.Context, rec *idempotency.Record, lockTimeout time.Duration) (*idempotency.Record, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired idempotency.Record
+	query := `INSERT INTO idempotency_keys (key, method, path, fingerprint, expires_at, tenant_id)
+	VALUES ($1, $2, $3, $4, NOW() + make_interval(secs => $5), $6)
+	ON CONFLICT (tenant_id, key, method, path) DO UPDATE SET
+		fingerprint = EXCLUDED.fingerprint,
+		status_code = NULL,
+		headers = NULL,
+		body = NULL,
+		created_at = NOW(),
+		completed_at = NULL,
+		expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at <= NOW()
+	RETURNING ` + recordColumns
+	err = s.conn(ctx).GetContext(ctx, &acquired, query, rec.Key, rec.Method, rec.Path, rec.Fingerprint, lockTimeout.Seconds(), tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return &acquired, nil
+}
+
+// Get returns the live record of a key, or sql.ErrNoRows.
+func (s *Idempotency) Get(ctx context.Context, key, method, path string) (*idempotency.Record, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rec idempotency.Record
+	query := "SELECT " + recordColumns + " FROM idempotency_keys WHERE tenant_id = $4 AND key = $1 AND method = $2 AND path = $3 AND expires_at > NOW()"
+	if err := s.conn(ctx).GetContext(ctx, &rec, query, key, method, path, tenantID); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Complete stores the response of the in-flight request that acquired rec
+// and keeps it for ttl. It returns sql.ErrNoRows when the key is no longer
+// held by that request, told apart from a later one by its created_at.
+func (s *Idempotency) Complete(ctx context.Context, rec *idempotency.Record, ttl time.Duration) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+
+	var completedAt time.Time
+	query := `UPDATE idempotency_keys SET
+		status_code = $5,
+		headers = $6,
+		body = $7,
+		completed_at = NOW(),
+		expires_at = NOW() + make_interval(secs => $8)
+	WHERE tenant_id = $9 AND key = $1 AND method = $2 AND path = $3 AND created_at = $4 AND completed_at IS NULL
+	RETURNING completed_at`
+	err = s.conn(ctx).GetContext(ctx, &completedAt, query, rec.Key, rec.Method, rec.Path, rec.CreatedAt,
+		rec.StatusCode, rec.Header, rec.Body, ttl.Seconds(), tenantID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to complete idempotency key")
+		return err
+	}
+	return nil
+}
+
+// Release frees the key held by the in-flight request that acquired rec so
+// that a retry runs the request again.
+func (s *Idempotency) Release(ctx context.Context, rec *idempotency.Record) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := "DELETE FROM idempotency_keys WHERE tenant_id = $5 AND key = $1 AND method = $2 AND path = $3 AND created_at = $4 AND completed_at IS NULL"
+	if _, err := s.conn(ctx).ExecContext(ctx, query, rec.Key, rec.Method, rec.Path, rec.CreatedAt, tenantID); err != nil {
+		log.Error().Err(err).Msg("failed to release idempotency key")
+		return err
+	}
+	return nil
+}
+
+// DeleteExpired removes the records of every tenant whose expiry has passed
+// and returns how many there were.
+func (s *Idempotency) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= NOW()")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete expired idempotency keys")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// WithinTenant runs fn with the row-level security policies of tenant-owned
+// tables scoped to tenantID, inside the transaction carried on ctx or a new
+// one opened on db. The setting is local to the transaction, so it never
+// outlives it on the pooled connection.
+func WithinTenant(ctx context.Context, db *sqlx.DB, tenantID int64, fn func(ctx context.Context) error) error {
+	return within(ctx, db, func(ctx context.Context, state *txState) error {
+		if state.tenantID != tenantID {
+			query := "SELECT set_config('app.tenant_id', $1, true)"
+			if _, err := state.tx.ExecContext(ctx, query, strconv.FormatInt(tenantID, 10)); err != nil {
+				return fmt.Errorf("set tenant: %w", err)
+			}
+			state.tenantID = tenantID
+		}
+		return fn(ctx)
+	})
+}
+
+// ForTenant runs fn with the row-level security policies of tenant-owned
+// tables scoped to tenantID. When ctx carries a tenant connection already
+// scoped to it, outside of a transaction, fn runs on that connection as is;
+// otherwise it runs as in WithinTenant. Statements that need a transaction
+// of their own must use WithinTenant instead.
+func ForTenant(ctx context.Context, db *sqlx.DB, tenantID int64, fn func(ctx context.Context) error) error {
+	if !InTx(ctx) {
+		if c := tenantConnFrom(ctx, db); c != nil && c.tenantID == tenantID {
+			if _, err := c.open(ctx); err != nil {
+				return err
+			}
+			return fn(ctx)
+		}
+	}
+	return WithinTenant(ctx, db, tenantID, fn)
+}
+
+// WithinAllTenants runs fn with the row-level security policies of
+// tenant-owned tables lifted, for maintenance that spans every tenant. Like
+// WithinTenant it joins the transaction on ctx or opens one on db; the
+// policies apply again once fn returns.
+func WithinAllTenants(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context) error) error {
+	return within(ctx, db, func(ctx context.Context, state *txState) error {
+		if _, err := state.tx.ExecContext(ctx, "SELECT set_config('app.all_tenants', 'on', true)"); err != nil {
+			return fmt.Errorf("lift tenant scope: %w", err)
+		}
+		if err := fn(ctx); err != nil {
+			return err
+		}
+		if _, err := state.tx.ExecContext(ctx, "SELECT set_config('app.all_tenants', 'off', true)"); err != nil {
+			return fmt.Errorf("restore tenant scope: %w", err)
+		}
+		return nil
+	})
+}
+
+// within runs fn in the transaction carried on ctx, without a savepoint, or
+// in a new one opened on db.
+func within(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context, state *txState) error) error {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return fn(ctx, state)
+	}
+	return NewTransactor(db).WithinTransaction(ctx, func(ctx context.Context) error {
+		return fn(ctx, ctx.Value(txKey{}).(*txState))
+	})
+}
+
+type tenantConnKey struct{}
+
+// tenantConn is a connection of db held for the statements run on a
+// context, with its session scoped to tenantID. It is taken from the pool
+// when first used.
+type tenantConn struct {
+	db       *sqlx.DB
+	tenantID int64
+	conn     *sqlx.Conn
+}
+
+// boundConn is a connection taken from db that runs statements as db does.
+type boundConn struct {
+	*sqlx.Conn
+	db *sqlx.DB
+}
+
+func (c boundConn) DriverName() string         { return c.db.DriverName() }
+func (c boundConn) Rebind(query string) string { return c.db.Rebind(query) }
+func (c boundConn) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	return c.db.BindNamed(query, arg)
+}
+
+// WithTenantConn returns a context on which the statements Conn, ForTenant
+// and Transactor run on db share one connection, scoped to tenantID once
+// rather than in a transaction per statement. release returns the
+// connection to the pool and must be called once the context is done with.
+//
+// Like a transaction, the context must not be shared across goroutines.
+func WithTenantConn(ctx context.Context, db *sqlx.DB, tenantID int64) (_ context.Context, release func()) {
+	c := &tenantConn{db: db, tenantID: tenantID}
+	return context.WithValue(ctx, tenantConnKey{}, c), c.release
+}
+
+// tenantConnFrom returns the tenant connection of db carried on ctx, if any.
+func tenantConnFrom(ctx context.Context, db *sqlx.DB) *tenantConn {
+	if c, ok := ctx.Value(tenantConnKey{}).(*tenantConn); ok && c.db == db {
+		return c
+	}
+	return nil
+}
+
+// open returns the connection of c, taking it from the pool and scoping its
+// session to the tenant on first use.
+func (c *tenantConn) open(ctx context.Context) (*sqlx.Conn, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	conn, err := c.db.Connx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get connection: %w", err)
+	}
+	query := "SELECT set_config('app.tenant_id', $1, false)"
+	if _, err := conn.ExecContext(ctx, query, strconv.FormatInt(c.tenantID, 10)); err != nil {
+		discard(conn)
+		return nil, fmt.Errorf("set tenant: %w", err)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// release clears the tenant of the connection of c, if it was taken, and
+// returns it to the pool. A connection whose tenant cannot be cleared is
+// closed instead, so it never serves another tenant.
+func (c *tenantConn) release() {
+	if c.conn == nil {
+		return
+	}
+	conn := c.conn
+	c.conn = nil
+	if _, err := conn.ExecContext(context.Background(), "SELECT set_config('app.tenant_id', '', false)"); err != nil {
+		discard(conn)
+		return
+	}
+	conn.Close()
+}
+
+// discard closes conn for good rather than returning it to the pool.
+func discard(conn *sqlx.Conn) {
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+// BypassesRowSecurity reports whether the role db connects as skips
+// row-level security, as superusers and BYPASSRLS roles do.
+func BypassesRowSecurity(ctx context.Context, db *sqlx.DB) (bool, error) {
+	var bypasses bool
+	query := "SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user"
+	if err := db.GetContext(ctx, &bypasses, query); err != nil {
+		return false, fmt.Errorf("check role: %w", err)
+	}
+	return bypasses, nil
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Querier is the subset of sqlx shared by *sqlx.DB and *sqlx.Tx that
+// repositories need to run their statements.
+type Querier interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+type txKey struct{}
+
+// txState is carried on the context while a transaction is open. depth is
+// the number of savepoints opened on top of the outer transaction; tenantID
+// is the tenant the row-level security policies are scoped to, if any.
+type txState struct {
+	tx       *sqlx.Tx
+	depth    int
+	tenantID int64
+}
+
+// Conn returns the transaction carried on ctx, else the tenant connection
+// of db carried on it once taken, or db when there is neither. Repositories
+// call it for every statement so they transparently join a transaction
+// started by a service.
+func Conn(ctx context.Context, db *sqlx.DB) Querier {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx
+	}
+	if c := tenantConnFrom(ctx, db); c != nil && c.conn != nil {
+		return boundConn{Conn: c.conn, db: db}
+	}
+	return db
+}
+
+// InTx reports whether ctx carries an open transaction.
+func InTx(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*txState)
+	return ok
+}
+
+// TxRunner runs fn atomically. Repository calls made with the context
+// handed to fn join the same transaction. Transactor implements it; services
+// take one so they can be tested without a database.
+type TxRunner interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Within runs fn through t, or directly when t is nil.
+func Within(ctx context.Context, t TxRunner, fn func(ctx context.Context) error) error {
+	if t == nil {
+		return fn(ctx)
+	}
+	return t.WithinTransaction(ctx, fn)
+}
+
+type Transactor struct {
+	db *sqlx.DB
+}
+
+func NewTransactor(db *sqlx.DB) *Transactor {
+	return &Transactor{db: db}
+}
+
+// WithinTransaction runs fn inside a transaction. When ctx already carries a
+// transaction, fn runs inside a savepoint of it instead, so nested calls can
+// be rolled back on their own. A new transaction runs on the tenant
+// connection carried on ctx, if any, and so starts scoped to its tenant. The
+// transaction (or savepoint) is rolled back when fn returns an error or
+// panics and committed (or released) otherwise.
+//
+// The context handed to fn must not be shared across goroutines.
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return withinSavepoint(ctx, state, fn)
+	}
+
+	var (
+		tx       *sqlx.Tx
+		tenantID int64
+	)
+	if c := tenantConnFrom(ctx, t.db); c != nil {
+		conn, err := c.open(ctx)
+		if err != nil {
+			return err
+		}
+		tx, err = conn.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		tenantID = c.tenantID
+	} else {
+		tx, err = t.db.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil && rbErr != sql.ErrTxDone {
+				err = fmt.Errorf("%w (rollback: %v)", err, rbErr)
+			}
+			return
+		}
+		if cmErr := tx.Commit(); cmErr != nil {
+			err = fmt.Errorf("commit transaction: %w", cmErr)
+		}
+	}()
+
+	return fn(context.WithValue(ctx, txKey{}, &txState{tx: tx, tenantID: tenantID}))
+}
+
+func withinSavepoint(ctx context.Context, parent *txState, fn func(ctx context.Context) error) (err error) {
+	// A rolled back savepoint also reverts settings made inside it, so
+	// only the parent's tenant carries over.
+	state := &txState{tx: parent.tx, depth: parent.depth + 1, tenantID: parent.tenantID}
+	name := fmt.Sprintf("sp_%d", state.depth)
+
+	if _, err := state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("create savepoint: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+			panic(p)
+		}
+		if err != nil {
+			if _, rbErr := state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+				err = fmt.Errorf("%w (rollback savepoint: %v)", err, rbErr)
+			}
+			return
+		}
+		if _, relErr := state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); relErr != nil {
+			err = fmt.Errorf("release savepoint: %w", relErr)
+		}
+	}()
+
+	return fn(context.WithValue(ctx, txKey{}, state))
+}
@@ -0,0 +1,106 @@
+// Package search normalizes and tokenizes book text so Japanese, English and
+// mixed titles can be matched without relying on the Postgres text parser,
+// which does not split kanji or kana into words.
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// Normalize folds s into the form used for indexing and querying: NFKC,
+// full-width/half-width folding, lower case and katakana folded to hiragana.
+func Normalize(s string) string {
+	s = norm.NFKC.String(s)
+	s = width.Fold.String(s)
+	s = strings.ToLower(s)
+	return strings.Map(foldKana, s)
+}
+
+// foldKana maps katakana to the matching hiragana so either script finds
+// the other.
+func foldKana(r rune) rune {
+	switch {
+	case r >= 'ァ' && r <= 'ヶ':
+		return r - ('ァ' - 'ぁ')
+	case r == 'ヽ' || r == 'ヾ':
+		return r - ('ヽ' - 'ゝ')
+	}
+	return r
+}
+
+// IndexTokens returns the tokens stored for s at write time: every word of
+// non-CJK text, and every unigram and bigram of CJK runs so both one and
+// two character queries match.
+func IndexTokens(s string) []string {
+	return tokenize(Normalize(s), true)
+}
+
+// QueryTokens returns the tokens a query for s must all match: every word of
+// non-CJK text, and the bigrams of CJK runs (or the single character of a
+// one character run).
+func QueryTokens(s string) []string {
+	return tokenize(Normalize(s), false)
+}
+
+func tokenize(s string, withUnigrams bool) []string {
+	tokens := []string{}
+	seen := map[string]bool{}
+	add := func(token string) {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+
+	var word strings.Builder
+	var cjk []rune
+	flushWord := func() {
+		if word.Len() > 0 {
+			add(word.String())
+			word.Reset()
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1:
+			add(string(cjk))
+		case len(cjk) > 1:
+			for i := range cjk {
+				if withUnigrams {
+					add(string(cjk[i]))
+				}
+				if i+1 < len(cjk) {
+					add(string(cjk[i : i+2]))
+				}
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range s {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word.WriteRune(r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		r == 'ー' || r == '々'
+}
@@ -0,0 +1,76 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"full-width latin", "ＲＡＭＥＮ　Ｇｕｉｄｅ", "ramen guide"},
+		{"half-width katakana", "ｽｼ", "すし"},
+		{"katakana to hiragana", "ラーメン", "らーめん"},
+		{"iteration mark", "ヽ", "ゝ"},
+		{"kanji untouched", "寿司", "寿司"},
+		{"mixed", "Tokyo ラーメン 2024", "tokyo らーめん 2024"},
+	}
+	for _, tc := range cases {
+		if got := Normalize(tc.in); got != tc.want {
+			t.Fatalf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIndexTokens(t *testing.T) {
+	got := IndexTokens("Sushi 寿司の歴史")
+	want := []string{"sushi", "寿", "寿司", "司", "司の", "の", "の歴", "歴", "歴史", "史"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestQueryTokens(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"english", "The Ramen Book", []string{"the", "ramen", "book"}},
+		{"kanji bigrams", "寿司の", []string{"寿司", "司の"}},
+		{"single kanji", "寿", []string{"寿"}},
+		{"katakana folds", "スシ", []string{"すし"}},
+		{"mixed", "ラーメン guide", []string{"らー", "ーめ", "めん", "guide"}},
+		{"punctuation only", "!!", []string{}},
+	}
+	for _, tc := range cases {
+		if got := QueryTokens(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestQueryTokensMatchIndexTokens(t *testing.T) {
+	index := map[string]bool{}
+	for _, token := range IndexTokens("すし職人の技 Sushi Craft") {
+		index[token] = true
+	}
+
+	for _, query := range []string{"スシ", "職人", "技", "ｓｕｓｈｉ", "SUSHI craft", "寿"} {
+		matched := true
+		for _, token := range QueryTokens(query) {
+			if !index[token] {
+				matched = false
+			}
+		}
+		if query == "寿" && matched {
+			t.Fatalf("query %q: expected no match", query)
+		}
+		if query != "寿" && !matched {
+			t.Fatalf("query %q: expected match", query)
+		}
+	}
+}
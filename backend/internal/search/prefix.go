@@ -0,0 +1,354 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/btree"
+)
+
+// RankMode decides how the items sharing a term are ranked by Lookup.
+type RankMode int
+
+const (
+	// RankByScore ranks a term by the highest score of its items, e.g. the
+	// time its most recent item was updated.
+	RankByScore RankMode = iota
+	// RankByCount ranks a term by how many items share it.
+	RankByCount
+)
+
+const (
+	// cacheScanThreshold is the number of matching entries above which the
+	// results of a prefix are cached. Broad prefixes such as "t" or "the"
+	// match a large part of the index, so caching them keeps every lookup
+	// cheap while narrow prefixes are answered straight from the tree.
+	cacheScanThreshold = 1000
+	// MaxLookupLimit is the largest number of suggestions Lookup returns.
+	MaxLookupLimit = 50
+	// cacheDepth is the number of top terms cached for a prefix. Keeping
+	// more than MaxLookupLimit lets a cached prefix absorb terms leaving it
+	// without being scanned again until fewer than MaxLookupLimit remain.
+	cacheDepth = 4 * MaxLookupLimit
+)
+
+// Suggestion is a term completing a prefix with its ranking score.
+type Suggestion struct {
+	Term  string  `json:"term"`
+	Score float64 `json:"score"`
+}
+
+type prefixItem struct {
+	id    string
+	term  string
+	score float64
+	keys  []string
+}
+
+// cachedLookup is the ranking of the top terms of a prefix. complete is set
+// when it holds every term matching the prefix.
+type cachedLookup struct {
+	suggestions []Suggestion
+	complete    bool
+}
+
+type prefixEntry struct {
+	key  string
+	item *prefixItem
+}
+
+func lessPrefixEntry(a, b prefixEntry) bool {
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	if a.item.term != b.item.term {
+		return a.item.term < b.item.term
+	}
+	return a.item.id < b.item.id
+}
+
+// PrefixIndex is an in-memory index completing prefixes of normalized terms.
+// Every word start of a term is indexed, so "mura" completes "Haruki
+// Murakami". It is safe for concurrent use.
+type PrefixIndex struct {
+	rank RankMode
+
+	mu    sync.RWMutex
+	tree  *btree.BTreeG[prefixEntry]
+	items map[string]*prefixItem
+	// terms holds the items of each term by ID, so the score of a term is
+	// known without scanning the tree.
+	terms      map[string]map[string]*prefixItem
+	cache      map[string]*cachedLookup
+	generation uint64
+}
+
+func NewPrefixIndex(rank RankMode) *PrefixIndex {
+	return &PrefixIndex{
+		rank:  rank,
+		tree:  btree.NewG(32, lessPrefixEntry),
+		items: map[string]*prefixItem{},
+		terms: map[string]map[string]*prefixItem{},
+		cache: map[string]*cachedLookup{},
+	}
+}
+
+// Put indexes term under id with score, replacing what id held before.
+func (x *PrefixIndex) Put(id, term string, score float64) {
+	item := &prefixItem{id: id, term: term, score: score, keys: wordStarts(Normalize(term))}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeLocked(id)
+	x.items[id] = item
+	if x.terms[term] == nil {
+		x.terms[term] = map[string]*prefixItem{}
+	}
+	x.terms[term][id] = item
+	for _, key := range item.keys {
+		x.tree.ReplaceOrInsert(prefixEntry{key: key, item: item})
+	}
+	for _, prefix := range x.cachedPrefixesLocked(item) {
+		x.cacheUpdateLocked(prefix, term)
+	}
+	x.generation++
+}
+
+// Remove drops id from the index.
+func (x *PrefixIndex) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.removeLocked(id)
+	x.generation++
+}
+
+func (x *PrefixIndex) removeLocked(id string) {
+	item, ok := x.items[id]
+	if !ok {
+		return
+	}
+	for _, key := range item.keys {
+		x.tree.Delete(prefixEntry{key: key, item: item})
+	}
+	delete(x.items, id)
+	delete(x.terms[item.term], id)
+	if len(x.terms[item.term]) == 0 {
+		delete(x.terms, item.term)
+	}
+	for _, prefix := range x.cachedPrefixesLocked(item) {
+		x.cacheUpdateLocked(prefix, item.term)
+	}
+}
+
+// cachedPrefixesLocked returns the cached prefixes matching any key of item.
+func (x *PrefixIndex) cachedPrefixesLocked(item *prefixItem) []string {
+	if len(x.cache) == 0 {
+		return nil
+	}
+	var prefixes []string
+	seen := map[string]bool{}
+	for _, key := range item.keys {
+		for i := range key {
+			_, size := utf8.DecodeRuneInString(key[i:])
+			prefix := key[:i+size]
+			if _, ok := x.cache[prefix]; ok && !seen[prefix] {
+				seen[prefix] = true
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+	return prefixes
+}
+
+// termScoreLocked returns the ranking score of term, or false when no item
+// holds it any more.
+func (x *PrefixIndex) termScoreLocked(term string) (float64, bool) {
+	items := x.terms[term]
+	if len(items) == 0 {
+		return 0, false
+	}
+	if x.rank == RankByCount {
+		return float64(len(items)), true
+	}
+	score := math.Inf(-1)
+	for _, item := range items {
+		score = max(score, item.score)
+	}
+	return score, true
+}
+
+// cacheUpdateLocked re-ranks term, whose items changed, in the cached
+// results of prefix. Terms outside a partial ranking are unknown, so a term
+// that falls to its end is left out, and the ranking is dropped, to be
+// scanned again, only once fewer than MaxLookupLimit terms remain.
+func (x *PrefixIndex) cacheUpdateLocked(prefix, term string) {
+	entry := x.cache[prefix]
+	cached := entry.suggestions
+	score, ok := x.termScoreLocked(term)
+
+	i := indexOfTerm(cached, term)
+	var previous float64
+	switch {
+	case i >= 0 && !ok:
+		cached = append(cached[:i], cached[i+1:]...)
+	case i >= 0:
+		previous = cached[i].Score
+		cached[i].Score = score
+	case ok:
+		cached = append(cached, Suggestion{Term: term, Score: score})
+	default:
+		return
+	}
+	sortSuggestions(cached)
+
+	if entry.complete && len(cached) > cacheDepth {
+		entry.complete = false
+	}
+	if !entry.complete {
+		if n := len(cached); ok && cached[n-1].Term == term && (i < 0 || score < previous) {
+			cached = cached[:n-1]
+		}
+		if len(cached) < MaxLookupLimit {
+			delete(x.cache, prefix)
+			return
+		}
+	}
+	entry.suggestions = truncateSuggestions(cached, cacheDepth)
+}
+
+func indexOfTerm(suggestions []Suggestion, term string) int {
+	for i, s := range suggestions {
+		if s.Term == term {
+			return i
+		}
+	}
+	return -1
+}
+
+// Len returns the number of indexed items.
+func (x *PrefixIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.items)
+}
+
+// Lookup returns up to limit distinct terms with a word starting with
+// prefix, best ranked first.
+func (x *PrefixIndex) Lookup(prefix string, limit int) []Suggestion {
+	prefix = Normalize(strings.TrimSpace(prefix))
+	if prefix == "" || limit <= 0 {
+		return []Suggestion{}
+	}
+	if limit > MaxLookupLimit {
+		limit = MaxLookupLimit
+	}
+
+	x.mu.RLock()
+	if cached, ok := x.cache[prefix]; ok {
+		suggestions := truncateSuggestions(cached.suggestions, limit)
+		x.mu.RUnlock()
+		return suggestions
+	}
+	generation := x.generation
+	suggestions, scanned := x.lookupLocked(prefix)
+	x.mu.RUnlock()
+
+	if scanned >= cacheScanThreshold {
+		x.mu.Lock()
+		if x.generation == generation {
+			x.cache[prefix] = &cachedLookup{
+				suggestions: truncateSuggestions(suggestions, cacheDepth),
+				complete:    len(suggestions) <= cacheDepth,
+			}
+		}
+		x.mu.Unlock()
+	}
+
+	return truncateSuggestions(suggestions, limit)
+}
+
+// lookupLocked ranks every term matching prefix and returns the number of
+// index entries scanned.
+func (x *PrefixIndex) lookupLocked(prefix string) ([]Suggestion, int) {
+	type aggregate struct {
+		score float64
+		count int
+	}
+	seen := map[string]bool{}
+	byTerm := map[string]*aggregate{}
+	scanned := 0
+
+	pivot := prefixEntry{key: prefix, item: &prefixItem{}}
+	x.tree.AscendGreaterOrEqual(pivot, func(e prefixEntry) bool {
+		if !strings.HasPrefix(e.key, prefix) {
+			return false
+		}
+		scanned++
+		if seen[e.item.id] {
+			return true
+		}
+		seen[e.item.id] = true
+
+		agg, ok := byTerm[e.item.term]
+		if !ok {
+			agg = &aggregate{score: e.item.score}
+			byTerm[e.item.term] = agg
+		}
+		agg.count++
+		if e.item.score > agg.score {
+			agg.score = e.item.score
+		}
+		return true
+	})
+
+	suggestions := make([]Suggestion, 0, len(byTerm))
+	for term, agg := range byTerm {
+		score := agg.score
+		if x.rank == RankByCount {
+			score = float64(agg.count)
+		}
+		suggestions = append(suggestions, Suggestion{Term: term, Score: score})
+	}
+	sortSuggestions(suggestions)
+
+	return suggestions, scanned
+}
+
+func sortSuggestions(suggestions []Suggestion) {
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].Term < suggestions[j].Term
+	})
+}
+
+func truncateSuggestions(suggestions []Suggestion, limit int) []Suggestion {
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	out := make([]Suggestion, len(suggestions))
+	copy(out, suggestions)
+	return out
+}
+
+// wordStarts returns the suffixes of s that start a word. The suffixes
+// share s's memory.
+func wordStarts(s string) []string {
+	var keys []string
+	inWord := false
+	for i, r := range s {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && !inWord {
+			keys = append(keys, s[i:])
+		}
+		inWord = isWord
+	}
+	return keys
+}
@@ -0,0 +1,199 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func terms(suggestions []Suggestion) []string {
+	out := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		out[i] = s.Term
+	}
+	return out
+}
+
+func TestPrefixIndexLookupByScore(t *testing.T) {
+	x := NewPrefixIndex(RankByScore)
+	x.Put("1", "Kafka on the Shore", 1)
+	x.Put("2", "The Kitchen", 3)
+	x.Put("3", "Kitchen Confidential", 2)
+	x.Put("4", "ラーメンの歴史", 4)
+
+	if got, want := terms(x.Lookup("ki", 10)), []string{"The Kitchen", "Kitchen Confidential"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := terms(x.Lookup("KAF", 10)), []string{"Kafka on the Shore"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got, want := terms(x.Lookup("ﾗｰﾒﾝ", 10)), []string{"ラーメンの歴史"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if got := x.Lookup("ki", 1); len(got) != 1 {
+		t.Fatalf("expected limit to apply, got %v", got)
+	}
+	if got := x.Lookup("  ", 10); len(got) != 0 {
+		t.Fatalf("expected no suggestions for a blank prefix, got %v", got)
+	}
+}
+
+func TestPrefixIndexLookupByCount(t *testing.T) {
+	x := NewPrefixIndex(RankByCount)
+	x.Put("1", "Haruki Murakami", 0)
+	x.Put("2", "Haruki Murakami", 0)
+	x.Put("3", "Ryu Murakami", 0)
+
+	got := x.Lookup("mura", 10)
+	want := []Suggestion{{Term: "Haruki Murakami", Score: 2}, {Term: "Ryu Murakami", Score: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
+func TestPrefixIndexUpdatesInvalidateCache(t *testing.T) {
+	x := NewPrefixIndex(RankByScore)
+	x.Put("1", "Sushi", 1)
+
+	if got := terms(x.Lookup("s", 10)); !reflect.DeepEqual(got, []string{"Sushi"}) {
+		t.Fatalf("got %q", got)
+	}
+
+	x.Put("2", "Sashimi", 2)
+	if got, want := terms(x.Lookup("s", 10)), []string{"Sashimi", "Sushi"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after put: got %q want %q", got, want)
+	}
+
+	x.Put("1", "Tempura", 1)
+	if got, want := terms(x.Lookup("s", 10)), []string{"Sashimi"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after replace: got %q want %q", got, want)
+	}
+
+	x.Remove("2")
+	if got := x.Lookup("s", 10); len(got) != 0 {
+		t.Fatalf("after remove: got %v", got)
+	}
+	if x.Len() != 1 {
+		t.Fatalf("expected 1 item, got %d", x.Len())
+	}
+}
+
+func TestPrefixIndexCachedPrefixStaysCurrent(t *testing.T) {
+	x := NewPrefixIndex(RankByScore)
+	for i := 0; i < 2*cacheScanThreshold; i++ {
+		x.Put(fmt.Sprint(i), fmt.Sprintf("Sushi %d", i), float64(i))
+	}
+
+	want := []string{"Sushi 1999", "Sushi 1998"}
+	if got := terms(x.Lookup("su", 2)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if _, ok := x.cache["su"]; !ok {
+		t.Fatal("expected broad prefix to be cached")
+	}
+
+	x.Put("new", "Sushi Deluxe", 5000)
+	if got, want := terms(x.Lookup("su", 2)), []string{"Sushi Deluxe", "Sushi 1999"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after put: got %q want %q", got, want)
+	}
+
+	x.Remove("new")
+	if got := terms(x.Lookup("su", 2)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after remove: got %q want %q", got, want)
+	}
+
+	x.Put("1999", "Tempura", 1999)
+	if got, want := terms(x.Lookup("su", 2)), []string{"Sushi 1998", "Sushi 1997"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after replace: got %q want %q", got, want)
+	}
+
+	// Terms leaving the prefix are backfilled from the deeper cached ranking
+	// until too few of it remain.
+	next := 1998
+	for len(x.cache["su"].suggestions) > MaxLookupLimit {
+		x.Remove(fmt.Sprint(next))
+		next--
+	}
+	if got, want := terms(x.Lookup("su", 1)), []string{fmt.Sprintf("Sushi %d", next)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after removes: got %q want %q", got, want)
+	}
+	x.Remove(fmt.Sprint(next))
+	if _, ok := x.cache["su"]; ok {
+		t.Fatal("expected a depleted ranking to be dropped")
+	}
+	if got, want := terms(x.Lookup("su", 1)), []string{fmt.Sprintf("Sushi %d", next-1)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after rescan: got %q want %q", got, want)
+	}
+}
+
+func TestPrefixIndexCachedCountStaysCurrent(t *testing.T) {
+	x := NewPrefixIndex(RankByCount)
+	for i := 0; i < 2*cacheScanThreshold; i++ {
+		x.Put(fmt.Sprint(i), fmt.Sprintf("Author %d", i%10), 0)
+	}
+
+	if got := x.Lookup("au", 1); len(got) != 1 || got[0].Score != 200 {
+		t.Fatalf("unexpected suggestions %v", got)
+	}
+
+	x.Put("extra", "Author 7", 0)
+	if got := x.Lookup("au", 1); got[0].Term != "Author 7" || got[0].Score != 201 {
+		t.Fatalf("after put: unexpected suggestions %v", got)
+	}
+
+	x.Remove("extra")
+	x.Remove("7")
+	if got := x.Lookup("au", 10); len(got) != 10 || got[9].Term != "Author 7" || got[9].Score != 199 {
+		t.Fatalf("after remove: unexpected suggestions %v", got)
+	}
+}
+
+func TestPrefixIndexLookupLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large index in short mode")
+	}
+
+	x := NewPrefixIndex(RankByScore)
+	for i := 0; i < 1_000_000; i++ {
+		x.Put(fmt.Sprint(i), fmt.Sprintf("Book %d of the Collection", i), float64(i))
+	}
+
+	lookup := func(prefix string) []Suggestion {
+		t.Helper()
+		start := time.Now()
+		suggestions := x.Lookup(prefix, 10)
+		if elapsed := time.Since(start); elapsed > 5*time.Millisecond {
+			t.Errorf("lookup %q took %s", prefix, elapsed)
+		}
+		return suggestions
+	}
+
+	for _, prefix := range []string{"b", "bo", "book 12", "collection"} {
+		x.Lookup(prefix, 10) // warm the cache for broad prefixes
+		lookup(prefix)
+	}
+
+	// Writes to top-ranked titles keep the broad prefixes cached.
+	x.Put("999999", "Book 999999 of the Collection, Revised", 1_000_000)
+	x.Remove("999998")
+	x.Put("999997", "Another Collection", 1_000_001)
+	for _, prefix := range []string{"b", "bo"} {
+		want := []string{"Book 999999 of the Collection, Revised", "Book 999996 of the Collection", "Book 999995 of the Collection"}
+		if got := terms(lookup(prefix))[:3]; !reflect.DeepEqual(got, want) {
+			t.Errorf("after writes: got %q want %q", got, want)
+		}
+	}
+}
+
+func BenchmarkPrefixIndexLookup(b *testing.B) {
+	x := NewPrefixIndex(RankByScore)
+	for i := 0; i < 100_000; i++ {
+		x.Put(fmt.Sprint(i), fmt.Sprintf("Book %d of the Collection", i), float64(i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x.Lookup("book 12", 10)
+	}
+}
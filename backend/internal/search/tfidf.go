@@ -0,0 +1,125 @@
+package search
+
+import (
+	"math"
+	"sort"
+)
+
+// Document is the bag of terms describing one item of a SimilarityIndex.
+type Document struct {
+	ID    int64
+	Terms []string
+}
+
+// Match is a document similar to a query with its cosine similarity.
+type Match struct {
+	ID    int64
+	Score float64
+}
+
+type posting struct {
+	doc    int64
+	weight float64
+}
+
+// SimilarityIndex ranks documents by the cosine similarity of their TF-IDF
+// vectors, using an inverted index so a query only visits documents sharing
+// a term with it. It is immutable once built and safe for concurrent use;
+// build a new one to pick up changes.
+type SimilarityIndex struct {
+	idf      map[string]float64
+	postings map[string][]posting
+	size     int
+}
+
+// NewSimilarityIndex weighs every term of docs by sublinear term frequency
+// times smoothed inverse document frequency, normalized per document.
+func NewSimilarityIndex(docs []Document) *SimilarityIndex {
+	df := map[string]int{}
+	for _, doc := range docs {
+		for term := range termFrequencies(doc.Terms) {
+			df[term]++
+		}
+	}
+
+	x := &SimilarityIndex{
+		idf:      make(map[string]float64, len(df)),
+		postings: make(map[string][]posting, len(df)),
+		size:     len(docs),
+	}
+	for term, n := range df {
+		x.idf[term] = math.Log(float64(len(docs)+1)/float64(n+1)) + 1
+	}
+	for _, doc := range docs {
+		for term, weight := range x.vector(doc.Terms) {
+			x.postings[term] = append(x.postings[term], posting{doc: doc.ID, weight: weight})
+		}
+	}
+
+	return x
+}
+
+// Len returns the number of indexed documents.
+func (x *SimilarityIndex) Len() int {
+	return x.size
+}
+
+// Similar returns up to limit documents most similar to terms, best first.
+// Documents for which keep returns false are skipped; a nil keep keeps all.
+// Terms unknown to the index are ignored.
+func (x *SimilarityIndex) Similar(terms []string, limit int, keep func(id int64) bool) []Match {
+	scores := map[int64]float64{}
+	for term, weight := range x.vector(terms) {
+		for _, p := range x.postings[term] {
+			scores[p.doc] += weight * p.weight
+		}
+	}
+
+	matches := make([]Match, 0, len(scores))
+	for id, score := range scores {
+		if keep != nil && !keep(id) {
+			continue
+		}
+		matches = append(matches, Match{ID: id, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// vector returns the unit-length TF-IDF vector of terms over the known terms.
+func (x *SimilarityIndex) vector(terms []string) map[string]float64 {
+	vector := map[string]float64{}
+	var norm float64
+	for term, tf := range termFrequencies(terms) {
+		idf, ok := x.idf[term]
+		if !ok {
+			continue
+		}
+		weight := (1 + math.Log(float64(tf))) * idf
+		vector[term] = weight
+		norm += weight * weight
+	}
+
+	norm = math.Sqrt(norm)
+	for term := range vector {
+		vector[term] /= norm
+	}
+	return vector
+}
+
+func termFrequencies(terms []string) map[string]int {
+	tf := make(map[string]int, len(terms))
+	for _, term := range terms {
+		tf[term]++
+	}
+	return tf
+}
@@ -0,0 +1,59 @@
+package search
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimilarityIndexRanksSharedRareTermsFirst(t *testing.T) {
+	x := NewSimilarityIndex([]Document{
+		{ID: 1, Terms: []string{"ramen", "noodle", "soup"}},
+		{ID: 2, Terms: []string{"ramen", "noodle", "history"}},
+		{ID: 3, Terms: []string{"sushi", "history"}},
+		{ID: 4, Terms: []string{"the", "cookbook"}},
+	})
+
+	if x.Len() != 4 {
+		t.Fatalf("expected 4 documents, got %d", x.Len())
+	}
+
+	matches := x.Similar([]string{"ramen", "noodle", "soup"}, 10, func(id int64) bool { return id != 1 })
+	if len(matches) != 1 || matches[0].ID != 2 {
+		t.Fatalf("expected only document 2 to share terms, got %+v", matches)
+	}
+	if matches[0].Score <= 0 || matches[0].Score >= 1 {
+		t.Fatalf("expected a partial similarity, got %f", matches[0].Score)
+	}
+
+	matches = x.Similar([]string{"history"}, 10, nil)
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %+v", matches)
+	}
+	// The shorter document is dominated by the shared term.
+	if matches[0].ID != 3 {
+		t.Fatalf("expected document 3 first, got %+v", matches)
+	}
+}
+
+func TestSimilarityIndexIdenticalDocument(t *testing.T) {
+	x := NewSimilarityIndex([]Document{
+		{ID: 1, Terms: []string{"kitchen", "confidential"}},
+		{ID: 2, Terms: []string{"kitchen"}},
+	})
+
+	matches := x.Similar([]string{"kitchen", "confidential"}, 1, nil)
+	if len(matches) != 1 || matches[0].ID != 1 || math.Abs(matches[0].Score-1) > 1e-9 {
+		t.Fatalf("expected document 1 with similarity 1, got %+v", matches)
+	}
+}
+
+func TestSimilarityIndexUnknownTerms(t *testing.T) {
+	x := NewSimilarityIndex([]Document{{ID: 1, Terms: []string{"tempura"}}})
+
+	if matches := x.Similar([]string{"unknown"}, 10, nil); len(matches) != 0 {
+		t.Fatalf("expected no matches, got %+v", matches)
+	}
+	if matches := NewSimilarityIndex(nil).Similar([]string{"tempura"}, 10, nil); len(matches) != 0 {
+		t.Fatalf("expected no matches from an empty index, got %+v", matches)
+	}
+}
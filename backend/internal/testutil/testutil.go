@@ -0,0 +1,35 @@
+// Package testutil holds the fakes shared by the service tests.
+package testutil
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Clock is a settable time source. Services under test take its Now method in
+// place of time.Now.
+type Clock struct{ now time.Time }
+
+// NewClock returns a Clock stopped at now.
+func NewClock(now time.Time) *Clock { return &Clock{now: now} }
+
+// Now returns the time the clock is stopped at.
+func (c *Clock) Now() time.Time { return c.now }
+
+// Set stops the clock at now.
+func (c *Clock) Set(now time.Time) { c.now = now }
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// Find returns a copy of the first row matching match, or sql.ErrNoRows like
+// the stores do when there is none.
+func Find[T any](rows []*T, match func(*T) bool) (*T, error) {
+	for _, row := range rows {
+		if match(row) {
+			copied := *row
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
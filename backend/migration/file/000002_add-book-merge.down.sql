@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS books_title_normalized_trgm_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS merged_into;
+DROP FUNCTION IF EXISTS normalize_book_text(TEXT);
+DROP EXTENSION IF EXISTS pg_trgm;
@@ -0,0 +1,11 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE OR REPLACE FUNCTION normalize_book_text(value TEXT) RETURNS TEXT AS $$
+    SELECT btrim(regexp_replace(lower(value), '[^[:alnum:]]+', ' ', 'g'));
+$$ LANGUAGE SQL IMMUTABLE STRICT PARALLEL SAFE;
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS merged_into INT REFERENCES books(id);
+
+CREATE INDEX IF NOT EXISTS books_title_normalized_trgm_idx
+    ON books USING GIST (normalize_book_text(title) gist_trgm_ops)
+    WHERE deleted_at IS NULL;
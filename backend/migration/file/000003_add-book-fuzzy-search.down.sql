@@ -0,0 +1 @@
+DROP INDEX IF EXISTS books_author_normalized_trgm_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS books_author_normalized_trgm_idx
+    ON books USING GIST (normalize_book_text(author) gist_trgm_ops)
+    WHERE deleted_at IS NULL;
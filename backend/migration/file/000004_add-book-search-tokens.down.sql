@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS books_search_tokens_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS author_tokens;
+ALTER TABLE books DROP COLUMN IF EXISTS title_tokens;
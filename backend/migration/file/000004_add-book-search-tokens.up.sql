@@ -0,0 +1,8 @@
+-- Tokens are produced by the Go search normalizer (NFKC, width and kana
+-- folding, CJK bigrams); NULL marks rows that still need to be indexed.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS title_tokens TEXT[];
+ALTER TABLE books ADD COLUMN IF NOT EXISTS author_tokens TEXT[];
+
+CREATE INDEX IF NOT EXISTS books_search_tokens_idx
+    ON books USING GIN ((title_tokens || author_tokens))
+    WHERE deleted_at IS NULL;
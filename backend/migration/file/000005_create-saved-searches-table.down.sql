@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS books_updated_at_idx;
+DROP TABLE IF EXISTS saved_searches;
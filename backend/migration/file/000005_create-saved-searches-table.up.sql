@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    query TEXT NOT NULL DEFAULT '',
+    filter JSONB NOT NULL DEFAULT '{}',
+    notify_email VARCHAR(255) NOT NULL DEFAULT '',
+    -- last_checked_at advances when a client reads the new matches;
+    -- last_notified_at when the background evaluator pushes them. Keeping
+    -- them apart lets neither consumer take matches from the other.
+    last_checked_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_notified_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS books_updated_at_idx
+    ON books (updated_at)
+    WHERE deleted_at IS NULL;
@@ -0,0 +1,6 @@
+DROP MATERIALIZED VIEW IF EXISTS book_stats_refreshed;
+DROP MATERIALIZED VIEW IF EXISTS book_stats_daily;
+DROP MATERIALIZED VIEW IF EXISTS book_stats_by_author;
+DROP MATERIALIZED VIEW IF EXISTS book_stats_by_year;
+DROP INDEX IF EXISTS books_deleted_at_idx;
+DROP INDEX IF EXISTS books_created_at_idx;
@@ -0,0 +1,37 @@
+CREATE INDEX IF NOT EXISTS books_created_at_idx ON books (created_at);
+CREATE INDEX IF NOT EXISTS books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Optional precomputed statistics, refreshed by the server when
+-- STATS_MATERIALIZED_VIEWS is enabled. The unique indexes allow
+-- REFRESH MATERIALIZED VIEW CONCURRENTLY.
+CREATE MATERIALIZED VIEW IF NOT EXISTS book_stats_by_year AS
+    SELECT published_year, COUNT(*) AS count
+    FROM books
+    WHERE deleted_at IS NULL
+    GROUP BY published_year;
+CREATE UNIQUE INDEX IF NOT EXISTS book_stats_by_year_idx ON book_stats_by_year (published_year);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS book_stats_by_author AS
+    SELECT author, COUNT(*) AS count
+    FROM books
+    WHERE deleted_at IS NULL
+    GROUP BY author;
+CREATE UNIQUE INDEX IF NOT EXISTS book_stats_by_author_idx ON book_stats_by_author (author);
+
+-- Additions and deletions per UTC day; coarser buckets are summed from it.
+CREATE MATERIALIZED VIEW IF NOT EXISTS book_stats_daily AS
+    SELECT day, SUM(added)::BIGINT AS added, SUM(deleted)::BIGINT AS deleted
+    FROM (
+        SELECT date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, 1 AS added, 0 AS deleted
+        FROM books
+        WHERE created_at IS NOT NULL
+        UNION ALL
+        SELECT date_trunc('day', deleted_at AT TIME ZONE 'UTC'), 0, 1
+        FROM books
+        WHERE deleted_at IS NOT NULL
+    ) changes
+    GROUP BY day;
+CREATE UNIQUE INDEX IF NOT EXISTS book_stats_daily_idx ON book_stats_daily (day);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS book_stats_refreshed AS
+    SELECT NOW() AS refreshed_at;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS books_change_xid_idx;
+DROP INDEX IF EXISTS books_change_seq_idx;
+DROP TRIGGER IF EXISTS books_record_update ON books;
+DROP TRIGGER IF EXISTS books_record_insert ON books;
+DROP FUNCTION IF EXISTS record_book_change();
+ALTER TABLE books DROP COLUMN IF EXISTS change_xid;
+ALTER TABLE books DROP COLUMN IF EXISTS change_seq;
+DROP SEQUENCE IF EXISTS books_change_seq;
@@ -0,0 +1,39 @@
+-- Every insert and every update of a synced column stamps the row with the
+-- next change sequence and the writing transaction's ID. Transactions can
+-- commit out of order, so sync tokens are anchored on transaction IDs (see
+-- stores.Book.Changes) while the sequence orders and pages the changes.
+CREATE SEQUENCE IF NOT EXISTS books_change_seq;
+
+ALTER TABLE books ADD COLUMN IF NOT EXISTS change_seq BIGINT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS change_xid XID8;
+
+UPDATE books SET change_seq = nextval('books_change_seq'), change_xid = pg_current_xact_id()
+    WHERE change_seq IS NULL;
+
+ALTER TABLE books ALTER COLUMN change_seq SET NOT NULL;
+ALTER TABLE books ALTER COLUMN change_xid SET NOT NULL;
+
+CREATE OR REPLACE FUNCTION record_book_change() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.change_seq := nextval('books_change_seq');
+    NEW.change_xid := pg_current_xact_id();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS books_record_insert ON books;
+CREATE TRIGGER books_record_insert
+    BEFORE INSERT ON books
+    FOR EACH ROW EXECUTE FUNCTION record_book_change();
+
+-- Search token backfills and similar maintenance do not count as changes.
+DROP TRIGGER IF EXISTS books_record_update ON books;
+CREATE TRIGGER books_record_update
+    BEFORE UPDATE ON books
+    FOR EACH ROW
+    WHEN ((OLD.title, OLD.author, OLD.published_year, OLD.updated_at, OLD.deleted_at, OLD.merged_into)
+        IS DISTINCT FROM (NEW.title, NEW.author, NEW.published_year, NEW.updated_at, NEW.deleted_at, NEW.merged_into))
+    EXECUTE FUNCTION record_book_change();
+
+CREATE INDEX IF NOT EXISTS books_change_seq_idx ON books (change_seq);
+CREATE INDEX IF NOT EXISTS books_change_xid_idx ON books (change_xid);
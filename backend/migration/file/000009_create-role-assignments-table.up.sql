@@ -0,0 +1,9 @@
+-- Roles granted to principals, keyed by the subject of their tokens.
+-- Principals without a row hold the policy's default role.
+CREATE TABLE IF NOT EXISTS role_assignments (
+    subject VARCHAR(255) PRIMARY KEY,
+    role VARCHAR(16) NOT NULL CHECK (role IN ('viewer', 'editor', 'admin')),
+    assigned_by VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
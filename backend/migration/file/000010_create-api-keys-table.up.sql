@@ -0,0 +1,17 @@
+-- API keys for integrations. Only a SHA-256 of each key is stored; prefix
+-- is the non-secret part used to find it. A rotated key points at the key
+-- it replaced, which expires after the overlap.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    prefix CHAR(12) NOT NULL UNIQUE,
+    key_hash CHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_by VARCHAR(255) NOT NULL DEFAULT '',
+    rotated_from INTEGER REFERENCES api_keys (id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    request_count BIGINT NOT NULL DEFAULT 0
+);
@@ -0,0 +1,11 @@
+-- OpenID Connect logins in progress. state_hash is a SHA-256 of the state
+-- sent to the provider; a row is consumed by the callback or expires.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state_hash CHAR(64) PRIMARY KEY,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_oidc_logins_expires_at ON oidc_logins (expires_at);
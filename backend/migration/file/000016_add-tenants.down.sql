@@ -0,0 +1,61 @@
+DROP POLICY IF EXISTS saved_searches_tenant_isolation ON saved_searches;
+ALTER TABLE saved_searches NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE saved_searches DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS books_tenant_isolation ON books;
+ALTER TABLE books NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE books DISABLE ROW LEVEL SECURITY;
+
+DROP MATERIALIZED VIEW IF EXISTS book_stats_daily;
+DROP MATERIALIZED VIEW IF EXISTS book_stats_by_author;
+DROP MATERIALIZED VIEW IF EXISTS book_stats_by_year;
+
+CREATE MATERIALIZED VIEW book_stats_by_year AS
+    SELECT published_year, COUNT(*) AS count
+    FROM books
+    WHERE deleted_at IS NULL
+    GROUP BY published_year;
+CREATE UNIQUE INDEX book_stats_by_year_idx ON book_stats_by_year (published_year);
+
+CREATE MATERIALIZED VIEW book_stats_by_author AS
+    SELECT author, COUNT(*) AS count
+    FROM books
+    WHERE deleted_at IS NULL
+    GROUP BY author;
+CREATE UNIQUE INDEX book_stats_by_author_idx ON book_stats_by_author (author);
+
+CREATE MATERIALIZED VIEW book_stats_daily AS
+    SELECT day, SUM(added)::BIGINT AS added, SUM(deleted)::BIGINT AS deleted
+    FROM (
+        SELECT date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, 1 AS added, 0 AS deleted
+        FROM books
+        WHERE created_at IS NOT NULL
+        UNION ALL
+        SELECT date_trunc('day', deleted_at AT TIME ZONE 'UTC'), 0, 1
+        FROM books
+        WHERE deleted_at IS NOT NULL
+    ) changes
+    GROUP BY day;
+CREATE UNIQUE INDEX book_stats_daily_idx ON book_stats_daily (day);
+
+-- Keys and roles of other tenants would collide once the tenant is gone.
+DELETE FROM idempotency_keys WHERE tenant_id <> 1;
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key, method, path);
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS tenant_id;
+
+DELETE FROM role_assignments WHERE tenant_id <> 1;
+ALTER TABLE role_assignments DROP CONSTRAINT IF EXISTS role_assignments_pkey;
+ALTER TABLE role_assignments ADD PRIMARY KEY (subject);
+ALTER TABLE role_assignments DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS api_keys_tenant_id_idx;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS saved_searches_tenant_id_idx;
+ALTER TABLE saved_searches DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS books_tenant_id_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,104 @@
+-- Tenants partition the catalog. Rows written before tenants existed
+-- belong to the default tenant, which also serves requests naming none.
+CREATE TABLE IF NOT EXISTS tenants (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(63) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO tenants (id, slug, name) VALUES (1, 'default', 'Default')
+    ON CONFLICT (id) DO NOTHING;
+SELECT setval('tenants_id_seq', GREATEST((SELECT MAX(id) FROM tenants), 1));
+
+-- Every tenant-owned table carries tenant_id, and so must every table
+-- added later that holds tenant data.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+CREATE INDEX IF NOT EXISTS books_tenant_id_idx ON books (tenant_id, created_at);
+
+ALTER TABLE saved_searches ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+CREATE INDEX IF NOT EXISTS saved_searches_tenant_id_idx ON saved_searches (tenant_id);
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+CREATE INDEX IF NOT EXISTS api_keys_tenant_id_idx ON api_keys (tenant_id);
+
+-- Roles are granted per tenant.
+ALTER TABLE role_assignments ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE role_assignments DROP CONSTRAINT IF EXISTS role_assignments_pkey;
+ALTER TABLE role_assignments ADD PRIMARY KEY (tenant_id, subject);
+
+-- Two tenants may use the same idempotency key without seeing each
+-- other's responses.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS tenant_id INTEGER NOT NULL DEFAULT 1 REFERENCES tenants (id);
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (tenant_id, key, method, path);
+
+-- The statistics views count per tenant.
+DROP MATERIALIZED VIEW IF EXISTS book_stats_daily;
+DROP MATERIALIZED VIEW IF EXISTS book_stats_by_author;
+DROP MATERIALIZED VIEW IF EXISTS book_stats_by_year;
+
+CREATE MATERIALIZED VIEW book_stats_by_year AS
+    SELECT tenant_id, published_year, COUNT(*) AS count
+    FROM books
+    WHERE deleted_at IS NULL
+    GROUP BY tenant_id, published_year;
+CREATE UNIQUE INDEX book_stats_by_year_idx ON book_stats_by_year (tenant_id, published_year);
+
+CREATE MATERIALIZED VIEW book_stats_by_author AS
+    SELECT tenant_id, author, COUNT(*) AS count
+    FROM books
+    WHERE deleted_at IS NULL
+    GROUP BY tenant_id, author;
+CREATE UNIQUE INDEX book_stats_by_author_idx ON book_stats_by_author (tenant_id, author);
+
+CREATE MATERIALIZED VIEW book_stats_daily AS
+    SELECT tenant_id, day, SUM(added)::BIGINT AS added, SUM(deleted)::BIGINT AS deleted
+    FROM (
+        SELECT tenant_id, date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, 1 AS added, 0 AS deleted
+        FROM books
+        WHERE created_at IS NOT NULL
+        UNION ALL
+        SELECT tenant_id, date_trunc('day', deleted_at AT TIME ZONE 'UTC'), 0, 1
+        FROM books
+        WHERE deleted_at IS NOT NULL
+    ) changes
+    GROUP BY tenant_id, day;
+CREATE UNIQUE INDEX book_stats_daily_idx ON book_stats_daily (tenant_id, day);
+
+-- Row-level security is a safety net under the tenant filter every query
+-- already has: a query missing it still sees only the tenant set with
+-- set_config('app.tenant_id', ...) in its transaction, or nothing. Maintenance
+-- spanning every tenant sets app.all_tenants to 'on', as must any later
+-- data migration on these tables. FORCE applies the policies to the table
+-- owner too; superusers and BYPASSRLS roles always skip them, so the server
+-- refuses to connect as one.
+--
+-- Only tenant data is guarded. Users, sessions, password_resets,
+-- oidc_logins and user_identities stay global: an account and the identity
+-- it signs in with belong to a person, who may hold roles in several tenants
+-- through role_assignments, and sign-in happens before any tenant applies.
+-- api_keys, role_assignments and idempotency_keys carry tenant_id, which
+-- every query of a request filters by, but are read before the tenant of a
+-- request is known or across tenants: an API key is found by its prefix to
+-- learn which tenant it acts for, roles are checked while authenticating,
+-- and expired idempotency keys are swept for every tenant at once. The usage
+-- tables are likewise filtered in every query and aggregated across tenants.
+ALTER TABLE books ENABLE ROW LEVEL SECURITY;
+ALTER TABLE books FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS books_tenant_isolation ON books;
+CREATE POLICY books_tenant_isolation ON books
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER
+        OR current_setting('app.all_tenants', true) = 'on')
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER
+        OR current_setting('app.all_tenants', true) = 'on');
+
+ALTER TABLE saved_searches ENABLE ROW LEVEL SECURITY;
+ALTER TABLE saved_searches FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS saved_searches_tenant_isolation ON saved_searches;
+CREATE POLICY saved_searches_tenant_isolation ON saved_searches
+    USING (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER
+        OR current_setting('app.all_tenants', true) = 'on')
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::INTEGER
+        OR current_setting('app.all_tenants', true) = 'on');
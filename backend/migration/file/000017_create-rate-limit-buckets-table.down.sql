@@ -0,0 +1,2 @@
+DROP FUNCTION IF EXISTS take_rate_limit_token(VARCHAR, DOUBLE PRECISION, DOUBLE PRECISION);
+DROP TABLE IF EXISTS rate_limit_buckets;
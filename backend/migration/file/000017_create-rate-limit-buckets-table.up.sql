@@ -0,0 +1,48 @@
+-- Token buckets of the rate limiter, one per client and rule, shared by
+-- every replica. full_at is when a bucket will have refilled; from then on
+-- it is no different from a bucket never used and may be deleted.
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key VARCHAR(512) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    full_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_full_at ON rate_limit_buckets (full_at);
+
+-- take_rate_limit_token refills the bucket key, holding up to capacity
+-- tokens and regaining rate tokens a second, and takes a token from it when
+-- one is left. The row lock serializes concurrent requests of a client, and
+-- the clock is read once it is held so that waiting never counts as refill.
+CREATE OR REPLACE FUNCTION take_rate_limit_token(bucket_key VARCHAR, capacity DOUBLE PRECISION, rate DOUBLE PRECISION)
+RETURNS TABLE (allowed BOOLEAN, tokens DOUBLE PRECISION) AS $$
+DECLARE
+    current_tokens DOUBLE PRECISION;
+    last_updated TIMESTAMP WITH TIME ZONE;
+    taken_at TIMESTAMP WITH TIME ZONE;
+BEGIN
+    INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at, full_at)
+    VALUES (bucket_key, capacity, clock_timestamp(), clock_timestamp())
+    ON CONFLICT (key) DO NOTHING;
+
+    SELECT b.tokens, b.updated_at INTO current_tokens, last_updated
+    FROM rate_limit_buckets b WHERE b.key = bucket_key FOR UPDATE;
+
+    taken_at := clock_timestamp();
+    current_tokens := LEAST(capacity,
+        current_tokens + GREATEST(0, EXTRACT(EPOCH FROM taken_at - last_updated)) * rate);
+    allowed := current_tokens >= 1;
+    IF allowed THEN
+        current_tokens := current_tokens - 1;
+    END IF;
+
+    UPDATE rate_limit_buckets b SET
+        tokens = current_tokens,
+        updated_at = taken_at,
+        full_at = taken_at + make_interval(secs => (capacity - current_tokens) / rate)
+    WHERE b.key = bucket_key;
+
+    tokens := current_tokens;
+    RETURN NEXT;
+END;
+$$ LANGUAGE plpgsql;
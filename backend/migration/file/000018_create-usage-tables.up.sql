@@ -0,0 +1,30 @@
+-- Hourly rollups of the requests of each consumer, a principal named by its
+-- token subject, to each endpoint. Servers count requests in memory and add
+-- them here in batches, so a row may be added to many times. Like quotas,
+-- rows carry tenant_id but have no row-level security: the batches and the
+-- monthly totals behind quota checks span every tenant.
+CREATE TABLE IF NOT EXISTS usage_hourly (
+    tenant_id INTEGER NOT NULL REFERENCES tenants (id),
+    consumer VARCHAR(255) NOT NULL,
+    endpoint VARCHAR(512) NOT NULL,
+    hour TIMESTAMP WITH TIME ZONE NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    request_bytes BIGINT NOT NULL DEFAULT 0,
+    response_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, consumer, endpoint, hour)
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_hourly_tenant_hour ON usage_hourly (tenant_id, hour);
+CREATE INDEX IF NOT EXISTS idx_usage_hourly_hour ON usage_hourly (hour);
+
+-- Monthly request quotas of consumers. 0 means no such threshold.
+CREATE TABLE IF NOT EXISTS usage_quotas (
+    tenant_id INTEGER NOT NULL REFERENCES tenants (id),
+    consumer VARCHAR(255) NOT NULL,
+    warn_requests BIGINT NOT NULL DEFAULT 0,
+    limit_requests BIGINT NOT NULL DEFAULT 0,
+    updated_by VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, consumer)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE saved_searches DROP COLUMN IF EXISTS notified_horizon;
+ALTER TABLE saved_searches DROP COLUMN IF EXISTS checked_horizon;
@@ -0,0 +1,10 @@
+-- The new matches of a saved search are the books changed by transactions
+-- between two transaction ID horizons, as taken by stores.Book.SyncHorizon,
+-- rather than between two timestamps: updated_at is when the writing
+-- transaction started, so a write committing after a check could carry a
+-- time the check had already passed and never be reported. Each cursor
+-- keeps its timestamp for display.
+ALTER TABLE saved_searches ADD COLUMN IF NOT EXISTS checked_horizon BIGINT NOT NULL
+    DEFAULT pg_snapshot_xmin(pg_current_snapshot())::TEXT::BIGINT;
+ALTER TABLE saved_searches ADD COLUMN IF NOT EXISTS notified_horizon BIGINT NOT NULL
+    DEFAULT pg_snapshot_xmin(pg_current_snapshot())::TEXT::BIGINT;
@@ -0,0 +1,8 @@
+-- Run once by the postgres image when it creates its data directory. The
+-- server connects as this ordinary role, which owns the database and runs
+-- the migrations, so the row-level security policies apply to it; the
+-- superuser the image creates is left for administration.
+CREATE ROLE app LOGIN PASSWORD 'password' NOSUPERUSER NOBYPASSRLS;
+CREATE DATABASE db OWNER app;
+\connect db
+ALTER SCHEMA public OWNER TO app;
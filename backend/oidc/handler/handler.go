@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/oidc"
+	"byfood-interview/user"
+	"context"
+	"net/http"
+)
+
+// statePath limits the state cookie to the login routes.
+const statePath = "/api/v1/auth/oidc"
+
+type OIDCService interface {
+	Begin(ctx context.Context) (string, string, error)
+	Callback(ctx context.Context, req *oidc.CallbackRequest) (*user.Session, error)
+}
+
+type Handler struct {
+	Service OIDCService
+	// SecureCookies marks the state and session cookies Secure, so browsers
+	// only send them over HTTPS. Only turn it off for local development.
+	SecureCookies bool
+	// PostLoginURL is where the browser goes once signed in.
+	PostLoginURL string
+}
+
+func (h *Handler) setStateCookie(w http.ResponseWriter, state string) {
+	cookie := &http.Cookie{
+		Name:     oidc.StateCookie,
+		Value:    state,
+		Path:     statePath,
+		HttpOnly: true,
+		Secure:   h.SecureCookies,
+		// Lax, so the cookie comes back on the provider's redirect.
+		SameSite: http.SameSiteLaxMode,
+	}
+	if state == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.MaxAge = int(oidc.StateCookieMaxAge.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+func (h *Handler) postLoginURL() string {
+	if h.PostLoginURL == "" {
+		return "/"
+	}
+	return h.PostLoginURL
+}
+
+// Login godoc
+// @Summary Log in with OpenID Connect
+// @Description Redirect the browser to the identity provider to sign in, with the authorization code flow and PKCE. The provider redirects back to the callback.
+// @Tags auth
+// @Success 302
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/oidc/login [get]
+// Login handles starting an OpenID Connect login
+func (h *Handler) Login() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		location, state, err := h.Service.Begin(r.Context())
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		h.setStateCookie(w, state)
+		http.Redirect(w, r, location, http.StatusFound)
+	}
+}
+
+// Callback godoc
+// @Summary Finish an OpenID Connect login
+// @Description Where the identity provider redirects back to. Verifies the state, exchanges the code, verifies the ID token and its nonce, then starts a session kept in an HttpOnly cookie and redirects to the app. Get the CSRF token from GET /api/v1/auth/session.
+// @Tags auth
+// @Produce json,xml,application/msgpack
+// @Param code query string false "Authorization code"
+// @Param state query string true "State of the login"
+// @Param error query string false "Error from the provider"
+// @Success 302
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/oidc/callback [get]
+// Callback handles finishing an OpenID Connect login
+func (h *Handler) Callback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		request := oidc.CallbackRequest{
+			Code:             query.Get("code"),
+			State:            query.Get("state"),
+			Error:            query.Get("error"),
+			ErrorDescription: query.Get("error_description"),
+		}
+		if cookie, err := r.Cookie(oidc.StateCookie); err == nil {
+			request.CookieState = cookie.Value
+		}
+
+		// The state works once, whatever happens next.
+		w.Header().Set("Cache-Control", "no-store")
+		h.setStateCookie(w, "")
+
+		data, err := h.Service.Callback(r.Context(), &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		http.SetCookie(w, user.NewSessionCookie(data.Token, data.ExpiresAt, h.SecureCookies))
+		http.Redirect(w, r, h.postLoginURL(), http.StatusFound)
+	}
+}
@@ -0,0 +1,309 @@
+// Package oidc signs people in through an OpenID Connect provider with the
+// authorization code flow and PKCE, as a relying party.
+package oidc
+
+import (
+	"byfood-interview/auth"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidState   = errors.New("invalid or expired login state")
+	ErrInvalidIDToken = errors.New("invalid ID token")
+)
+
+// StateCookie carries the state of a login in progress, binding the
+// callback to the browser that started it.
+const StateCookie = "oidc_state"
+
+// StateCookieMaxAge is how long the state cookie lasts, long enough for
+// anyone to sign in at the provider.
+const StateCookieMaxAge = 10 * time.Minute
+
+// DefaultScopes are requested when the configuration does not say.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+const maxResponseSize = 1 << 20
+
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Metadata is the part of a provider's discovery document the flow uses.
+type Metadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// Discover reads the discovery document of issuer. The document must name
+// the same issuer, so a provider cannot speak for another.
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Metadata, error) {
+	location := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	var metadata Metadata
+	if err := getJSON(ctx, client, location, &metadata); err != nil {
+		return nil, fmt.Errorf("discover %s: %w", issuer, err)
+	}
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("discover %s: document is for issuer %q", issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("discover %s: document lacks an endpoint", issuer)
+	}
+	if len(metadata.CodeChallengeMethodsSupported) > 0 && !contains(metadata.CodeChallengeMethodsSupported, "S256") {
+		return nil, fmt.Errorf("discover %s: provider does not support PKCE with S256", issuer)
+	}
+	return &metadata, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, location string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RandomString returns an unguessable URL-safe string, for states, nonces
+// and PKCE code verifiers.
+func RandomString() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge of verifier (RFC 7636).
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// HashState returns the stored form of a state.
+func HashState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// Login is a login in progress, from the redirect to the provider until
+// the callback. Only a hash of the state is stored.
+type Login struct {
+	StateHash    string    `db:"state_hash"`
+	Nonce        string    `db:"nonce"`
+	CodeVerifier string    `db:"code_verifier"`
+	CreatedAt    time.Time `db:"created_at"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// CallbackRequest is what the provider's redirect and the state cookie
+// bring back.
+type CallbackRequest struct {
+	Code             string
+	State            string
+	Error            string
+	ErrorDescription string
+	// CookieState is the state in StateCookie.
+	CookieState string
+}
+
+// StateMatches reports whether the state came back to the browser that
+// started the login.
+func (r CallbackRequest) StateMatches() bool {
+	return r.State != "" && subtle.ConstantTimeCompare([]byte(r.State), []byte(r.CookieState)) == 1
+}
+
+// Client talks to one provider. Its discovery document is fetched on first
+// use and kept; a failed discovery is retried on the next login.
+type Client struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider.
+	RedirectURL string
+	Scopes      []string
+	// Leeway is the clock skew allowed when checking ID token expiry.
+	Leeway     time.Duration
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	verifier *auth.Verifier
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return defaultHTTPClient
+	}
+	return c.HTTPClient
+}
+
+// discover returns the provider's metadata and a verifier for its ID
+// tokens.
+func (c *Client) discover(ctx context.Context) (*Metadata, *auth.Verifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.metadata != nil {
+		return c.metadata, c.verifier, nil
+	}
+	metadata, err := Discover(ctx, c.httpClient(), c.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := auth.NewJWKS(metadata.JWKSURI)
+	if c.HTTPClient != nil {
+		keys.Client = c.HTTPClient
+	}
+	c.metadata = metadata
+	c.verifier = &auth.Verifier{Keys: keys, Issuer: metadata.Issuer, Audience: c.ClientID, Leeway: c.Leeway}
+	return c.metadata, c.verifier, nil
+}
+
+// AuthCodeURL returns where to send the browser to sign in.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, _, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	location, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse authorization endpoint: %w", err)
+	}
+
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	query := location.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", c.ClientID)
+	query.Set("redirect_uri", c.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	location.RawQuery = query.Encode()
+	return location.String(), nil
+}
+
+// TokenResponse is the part of a token endpoint response the flow uses.
+type TokenResponse struct {
+	AccessToken string `json:"access_token,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
+	IDToken     string `json:"id_token,omitempty"`
+
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Exchange trades an authorization code for tokens, proving with
+// codeVerifier that it started the login.
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier string) (*TokenResponse, error) {
+	metadata, _, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if c.ClientSecret == "" {
+		form.Set("client_id", c.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens TokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("exchange code: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("exchange code: %s: %s %s", resp.Status, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("exchange code: response has no ID token")
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience and expiry of an ID
+// token and that it carries the nonce of the login, and returns its
+// principal.
+func (c *Client) VerifyIDToken(ctx context.Context, raw, nonce string) (*auth.Principal, error) {
+	_, verifier, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	principal, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, errors.Join(ErrInvalidIDToken, err)
+	}
+
+	got, _ := principal.Claims["nonce"].(string)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.Join(ErrInvalidIDToken, errors.New("nonce does not match the login"))
+	}
+	// A token for several audiences must have been issued to us.
+	if azp, ok := principal.Claims["azp"].(string); ok && azp != c.ClientID {
+		return nil, errors.Join(ErrInvalidIDToken, fmt.Errorf("token was issued to %q", azp))
+	}
+	return principal, nil
+}
+
+// Identity links the account a provider knows by Subject to a user.
+type Identity struct {
+	Issuer    string    `db:"issuer"`
+	Subject   string    `db:"subject"`
+	UserID    int64     `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
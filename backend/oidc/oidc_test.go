@@ -0,0 +1,149 @@
+package oidc_test
+
+import (
+	"byfood-interview/oidc"
+	"byfood-interview/oidc/oidctest"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// authorize follows location to the provider and returns the code and state
+// it redirects back with.
+func authorize(t *testing.T, location string) (string, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %d", resp.StatusCode)
+	}
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return back.Query().Get("code"), back.Query().Get("state")
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// The example in RFC 7636, appendix B.
+	got := oidc.CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge() = %q, want %q", got, want)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"issuer": "https://evil.example.com", "authorization_endpoint": "https://evil.example.com/a",
+			"token_endpoint": "https://evil.example.com/t", "jwks_uri": "https://evil.example.com/k"}`))
+	}))
+	defer server.Close()
+
+	if _, err := oidc.Discover(context.Background(), server.Client(), server.URL); err == nil {
+		t.Error("Discover() accepted a document for another issuer")
+	}
+}
+
+func TestCallbackRequestStateMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    oidc.CallbackRequest
+		result bool
+	}{
+		{"same", oidc.CallbackRequest{State: "abc", CookieState: "abc"}, true},
+		{"different", oidc.CallbackRequest{State: "abc", CookieState: "abd"}, false},
+		{"no cookie", oidc.CallbackRequest{State: "abc"}, false},
+		{"empty", oidc.CallbackRequest{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.StateMatches(); got != tt.result {
+				t.Errorf("StateMatches() = %v, want %v", got, tt.result)
+			}
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	provider := oidctest.NewProvider("books", "s3cret")
+	defer provider.Close()
+	provider.SetUser("alice", map[string]interface{}{"email": "alice@example.com", "email_verified": true})
+
+	client := &oidc.Client{
+		Issuer:       provider.Issuer(),
+		ClientID:     "books",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://app.example.com/callback",
+	}
+	ctx := context.Background()
+
+	login := func(t *testing.T, verifier string) (string, string) {
+		t.Helper()
+		location, err := client.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := authorize(t, location)
+		if state != "state-1" {
+			t.Fatalf("provider returned state %q", state)
+		}
+		return code, verifier
+	}
+
+	t.Run("signs in", func(t *testing.T) {
+		code, verifier := login(t, "verifier-0123456789-0123456789-0123456789")
+		tokens, err := client.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		principal, err := client.VerifyIDToken(ctx, tokens.IDToken, "nonce-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if principal.Subject != "alice" || principal.Claims["email"] != "alice@example.com" {
+			t.Errorf("got principal %+v", principal)
+		}
+
+		if _, err := client.Exchange(ctx, code, verifier); err == nil {
+			t.Error("Exchange() accepted a code twice")
+		}
+	})
+
+	t.Run("wrong code verifier", func(t *testing.T) {
+		code, _ := login(t, "verifier-0123456789-0123456789-0123456789")
+		if _, err := client.Exchange(ctx, code, "verifier-of-someone-else-0123456789-01234"); err == nil {
+			t.Error("Exchange() accepted a wrong code verifier")
+		}
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		code, verifier := login(t, "verifier-0123456789-0123456789-0123456789")
+		tokens, err := client.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.VerifyIDToken(ctx, tokens.IDToken, "nonce-2"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("VerifyIDToken() error = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("token for another client", func(t *testing.T) {
+		other := &oidc.Client{Issuer: provider.Issuer(), ClientID: "other", RedirectURL: client.RedirectURL}
+		code, verifier := login(t, "verifier-0123456789-0123456789-0123456789")
+		tokens, err := client.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.VerifyIDToken(ctx, tokens.IDToken, "nonce-1"); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("VerifyIDToken() error = %v, want ErrInvalidIDToken", err)
+		}
+	})
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/oidc"
+	"byfood-interview/rbac"
+	"byfood-interview/user"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults of the OIDC service settings left zero.
+const (
+	DefaultLoginTTL  = 10 * time.Minute
+	DefaultRoleClaim = "groups"
+)
+
+// AssignedBy marks the roles the OIDC service assigns from claims, so it
+// never overrides a role an admin assigned by hand.
+const AssignedBy = "oidc"
+
+// Provider is the OpenID Connect provider people sign in with.
+type Provider interface {
+	AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error)
+	Exchange(ctx context.Context, code, codeVerifier string) (*oidc.TokenResponse, error)
+	VerifyIDToken(ctx context.Context, raw, nonce string) (*auth.Principal, error)
+}
+
+type LoginRepository interface {
+	Create(ctx context.Context, data *oidc.Login) error
+	Consume(ctx context.Context, stateHash string, at time.Time) (*oidc.Login, error)
+}
+
+type IdentityRepository interface {
+	Get(ctx context.Context, issuer, subject string) (*oidc.Identity, error)
+	Create(ctx context.Context, data *oidc.Identity) (*oidc.Identity, error)
+}
+
+type UserRepository interface {
+	Create(ctx context.Context, data *user.User) (*user.User, error)
+	GetByID(ctx context.Context, id int64) (*user.User, error)
+	GetByEmail(ctx context.Context, email string) (*user.User, error)
+}
+
+type RoleRepository interface {
+	Get(ctx context.Context, subject string) (*rbac.Assignment, error)
+	Upsert(ctx context.Context, data *rbac.Assignment) (*rbac.Assignment, error)
+	Delete(ctx context.Context, subject string) error
+}
+
+// SessionStarter signs users in, as the User service does.
+type SessionStarter interface {
+	StartSession(ctx context.Context, account *user.User) (*user.Session, error)
+}
+
+// OIDC signs people in through an OpenID Connect provider. The first
+// sign-in of a verified email creates a user, or links the user that
+// already has it; later ones find the user by the provider's subject.
+type OIDC struct {
+	Provider           Provider
+	LoginRepository    LoginRepository
+	IdentityRepository IdentityRepository
+	UserRepository     UserRepository
+	RoleRepository     RoleRepository
+	Sessions           SessionStarter
+	Transactor         internalDb.TxRunner
+
+	// RoleClaim is the ID token claim listing the person's groups.
+	RoleClaim string
+	// RoleMap maps values of RoleClaim to roles. At each sign-in the user
+	// gets the highest role mapped from its claims, or loses the role when
+	// none maps. Roles assigned by an admin are left alone. When RoleMap is
+	// empty, claims grant no roles.
+	RoleMap map[string]rbac.Role
+	// LoginTTL is how long a login may take at the provider.
+	LoginTTL time.Duration
+
+	now func() time.Time
+}
+
+func (s *OIDC) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *OIDC) roleClaim() string {
+	if s.RoleClaim == "" {
+		return DefaultRoleClaim
+	}
+	return s.RoleClaim
+}
+
+func (s *OIDC) loginTTL() time.Duration {
+	if s.LoginTTL <= 0 {
+		return DefaultLoginTTL
+	}
+	return s.LoginTTL
+}
+
+// Begin starts a login and returns where to send the browser and the
+// state to bind the callback to it.
+func (s *OIDC) Begin(ctx context.Context) (string, string, error) {
+	log := log.Ctx(ctx).With().Str("service", "oidc").Logger()
+
+	var secrets [3]string
+	for i := range secrets {
+		secret, err := oidc.RandomString()
+		if err != nil {
+			return "", "", err
+		}
+		secrets[i] = secret
+	}
+	state, nonce, codeVerifier := secrets[0], secrets[1], secrets[2]
+
+	location, err := s.Provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to build authorization URL")
+		return "", "", err
+	}
+
+	err = s.LoginRepository.Create(ctx, &oidc.Login{
+		StateHash:    oidc.HashState(state),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    s.clock().Add(s.loginTTL()),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to store login")
+		return "", "", err
+	}
+	return location, state, nil
+}
+
+// Callback finishes a login with what the provider sent back and signs
+// the user in.
+func (s *OIDC) Callback(ctx context.Context, req *oidc.CallbackRequest) (*user.Session, error) {
+	log := log.Ctx(ctx).With().Str("service", "oidc").Logger()
+
+	if req.Error != "" {
+		log.Info().Str("error", req.Error).Str("description", req.ErrorDescription).Msg("provider did not sign in")
+		return nil, helper.NewErrUnauthorized(fmt.Sprintf("sign-in was not completed: %s", req.Error))
+	}
+	if !req.StateMatches() {
+		return nil, helper.NewErrValidation("state", helper.CodeInvalid, oidc.ErrInvalidState.Error())
+	}
+	if req.Code == "" {
+		return nil, helper.NewErrValidation("code", helper.CodeRequired, "code is required")
+	}
+
+	login, err := s.LoginRepository.Consume(ctx, oidc.HashState(req.State), s.clock())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrValidation("state", helper.CodeInvalid, oidc.ErrInvalidState.Error())
+		}
+		log.Error().Err(err).Msg("failed to consume login")
+		return nil, err
+	}
+
+	tokens, err := s.Provider.Exchange(ctx, req.Code, login.CodeVerifier)
+	if err != nil {
+		log.Info().Err(err).Msg("failed to exchange authorization code")
+		return nil, helper.NewErrUnauthorized("authorization code was rejected")
+	}
+	principal, err := s.Provider.VerifyIDToken(ctx, tokens.IDToken, login.Nonce)
+	if err != nil {
+		log.Warn().Err(err).Msg("rejected ID token")
+		return nil, helper.NewErrUnauthorized(oidc.ErrInvalidIDToken.Error())
+	}
+
+	var account *user.User
+	err = internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		var err error
+		if account, err = s.findOrCreateUser(ctx, principal); err != nil {
+			return err
+		}
+		return s.syncRole(ctx, account, principal)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.Sessions.StartSession(ctx, account)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start session")
+		return nil, err
+	}
+	log.Info().Int64("user_id", account.ID).Msg("user signed in with OIDC")
+	return session, nil
+}
+
+// findOrCreateUser returns the user linked to the principal, linking or
+// creating one by its email the first time.
+func (s *OIDC) findOrCreateUser(ctx context.Context, principal *auth.Principal) (*user.User, error) {
+	log := log.Ctx(ctx).With().Str("service", "oidc").Logger()
+
+	issuer, _ := principal.Claims["iss"].(string)
+	identity, err := s.IdentityRepository.Get(ctx, issuer, principal.Subject)
+	switch {
+	case err == nil:
+		return s.UserRepository.GetByID(ctx, identity.UserID)
+	case err != sql.ErrNoRows:
+		log.Error().Err(err).Msg("failed to get identity")
+		return nil, err
+	}
+
+	email, _ := principal.Claims["email"].(string)
+	email = user.NormalizeEmail(email)
+	if email == "" {
+		return nil, helper.NewErrForbidden("the identity provider did not share an email address")
+	}
+	// An unverified email may belong to someone else, who must not be
+	// signed in as or locked out of their account.
+	if !emailVerified(principal) {
+		return nil, helper.NewErrForbidden("the identity provider has not verified the email address")
+	}
+
+	account, err := s.UserRepository.GetByEmail(ctx, email)
+	switch {
+	case err == sql.ErrNoRows:
+		// Accounts made here have no password.
+		if account, err = s.UserRepository.Create(ctx, &user.User{Email: email}); err != nil {
+			log.Error().Err(err).Msg("failed to create user")
+			return nil, err
+		}
+	case err != nil:
+		log.Error().Err(err).Msg("failed to get user")
+		return nil, err
+	}
+
+	if _, err := s.IdentityRepository.Create(ctx, &oidc.Identity{Issuer: issuer, Subject: principal.Subject, UserID: account.ID}); err != nil {
+		log.Error().Err(err).Msg("failed to link identity")
+		return nil, err
+	}
+	log.Info().Int64("user_id", account.ID).Msg("linked OIDC identity")
+	return account, nil
+}
+
+func emailVerified(principal *auth.Principal) bool {
+	switch verified := principal.Claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		// Some providers send it as a string.
+		return verified == "true"
+	}
+	return false
+}
+
+// syncRole assigns account the highest role its claims map to.
+func (s *OIDC) syncRole(ctx context.Context, account *user.User, principal *auth.Principal) error {
+	log := log.Ctx(ctx).With().Str("service", "oidc").Logger()
+
+	if len(s.RoleMap) == 0 {
+		return nil
+	}
+
+	var role rbac.Role
+	for value, mapped := range s.RoleMap {
+		if principal.HasClaim(s.roleClaim(), value) && (role == "" || mapped.Covers(role)) {
+			role = mapped
+		}
+	}
+
+	subject := account.Subject()
+	current, err := s.RoleRepository.Get(ctx, subject)
+	switch {
+	case err == sql.ErrNoRows:
+		current = nil
+	case err != nil:
+		log.Error().Err(err).Msg("failed to get role assignment")
+		return err
+	case current.AssignedBy != AssignedBy:
+		return nil
+	}
+
+	switch {
+	case role == "" && current != nil:
+		if err := s.RoleRepository.Delete(ctx, subject); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Error().Err(err).Msg("failed to revoke role")
+			return err
+		}
+		log.Info().Str("subject", subject).Msg("revoked role no claim maps to")
+	case role != "" && (current == nil || current.Role != role):
+		if _, err := s.RoleRepository.Upsert(ctx, &rbac.Assignment{Subject: subject, Role: role, AssignedBy: AssignedBy}); err != nil {
+			log.Error().Err(err).Msg("failed to assign role")
+			return err
+		}
+		log.Info().Str("subject", subject).Str("role", string(role)).Msg("assigned role from claims")
+	}
+	return nil
+}
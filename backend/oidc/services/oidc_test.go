@@ -0,0 +1,296 @@
+package services
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/oidc"
+	"byfood-interview/oidc/oidctest"
+	"byfood-interview/rbac"
+	"byfood-interview/user"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// memoryLogins is an in-memory LoginRepository.
+type memoryLogins map[string]oidc.Login
+
+func (m memoryLogins) Create(ctx context.Context, data *oidc.Login) error {
+	m[data.StateHash] = *data
+	return nil
+}
+
+func (m memoryLogins) Consume(ctx context.Context, stateHash string, at time.Time) (*oidc.Login, error) {
+	login, ok := m[stateHash]
+	delete(m, stateHash)
+	if !ok || !login.ExpiresAt.After(at) {
+		return nil, sql.ErrNoRows
+	}
+	return &login, nil
+}
+
+// memoryIdentities is an in-memory IdentityRepository.
+type memoryIdentities map[[2]string]oidc.Identity
+
+func (m memoryIdentities) Get(ctx context.Context, issuer, subject string) (*oidc.Identity, error) {
+	identity, ok := m[[2]string{issuer, subject}]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &identity, nil
+}
+
+func (m memoryIdentities) Create(ctx context.Context, data *oidc.Identity) (*oidc.Identity, error) {
+	m[[2]string{data.Issuer, data.Subject}] = *data
+	return data, nil
+}
+
+// memoryUsers is an in-memory UserRepository.
+type memoryUsers []user.User
+
+func (m *memoryUsers) Create(ctx context.Context, data *user.User) (*user.User, error) {
+	created := *data
+	created.ID = int64(len(*m) + 1)
+	*m = append(*m, created)
+	return &created, nil
+}
+
+func (m *memoryUsers) GetByID(ctx context.Context, id int64) (*user.User, error) {
+	for _, u := range *m {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryUsers) GetByEmail(ctx context.Context, email string) (*user.User, error) {
+	for _, u := range *m {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// memoryRoles is an in-memory RoleRepository.
+type memoryRoles map[string]rbac.Assignment
+
+func (m memoryRoles) Get(ctx context.Context, subject string) (*rbac.Assignment, error) {
+	assignment, ok := m[subject]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &assignment, nil
+}
+
+func (m memoryRoles) Upsert(ctx context.Context, data *rbac.Assignment) (*rbac.Assignment, error) {
+	m[data.Subject] = *data
+	return data, nil
+}
+
+func (m memoryRoles) Delete(ctx context.Context, subject string) error {
+	if _, ok := m[subject]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m, subject)
+	return nil
+}
+
+// fakeSessions starts sessions without storing them.
+type fakeSessions struct{}
+
+func (fakeSessions) StartSession(ctx context.Context, account *user.User) (*user.Session, error) {
+	return &user.Session{UserID: account.ID, User: account, Token: "token"}, nil
+}
+
+type fixture struct {
+	service  *OIDC
+	provider *oidctest.Provider
+	users    *memoryUsers
+	roles    memoryRoles
+	now      time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	provider := oidctest.NewProvider("books", "s3cret")
+	t.Cleanup(provider.Close)
+
+	f := &fixture{
+		provider: provider,
+		users:    &memoryUsers{},
+		roles:    memoryRoles{},
+		now:      time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.service = &OIDC{
+		Provider: &oidc.Client{
+			Issuer:       provider.Issuer(),
+			ClientID:     "books",
+			ClientSecret: "s3cret",
+			RedirectURL:  "http://app.example.com/api/v1/auth/oidc/callback",
+		},
+		LoginRepository:    memoryLogins{},
+		IdentityRepository: memoryIdentities{},
+		UserRepository:     f.users,
+		RoleRepository:     f.roles,
+		Sessions:           fakeSessions{},
+		RoleMap:            map[string]rbac.Role{"librarians": rbac.RoleEditor, "library-admins": rbac.RoleAdmin},
+		now:                func() time.Time { return f.now },
+	}
+	return f
+}
+
+// begin starts a login and approves it at the provider, returning the
+// callback the browser would make.
+func (f *fixture) begin(t *testing.T) *oidc.CallbackRequest {
+	t.Helper()
+	location, state, err := f.service.Begin(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &oidc.CallbackRequest{Code: back.Query().Get("code"), State: back.Query().Get("state"), CookieState: state}
+}
+
+func (f *fixture) login(t *testing.T) (*user.Session, error) {
+	t.Helper()
+	return f.service.Callback(context.Background(), f.begin(t))
+}
+
+func assertStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("got no error, want status %d", status)
+	}
+	if got := helper.StatusCode(err); got != status {
+		t.Errorf("got status %d (%v), want %d", got, err, status)
+	}
+}
+
+func TestCallbackCreatesAndLinksUsers(t *testing.T) {
+	f := newFixture(t)
+	f.provider.SetUser("alice", map[string]interface{}{"email": "Alice@Example.com", "email_verified": true, "groups": []string{"librarians"}})
+
+	session, err := f.login(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.User.Email != "alice@example.com" || session.User.PasswordHash != "" {
+		t.Errorf("created user %+v", session.User)
+	}
+	if got := f.roles[session.User.Subject()]; got.Role != rbac.RoleEditor || got.AssignedBy != AssignedBy {
+		t.Errorf("assigned %+v, want editor from oidc", got)
+	}
+
+	// The email may change at the provider; the subject is what counts.
+	f.provider.SetUser("alice", map[string]interface{}{"email": "alice@new.example.com", "email_verified": true, "groups": []string{"librarians", "library-admins"}})
+	again, err := f.login(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.User.ID != session.User.ID || len(*f.users) != 1 {
+		t.Errorf("signed in as user %d of %d, want the same user", again.User.ID, len(*f.users))
+	}
+	if got := f.roles[session.User.Subject()].Role; got != rbac.RoleAdmin {
+		t.Errorf("role = %q, want the highest mapped, admin", got)
+	}
+
+	f.provider.SetUser("alice", map[string]interface{}{"groups": "readers"})
+	if _, err := f.login(t); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.roles[session.User.Subject()]; ok {
+		t.Error("role kept after the claims stopped mapping to it")
+	}
+}
+
+func TestCallbackLinksExistingAccount(t *testing.T) {
+	f := newFixture(t)
+	existing, _ := f.users.Create(context.Background(), &user.User{Email: "bob@example.com", PasswordHash: "hash"})
+	f.roles[existing.Subject()] = rbac.Assignment{Subject: existing.Subject(), Role: rbac.RoleAdmin, AssignedBy: "user:1"}
+
+	f.provider.SetUser("bob", map[string]interface{}{"email": "bob@example.com", "email_verified": true, "groups": []string{"librarians"}})
+	session, err := f.login(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.User.ID != existing.ID {
+		t.Errorf("signed in as user %d, want %d", session.User.ID, existing.ID)
+	}
+	if got := f.roles[existing.Subject()].Role; got != rbac.RoleAdmin {
+		t.Errorf("role = %q, want the admin assigned by hand kept", got)
+	}
+}
+
+func TestCallbackRejectsUnverifiedEmail(t *testing.T) {
+	f := newFixture(t)
+	f.users.Create(context.Background(), &user.User{Email: "carol@example.com", PasswordHash: "hash"})
+
+	f.provider.SetUser("mallory", map[string]interface{}{"email": "carol@example.com", "email_verified": false})
+	_, err := f.login(t)
+	assertStatus(t, err, http.StatusForbidden)
+
+	f.provider.SetUser("mallory", map[string]interface{}{})
+	_, err = f.login(t)
+	assertStatus(t, err, http.StatusForbidden)
+}
+
+func TestCallbackState(t *testing.T) {
+	f := newFixture(t)
+	f.provider.SetUser("alice", map[string]interface{}{"email": "alice@example.com", "email_verified": true})
+	ctx := context.Background()
+
+	t.Run("cookie from another browser", func(t *testing.T) {
+		req := f.begin(t)
+		req.CookieState = f.begin(t).CookieState
+		_, err := f.service.Callback(ctx, req)
+		assertStatus(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		req := f.begin(t)
+		if _, err := f.service.Callback(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		_, err := f.service.Callback(ctx, req)
+		assertStatus(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		req := f.begin(t)
+		f.now = f.now.Add(DefaultLoginTTL)
+		_, err := f.service.Callback(ctx, req)
+		assertStatus(t, err, http.StatusBadRequest)
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		req := f.begin(t)
+		req.Code, req.Error = "", "access_denied"
+		_, err := f.service.Callback(ctx, req)
+		assertStatus(t, err, http.StatusUnauthorized)
+	})
+}
+
+func TestCallbackRejectsWrongNonce(t *testing.T) {
+	f := newFixture(t)
+	f.provider.SetUser("alice", map[string]interface{}{"email": "alice@example.com", "email_verified": true})
+	f.provider.SetNonce("nonce-of-another-login")
+
+	_, err := f.login(t)
+	assertStatus(t, err, http.StatusUnauthorized)
+	if len(*f.users) != 0 {
+		t.Error("created a user from a rejected ID token")
+	}
+}
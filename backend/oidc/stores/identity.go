@@ -0,0 +1,45 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/oidc"
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const identityColumns = "issuer, subject, user_id, created_at"
+
+type Identity struct {
+	db *sqlx.DB
+}
+
+func NewIdentity(db *sqlx.DB) *Identity {
+	return &Identity{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *Identity) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+func (s *Identity) Get(ctx context.Context, issuer, subject string) (*oidc.Identity, error) {
+	var data oidc.Identity
+	query := "SELECT " + identityColumns + " FROM user_identities WHERE issuer = $1 AND subject = $2"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, issuer, subject); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *Identity) Create(ctx context.Context, data *oidc.Identity) (*oidc.Identity, error) {
+	var created oidc.Identity
+	query := "INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3) RETURNING " + identityColumns
+	if err := s.conn(ctx).GetContext(ctx, &created, query, data.Issuer, data.Subject, data.UserID); err != nil {
+		log.Error().Err(err).Msg("failed to insert user identity")
+		return nil, err
+	}
+	return &created, nil
+}
@@ -0,0 +1,58 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/oidc"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const loginColumns = "state_hash, nonce, code_verifier, created_at, expires_at"
+
+type Login struct {
+	db *sqlx.DB
+}
+
+func NewLogin(db *sqlx.DB) *Login {
+	return &Login{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *Login) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+// Create stores a login in progress. Logins abandoned at the provider are
+// never consumed, so the expired ones are removed on the way.
+func (s *Login) Create(ctx context.Context, data *oidc.Login) error {
+	if _, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM oidc_logins WHERE expires_at <= NOW()"); err != nil {
+		log.Warn().Err(err).Msg("failed to delete expired OIDC logins")
+	}
+
+	query := "INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4)"
+	if _, err := s.conn(ctx).ExecContext(ctx, query, data.StateHash, data.Nonce, data.CodeVerifier, data.ExpiresAt); err != nil {
+		log.Error().Err(err).Msg("failed to insert OIDC login")
+		return err
+	}
+	return nil
+}
+
+// Consume removes the login with the state hash and returns it, so a state
+// works once. It returns sql.ErrNoRows when there is none or it expired
+// before at.
+func (s *Login) Consume(ctx context.Context, stateHash string, at time.Time) (*oidc.Login, error) {
+	var data oidc.Login
+	query := "DELETE FROM oidc_logins WHERE state_hash = $1 RETURNING " + loginColumns
+	if err := s.conn(ctx).GetContext(ctx, &data, query, stateHash); err != nil {
+		return nil, err
+	}
+	if !data.ExpiresAt.After(at) {
+		return nil, sql.ErrNoRows
+	}
+	return &data, nil
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"byfood-interview/helper"
 	"byfood-interview/process-url/service"
 	"encoding/json"
 	"net/http"
@@ -15,10 +16,6 @@ type processResp struct {
 	ProcessedURL string `json:"processed_url"`
 }
 
-type errResp struct {
-	Error string `json:"error"`
-}
-
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
@@ -33,24 +30,21 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 // @Produce json
 // @Param request body processReq true "URL Cleanup Request"
 // @Success 200 {object} processResp
-// @Failure 400 {object} errResp
+// @Failure 400 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
 // @Router /api/v1/process-url [post]
 func ProcessURLHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req processReq
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeJSON(w, http.StatusBadRequest, errResp{Error: "invalid JSON body"})
-			return
-		}
-
-		if req.URL == "" {
-			writeJSON(w, http.StatusBadRequest, errResp{Error: "url is required"})
+		if err := helper.DecodeJSON(r.Body, &req); err != nil {
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
 		out, err := service.ProcessURL(r.Context(), req.URL, req.Operation)
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, errResp{Error: err.Error()})
+			helper.WriteResponse(w, r, err, nil)
 			return
 		}
 
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"byfood-interview/helper"
 	"context"
 	"errors"
 	"net/url"
@@ -9,26 +10,31 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ProcessURL applies operation to rawURL. Problems with either are reported
+// together as a *helper.ErrValidation.
 func ProcessURL(ctx context.Context, rawURL string, operation string) (string, error) {
 	log := log.Ctx(ctx).With().Str("service", "process_url").Logger()
 
+	var v helper.Validator
 	u, err := url.Parse(rawURL)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to parse URL")
-		return "", errors.New("invalid url")
-	}
-	if u.Scheme == "" || (u.Scheme != "http" && u.Scheme != "https") {
-		log.Error().Err(err).Msg("URL must include scheme http or https")
-		return "", errors.New("url must include scheme http or https")
-	}
-	if u.Host == "" {
-		log.Error().Msg("URL must include a host")
-		return "", errors.New("url must include a host")
+	switch {
+	case rawURL == "":
+		v.Add("url", helper.CodeRequired, "url is required")
+	case err != nil:
+		v.Add("url", helper.CodeInvalid, "invalid url")
+	case u.Scheme != "http" && u.Scheme != "https":
+		v.Add("url", helper.CodeInvalid, "url must include scheme http or https")
+	case u.Host == "":
+		v.Add("url", helper.CodeInvalid, "url must include a host")
 	}
 
 	op, err := ParseOperation(operation)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to parse operation")
+		v.Add("operation", helper.CodeInvalid, err.Error())
+	}
+
+	if err := v.Err(); err != nil {
+		log.Error().Err(err).Msg("invalid process url request")
 		return "", err
 	}
 
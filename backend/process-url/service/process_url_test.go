@@ -1,7 +1,9 @@
 package service
 
 import (
+	"byfood-interview/helper"
 	"context"
+	"errors"
 	"testing"
 )
 
@@ -70,3 +72,21 @@ func TestParseOperation(t *testing.T) {
 		t.Fatalf("parse canonical failed: %v %v", op, err)
 	}
 }
+
+func TestProcessURL_ReportsEveryField(t *testing.T) {
+	_, err := ProcessURL(context.TODO(), "", "shorten")
+
+	var validationErr *helper.ErrValidation
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected *helper.ErrValidation, got %v", err)
+	}
+	if len(validationErr.Errors) != 2 {
+		t.Fatalf("expected 2 field errors, got %+v", validationErr.Errors)
+	}
+	if validationErr.Errors[0].Field != "url" || validationErr.Errors[0].Code != helper.CodeRequired {
+		t.Fatalf("unexpected url error: %+v", validationErr.Errors[0])
+	}
+	if validationErr.Errors[1].Field != "operation" || validationErr.Errors[1].Code != helper.CodeInvalid {
+		t.Fatalf("unexpected operation error: %+v", validationErr.Errors[1])
+	}
+}
@@ -0,0 +1,214 @@
+// Package ratelimit limits how fast each client may call each route with
+// token buckets: a bucket holds up to Burst tokens, refills at Limit tokens
+// per Period and every request takes one.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Any matches every method or every route in a Rule.
+const Any = "*"
+
+// Rule limits the requests of each client to Method and Route, a route
+// template such as "/api/v1/books/{id}". Either may be Any.
+type Rule struct {
+	Method string
+	Route  string
+	Limit  int
+	Period time.Duration
+	// Burst is how many requests a client may make at once after being
+	// idle; it defaults to Limit.
+	Burst int
+}
+
+// String renders the rule as ParseRules reads it.
+func (r Rule) String() string {
+	s := fmt.Sprintf("%s %s=%d/%s", r.Method, r.Route, r.Limit, r.Period)
+	if r.Burst > 0 && r.Burst != r.Limit {
+		s += ":" + strconv.Itoa(r.Burst)
+	}
+	return s
+}
+
+// Capacity is the most tokens a bucket of the rule holds.
+func (r Rule) Capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Limit)
+}
+
+// Rate is how many tokens a bucket of the rule regains per second.
+func (r Rule) Rate() float64 {
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// Decide describes a bucket of the rule left holding tokens after a request
+// that was allowed or not.
+func (r Rule) Decide(tokens float64, allowed bool) Decision {
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     r.Limit,
+		Remaining: int(math.Max(0, math.Floor(tokens))),
+		Reset:     seconds((r.Capacity() - tokens) / r.Rate()),
+		Policy:    r.Policy(),
+	}
+	if !allowed {
+		d.RetryAfter = seconds((1 - tokens) / r.Rate())
+	}
+	return d
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Max(0, s) * float64(time.Second))
+}
+
+// Policy renders the rule for the RateLimit-Policy header.
+func (r Rule) Policy() string {
+	policy := fmt.Sprintf("%d;w=%d", r.Limit, int(math.Ceil(r.Period.Seconds())))
+	if r.Burst > 0 && r.Burst != r.Limit {
+		policy += fmt.Sprintf(";burst=%d", r.Burst)
+	}
+	return policy
+}
+
+func (r Rule) matches(method, route string) bool {
+	return (r.Method == Any || r.Method == method) && (r.Route == Any || r.Route == route)
+}
+
+// specificity ranks rules naming a route above rules naming a method above
+// catch-alls.
+func (r Rule) specificity() int {
+	n := 0
+	if r.Route != Any {
+		n += 2
+	}
+	if r.Method != Any {
+		n++
+	}
+	return n
+}
+
+// Rules is a set of rules, of which the most specific matching one limits a
+// request.
+type Rules []Rule
+
+// Match returns the rule limiting requests to method and route, or nil
+// when none does.
+func (rs Rules) Match(method, route string) *Rule {
+	var match *Rule
+	for i := range rs {
+		if rs[i].matches(method, route) && (match == nil || rs[i].specificity() > match.specificity()) {
+			match = &rs[i]
+		}
+	}
+	return match
+}
+
+// ParseRules reads rules written as "[METHOD ]ROUTE=LIMIT/PERIOD[:BURST]",
+// such as "POST /api/v1/process-url=10/1m:20" or "*=600/m". A missing
+// method, and a route of "*", match everything; PERIOD is a Go duration
+// whose leading 1 may be left out.
+func ParseRules(specs []string) (Rules, error) {
+	var rules Rules
+	for _, spec := range specs {
+		rule, err := parseRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit %q: %w", spec, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(spec string) (Rule, error) {
+	target, limit, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	if !ok {
+		return Rule{}, fmt.Errorf("expected ROUTE=LIMIT/PERIOD")
+	}
+
+	rule := Rule{Method: Any}
+	fields := strings.Fields(target)
+	switch len(fields) {
+	case 1:
+		rule.Route = fields[0]
+	case 2:
+		rule.Method, rule.Route = strings.ToUpper(fields[0]), fields[1]
+	default:
+		return Rule{}, fmt.Errorf("expected an optional method and a route")
+	}
+	if rule.Route != Any && !strings.HasPrefix(rule.Route, "/") {
+		return Rule{}, fmt.Errorf("route must start with / or be *")
+	}
+
+	limit, burst, hasBurst := strings.Cut(strings.TrimSpace(limit), ":")
+	count, period, ok := strings.Cut(limit, "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("expected LIMIT/PERIOD")
+	}
+	var err error
+	if rule.Limit, err = strconv.Atoi(count); err != nil || rule.Limit <= 0 {
+		return Rule{}, fmt.Errorf("limit must be a positive integer")
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	if rule.Period, err = time.ParseDuration(period); err != nil || rule.Period <= 0 {
+		return Rule{}, fmt.Errorf("period must be a positive duration such as 1m")
+	}
+	if hasBurst {
+		if rule.Burst, err = strconv.Atoi(burst); err != nil || rule.Burst <= 0 {
+			return Rule{}, fmt.Errorf("burst must be a positive integer")
+		}
+	}
+	return rule, nil
+}
+
+// Decision is the outcome of taking a token for a request.
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests allowed per period.
+	Limit int
+	// Remaining is how many more requests would be allowed right away.
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long a refused client must wait for a token.
+	RetryAfter time.Duration
+	// Policy describes the rule applied; see Rule.Policy.
+	Policy string
+}
+
+// Bucket is the state of one client's bucket for one rule.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// NewBucket returns the full bucket of a client first seen at now.
+func NewBucket(rule Rule, now time.Time) *Bucket {
+	return &Bucket{Tokens: rule.Capacity(), UpdatedAt: now}
+}
+
+// Take refills b up to now and takes a token from it when one is left.
+func (b *Bucket) Take(rule Rule, now time.Time) Decision {
+	if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(rule.Capacity(), b.Tokens+elapsed*rule.Rate())
+		b.UpdatedAt = now
+	}
+	allowed := b.Tokens >= 1
+	if allowed {
+		b.Tokens--
+	}
+	return rule.Decide(b.Tokens, allowed)
+}
+
+// FullAt is when b will have refilled completely.
+func (b *Bucket) FullAt(rule Rule) time.Time {
+	return b.UpdatedAt.Add(seconds((rule.Capacity() - b.Tokens) / rule.Rate()))
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]string{"POST /api/v1/process-url=10/1m:20", "get /api/v1/books=5/s", "*=600/m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Rules{
+		{Method: "POST", Route: "/api/v1/process-url", Limit: 10, Period: time.Minute, Burst: 20},
+		{Method: "GET", Route: "/api/v1/books", Limit: 5, Period: time.Second},
+		{Method: Any, Route: Any, Limit: 600, Period: time.Minute},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d: got %+v, want %+v", i, rules[i], want[i])
+		}
+		if again, err := ParseRules([]string{rules[i].String()}); err != nil || again[0] != rules[i] {
+			t.Errorf("rule %d does not round-trip through %q: %+v, %v", i, rules[i].String(), again, err)
+		}
+	}
+
+	for _, spec := range []string{"", "/books", "/books=10", "/books=0/m", "/books=10/0s", "/books=10/fortnight", "books=10/m", "GET /books x=10/m", "/books=10/m:0"} {
+		if _, err := ParseRules([]string{spec}); err == nil {
+			t.Errorf("expected %q to be invalid", spec)
+		}
+	}
+}
+
+func TestRulesMatch(t *testing.T) {
+	rules, err := ParseRules([]string{"*=600/m", "GET *=300/m", "/api/v1/books/{id}=100/m", "POST /api/v1/books/{id}=10/m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		method, route string
+		limit         int
+	}{
+		{"POST", "/api/v1/books/{id}", 10},
+		{"PUT", "/api/v1/books/{id}", 100},
+		{"GET", "/api/v1/books/{id}", 100},
+		{"GET", "/api/v1/books", 300},
+		{"DELETE", "/api/v1/books", 600},
+	}
+	for _, tc := range cases {
+		if rule := rules.Match(tc.method, tc.route); rule == nil || rule.Limit != tc.limit {
+			t.Errorf("%s %s: got %+v, want the rule of limit %d", tc.method, tc.route, rule, tc.limit)
+		}
+	}
+	if rule := (Rules{}).Match("GET", "/api/v1/books"); rule != nil {
+		t.Errorf("expected no rule to match, got %+v", rule)
+	}
+}
+
+func TestBucketTake(t *testing.T) {
+	rule := Rule{Method: Any, Route: Any, Limit: 2, Period: time.Second, Burst: 3}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	bucket := NewBucket(rule, now)
+
+	for i := 2; i >= 0; i-- {
+		d := bucket.Take(rule, now)
+		if !d.Allowed || d.Remaining != i {
+			t.Fatalf("expected the burst to be allowed with %d remaining, got %+v", i, d)
+		}
+	}
+	refused := bucket.Take(rule, now)
+	if refused.Allowed || refused.RetryAfter != 500*time.Millisecond || refused.Reset != 1500*time.Millisecond {
+		t.Fatalf("expected a refusal until the next token in 500ms, got %+v", refused)
+	}
+	if refused.Limit != 2 || refused.Policy != "2;w=1;burst=3" {
+		t.Errorf("unexpected limit or policy: %+v", refused)
+	}
+
+	if d := bucket.Take(rule, now.Add(500*time.Millisecond)); !d.Allowed || d.Remaining != 0 {
+		t.Fatalf("expected a token after 500ms, got %+v", d)
+	}
+	if d := bucket.Take(rule, now.Add(time.Hour)); !d.Allowed || d.Remaining != 2 {
+		t.Fatalf("expected an idle bucket to refill only up to its burst, got %+v", d)
+	}
+	if full := bucket.FullAt(rule); !full.Equal(now.Add(time.Hour + 500*time.Millisecond)) {
+		t.Errorf("unexpected full time %v", full)
+	}
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"byfood-interview/ratelimit"
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type BucketRepository interface {
+	Take(ctx context.Context, key string, rule ratelimit.Rule) (ratelimit.Decision, error)
+}
+
+// fullBucketDeleter is implemented by repositories whose buckets outlive
+// the process and must be purged.
+type fullBucketDeleter interface {
+	DeleteFull(ctx context.Context) (int64, error)
+}
+
+// RateLimit applies Rules to the requests of each client.
+type RateLimit struct {
+	BucketRepository BucketRepository
+	Rules            ratelimit.Rules
+}
+
+// Allow takes a token for a request of client to method and route from the
+// bucket of the rule limiting them. It returns nil when no rule does. When
+// the buckets cannot be reached the request is let through rather than
+// failing every request, and the error is only logged.
+func (s *RateLimit) Allow(ctx context.Context, method, route, client string) *ratelimit.Decision {
+	log := log.Ctx(ctx).With().Str("service", "ratelimit").Logger()
+
+	rule := s.Rules.Match(method, route)
+	if rule == nil {
+		return nil
+	}
+
+	decision, err := s.BucketRepository.Take(ctx, rule.String()+"|"+client, *rule)
+	if err != nil {
+		log.Error().Err(err).Str("rule", rule.String()).Msg("failed to take rate limit token, allowing request")
+		return nil
+	}
+	if !decision.Allowed {
+		log.Info().Str("rule", rule.String()).Str("client", client).Msg("rate limit exceeded")
+	}
+	return &decision
+}
+
+// PurgeFull deletes refilled buckets every interval until ctx is done, for
+// repositories that keep them outside the process.
+func (s *RateLimit) PurgeFull(ctx context.Context, interval time.Duration) {
+	deleter, ok := s.BucketRepository.(fullBucketDeleter)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := deleter.DeleteFull(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to purge full rate limit buckets")
+				continue
+			}
+			log.Debug().Int64("deleted", deleted).Msg("full rate limit buckets purged")
+		}
+	}
+}
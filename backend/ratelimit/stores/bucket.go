@@ -0,0 +1,50 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/ratelimit"
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// Bucket keeps buckets in Postgres so that every replica draws on the same
+// ones. Each request costs one round trip, which takes the token under a
+// row lock, so concurrent requests of a client never overdraw its bucket.
+type Bucket struct {
+	db *sqlx.DB
+}
+
+func NewBucket(db *sqlx.DB) *Bucket {
+	return &Bucket{db: db}
+}
+
+func (s *Bucket) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+// Take takes a token from the bucket key of rule.
+func (s *Bucket) Take(ctx context.Context, key string, rule ratelimit.Rule) (ratelimit.Decision, error) {
+	var result struct {
+		Allowed bool    `db:"allowed"`
+		Tokens  float64 `db:"tokens"`
+	}
+	query := "SELECT allowed, tokens FROM take_rate_limit_token($1, $2, $3)"
+	if err := s.conn(ctx).GetContext(ctx, &result, query, key, rule.Capacity(), rule.Rate()); err != nil {
+		log.Error().Err(err).Msg("failed to take rate limit token")
+		return ratelimit.Decision{}, err
+	}
+	return rule.Decide(result.Tokens, result.Allowed), nil
+}
+
+// DeleteFull removes the buckets that have refilled, which are no different
+// from buckets never used, and returns how many there were.
+func (s *Bucket) DeleteFull(ctx context.Context) (int64, error) {
+	result, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE full_at <= NOW()")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete full rate limit buckets")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,71 @@
+package stores
+
+import (
+	"byfood-interview/ratelimit"
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often Memory drops the buckets that have
+// refilled, which are no different from buckets never used.
+const memorySweepInterval = time.Minute
+
+type memoryBucket struct {
+	*ratelimit.Bucket
+	fullAt time.Time
+}
+
+// Memory keeps buckets in the process. Each replica then limits clients on
+// its own, so a client spread over n replicas gets up to n times its limit;
+// use Bucket to share the limits.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]memoryBucket
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]memoryBucket{}}
+}
+
+func (m *Memory) clock() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+// Take takes a token from the bucket key of rule.
+func (m *Memory) Take(ctx context.Context, key string, rule ratelimit.Rule) (ratelimit.Decision, error) {
+	now := m.clock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.sweptAt) >= memorySweepInterval {
+		for k, b := range m.buckets {
+			if !now.Before(b.fullAt) {
+				delete(m.buckets, k)
+			}
+		}
+		m.sweptAt = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b.Bucket = ratelimit.NewBucket(rule, now)
+	}
+	decision := b.Take(rule, now)
+	b.fullAt = b.FullAt(rule)
+	m.buckets[key] = b
+	return decision, nil
+}
+
+// Len is the number of buckets held.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.buckets)
+}
@@ -0,0 +1,53 @@
+package stores
+
+import (
+	"byfood-interview/ratelimit"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryTake(t *testing.T) {
+	rule := ratelimit.Rule{Method: ratelimit.Any, Route: ratelimit.Any, Limit: 10, Period: time.Minute}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemory()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 25; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := store.Take(ctx, "a", rule)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if d.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Fatalf("expected concurrent requests to draw exactly the burst, got %d", allowed)
+	}
+	if d, _ := store.Take(ctx, "b", rule); !d.Allowed || d.Remaining != 9 {
+		t.Fatalf("expected keys to have buckets of their own, got %+v", d)
+	}
+
+	// Buckets that have refilled are dropped on the next sweep.
+	now = now.Add(time.Minute)
+	if d, _ := store.Take(ctx, "b", rule); !d.Allowed || d.Remaining != 9 {
+		t.Fatalf("expected a refilled bucket, got %+v", d)
+	}
+	if store.Len() != 1 {
+		t.Errorf("expected the idle bucket swept, got %d buckets", store.Len())
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type RoleService interface {
+	GetAll(ctx context.Context) ([]rbac.Assignment, error)
+	Assign(ctx context.Context, data *rbac.Assignment) (*rbac.Assignment, error)
+	Revoke(ctx context.Context, subject string) error
+}
+
+type Handler struct {
+	Service RoleService
+}
+
+// AssignRoleRequest is the body of AssignRole.
+type AssignRoleRequest struct {
+	Role rbac.Role `json:"role" example:"editor"`
+}
+
+// GetRoles godoc
+// @Summary List role assignments
+// @Description List the roles assigned to principals. Principals without one hold the default role. Admins only.
+// @Tags roles
+// @Produce json,xml,application/msgpack,text/csv
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=[]rbac.Assignment}
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/roles [get]
+// GetRoles handles listing role assignments
+func (h *Handler) GetRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := h.Service.GetAll(r.Context())
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// AssignRole godoc
+// @Summary Assign a role to a principal
+// @Description Grant the principal with the token subject a role (viewer, editor or admin), replacing the one it held. Admins only.
+// @Tags roles
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param subject path string true "Token subject of the principal"
+// @Param request body AssignRoleRequest true "Role to assign"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=rbac.Assignment}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/roles/{subject} [put]
+// AssignRole handles assigning a role to a principal
+func (h *Handler) AssignRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request AssignRoleRequest
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.Assign(r.Context(), &rbac.Assignment{Subject: mux.Vars(r)["subject"], Role: request.Role})
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// RevokeRole godoc
+// @Summary Revoke the role of a principal
+// @Description Remove the role assigned to a principal, leaving it with the default role. Admins only.
+// @Tags roles
+// @Produce json,xml,application/msgpack
+// @Param subject path string true "Token subject of the principal"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{}
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/roles/{subject} [delete]
+// RevokeRole handles revoking the role of a principal
+func (h *Handler) RevokeRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Service.Revoke(r.Context(), mux.Vars(r)["subject"]); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, "Role revoked successfully")
+	}
+}
@@ -0,0 +1,212 @@
+// Package rbac grants principals roles and decides which operations each
+// role may perform.
+package rbac
+
+import (
+	"byfood-interview/helper"
+	"context"
+	"strings"
+	"time"
+)
+
+// Role is a named set of permissions. Roles are ordered: each may do
+// everything the roles below it may.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// Roles lists every role, lowest first.
+var Roles = []Role{RoleViewer, RoleEditor, RoleAdmin}
+
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Valid reports whether r is one of Roles.
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// Covers reports whether r grants everything required does. No role, the
+// empty one included, covers an invalid role.
+func (r Role) Covers(required Role) bool {
+	return required.Valid() && r.rank() >= required.rank()
+}
+
+// Action is an operation guarded by the policy.
+type Action string
+
+const (
+	ActionReadBooks     Action = "books:read"
+	ActionCreateBook    Action = "books:create"
+	ActionUpdateBook    Action = "books:update"
+	ActionDeleteBook    Action = "books:delete"
+	ActionMergeBooks    Action = "books:merge"
+	ActionManageRoles   Action = "roles:manage"
+	ActionManageKeys    Action = "api-keys:manage"
+	ActionProcessURL    Action = "urls:process"
+	ActionManageTenants Action = "tenants:manage"
+	ActionManageUsage   Action = "usage:manage"
+
+	ActionReadSavedSearches  Action = "saved-searches:read"
+	ActionWriteSavedSearches Action = "saved-searches:write"
+)
+
+// Scopes that can be granted to API keys.
+const (
+	ScopeReadBooks  = "books:read"
+	ScopeWriteBooks = "books:write"
+	ScopeProcessURL = "urls:process"
+)
+
+// Scopes lists every scope that can be granted.
+var Scopes = []string{ScopeReadBooks, ScopeWriteBooks, ScopeProcessURL}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizer decides whether the caller on ctx may perform action, returning
+// the error to report when not. Services consult one, when set, before each
+// operation called on behalf of a client.
+type Authorizer interface {
+	Authorize(ctx context.Context, action Action) error
+}
+
+// Policy maps each action to the lowest role allowed to perform it, and
+// says which role callers hold before any is assigned to them. Actions
+// missing from Actions are denied to everyone.
+type Policy struct {
+	// Anonymous is the role of callers without a token.
+	Anonymous Role
+	// Authenticated is the role of callers with a token but no assigned role.
+	Authenticated Role
+	Actions       map[Action]Role
+	// Scopes maps the actions a scoped principal, such as an API key, may
+	// perform to the scope it needs. Scoped principals have no role and may
+	// perform no action missing from Scopes.
+	Scopes map[Action]string
+}
+
+// DefaultPolicy lets anyone read the catalog and saved searches and process
+// URLs, editors add and change books and save searches, and only admins
+// remove books, by deleting or merging, or manage roles, API keys, tenants
+// and usage quotas. API keys may read and write books and saved searches and
+// process URLs as their scopes allow, but never remove books or administer
+// anything. Tenants are managed by the admins of the default
+// tenant.
+var DefaultPolicy = Policy{
+	Anonymous:     RoleViewer,
+	Authenticated: RoleViewer,
+	Actions: map[Action]Role{
+		ActionReadBooks:     RoleViewer,
+		ActionProcessURL:    RoleViewer,
+		ActionCreateBook:    RoleEditor,
+		ActionUpdateBook:    RoleEditor,
+		ActionDeleteBook:    RoleAdmin,
+		ActionMergeBooks:    RoleAdmin,
+		ActionManageRoles:   RoleAdmin,
+		ActionManageKeys:    RoleAdmin,
+		ActionManageTenants: RoleAdmin,
+		ActionManageUsage:   RoleAdmin,
+
+		ActionReadSavedSearches:  RoleViewer,
+		ActionWriteSavedSearches: RoleEditor,
+	},
+	Scopes: map[Action]string{
+		ActionReadBooks:  ScopeReadBooks,
+		ActionCreateBook: ScopeWriteBooks,
+		ActionUpdateBook: ScopeWriteBooks,
+		ActionProcessURL: ScopeProcessURL,
+
+		ActionReadSavedSearches:  ScopeReadBooks,
+		ActionWriteSavedSearches: ScopeWriteBooks,
+	},
+}
+
+// OpenPolicy is the policy of servers no one can sign in to. Anonymous
+// callers keep the access to books they had before roles existed, removing
+// books included, but administer nothing: managing roles, API keys, tenants
+// and usage needs an admin, and so someone able to sign in.
+var OpenPolicy = Policy{
+	Anonymous:     RoleEditor,
+	Authenticated: RoleViewer,
+	Actions: map[Action]Role{
+		ActionReadBooks:     RoleViewer,
+		ActionProcessURL:    RoleViewer,
+		ActionCreateBook:    RoleEditor,
+		ActionUpdateBook:    RoleEditor,
+		ActionDeleteBook:    RoleEditor,
+		ActionMergeBooks:    RoleEditor,
+		ActionManageRoles:   RoleAdmin,
+		ActionManageKeys:    RoleAdmin,
+		ActionManageTenants: RoleAdmin,
+		ActionManageUsage:   RoleAdmin,
+
+		ActionReadSavedSearches:  RoleViewer,
+		ActionWriteSavedSearches: RoleEditor,
+	},
+	Scopes: DefaultPolicy.Scopes,
+}
+
+// Allows reports whether role may perform action.
+func (p Policy) Allows(role Role, action Action) bool {
+	required, ok := p.Actions[action]
+	return ok && role.Covers(required)
+}
+
+// AllowsScopes reports whether a principal granted scopes may perform
+// action.
+func (p Policy) AllowsScopes(scopes []string, action Action) bool {
+	required, ok := p.Scopes[action]
+	if !ok {
+		return false
+	}
+	for _, scope := range scopes {
+		if scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxSubjectLength is the longest principal subject a role can be assigned to.
+const MaxSubjectLength = 255
+
+// Assignment grants the principal with the token subject Subject a role.
+type Assignment struct {
+	Subject    string    `json:"subject" db:"subject"`
+	Role       Role      `json:"role" db:"role"`
+	AssignedBy string    `json:"assigned_by" db:"assigned_by"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (a *Assignment) Validate() error {
+	a.Subject = strings.TrimSpace(a.Subject)
+
+	var v helper.Validator
+	v.Check(a.Subject != "", "subject", helper.CodeRequired, "subject is required")
+	v.Check(len(a.Subject) <= MaxSubjectLength, "subject", helper.CodeTooLong, "subject must be at most 255 characters")
+	if a.Role == "" {
+		v.Add("role", helper.CodeRequired, "role is required")
+	} else {
+		v.Check(a.Role.Valid(), "role", helper.CodeInvalid, "role must be one of viewer, editor, admin")
+	}
+	return v.Err()
+}
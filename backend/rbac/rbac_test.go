@@ -0,0 +1,87 @@
+package rbac
+
+import "testing"
+
+func TestDefaultPolicy(t *testing.T) {
+	allowed := map[Role][]Action{
+		RoleViewer: {ActionReadBooks, ActionProcessURL, ActionReadSavedSearches},
+		RoleEditor: {ActionReadBooks, ActionProcessURL, ActionReadSavedSearches, ActionCreateBook, ActionUpdateBook,
+			ActionWriteSavedSearches},
+		RoleAdmin: {ActionReadBooks, ActionProcessURL, ActionReadSavedSearches, ActionCreateBook, ActionUpdateBook,
+			ActionWriteSavedSearches, ActionDeleteBook, ActionMergeBooks, ActionManageRoles, ActionManageKeys,
+			ActionManageTenants, ActionManageUsage},
+	}
+	for _, role := range Roles {
+		for action := range DefaultPolicy.Actions {
+			want := false
+			for _, a := range allowed[role] {
+				want = want || a == action
+			}
+			if got := DefaultPolicy.Allows(role, action); got != want {
+				t.Errorf("%s %s: got %v want %v", role, action, got, want)
+			}
+		}
+	}
+
+	if DefaultPolicy.Allows(RoleAdmin, Action("books:unknown")) {
+		t.Errorf("actions missing from the policy must be denied")
+	}
+	if DefaultPolicy.Allows(Role("owner"), ActionReadBooks) || DefaultPolicy.Allows("", ActionReadBooks) {
+		t.Errorf("invalid roles must be denied")
+	}
+}
+
+func TestDefaultPolicyScopes(t *testing.T) {
+	cases := []struct {
+		scopes []string
+		action Action
+		want   bool
+	}{
+		{[]string{ScopeReadBooks}, ActionReadBooks, true},
+		{[]string{ScopeReadBooks}, ActionCreateBook, false},
+		{[]string{ScopeWriteBooks}, ActionUpdateBook, true},
+		{[]string{ScopeWriteBooks}, ActionReadBooks, false},
+		{[]string{ScopeReadBooks, ScopeWriteBooks, ScopeProcessURL}, ActionDeleteBook, false},
+		{[]string{ScopeReadBooks, ScopeWriteBooks, ScopeProcessURL}, ActionManageKeys, false},
+		{[]string{ScopeProcessURL}, ActionProcessURL, true},
+		{[]string{ScopeReadBooks}, ActionWriteSavedSearches, false},
+		{[]string{ScopeWriteBooks}, ActionWriteSavedSearches, true},
+		{[]string{}, ActionReadBooks, false},
+	}
+	for _, tc := range cases {
+		if got := DefaultPolicy.AllowsScopes(tc.scopes, tc.action); got != tc.want {
+			t.Errorf("%v %s: got %v want %v", tc.scopes, tc.action, got, tc.want)
+		}
+	}
+}
+
+func TestAssignmentValidate(t *testing.T) {
+	valid := Assignment{Subject: " editor-1 ", Role: RoleEditor}
+	if err := valid.Validate(); err != nil || valid.Subject != "editor-1" {
+		t.Fatalf("unexpected %v, %q", err, valid.Subject)
+	}
+	for _, a := range []Assignment{{Subject: "", Role: RoleEditor}, {Subject: "x"}, {Subject: "x", Role: "owner"}} {
+		if err := a.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", a)
+		}
+	}
+}
+
+func TestOpenPolicy(t *testing.T) {
+	for _, action := range []Action{ActionReadBooks, ActionCreateBook, ActionUpdateBook, ActionDeleteBook, ActionMergeBooks,
+		ActionProcessURL, ActionReadSavedSearches, ActionWriteSavedSearches} {
+		if !OpenPolicy.Allows(OpenPolicy.Anonymous, action) {
+			t.Errorf("anonymous callers must be allowed %s", action)
+		}
+	}
+	for _, action := range []Action{ActionManageRoles, ActionManageKeys, ActionManageTenants, ActionManageUsage} {
+		if OpenPolicy.Allows(OpenPolicy.Anonymous, action) {
+			t.Errorf("anonymous callers must not be allowed %s", action)
+		}
+	}
+	for action := range DefaultPolicy.Actions {
+		if _, ok := OpenPolicy.Actions[action]; !ok {
+			t.Errorf("%s is missing from the open policy", action)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+type RoleRepository interface {
+	Get(ctx context.Context, subject string) (*rbac.Assignment, error)
+	GetAll(ctx context.Context) ([]rbac.Assignment, error)
+	Upsert(ctx context.Context, data *rbac.Assignment) (*rbac.Assignment, error)
+	Delete(ctx context.Context, subject string) error
+}
+
+// RBAC resolves the role of the principal on a request and checks it
+// against Policy.
+type RBAC struct {
+	RoleRepository RoleRepository
+	Policy         rbac.Policy
+	// Admins are subjects that hold the admin role whatever is stored, so
+	// that there is someone to assign the first roles.
+	Admins []string
+}
+
+// RoleOf returns the role of the principal p, nil for an anonymous caller.
+func (s *RBAC) RoleOf(ctx context.Context, p *auth.Principal) (rbac.Role, error) {
+	if p == nil {
+		return s.Policy.Anonymous, nil
+	}
+	for _, admin := range s.Admins {
+		if p.Subject == admin {
+			return rbac.RoleAdmin, nil
+		}
+	}
+
+	assignment, err := s.RoleRepository.Get(ctx, p.Subject)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return s.Policy.Authenticated, nil
+		}
+		return "", err
+	}
+	return assignment.Role, nil
+}
+
+// Authorize returns nil when the principal on ctx may perform action: by
+// its role, or by its scopes when it has them. A denial is a 401 for an
+// anonymous caller, who may be allowed once signed in, and a 403 otherwise.
+func (s *RBAC) Authorize(ctx context.Context, action rbac.Action) error {
+	log := log.Ctx(ctx).With().Str("service", "rbac").Logger()
+
+	principal := auth.PrincipalFrom(ctx)
+
+	if principal != nil && principal.Scopes != nil {
+		if !s.Policy.AllowsScopes(principal.Scopes, action) {
+			log.Info().Str("subject", principal.Subject).Str("action", string(action)).Msg("access denied to scoped principal")
+			return helper.NewErrForbidden(fmt.Sprintf("credentials lack the scope to perform %s", action))
+		}
+		return nil
+	}
+
+	// Skip the role lookup for actions every caller of this kind may do.
+	base := s.Policy.Anonymous
+	if principal != nil {
+		base = s.Policy.Authenticated
+	}
+	if s.Policy.Allows(base, action) {
+		return nil
+	}
+	if principal == nil {
+		return helper.NewErrUnauthorized(auth.ErrMissingToken.Error())
+	}
+
+	role, err := s.RoleOf(ctx, principal)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to look up role")
+		return err
+	}
+	if !s.Policy.Allows(role, action) {
+		log.Info().Str("subject", principal.Subject).Str("role", string(role)).Str("action", string(action)).Msg("access denied")
+		return helper.NewErrForbidden(fmt.Sprintf("role %q may not perform %s", role, action))
+	}
+	return nil
+}
+
+// GetAll lists the assigned roles.
+func (s *RBAC) GetAll(ctx context.Context) ([]rbac.Assignment, error) {
+	log := log.Ctx(ctx).With().Str("service", "rbac").Logger()
+
+	if err := s.Authorize(ctx, rbac.ActionManageRoles); err != nil {
+		return nil, err
+	}
+
+	assignments, err := s.RoleRepository.GetAll(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get role assignments")
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// Assign grants data.Role to data.Subject, replacing the role it held.
+func (s *RBAC) Assign(ctx context.Context, data *rbac.Assignment) (*rbac.Assignment, error) {
+	log := log.Ctx(ctx).With().Str("service", "rbac").Logger()
+
+	if err := s.Authorize(ctx, rbac.ActionManageRoles); err != nil {
+		return nil, err
+	}
+	if err := data.Validate(); err != nil {
+		return nil, err
+	}
+	if principal := auth.PrincipalFrom(ctx); principal != nil {
+		data.AssignedBy = principal.Subject
+	}
+
+	saved, err := s.RoleRepository.Upsert(ctx, data)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to assign role")
+		return nil, err
+	}
+	log.Info().Str("subject", saved.Subject).Str("role", string(saved.Role)).Msg("role assigned")
+	return saved, nil
+}
+
+// Revoke removes the role assigned to subject, leaving it with the
+// policy's default role.
+func (s *RBAC) Revoke(ctx context.Context, subject string) error {
+	log := log.Ctx(ctx).With().Str("service", "rbac").Logger()
+
+	if err := s.Authorize(ctx, rbac.ActionManageRoles); err != nil {
+		return err
+	}
+
+	if err := s.RoleRepository.Delete(ctx, subject); err != nil {
+		if err == sql.ErrNoRows {
+			return helper.NewErrNotFound("role assignment not found")
+		}
+		log.Error().Err(err).Msg("failed to revoke role")
+		return err
+	}
+	log.Info().Str("subject", subject).Msg("role revoked")
+	return nil
+}
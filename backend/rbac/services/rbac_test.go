@@ -0,0 +1,127 @@
+package services
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+)
+
+// memoryRoles is an in-memory RoleRepository.
+type memoryRoles struct {
+	assignments map[string]rbac.Assignment
+	lookups     int
+}
+
+func (m *memoryRoles) Get(ctx context.Context, subject string) (*rbac.Assignment, error) {
+	m.lookups++
+	a, ok := m.assignments[subject]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &a, nil
+}
+
+func (m *memoryRoles) GetAll(ctx context.Context) ([]rbac.Assignment, error) {
+	var all []rbac.Assignment
+	for _, a := range m.assignments {
+		all = append(all, a)
+	}
+	return all, nil
+}
+
+func (m *memoryRoles) Upsert(ctx context.Context, data *rbac.Assignment) (*rbac.Assignment, error) {
+	m.assignments[data.Subject] = *data
+	return data, nil
+}
+
+func (m *memoryRoles) Delete(ctx context.Context, subject string) error {
+	if _, ok := m.assignments[subject]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.assignments, subject)
+	return nil
+}
+
+func as(subject string) context.Context {
+	return auth.WithPrincipal(context.Background(), &auth.Principal{Subject: subject})
+}
+
+// scoped returns a context for a principal limited to scopes, as API keys
+// are.
+func scoped(subject string, scopes ...string) context.Context {
+	return auth.WithPrincipal(context.Background(), &auth.Principal{Subject: subject, Scopes: append([]string{}, scopes...)})
+}
+
+func TestAuthorize(t *testing.T) {
+	roles := &memoryRoles{assignments: map[string]rbac.Assignment{
+		"editor-1": {Subject: "editor-1", Role: rbac.RoleEditor},
+	}}
+	service := &RBAC{RoleRepository: roles, Policy: rbac.DefaultPolicy, Admins: []string{"root"}}
+
+	cases := []struct {
+		ctx    context.Context
+		action rbac.Action
+		status int
+	}{
+		{context.Background(), rbac.ActionReadBooks, http.StatusOK},
+		{context.Background(), rbac.ActionCreateBook, http.StatusUnauthorized},
+		{as("viewer-1"), rbac.ActionReadBooks, http.StatusOK},
+		{as("viewer-1"), rbac.ActionCreateBook, http.StatusForbidden},
+		{as("editor-1"), rbac.ActionUpdateBook, http.StatusOK},
+		{as("editor-1"), rbac.ActionDeleteBook, http.StatusForbidden},
+		{as("root"), rbac.ActionMergeBooks, http.StatusOK},
+		{scoped("api-key:1", rbac.ScopeReadBooks), rbac.ActionReadBooks, http.StatusOK},
+		{scoped("api-key:1", rbac.ScopeReadBooks), rbac.ActionCreateBook, http.StatusForbidden},
+		{scoped("root", rbac.ScopeWriteBooks), rbac.ActionDeleteBook, http.StatusForbidden},
+		{scoped("api-key:2"), rbac.ActionReadBooks, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		err := service.Authorize(tc.ctx, tc.action)
+		got := http.StatusOK
+		if err != nil {
+			got = helper.StatusCode(err)
+		}
+		if got != tc.status {
+			t.Errorf("%v %s: got %d want %d (%v)", auth.PrincipalFrom(tc.ctx), tc.action, got, tc.status, err)
+		}
+	}
+
+	roles.lookups = 0
+	if err := service.Authorize(as("editor-1"), rbac.ActionReadBooks); err != nil || roles.lookups != 0 {
+		t.Fatalf("reads allowed to everyone should not look up the role, got %v after %d lookups", err, roles.lookups)
+	}
+}
+
+func TestAssignAndRevoke(t *testing.T) {
+	roles := &memoryRoles{assignments: map[string]rbac.Assignment{}}
+	service := &RBAC{RoleRepository: roles, Policy: rbac.DefaultPolicy, Admins: []string{"root"}}
+
+	if _, err := service.Assign(as("editor-1"), &rbac.Assignment{Subject: "editor-1", Role: rbac.RoleAdmin}); helper.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected non-admins to be refused, got %v", err)
+	}
+
+	saved, err := service.Assign(as("root"), &rbac.Assignment{Subject: "editor-1", Role: rbac.RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AssignedBy != "root" {
+		t.Fatalf("expected the assigning admin to be recorded, got %q", saved.AssignedBy)
+	}
+	if role, _ := service.RoleOf(context.Background(), &auth.Principal{Subject: "editor-1"}); role != rbac.RoleEditor {
+		t.Fatalf("got role %q", role)
+	}
+
+	if err := service.Revoke(as("root"), "editor-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Revoke(as("root"), "editor-1"); helper.StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected 404 revoking twice, got %v", err)
+	}
+	if role, _ := service.RoleOf(context.Background(), &auth.Principal{Subject: "editor-1"}); role != rbac.RoleViewer {
+		t.Fatalf("expected the default role after revoking, got %q", role)
+	}
+}
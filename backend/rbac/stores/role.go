@@ -0,0 +1,88 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/rbac"
+	"byfood-interview/tenant"
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const assignmentColumns = "subject, role, assigned_by, created_at, updated_at"
+
+// Role stores role assignments. Roles are granted per tenant: every call
+// reads and writes the assignments of the tenant on its context.
+type Role struct {
+	db *sqlx.DB
+}
+
+func NewRole(db *sqlx.DB) *Role {
+	return &Role{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *Role) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+func (s *Role) Get(ctx context.Context, subject string) (*rbac.Assignment, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var data rbac.Assignment
+	query := "SELECT " + assignmentColumns + " FROM role_assignments WHERE tenant_id = $1 AND subject = $2"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, tenantID, subject); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *Role) GetAll(ctx context.Context) ([]rbac.Assignment, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := []rbac.Assignment{}
+	query := "SELECT " + assignmentColumns + " FROM role_assignments WHERE tenant_id = $1 ORDER BY subject"
+	if err := s.conn(ctx).SelectContext(ctx, &assignments, query, tenantID); err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// Upsert assigns data.Role to data.Subject, replacing any role it held.
+func (s *Role) Upsert(ctx context.Context, data *rbac.Assignment) (*rbac.Assignment, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var saved rbac.Assignment
+	query := `INSERT INTO role_assignments (tenant_id, subject, role, assigned_by) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (tenant_id, subject) DO UPDATE SET role = EXCLUDED.role, assigned_by = EXCLUDED.assigned_by, updated_at = NOW()
+	RETURNING ` + assignmentColumns
+	if err := s.conn(ctx).GetContext(ctx, &saved, query, tenantID, data.Subject, data.Role, data.AssignedBy); err != nil {
+		log.Error().Err(err).Msg("failed to upsert role assignment")
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// Delete removes the role assigned to subject. It returns sql.ErrNoRows
+// when there is none.
+func (s *Role) Delete(ctx context.Context, subject string) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+
+	var deleted string
+	query := "DELETE FROM role_assignments WHERE tenant_id = $1 AND subject = $2 RETURNING subject"
+	return s.conn(ctx).GetContext(ctx, &deleted, query, tenantID, subject)
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/savedsearch"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type SavedSearchService interface {
+	Create(ctx context.Context, data *savedsearch.SavedSearch) (*savedsearch.SavedSearch, error)
+	GetByID(ctx context.Context, id int64) (*savedsearch.SavedSearch, error)
+	GetAll(ctx context.Context) ([]savedsearch.SavedSearch, error)
+	Delete(ctx context.Context, id int64) error
+	NewMatches(ctx context.Context, id int64) (*savedsearch.NewMatches, error)
+}
+
+type Handler struct {
+	Service SavedSearchService
+}
+
+// CreateSavedSearch godoc
+// @Summary Save a book query
+// @Description Save a text search and facet filter under a name. New matches are checked from the time it is saved.
+// @Tags saved-searches
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param savedSearch body savedsearch.SavedSearch true "Saved search (name, query, filter, notify_email)"
+// @Param Idempotency-Key header string false "Key making retries of this request safe"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=savedsearch.SavedSearch}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 409 {object} helper.Response
+// @Failure 422 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/saved-searches [post]
+// CreateSavedSearch handles saving a book query
+func (h *Handler) CreateSavedSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request savedsearch.SavedSearch
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.Create(r.Context(), &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// GetSavedSearches godoc
+// @Summary List saved searches
+// @Description List all saved searches
+// @Tags saved-searches
+// @Produce json,xml,application/msgpack,text/csv
+// @Success 200 {object} helper.Response{data=[]savedsearch.SavedSearch}
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/saved-searches [get]
+// GetSavedSearches handles listing saved searches
+func (h *Handler) GetSavedSearches() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := h.Service.GetAll(r.Context())
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// GetSavedSearchByID godoc
+// @Summary Get a saved search by ID
+// @Description Get a saved search by its ID
+// @Tags saved-searches
+// @Produce json,xml,application/msgpack
+// @Param id path int true "Saved search ID"
+// @Success 200 {object} helper.Response{data=savedsearch.SavedSearch}
+// @Failure 400 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/saved-searches/{id} [get]
+// GetSavedSearchByID handles fetching a saved search by its ID
+func (h *Handler) GetSavedSearchByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrBadRequest("invalid saved search ID"), nil)
+			return
+		}
+
+		data, err := h.Service.GetByID(r.Context(), id)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// DeleteSavedSearch godoc
+// @Summary Delete a saved search by ID
+// @Description Delete a saved search by its ID
+// @Tags saved-searches
+// @Produce json,xml,application/msgpack
+// @Param id path int true "Saved search ID"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/saved-searches/{id} [delete]
+// DeleteSavedSearch handles deleting a saved search by its ID
+func (h *Handler) DeleteSavedSearch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrBadRequest("invalid saved search ID"), nil)
+			return
+		}
+
+		if err := h.Service.Delete(r.Context(), id); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, "Saved search deleted successfully")
+	}
+}
+
+// GetNewMatches godoc
+// @Summary Get new matches of a saved search
+// @Description Get the books matching a saved search that were created or updated since it was last checked, and mark it checked now
+// @Tags saved-searches
+// @Produce json,xml,application/msgpack
+// @Param id path int true "Saved search ID"
+// @Success 200 {object} helper.Response{data=savedsearch.NewMatches}
+// @Failure 400 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/saved-searches/{id}/new [get]
+// GetNewMatches handles fetching the new matches of a saved search
+func (h *Handler) GetNewMatches() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrBadRequest("invalid saved search ID"), nil)
+			return
+		}
+
+		data, err := h.Service.NewMatches(r.Context(), id)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
@@ -0,0 +1,28 @@
+// Package notify delivers the new matches of saved searches.
+package notify
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/savedsearch"
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Log writes new matches to the context logger. It is the fallback when no
+// mail server is configured.
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, search *savedsearch.SavedSearch, books []book.Book) error {
+	ids := make([]int64, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+	}
+
+	log.Ctx(ctx).Info().
+		Int64("saved_search_id", search.ID).
+		Str("name", search.Name).
+		Ints64("book_ids", ids).
+		Msg("saved search has new matches")
+	return nil
+}
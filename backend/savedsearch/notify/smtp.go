@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/savedsearch"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSMTPTimeout bounds a delivery whose context has no deadline.
+const DefaultSMTPTimeout = 30 * time.Second
+
+// SMTP mails new matches to the notify_email of a saved search. Saved
+// searches without one are skipped.
+type SMTP struct {
+	// Addr is the host:port of the mail server.
+	Addr string
+	From string
+	// Auth is optional. net/smtp only sends credentials over TLS or to
+	// localhost.
+	Auth smtp.Auth
+	// Timeout bounds the whole delivery, from dialing to the end of the
+	// message, when the context has no deadline. It defaults to
+	// DefaultSMTPTimeout.
+	Timeout time.Duration
+}
+
+func (n *SMTP) Notify(ctx context.Context, search *savedsearch.SavedSearch, books []book.Book) error {
+	if search.NotifyEmail == "" {
+		log.Ctx(ctx).Debug().Int64("saved_search_id", search.ID).Msg("saved search has no notify email, skipping")
+		return nil
+	}
+
+	if err := n.send(ctx, search.NotifyEmail, n.message(search, books)); err != nil {
+		return fmt.Errorf("send saved search notification: %w", err)
+	}
+	return nil
+}
+
+// send is smtp.SendMail bounded by the deadline of ctx, so a stalled mail
+// server cannot hold the caller.
+func (n *SMTP) send(ctx context.Context, to string, msg []byte) error {
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := n.Timeout
+		if timeout == 0 {
+			timeout = DefaultSMTPTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	// Cancelling ctx interrupts the exchange too.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(n.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (n *SMTP) message(search *savedsearch.SavedSearch, books []book.Book) []byte {
+	var msg bytes.Buffer
+	subject := fmt.Sprintf("%d new books for %q", len(books), search.Name)
+
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", search.NotifyEmail)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+
+	fmt.Fprintf(&msg, "New books match your saved search %q:\r\n\r\n", search.Name)
+	for _, b := range books {
+		fmt.Fprintf(&msg, "- %s by %s (%d)\r\n", b.Title, b.Author, b.PublishedYear)
+	}
+
+	return msg.Bytes()
+}
@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"bufio"
+	"byfood-interview/book"
+	"byfood-interview/savedsearch"
+	"context"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts one message at a time and hands it to messages.
+type fakeSMTPServer struct {
+	listener net.Listener
+	messages chan fakeMessage
+}
+
+type fakeMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &fakeSMTPServer{listener: listener, messages: make(chan fakeMessage, 1)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var msg fakeMessage
+	reply("220 localhost fake SMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			msg.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			msg.data = data.String()
+			s.messages <- msg
+			msg = fakeMessage{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPNotify(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	notifier := &SMTP{Addr: server.listener.Addr().String(), From: "books@example.com"}
+
+	search := &savedsearch.SavedSearch{ID: 7, Name: "Ramen ラーメン", NotifyEmail: "reader@example.com"}
+	books := []book.Book{
+		{ID: 1, Title: "Ramen Obsession", Author: "Naomi Imatome-Yun", PublishedYear: 2017},
+		{ID: 2, Title: ".Dotted Title", Author: "Someone", PublishedYear: 2020},
+	}
+
+	if err := notifier.Notify(context.Background(), search, books); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	msg := <-server.messages
+	if msg.from != "books@example.com" {
+		t.Fatalf("unexpected sender %q", msg.from)
+	}
+	if len(msg.to) != 1 || msg.to[0] != "reader@example.com" {
+		t.Fatalf("unexpected recipients %q", msg.to)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(msg.data))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("failed to decode subject: %v", err)
+	}
+	if want := `2 new books for "Ramen ラーメン"`; subject != want {
+		t.Fatalf("got subject %q want %q", subject, want)
+	}
+	if !strings.Contains(msg.data, "- Ramen Obsession by Naomi Imatome-Yun (2017)\r\n") {
+		t.Fatalf("missing first book in body:\n%s", msg.data)
+	}
+	if !strings.Contains(msg.data, "- .Dotted Title by Someone (2020)\r\n") {
+		t.Fatalf("missing second book in body:\n%s", msg.data)
+	}
+}
+
+func TestSMTPNotifySkipsSearchWithoutEmail(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	notifier := &SMTP{Addr: server.listener.Addr().String(), From: "books@example.com"}
+
+	search := &savedsearch.SavedSearch{ID: 7, Name: "Ramen"}
+	if err := notifier.Notify(context.Background(), search, []book.Book{{ID: 1}}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	select {
+	case msg := <-server.messages:
+		t.Fatalf("expected no message, got %+v", msg)
+	default:
+	}
+}
+
+func TestSMTPNotifyReportsServerErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	notifier := &SMTP{Addr: addr, From: "books@example.com"}
+	search := &savedsearch.SavedSearch{ID: 7, Name: "Ramen", NotifyEmail: "reader@example.com"}
+	if err := notifier.Notify(context.Background(), search, []book.Book{{ID: 1}}); err == nil {
+		t.Fatal("expected an error when the server is unreachable")
+	}
+}
+
+func TestSMTPNotifyTimesOut(t *testing.T) {
+	// A server that accepts connections and never greets.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	notifier := &SMTP{Addr: listener.Addr().String(), From: "books@example.com", Timeout: 50 * time.Millisecond}
+	search := &savedsearch.SavedSearch{ID: 7, Name: "Ramen", NotifyEmail: "reader@example.com"}
+
+	start := time.Now()
+	if err := notifier.Notify(context.Background(), search, []book.Book{{ID: 1}}); err == nil {
+		t.Fatal("expected a stalled server to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the timeout to apply, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	notifier.Timeout = time.Hour
+	start = time.Now()
+	if err := notifier.Notify(ctx, search, []book.Book{{ID: 1}}); err == nil {
+		t.Fatal("expected the deadline of the context to apply")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the context deadline to apply, took %s", elapsed)
+	}
+}
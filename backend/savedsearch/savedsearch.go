@@ -0,0 +1,95 @@
+package savedsearch
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/helper"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNameRequired       = errors.New("name is required")
+	ErrInvalidNotifyEmail = errors.New("notify_email must be a valid email address")
+)
+
+// Filter is the facet selection of a saved search. It is stored as JSON.
+type Filter struct {
+	Authors        []string `json:"authors,omitempty"`
+	Decades        []int    `json:"decades,omitempty"`
+	PublishedYears []int    `json:"published_years,omitempty"`
+}
+
+// BookFilter returns f as the filter accepted by the book listing and search.
+func (f Filter) BookFilter() book.Filter {
+	return book.Filter{Authors: f.Authors, Decades: f.Decades, PublishedYears: f.PublishedYears}
+}
+
+func (f Filter) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+func (f *Filter) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, f)
+	case string:
+		return json.Unmarshal([]byte(v), f)
+	case nil:
+		*f = Filter{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into saved search filter", src)
+	}
+}
+
+// SavedSearch is a named book query, a text search and a filter, whose new
+// matches can be fetched or pushed to NotifyEmail. CheckedHorizon and
+// NotifiedHorizon are the transaction ID horizons the checked and notified
+// cursors stand at: the book changes of older transactions were reported.
+type SavedSearch struct {
+	ID              int64      `json:"id" db:"id"`
+	Name            string     `json:"name" db:"name"`
+	Query           string     `json:"query" db:"query"`
+	Filter          Filter     `json:"filter" db:"filter"`
+	NotifyEmail     string     `json:"notify_email,omitempty" db:"notify_email"`
+	LastCheckedAt   time.Time  `json:"last_checked_at" db:"last_checked_at"`
+	LastNotifiedAt  time.Time  `json:"-" db:"last_notified_at"`
+	CheckedHorizon  int64      `json:"-" db:"checked_horizon"`
+	NotifiedHorizon int64      `json:"-" db:"notified_horizon"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"-" db:"updated_at"`
+	DeletedAt       *time.Time `json:"-" db:"deleted_at"`
+	TenantID        int64      `json:"-" db:"tenant_id"`
+}
+
+func (s *SavedSearch) Validate() error {
+	s.Name = strings.TrimSpace(s.Name)
+	s.Query = strings.TrimSpace(s.Query)
+	s.NotifyEmail = strings.TrimSpace(s.NotifyEmail)
+
+	var v helper.Validator
+	v.Check(s.Name != "", "name", helper.CodeRequired, ErrNameRequired.Error())
+	if s.NotifyEmail != "" {
+		address, err := mail.ParseAddress(s.NotifyEmail)
+		v.Check(err == nil && address.Address == s.NotifyEmail, "notify_email", helper.CodeInvalid, ErrInvalidNotifyEmail.Error())
+	}
+	for _, decade := range s.Filter.Decades {
+		v.Check(decade%10 == 0, "filter.decades", helper.CodeInvalid, fmt.Sprintf("decade must be a multiple of 10, got %d", decade))
+	}
+	return v.Err()
+}
+
+// NewMatches are the books matching a saved search that were created or
+// updated since it was last checked at Since. CheckedAt is when this check
+// was made; changes still being written then are reported by the next one.
+type NewMatches struct {
+	SavedSearchID int64       `json:"saved_search_id"`
+	Since         time.Time   `json:"since"`
+	CheckedAt     time.Time   `json:"checked_at"`
+	Books         []book.Book `json:"books"`
+}
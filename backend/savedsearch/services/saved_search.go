@@ -0,0 +1,281 @@
+package services
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/helper"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/rbac"
+	"byfood-interview/savedsearch"
+	"byfood-interview/tenant"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type SavedSearchRepository interface {
+	Create(ctx context.Context, data *savedsearch.SavedSearch) (*savedsearch.SavedSearch, error)
+	GetByID(ctx context.Context, id int64) (*savedsearch.SavedSearch, error)
+	GetByIDForUpdate(ctx context.Context, id int64) (*savedsearch.SavedSearch, error)
+	GetByIDForUpdateSkipLocked(ctx context.Context, id int64) (*savedsearch.SavedSearch, error)
+	GetAll(ctx context.Context) ([]savedsearch.SavedSearch, error)
+	GetAllTenants(ctx context.Context) ([]savedsearch.SavedSearch, error)
+	Delete(ctx context.Context, id int64) error
+	MarkChecked(ctx context.Context, id, horizon int64) (time.Time, error)
+	MarkNotified(ctx context.Context, id, horizon int64) (time.Time, error)
+	RewindNotified(ctx context.Context, id, from, to int64) error
+}
+
+// BookMatcher finds the books a saved search matches within a change
+// window, bounded by transaction ID horizons as ChangeHorizon returns.
+type BookMatcher interface {
+	ChangeHorizon(ctx context.Context) (int64, error)
+	MatchingSince(ctx context.Context, query string, f book.Filter, since, until int64) ([]book.Book, error)
+}
+
+// Notifier delivers the new matches of a saved search. It is only called
+// with at least one book.
+type Notifier interface {
+	Notify(ctx context.Context, search *savedsearch.SavedSearch, books []book.Book) error
+}
+
+type SavedSearch struct {
+	SavedSearchRepository SavedSearchRepository
+	BookMatcher           BookMatcher
+	Transactor            internalDb.TxRunner
+	// Notifier receives the matches found by Evaluate.
+	Notifier Notifier
+	// Authorizer, when set, is consulted before each operation called on
+	// behalf of a client.
+	Authorizer rbac.Authorizer
+}
+
+// authorize checks action with the configured Authorizer, allowing it when
+// none is set.
+func (s *SavedSearch) authorize(ctx context.Context, action rbac.Action) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	return s.Authorizer.Authorize(ctx, action)
+}
+
+func (s *SavedSearch) Create(ctx context.Context, data *savedsearch.SavedSearch) (*savedsearch.SavedSearch, error) {
+	log := log.Ctx(ctx).With().Str("service", "saved_search").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionWriteSavedSearches); err != nil {
+		return nil, err
+	}
+
+	if err := data.Validate(); err != nil {
+		log.Error().Err(err).Msg("invalid saved search")
+		return nil, err
+	}
+
+	created, err := s.SavedSearchRepository.Create(ctx, data)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create saved search")
+		return nil, err
+	}
+	return created, nil
+}
+
+func (s *SavedSearch) GetByID(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	log := log.Ctx(ctx).With().Str("service", "saved_search").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadSavedSearches); err != nil {
+		return nil, err
+	}
+
+	data, err := s.SavedSearchRepository.GetByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrNotFound("saved search not found")
+		}
+		log.Error().Err(err).Msg("failed to get saved search by ID")
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *SavedSearch) GetAll(ctx context.Context) ([]savedsearch.SavedSearch, error) {
+	log := log.Ctx(ctx).With().Str("service", "saved_search").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadSavedSearches); err != nil {
+		return nil, err
+	}
+
+	searches, err := s.SavedSearchRepository.GetAll(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get saved searches")
+		return nil, err
+	}
+	return searches, nil
+}
+
+func (s *SavedSearch) Delete(ctx context.Context, id int64) error {
+	log := log.Ctx(ctx).With().Str("service", "saved_search").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionWriteSavedSearches); err != nil {
+		return err
+	}
+
+	if err := s.SavedSearchRepository.Delete(ctx, id); err != nil {
+		if err == sql.ErrNoRows {
+			return helper.NewErrNotFound("saved search not found")
+		}
+		log.Error().Err(err).Msg("failed to delete saved search")
+		return err
+	}
+	return nil
+}
+
+// NewMatches returns the books matching saved search id that were created or
+// updated since it was last checked, and moves its checked time to now.
+// Concurrent checks are serialized so every change is returned once.
+func (s *SavedSearch) NewMatches(ctx context.Context, id int64) (*savedsearch.NewMatches, error) {
+	log := log.Ctx(ctx).With().Str("service", "saved_search").Logger()
+
+	if err := s.authorize(ctx, rbac.ActionReadSavedSearches); err != nil {
+		return nil, err
+	}
+
+	var matches *savedsearch.NewMatches
+	err := internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		data, err := s.SavedSearchRepository.GetByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		since, sinceAt := data.CheckedHorizon, data.LastCheckedAt
+		horizon, err := s.BookMatcher.ChangeHorizon(ctx)
+		if err != nil {
+			return err
+		}
+		checkedAt, err := s.SavedSearchRepository.MarkChecked(ctx, id, horizon)
+		if err != nil {
+			return err
+		}
+
+		books, err := s.BookMatcher.MatchingSince(ctx, data.Query, data.Filter.BookFilter(), since, horizon)
+		if err != nil {
+			return err
+		}
+
+		matches = &savedsearch.NewMatches{SavedSearchID: id, Since: sinceAt, CheckedAt: checkedAt, Books: books}
+		return nil
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrNotFound("saved search not found")
+		}
+		log.Error().Err(err).Msg("failed to get new matches of saved search")
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// Evaluate pushes the new matches of every saved search, of every tenant, to
+// the Notifier and returns how many saved searches had any. A saved search
+// whose notification fails keeps its matches for the next run; one being
+// evaluated elsewhere is skipped.
+func (s *SavedSearch) Evaluate(ctx context.Context) (int, error) {
+	if s.Notifier == nil {
+		return 0, nil
+	}
+
+	searches, err := s.SavedSearchRepository.GetAllTenants(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("service", "saved_search").Msg("failed to list saved searches")
+		return 0, err
+	}
+
+	notified := 0
+	for _, data := range searches {
+		if ctx.Err() != nil {
+			return notified, ctx.Err()
+		}
+
+		// Each search only sees the books of its own tenant.
+		found, err := s.evaluate(tenant.WithTenant(ctx, &tenant.Tenant{ID: data.TenantID}), data.ID)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Int64("saved_search_id", data.ID).Msg("failed to evaluate saved search")
+			continue
+		}
+		if found {
+			notified++
+		}
+	}
+
+	return notified, nil
+}
+
+func (s *SavedSearch) evaluate(ctx context.Context, id int64) (bool, error) {
+	var (
+		data           *savedsearch.SavedSearch
+		since, horizon int64
+		books          []book.Book
+	)
+	err := internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		var err error
+		data, err = s.SavedSearchRepository.GetByIDForUpdateSkipLocked(ctx, id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				// Deleted meanwhile or being evaluated elsewhere.
+				data = nil
+				return nil
+			}
+			return err
+		}
+
+		since = data.NotifiedHorizon
+		if horizon, err = s.BookMatcher.ChangeHorizon(ctx); err != nil {
+			return err
+		}
+		if _, err := s.SavedSearchRepository.MarkNotified(ctx, id, horizon); err != nil {
+			return err
+		}
+
+		books, err = s.BookMatcher.MatchingSince(ctx, data.Query, data.Filter.BookFilter(), since, horizon)
+		return err
+	})
+	if err != nil || data == nil || len(books) == 0 {
+		return false, err
+	}
+
+	// The notification is sent once the cursor is committed, so a slow mail
+	// server holds neither the transaction nor the lock on the saved search.
+	// When it fails the cursor is moved back for the next run to send again.
+	if err := s.Notifier.Notify(ctx, data, books); err != nil {
+		if rewindErr := s.SavedSearchRepository.RewindNotified(ctx, id, horizon, since); rewindErr != nil {
+			log.Ctx(ctx).Error().Err(rewindErr).Int64("saved_search_id", id).Msg("failed to rewind saved search after failed notification")
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+// RunEvaluator calls Evaluate every interval until ctx is done.
+func (s *SavedSearch) RunEvaluator(ctx context.Context, interval time.Duration) {
+	if s.Notifier == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			notified, err := s.Evaluate(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to evaluate saved searches")
+				continue
+			}
+			log.Debug().Int("notified", notified).Msg("saved searches evaluated")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"byfood-interview/book"
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"byfood-interview/savedsearch"
+	"byfood-interview/tenant"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// memorySearches is an in-memory SavedSearchRepository scoped like the
+// Postgres one: every call but GetAllTenants needs a tenant on its context.
+type memorySearches struct {
+	searches []savedsearch.SavedSearch
+}
+
+func (m *memorySearches) find(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range m.searches {
+		if m.searches[i].ID == id && m.searches[i].TenantID == tenantID {
+			return &m.searches[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memorySearches) Create(ctx context.Context, data *savedsearch.SavedSearch) (*savedsearch.SavedSearch, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	created := *data
+	created.ID = int64(len(m.searches) + 1)
+	created.TenantID = tenantID
+	m.searches = append(m.searches, created)
+	return &created, nil
+}
+
+func (m *memorySearches) GetByID(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	return m.find(ctx, id)
+}
+
+func (m *memorySearches) GetByIDForUpdate(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	return m.find(ctx, id)
+}
+
+func (m *memorySearches) GetByIDForUpdateSkipLocked(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	return m.find(ctx, id)
+}
+
+func (m *memorySearches) GetAll(ctx context.Context) ([]savedsearch.SavedSearch, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var searches []savedsearch.SavedSearch
+	for _, data := range m.searches {
+		if data.TenantID == tenantID {
+			searches = append(searches, data)
+		}
+	}
+	return searches, nil
+}
+
+func (m *memorySearches) GetAllTenants(ctx context.Context) ([]savedsearch.SavedSearch, error) {
+	return m.searches, nil
+}
+
+func (m *memorySearches) Delete(ctx context.Context, id int64) error {
+	if _, err := m.find(ctx, id); err != nil {
+		return err
+	}
+	for i := range m.searches {
+		if m.searches[i].ID == id {
+			m.searches = append(m.searches[:i], m.searches[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memorySearches) MarkChecked(ctx context.Context, id, horizon int64) (time.Time, error) {
+	data, err := m.find(ctx, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	data.LastCheckedAt, data.CheckedHorizon = time.Now(), horizon
+	return data.LastCheckedAt, nil
+}
+
+func (m *memorySearches) MarkNotified(ctx context.Context, id, horizon int64) (time.Time, error) {
+	data, err := m.find(ctx, id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	data.LastNotifiedAt, data.NotifiedHorizon = time.Now(), horizon
+	return data.LastNotifiedAt, nil
+}
+
+func (m *memorySearches) RewindNotified(ctx context.Context, id, from, to int64) error {
+	data, err := m.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	if data.NotifiedHorizon == from {
+		data.NotifiedHorizon = to
+	}
+	return nil
+}
+
+// tenantBooks matches one book in every tenant, named after the tenant.
+type tenantBooks struct{}
+
+func (tenantBooks) ChangeHorizon(ctx context.Context) (int64, error) {
+	return 1, nil
+}
+
+func (tenantBooks) MatchingSince(ctx context.Context, query string, f book.Filter, since, until int64) ([]book.Book, error) {
+	current := tenant.From(ctx)
+	if current == nil {
+		return nil, tenant.ErrNoTenant
+	}
+	return []book.Book{{Title: "book of tenant " + current.Slug}}, nil
+}
+
+// recordingNotifier collects the saved searches it was called for.
+type recordingNotifier struct {
+	notified []int64
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, search *savedsearch.SavedSearch, books []book.Book) error {
+	n.notified = append(n.notified, search.ID)
+	return nil
+}
+
+type txKey struct{}
+
+// markingTransactor marks the context of the transactions it runs.
+type markingTransactor struct{}
+
+func (markingTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, txKey{}, true))
+}
+
+// failingNotifier fails every notification, recording whether it was sent
+// inside a transaction.
+type failingNotifier struct {
+	inTx bool
+}
+
+func (n *failingNotifier) Notify(ctx context.Context, search *savedsearch.SavedSearch, books []book.Book) error {
+	n.inTx = ctx.Value(txKey{}) != nil
+	return errors.New("mail server unavailable")
+}
+
+// roleAuthorizer authorizes every caller as role under the default policy.
+type roleAuthorizer struct {
+	role rbac.Role
+}
+
+func (a roleAuthorizer) Authorize(ctx context.Context, action rbac.Action) error {
+	if !rbac.DefaultPolicy.Allows(a.role, action) {
+		return helper.NewErrForbidden("role does not allow " + string(action))
+	}
+	return nil
+}
+
+func withTenant(id int64) context.Context {
+	return tenant.WithTenant(context.Background(), &tenant.Tenant{ID: id})
+}
+
+func TestGetAllIsScopedToTenant(t *testing.T) {
+	repo := &memorySearches{}
+	service := &SavedSearch{SavedSearchRepository: repo}
+
+	for _, id := range []int64{1, 2} {
+		if _, err := service.Create(withTenant(id), &savedsearch.SavedSearch{Name: "search", NotifyEmail: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	searches, err := service.GetAll(withTenant(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 1 || searches[0].TenantID != 2 {
+		t.Fatalf("expected only the saved search of tenant 2, got %+v", searches)
+	}
+}
+
+func TestEvaluateSpansTenants(t *testing.T) {
+	repo := &memorySearches{}
+	notifier := &recordingNotifier{}
+	service := &SavedSearch{SavedSearchRepository: repo, BookMatcher: tenantBooks{}, Notifier: notifier}
+
+	for _, id := range []int64{1, 2} {
+		if _, err := service.Create(withTenant(id), &savedsearch.SavedSearch{Name: "search"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The evaluator runs in the background, without a tenant.
+	notified, err := service.Evaluate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified != 2 || len(notifier.notified) != 2 {
+		t.Fatalf("expected the saved searches of both tenants notified, got %d %v", notified, notifier.notified)
+	}
+}
+
+func TestViewerCannotChangeSavedSearches(t *testing.T) {
+	repo := &memorySearches{searches: []savedsearch.SavedSearch{{ID: 1, TenantID: 1, Name: "search"}}}
+	service := &SavedSearch{SavedSearchRepository: repo, Authorizer: roleAuthorizer{rbac.RoleViewer}}
+	ctx := withTenant(1)
+
+	if _, err := service.Create(ctx, &savedsearch.SavedSearch{Name: "another"}); helper.StatusCode(err) != http.StatusForbidden {
+		t.Errorf("expected creating to be forbidden, got %v", err)
+	}
+	if err := service.Delete(ctx, 1); helper.StatusCode(err) != http.StatusForbidden {
+		t.Errorf("expected deleting to be forbidden, got %v", err)
+	}
+	if len(repo.searches) != 1 {
+		t.Errorf("expected saved searches untouched, got %+v", repo.searches)
+	}
+
+	if _, err := service.GetAll(ctx); err != nil {
+		t.Errorf("expected viewers to list saved searches, got %v", err)
+	}
+	if _, err := service.GetByID(ctx, 1); err != nil {
+		t.Errorf("expected viewers to get a saved search, got %v", err)
+	}
+}
+
+func TestEvaluateNotifiesAfterCommit(t *testing.T) {
+	repo := &memorySearches{searches: []savedsearch.SavedSearch{{ID: 1, TenantID: 1, Name: "search", NotifiedHorizon: 0}}}
+	notifier := &failingNotifier{}
+	service := &SavedSearch{SavedSearchRepository: repo, BookMatcher: tenantBooks{}, Transactor: markingTransactor{}, Notifier: notifier}
+
+	if _, err := service.Evaluate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if notifier.inTx {
+		t.Error("expected the notification to be sent outside the transaction")
+	}
+	if repo.searches[0].NotifiedHorizon != 0 {
+		t.Errorf("expected a failed notification to rewind the cursor, got %d", repo.searches[0].NotifiedHorizon)
+	}
+}
@@ -0,0 +1,160 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/savedsearch"
+	"byfood-interview/tenant"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const savedSearchColumns = "id, name, query, filter, notify_email, last_checked_at, last_notified_at, checked_horizon, notified_horizon, created_at, updated_at, deleted_at, tenant_id"
+
+type SavedSearch struct {
+	db *sqlx.DB
+}
+
+func NewSavedSearch(db *sqlx.DB) *SavedSearch {
+	return &SavedSearch{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *SavedSearch) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+// scoped runs fn for the tenant on ctx with row-level security limited to
+// that tenant, on the transaction or tenant connection carried on ctx when
+// there is one.
+func (s *SavedSearch) scoped(ctx context.Context, fn func(ctx context.Context, tenantID int64) error) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+	return internalDb.ForTenant(ctx, s.db, tenantID, func(ctx context.Context) error {
+		return fn(ctx, tenantID)
+	})
+}
+
+func (s *SavedSearch) Create(ctx context.Context, data *savedsearch.SavedSearch) (*savedsearch.SavedSearch, error) {
+	var created savedsearch.SavedSearch
+	err := s.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "INSERT INTO saved_searches (name, query, filter, notify_email, tenant_id) VALUES ($1, $2, $3, $4, $5) RETURNING " + savedSearchColumns
+		return s.conn(ctx).GetContext(ctx, &created, query, data.Name, data.Query, data.Filter, data.NotifyEmail, tenantID)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to insert saved search")
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (s *SavedSearch) GetByID(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	return s.get(ctx, id, "")
+}
+
+// GetByIDForUpdate is GetByID with the row locked until the surrounding
+// transaction ends. It must be called inside a transaction.
+func (s *SavedSearch) GetByIDForUpdate(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	return s.get(ctx, id, " FOR UPDATE")
+}
+
+// GetByIDForUpdateSkipLocked is GetByIDForUpdate that returns sql.ErrNoRows
+// instead of waiting when another transaction holds the row, so concurrent
+// evaluators never handle the same saved search twice.
+func (s *SavedSearch) GetByIDForUpdateSkipLocked(ctx context.Context, id int64) (*savedsearch.SavedSearch, error) {
+	return s.get(ctx, id, " FOR UPDATE SKIP LOCKED")
+}
+
+// get reads a live saved search of the tenant on ctx, locked as lock says.
+func (s *SavedSearch) get(ctx context.Context, id int64, lock string) (*savedsearch.SavedSearch, error) {
+	var data savedsearch.SavedSearch
+	err := s.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "SELECT " + savedSearchColumns + " FROM saved_searches WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL" + lock
+		return s.conn(ctx).GetContext(ctx, &data, query, id, tenantID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *SavedSearch) GetAll(ctx context.Context) ([]savedsearch.SavedSearch, error) {
+	searches := []savedsearch.SavedSearch{}
+	err := s.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "SELECT " + savedSearchColumns + " FROM saved_searches WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY id"
+		return s.conn(ctx).SelectContext(ctx, &searches, query, tenantID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return searches, nil
+}
+
+// GetAllTenants lists the live saved searches of every tenant, for the
+// background evaluator.
+func (s *SavedSearch) GetAllTenants(ctx context.Context) ([]savedsearch.SavedSearch, error) {
+	searches := []savedsearch.SavedSearch{}
+	err := internalDb.WithinAllTenants(ctx, s.db, func(ctx context.Context) error {
+		query := "SELECT " + savedSearchColumns + " FROM saved_searches WHERE deleted_at IS NULL ORDER BY id"
+		return s.conn(ctx).SelectContext(ctx, &searches, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return searches, nil
+}
+
+// Delete soft-deletes a live saved search. It returns sql.ErrNoRows when it
+// does not exist or is already deleted.
+func (s *SavedSearch) Delete(ctx context.Context, id int64) error {
+	return s.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		var deletedID int64
+		query := "UPDATE saved_searches SET deleted_at = NOW() WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL RETURNING id"
+		return s.conn(ctx).GetContext(ctx, &deletedID, query, id, tenantID)
+	})
+}
+
+// MarkChecked moves the checked cursor of a saved search to horizon, timed
+// at the start of the current transaction, and returns that time.
+func (s *SavedSearch) MarkChecked(ctx context.Context, id, horizon int64) (time.Time, error) {
+	var checkedAt time.Time
+	err := s.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "UPDATE saved_searches SET last_checked_at = NOW(), checked_horizon = $3 WHERE id = $1 AND tenant_id = $2 RETURNING last_checked_at"
+		return s.conn(ctx).GetContext(ctx, &checkedAt, query, id, tenantID, horizon)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to mark saved search checked")
+		return time.Time{}, err
+	}
+	return checkedAt, nil
+}
+
+// RewindNotified moves the notified cursor of a saved search back from
+// horizon from to horizon to, unless it has moved since.
+func (s *SavedSearch) RewindNotified(ctx context.Context, id, from, to int64) error {
+	return s.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "UPDATE saved_searches SET notified_horizon = $4 WHERE id = $1 AND tenant_id = $2 AND notified_horizon = $3"
+		_, err := s.conn(ctx).ExecContext(ctx, query, id, tenantID, from, to)
+		return err
+	})
+}
+
+// MarkNotified moves the notified cursor of a saved search to horizon, timed
+// at the start of the current transaction, and returns that time.
+func (s *SavedSearch) MarkNotified(ctx context.Context, id, horizon int64) (time.Time, error) {
+	var notifiedAt time.Time
+	err := s.scoped(ctx, func(ctx context.Context, tenantID int64) error {
+		query := "UPDATE saved_searches SET last_notified_at = NOW(), notified_horizon = $3 WHERE id = $1 AND tenant_id = $2 RETURNING last_notified_at"
+		return s.conn(ctx).GetContext(ctx, &notifiedAt, query, id, tenantID, horizon)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to mark saved search notified")
+		return time.Time{}, err
+	}
+	return notifiedAt, nil
+}
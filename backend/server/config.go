@@ -0,0 +1,290 @@
+package server
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/book/services"
+	"byfood-interview/oidc"
+	"byfood-interview/ratelimit"
+	rateLimitServices "byfood-interview/ratelimit/services"
+	rateLimitStores "byfood-interview/ratelimit/stores"
+	"byfood-interview/rbac"
+	"byfood-interview/savedsearch/notify"
+	savedSearchServices "byfood-interview/savedsearch/services"
+	"byfood-interview/user"
+	"byfood-interview/user/mail"
+	userServices "byfood-interview/user/services"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// envFloat reads a float from the environment, returning 0 when it is unset
+// or invalid so callers fall back to their defaults.
+func envFloat(key string) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("invalid float in environment, using default")
+		return 0
+	}
+	return f
+}
+
+// envBool reads a boolean such as "true" or "1" from the environment,
+// returning false when it is unset or invalid.
+func envBool(key string) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("invalid boolean in environment, using default")
+		return false
+	}
+	return b
+}
+
+// envInt reads an integer from the environment, returning fallback when it
+// is unset or invalid.
+func envInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		log.Warn().Str("key", key).Str("value", v).Msg("invalid integer in environment, using default")
+		return fallback
+	}
+	return i
+}
+
+// envList reads a comma-separated list from the environment, skipping
+// empty items.
+func envList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+const (
+	defaultSimilarIndexRefreshInterval   = 10 * time.Minute
+	defaultSavedSearchEvaluationInterval = 5 * time.Minute
+	defaultStatsRefreshInterval          = 15 * time.Minute
+	defaultIdempotencyPurgeInterval      = time.Hour
+	defaultAuthClockSkew                 = 30 * time.Second
+	defaultAPIKeyUsageFlushInterval      = time.Minute
+	defaultSessionPurgeInterval          = time.Hour
+	defaultTenantClaim                   = "tenant"
+	defaultRateLimitPurgeInterval        = 10 * time.Minute
+	defaultUsageFlushInterval            = time.Minute
+)
+
+// envDuration reads a Go duration such as "5m" from the environment,
+// returning fallback when it is unset or invalid.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Warn().Str("key", key).Str("value", v).Msg("invalid duration in environment, using default")
+		return fallback
+	}
+	return d
+}
+
+// tenantClaim is the bearer token claim naming the tenant a token is bound
+// to, TENANT_CLAIM or "tenant". Set it to "-" to bind no token.
+func tenantClaim() string {
+	switch claim := os.Getenv("TENANT_CLAIM"); claim {
+	case "":
+		return defaultTenantClaim
+	case "-":
+		return ""
+	default:
+		return claim
+	}
+}
+
+// rateLimitService applies the rules in RATE_LIMITS with buckets kept where
+// RATE_LIMIT_STORE says: in memory, the default, or in Postgres to share
+// them between replicas. It returns nil when there are no rules.
+func rateLimitService(db *sqlx.DB) *rateLimitServices.RateLimit {
+	rules, err := ratelimit.ParseRules(envList("RATE_LIMITS"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("RATE_LIMITS must be a list of [METHOD ]ROUTE=LIMIT/PERIOD[:BURST] rules")
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	service := &rateLimitServices.RateLimit{Rules: rules}
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		service.BucketRepository = rateLimitStores.NewMemory()
+	case "postgres":
+		service.BucketRepository = rateLimitStores.NewBucket(db)
+	default:
+		log.Fatal().Str("value", store).Msg("RATE_LIMIT_STORE must be memory or postgres")
+	}
+	return service
+}
+
+func searchConfig() services.SearchConfig {
+	return services.SearchConfig{
+		MatchThreshold:      envFloat("SEARCH_MATCH_THRESHOLD"),
+		SuggestionThreshold: envFloat("SEARCH_SUGGESTION_THRESHOLD"),
+	}
+}
+
+// savedSearchNotifier mails saved search matches through SMTP_ADDR when it
+// is set and logs them otherwise.
+func savedSearchNotifier() savedSearchServices.Notifier {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return notify.Log{}
+	}
+
+	return &notify.SMTP{Addr: addr, From: os.Getenv("SMTP_FROM"), Auth: smtpAuth(addr)}
+}
+
+// passwordResetMailer mails password reset tokens through SMTP_ADDR when it
+// is set and logs them otherwise.
+func passwordResetMailer() userServices.Mailer {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return mail.Log{}
+	}
+	return &mail.SMTP{
+		Addr:     addr,
+		From:     os.Getenv("SMTP_FROM"),
+		Auth:     smtpAuth(addr),
+		ResetURL: os.Getenv("PASSWORD_RESET_URL"),
+	}
+}
+
+// smtpAuth returns the credentials for the mail server at addr from
+// SMTP_USERNAME and SMTP_PASSWORD, or nil when there are none.
+func smtpAuth(addr string) smtp.Auth {
+	username := os.Getenv("SMTP_USERNAME")
+	if username == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+}
+
+// passwordHasher hashes passwords with PASSWORD_HASH, argon2id or bcrypt.
+func passwordHasher() user.Hasher {
+	switch alg := os.Getenv("PASSWORD_HASH"); alg {
+	case "", user.AlgArgon2id:
+		return user.Hasher{Algorithm: user.AlgArgon2id}
+	case user.AlgBcrypt:
+		return user.Hasher{Algorithm: user.AlgBcrypt}
+	default:
+		log.Fatal().Str("value", alg).Msg("PASSWORD_HASH must be argon2id or bcrypt")
+		return user.Hasher{}
+	}
+}
+
+// authVerifier builds the bearer token verifier from AUTH_HS256_SECRET,
+// AUTH_PUBLIC_KEY_FILE and AUTH_JWKS_URL, which may be combined. It returns
+// nil when none is set, leaving the API open.
+func authVerifier() *auth.Verifier {
+	var sources auth.MultiSource
+
+	var static auth.StaticKeys
+	if secret := os.Getenv("AUTH_HS256_SECRET"); secret != "" {
+		if len(secret) < 32 {
+			log.Fatal().Msg("AUTH_HS256_SECRET must be at least 32 bytes")
+		}
+		static = append(static, auth.Key{Alg: auth.AlgHS256, Key: []byte(secret)})
+	}
+	if path := os.Getenv("AUTH_PUBLIC_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to read AUTH_PUBLIC_KEY_FILE")
+		}
+		keys, err := auth.ParsePublicKeys(data)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to parse AUTH_PUBLIC_KEY_FILE")
+		}
+		static = append(static, keys...)
+	}
+	if len(static) > 0 {
+		sources = append(sources, static)
+	}
+
+	if location := os.Getenv("AUTH_JWKS_URL"); location != "" {
+		jwks := auth.NewJWKS(location)
+		jwks.RefreshInterval = envDuration("AUTH_JWKS_REFRESH_INTERVAL", auth.DefaultJWKSRefreshInterval)
+		sources = append(sources, jwks)
+	}
+
+	if len(sources) == 0 {
+		log.Warn().Msg("no authentication keys configured, every endpoint is public")
+		return nil
+	}
+	return &auth.Verifier{
+		Keys:     sources,
+		Issuer:   os.Getenv("AUTH_ISSUER"),
+		Audience: os.Getenv("AUTH_AUDIENCE"),
+		Leeway:   envDuration("AUTH_CLOCK_SKEW", defaultAuthClockSkew),
+	}
+}
+
+// oidcClient builds the OpenID Connect client from OIDC_ISSUER,
+// OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, OIDC_REDIRECT_URL and OIDC_SCOPES. It
+// returns nil when OIDC_ISSUER is unset, leaving single sign-on off.
+func oidcClient() *oidc.Client {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	client := &oidc.Client{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		Leeway:       envDuration("AUTH_CLOCK_SKEW", defaultAuthClockSkew),
+	}
+	if client.ClientID == "" || client.RedirectURL == "" {
+		log.Fatal().Msg("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER")
+	}
+	return client
+}
+
+// oidcRoleMap reads OIDC_ROLE_MAP, a list of group=role pairs such as
+// "library-admins=admin,librarians=editor".
+func oidcRoleMap() map[string]rbac.Role {
+	roles := map[string]rbac.Role{}
+	for _, pair := range envList("OIDC_ROLE_MAP") {
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || !rbac.Role(role).Valid() {
+			log.Fatal().Str("value", pair).Msg("OIDC_ROLE_MAP must be a list of group=role pairs with roles viewer, editor or admin")
+		}
+		roles[group] = rbac.Role(role)
+	}
+	return roles
+}
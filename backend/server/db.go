@@ -3,6 +3,7 @@ package server
 import (
 	internalDb "byfood-interview/internal/db"
 	"byfood-interview/migration"
+	"context"
 	"os"
 
 	"github.com/jmoiron/sqlx"
@@ -23,6 +24,16 @@ func db(migrationPath string) *sqlx.DB {
 		log.Fatal().Err(err).Msg("failed to connect to database")
 	}
 
+	// Tenant isolation rests on row-level security, which a superuser or
+	// BYPASSRLS role would silently skip.
+	bypasses, err := internalDb.BypassesRowSecurity(context.Background(), db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to check database role")
+	}
+	if bypasses {
+		log.Fatal().Str("user", config.User).Msg("database user bypasses row-level security; connect as a role that is neither a superuser nor BYPASSRLS")
+	}
+
 	migration := migration.NewMigration(db)
 	if err := migration.Run(migrationPath); err != nil {
 		log.Fatal().Err(err).Msg("failed to run migrations")
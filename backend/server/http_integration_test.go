@@ -1,30 +1,46 @@
 package server
 
 import (
+	"byfood-interview/apikey"
 	"byfood-interview/book"
 	"byfood-interview/helper"
+	"byfood-interview/oidc"
+	"byfood-interview/oidc/oidctest"
+	"byfood-interview/rbac"
+	"byfood-interview/savedsearch"
+	"byfood-interview/tenant"
+	"byfood-interview/usage"
+	"byfood-interview/user"
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 type HTTPTestSuite struct {
 	server    *Server
 	container testcontainers.Container
-	db        *sqlx.DB
+	// db connects as the superuser, past row-level security, to inspect
+	// what the server stored.
+	db *sqlx.DB
 }
 
 func setupHTTPTestSuite(t *testing.T) *HTTPTestSuite {
@@ -36,8 +52,8 @@ func setupHTTPTestSuite(t *testing.T) *HTTPTestSuite {
 		ExposedPorts: []string{"5432/tcp"},
 		Env: map[string]string{
 			"POSTGRES_DB":       "book_test",
-			"POSTGRES_USER":     "test_user",
-			"POSTGRES_PASSWORD": "test_password",
+			"POSTGRES_USER":     "postgres",
+			"POSTGRES_PASSWORD": "postgres",
 		},
 		WaitingFor: wait.ForLog("database system is ready to accept connections").
 			WithOccurrence(2).
@@ -58,6 +74,22 @@ func setupHTTPTestSuite(t *testing.T) *HTTPTestSuite {
 	port, err := container.MappedPort(ctx, "5432")
 	require.NoError(t, err)
 
+	// Wait a bit more for database to be fully ready
+	time.Sleep(5 * time.Second) // Adjust as necessary for your environment
+
+	// The server connects as an ordinary role owning the database, as it
+	// must for row-level security to bind it.
+	admin, err := sqlx.Connect("postgres", fmt.Sprintf("postgres://postgres:postgres@%s:%s/book_test?sslmode=disable", host, port.Port()))
+	require.NoError(t, err)
+	for _, statement := range []string{
+		"CREATE ROLE test_user LOGIN PASSWORD 'test_password' NOSUPERUSER NOBYPASSRLS",
+		"ALTER DATABASE book_test OWNER TO test_user",
+		"ALTER SCHEMA public OWNER TO test_user",
+	} {
+		_, err := admin.Exec(statement)
+		require.NoError(t, err)
+	}
+
 	// Set environment variables for database connection
 	os.Setenv("DB_HOST", host)
 	os.Setenv("DB_PORT", port.Port())
@@ -65,20 +97,21 @@ func setupHTTPTestSuite(t *testing.T) *HTTPTestSuite {
 	os.Setenv("DB_PASSWORD", "test_password")
 	os.Setenv("DB_NAME", "book_test")
 
-	// Wait a bit more for database to be fully ready
-	time.Sleep(5 * time.Second) // Adjust as necessary for your environment
 	// Create server instance
 	server := NewServer("../migration/file")
 
 	return &HTTPTestSuite{
 		server:    server,
 		container: container,
-		db:        server.DB,
+		db:        admin,
 	}
 }
 
 func (suite *HTTPTestSuite) tearDown(t *testing.T) {
 	ctx := context.Background()
+	if suite.server != nil {
+		suite.server.DB.Close()
+	}
 	if suite.db != nil {
 		suite.db.Close()
 	}
@@ -450,6 +483,1996 @@ func TestDeleteBook(t *testing.T) {
 	}
 }
 
+func TestMergeBook(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	createBook := func(b book.Book) float64 {
+		jsonBody, err := json.Marshal(b)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(jsonBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response.Data.(map[string]interface{})["id"].(float64)
+	}
+
+	targetID := createBook(book.Book{Title: "Sushi at Home", Author: "Aiko Mori", PublishedYear: 2019})
+	sourceID := createBook(book.Book{Title: "Sushi at home", Author: "Aiko Mori", PublishedYear: 2020})
+	target := strconv.FormatFloat(targetID, 'f', 0, 64)
+	source := strconv.FormatFloat(sourceID, 'f', 0, 64)
+
+	// The pair shows up as a duplicate candidate
+	req, err := http.NewRequest("GET", "/api/v1/books/duplicates", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response helper.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.Data)
+
+	tests := []struct {
+		name           string
+		bookID         string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "Merge into itself",
+			bookID:         target,
+			body:           `{"source_id": ` + target + `}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown keep field",
+			bookID:         target,
+			body:           `{"source_id": ` + source + `, "keep": {"isbn": "source"}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing source",
+			bookID:         target,
+			body:           `{"source_id": 999999}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Valid merge",
+			bookID:         target,
+			body:           `{"source_id": ` + source + `, "keep": {"published_year": "source"}}`,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/v1/books/"+tt.bookID+"/merge", bytes.NewBufferString(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			suite.server.Router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response helper.Response
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+				survivor := response.Data.(map[string]interface{})
+				assert.Equal(t, targetID, survivor["id"])
+				assert.Equal(t, "Sushi at Home", survivor["title"])
+				assert.Equal(t, float64(2020), survivor["published_year"])
+			}
+		})
+	}
+
+	// The merged book redirects to the survivor, keeping the query
+	req, err = http.NewRequest("GET", "/api/v1/books/"+source+"?fields=title", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Equal(t, "/api/v1/books/"+target+"?fields=title", rr.Header().Get("Location"))
+}
+
+func TestSearchBooks(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	jsonBody, err := json.Marshal(book.Book{Title: "Norwegian Wood", Author: "Haruki Murakami", PublishedYear: 1987})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(jsonBody))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectResults  bool
+	}{
+		{
+			name:           "Misspelled author",
+			query:          "q=Murakmi",
+			expectedStatus: http.StatusOK,
+			expectResults:  true,
+		},
+		{
+			name:           "Missing query",
+			query:          "",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid limit",
+			query:          "q=wood&limit=abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/api/v1/books/search?"+tt.query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			suite.server.Router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response helper.Response
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+				result := response.Data.(map[string]interface{})
+				assert.Equal(t, tt.expectResults, len(result["results"].([]interface{})) > 0)
+			}
+		})
+	}
+}
+
+func TestSuggestBooks(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	jsonBody, err := json.Marshal(book.Book{Title: "Kitchen Confidential", Author: "Anthony Bourdain", PublishedYear: 2000})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(jsonBody))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedTerm   string
+	}{
+		{
+			name:           "Title prefix",
+			query:          "prefix=kitch",
+			expectedStatus: http.StatusOK,
+			expectedTerm:   "Kitchen Confidential",
+		},
+		{
+			name:           "Author word prefix",
+			query:          "prefix=bour&field=author",
+			expectedStatus: http.StatusOK,
+			expectedTerm:   "Anthony Bourdain",
+		},
+		{
+			name:           "Unknown field",
+			query:          "prefix=kitch&field=isbn",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Missing prefix",
+			query:          "field=title",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/api/v1/books/suggest?"+tt.query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			suite.server.Router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response helper.Response
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+				suggestions := response.Data.([]interface{})
+				require.NotEmpty(t, suggestions)
+				assert.Equal(t, tt.expectedTerm, suggestions[0].(map[string]interface{})["term"])
+			}
+		})
+	}
+}
+
+func TestGetAllBooksWithFacets(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	testBooks := []book.Book{
+		{Title: "Book 1", Author: "Author 1", PublishedYear: 1999},
+		{Title: "Book 2", Author: "Author 1", PublishedYear: 2001},
+		{Title: "Book 3", Author: "Author 2", PublishedYear: 2005},
+	}
+	for _, b := range testBooks {
+		jsonBody, err := json.Marshal(b)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(jsonBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBooks  int
+	}{
+		{
+			name:           "Filter by decade with facets",
+			query:          "decade=2000&facets=author,decade",
+			expectedStatus: http.StatusOK,
+			expectedBooks:  2,
+		},
+		{
+			name:           "Filter by several authors",
+			query:          "author=Author+1&author=Author+2",
+			expectedStatus: http.StatusOK,
+			expectedBooks:  3,
+		},
+		{
+			name:           "Unknown facet",
+			query:          "facets=isbn",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid decade",
+			query:          "decade=1995",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "/api/v1/books?"+tt.query, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			suite.server.Router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response helper.Response
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+				assert.Len(t, response.Data.([]interface{}), tt.expectedBooks)
+			}
+		})
+	}
+
+	req, err := http.NewRequest("GET", "/api/v1/books?decade=2000&facets=decade", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response helper.Response
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	facets := response.Meta.(map[string]interface{})["facets"].(map[string]interface{})
+	assert.Len(t, facets["decade"], 2)
+}
+
+func TestGetSimilarBooks(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	testBooks := []book.Book{
+		{Title: "Ramen Noodle Soup", Author: "Aiko Mori", PublishedYear: 2010},
+		{Title: "Ramen Noodle History", Author: "Botan Sato", PublishedYear: 2015},
+		{Title: "Ramen Noodle Guide", Author: "Chie Ito", PublishedYear: 1990},
+		{Title: "Sushi Basics", Author: "Daichi Ueda", PublishedYear: 2012},
+	}
+	var ids []string
+	for _, b := range testBooks {
+		jsonBody, err := json.Marshal(b)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(jsonBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		ids = append(ids, strconv.FormatFloat(response.Data.(map[string]interface{})["id"].(float64), 'f', 0, 64))
+	}
+
+	// Books created after startup are picked up on the next rebuild
+	require.NoError(t, suite.server.bookService.RebuildSimilarIndex(context.Background()))
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedTitles []string
+	}{
+		{
+			name:           "Similar titles",
+			url:            "/api/v1/books/" + ids[0] + "/similar",
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"Ramen Noodle History", "Ramen Noodle Guide"},
+		},
+		{
+			name:           "Year range",
+			url:            "/api/v1/books/" + ids[0] + "/similar?year_from=2000&year_to=2020",
+			expectedStatus: http.StatusOK,
+			expectedTitles: []string{"Ramen Noodle History"},
+		},
+		{
+			name:           "Inverted year range",
+			url:            "/api/v1/books/" + ids[0] + "/similar?year_from=2020&year_to=2000",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown book",
+			url:            "/api/v1/books/999999/similar",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			require.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			suite.server.Router.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if tt.expectedStatus == http.StatusOK {
+				var response helper.Response
+				require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+
+				var titles []string
+				for _, b := range response.Data.([]interface{}) {
+					titles = append(titles, b.(map[string]interface{})["title"].(string))
+				}
+				assert.ElementsMatch(t, tt.expectedTitles, titles)
+			}
+		})
+	}
+}
+
+func TestGetBookStats(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	var ids []string
+	for _, b := range []book.Book{
+		{Title: "Norwegian Wood", Author: "Haruki Murakami", PublishedYear: 1987},
+		{Title: "Kafka on the Shore", Author: "Haruki Murakami", PublishedYear: 2002},
+		{Title: "1Q84", Author: "Haruki Murakami", PublishedYear: 2009},
+		{Title: "Kitchen", Author: "Banana Yoshimoto", PublishedYear: 1988},
+		{Title: "Coin Locker Babies", Author: "Ryu Murakami", PublishedYear: 1980},
+	} {
+		jsonBody, err := json.Marshal(b)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest("POST", "/api/v1/books", bytes.NewBuffer(jsonBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		ids = append(ids, strconv.FormatFloat(response.Data.(map[string]interface{})["id"].(float64), 'f', 0, 64))
+	}
+
+	req, err := http.NewRequest("DELETE", "/api/v1/books/"+ids[4], nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	getStats := func(target string) (int, *book.Stats) {
+		req, err := http.NewRequest("GET", target, nil)
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			return rr.Code, nil
+		}
+
+		var response struct {
+			Data book.Stats `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return rr.Code, &response.Data
+	}
+
+	assertStats := func(t *testing.T, stats *book.Stats) {
+		assert.Equal(t, int64(4), stats.Total)
+		assert.Equal(t, int64(1), stats.Deleted)
+		assert.Equal(t, []book.YearCount{{Year: 1987, Count: 1}, {Year: 1988, Count: 1}, {Year: 2002, Count: 1}, {Year: 2009, Count: 1}}, stats.ByPublishedYear)
+		assert.Equal(t, []book.DecadeCount{{Decade: 1980, Count: 2}, {Decade: 2000, Count: 2}}, stats.ByDecade)
+		assert.Equal(t, []book.AuthorCount{{Author: "Haruki Murakami", Count: 3}}, stats.TopAuthors)
+
+		require.Len(t, stats.Timeline, 7)
+		today := stats.Timeline[6]
+		assert.Equal(t, time.Now().UTC().Format(time.DateOnly), today.Period.Format(time.DateOnly))
+		assert.Equal(t, int64(5), today.Added)
+		assert.Equal(t, int64(1), today.Deleted)
+		for _, bucket := range stats.Timeline[:6] {
+			assert.Zero(t, bucket.Added+bucket.Deleted)
+		}
+	}
+
+	from := time.Now().UTC().AddDate(0, 0, -6).Format(time.DateOnly)
+	statsURL := "/api/v1/stats/books?interval=day&top_authors=1&from=" + from
+
+	code, stats := getStats(statsURL)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, book.StatsSourceLive, stats.Source)
+	assertStats(t, stats)
+
+	t.Run("Materialized views", func(t *testing.T) {
+		suite.server.bookService.MaterializedStats = true
+		defer func() { suite.server.bookService.MaterializedStats = false }()
+
+		_, err := suite.server.bookService.BookRepository.RefreshStats(context.Background())
+		require.NoError(t, err)
+
+		code, stats := getStats(statsURL)
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, book.StatsSourceMaterialized, stats.Source)
+		assertStats(t, stats)
+	})
+
+	t.Run("Monthly default", func(t *testing.T) {
+		code, stats := getStats("/api/v1/stats/books")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, book.StatsIntervalMonth, stats.Interval)
+		assert.Len(t, stats.Timeline, 12)
+		assert.Len(t, stats.TopAuthors, 2)
+	})
+
+	for _, target := range []string{
+		"/api/v1/stats/books?interval=year",
+		"/api/v1/stats/books?from=yesterday",
+		"/api/v1/stats/books?from=2024-02-01&to=2024-01-01",
+		"/api/v1/stats/books?interval=day&from=2000-01-01&to=2024-01-01",
+		"/api/v1/stats/books?top_authors=1000",
+	} {
+		code, _ := getStats(target)
+		assert.Equal(t, http.StatusBadRequest, code, target)
+	}
+}
+
+func TestGetBookChanges(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, url string, body interface{}) *httptest.ResponseRecorder {
+		var reqBody bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+		}
+		req, err := http.NewRequest(method, url, &reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	sync := func(token string, limit int) book.ChangeSet {
+		rr := do("GET", fmt.Sprintf("/api/v1/books/changes?since=%s&limit=%d", token, limit), nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var response struct {
+			Data book.ChangeSet `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return response.Data
+	}
+
+	var ids []int64
+	for _, b := range []book.Book{
+		{Title: "Norwegian Wood", Author: "Haruki Murakami", PublishedYear: 1987},
+		{Title: "Kitchen", Author: "Banana Yoshimoto", PublishedYear: 1988},
+		{Title: "Coin Locker Babies", Author: "Ryu Murakami", PublishedYear: 1980},
+	} {
+		rr := do("POST", "/api/v1/books", b)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		ids = append(ids, int64(response.Data.(map[string]interface{})["id"].(float64)))
+	}
+	require.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/api/v1/books/%d", ids[2]), nil).Code)
+
+	// A full sync pages through the live books only.
+	page := sync("", 1)
+	require.True(t, page.HasMore)
+	require.Len(t, page.Changes, 1)
+	assert.Equal(t, ids[0], page.Changes[0].ID)
+
+	page = sync(page.NextToken, 1)
+	require.True(t, page.HasMore)
+	require.Len(t, page.Changes, 1)
+	assert.Equal(t, ids[1], page.Changes[0].ID)
+	assert.Equal(t, "Kitchen", page.Changes[0].Book.Title)
+
+	page = sync(page.NextToken, 1)
+	assert.False(t, page.HasMore)
+	assert.Empty(t, page.Changes)
+	token := page.NextToken
+
+	assert.Empty(t, sync(token, 10).Changes, "nothing changed since the last sync")
+
+	require.Equal(t, http.StatusOK, do("PUT", fmt.Sprintf("/api/v1/books/%d", ids[0]), book.Book{Title: "Norwegian Wood (Vintage)"}).Code)
+	require.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/api/v1/books/%d", ids[1]), nil).Code)
+
+	page = sync(token, 10)
+	require.Len(t, page.Changes, 2)
+	assert.Equal(t, ids[0], page.Changes[0].ID)
+	assert.False(t, page.Changes[0].Deleted)
+	assert.Equal(t, "Norwegian Wood (Vintage)", page.Changes[0].Book.Title)
+	assert.Equal(t, ids[1], page.Changes[1].ID)
+	assert.True(t, page.Changes[1].Deleted, "deletes are tombstones")
+	assert.Nil(t, page.Changes[1].Book)
+	token = page.NextToken
+
+	// A change committed after a sync read past it is still delivered.
+	tx, err := suite.db.Beginx()
+	require.NoError(t, err)
+	var lateID int64
+	require.NoError(t, tx.Get(&lateID, "INSERT INTO books (title, author, published_year) VALUES ('Kafka on the Shore', 'Haruki Murakami', 2002) RETURNING id"))
+
+	require.Equal(t, http.StatusOK, do("POST", "/api/v1/books", book.Book{Title: "1Q84", Author: "Haruki Murakami", PublishedYear: 2009}).Code)
+	page = sync(token, 10)
+	require.Len(t, page.Changes, 1)
+	assert.Equal(t, "1Q84", page.Changes[0].Book.Title)
+
+	require.NoError(t, tx.Commit())
+	page = sync(page.NextToken, 10)
+	var synced []int64
+	for _, change := range page.Changes {
+		synced = append(synced, change.ID)
+	}
+	assert.Contains(t, synced, lateID)
+
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/books/changes?since=garbage", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", "/api/v1/books/changes?limit=5000", nil).Code)
+}
+
+func TestBookTimestampsAndConditionalGet(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, url string, body interface{}, header http.Header) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var reqBody bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+		}
+		req, err := http.NewRequest(method, url, &reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+
+		var response helper.Response
+		if rr.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		}
+		data, _ := response.Data.(map[string]interface{})
+		return rr, data
+	}
+	parse := func(v interface{}) time.Time {
+		ts, err := time.Parse(time.RFC3339, v.(string))
+		require.NoError(t, err)
+		return ts
+	}
+
+	rr, created := do("POST", "/api/v1/books", book.Book{Title: "Timestamped", Author: "Clock Author", PublishedYear: 2001}, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	id := strconv.FormatFloat(created["id"].(float64), 'f', 0, 64)
+	createdAt := parse(created["created_at"])
+	assert.Equal(t, createdAt, parse(created["updated_at"]))
+	assert.NotContains(t, created, "deleted_at")
+
+	rr, _ = do("GET", "/api/v1/books/"+id, nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	lastModified := rr.Header().Get("Last-Modified")
+	require.NotEmpty(t, lastModified)
+
+	rr, _ = do("GET", "/api/v1/books/"+id, nil, http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.String())
+
+	// Last-Modified has second precision.
+	time.Sleep(1100 * time.Millisecond)
+	rr, updated := do("PUT", "/api/v1/books/"+id, book.Book{Title: "Timestamped Again"}, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	updatedAt := parse(updated["updated_at"])
+	assert.True(t, updatedAt.After(createdAt), "update returns the new updated_at")
+	assert.Equal(t, createdAt, parse(updated["created_at"]))
+
+	rr, fetched := do("GET", "/api/v1/books/"+id, nil, http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Timestamped Again", fetched["title"])
+	assert.NotEqual(t, lastModified, rr.Header().Get("Last-Modified"))
+
+	count := func(query string) int {
+		req, err := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, query)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		books, _ := response.Data.([]interface{})
+		return len(books)
+	}
+	format := func(ts time.Time) string { return url.QueryEscape(ts.Format(time.RFC3339Nano)) }
+
+	assert.Equal(t, 1, count("updated_after="+format(createdAt)))
+	assert.Equal(t, 0, count("updated_after="+format(updatedAt)))
+	assert.Equal(t, 0, count("updated_before="+format(updatedAt)))
+	assert.Equal(t, 1, count("created_after="+format(createdAt.Add(-time.Second))))
+	assert.Equal(t, 0, count("created_after="+format(createdAt)))
+
+	for _, query := range []string{"created_after=yesterday", "updated_after=2024-02-01&updated_before=2024-01-01"} {
+		req, err := http.NewRequest("GET", "/api/v1/books?"+query, nil)
+		require.NoError(t, err)
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
+
+func TestSparseFieldsAndBatchGet(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, url string, body interface{}) (*httptest.ResponseRecorder, helper.Response) {
+		var reqBody bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+		}
+		req, err := http.NewRequest(method, url, &reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return rr, response
+	}
+
+	var ids []int64
+	for _, b := range []book.Book{
+		{Title: "Norwegian Wood", Author: "Haruki Murakami", PublishedYear: 1987},
+		{Title: "Kitchen", Author: "Banana Yoshimoto", PublishedYear: 1988},
+		{Title: "Coin Locker Babies", Author: "Ryu Murakami", PublishedYear: 1980},
+	} {
+		rr, response := do("POST", "/api/v1/books", b)
+		require.Equal(t, http.StatusOK, rr.Code)
+		ids = append(ids, int64(response.Data.(map[string]interface{})["id"].(float64)))
+	}
+	rr, _ := do("DELETE", fmt.Sprintf("/api/v1/books/%d", ids[1]), nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	t.Run("Sparse list", func(t *testing.T) {
+		rr, response := do("GET", "/api/v1/books?fields=title", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		books := response.Data.([]interface{})
+		require.Len(t, books, 2)
+		for _, b := range books {
+			assert.ElementsMatch(t, []string{"id", "title"}, keys(b.(map[string]interface{})))
+		}
+	})
+
+	t.Run("Sparse get", func(t *testing.T) {
+		rr, response := do("GET", fmt.Sprintf("/api/v1/books/%d?fields=author,published_year", ids[0]), nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+		data := response.Data.(map[string]interface{})
+		assert.ElementsMatch(t, []string{"id", "author", "published_year"}, keys(data))
+		assert.Equal(t, "Haruki Murakami", data["author"])
+	})
+
+	t.Run("Batch get by query", func(t *testing.T) {
+		rr, response := do("GET", fmt.Sprintf("/api/v1/books?ids=%d,999999,%d,%d&fields=title", ids[2], ids[1], ids[0]), nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var titles []string
+		for _, b := range response.Data.([]interface{}) {
+			titles = append(titles, b.(map[string]interface{})["title"].(string))
+		}
+		assert.Equal(t, []string{"Coin Locker Babies", "Norwegian Wood"}, titles, "request order is kept")
+		assert.Equal(t, []interface{}{float64(999999), float64(ids[1])}, response.Meta.(map[string]interface{})["missing"])
+	})
+
+	t.Run("Batch get by body", func(t *testing.T) {
+		rr, response := do("POST", "/api/v1/books/batch", book.BatchGet{IDs: []int64{ids[2], ids[0], ids[2]}})
+		require.Equal(t, http.StatusOK, rr.Code)
+		books := response.Data.([]interface{})
+		require.Len(t, books, 2, "duplicate IDs are returned once")
+		assert.Equal(t, "Coin Locker Babies", books[0].(map[string]interface{})["title"])
+		assert.Equal(t, "Ryu Murakami", books[0].(map[string]interface{})["author"])
+		assert.Nil(t, response.Meta)
+	})
+
+	for _, tt := range []struct {
+		method string
+		url    string
+		body   interface{}
+	}{
+		{"GET", "/api/v1/books?fields=isbn", nil},
+		{"GET", fmt.Sprintf("/api/v1/books/%d?fields=isbn", ids[0]), nil},
+		{"GET", "/api/v1/books?ids=1,abc", nil},
+		{"GET", "/api/v1/books?ids=", nil},
+		{"GET", "/api/v1/books?ids=1&author=Ryu+Murakami", nil},
+		{"POST", "/api/v1/books/batch", book.BatchGet{}},
+		{"POST", "/api/v1/books/batch", book.BatchGet{IDs: []int64{ids[0]}, Fields: []string{"isbn"}}},
+	} {
+		rr, _ := do(tt.method, tt.url, tt.body)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, tt.url)
+	}
+}
+
+func keys(m map[string]interface{}) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestValidationErrors(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	req, err := http.NewRequest("POST", "/api/v1/books", strings.NewReader(`{"title": "Validated Book", "author": "Validated Author", "published_year": 2001}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var createResp struct {
+		Data book.Book `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &createResp))
+	created := createResp.Data
+
+	longTitle := strings.Repeat("a", book.MaxTextLength+1)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		expected []helper.FieldError
+	}{
+		{
+			name:   "create reports every missing field",
+			method: "POST",
+			path:   "/api/v1/books",
+			body:   `{}`,
+			expected: []helper.FieldError{
+				{Field: "title", Code: helper.CodeRequired},
+				{Field: "author", Code: helper.CodeRequired},
+				{Field: "published_year", Code: helper.CodeRequired},
+			},
+		},
+		{
+			name:     "create rejects a value of the wrong type",
+			method:   "POST",
+			path:     "/api/v1/books",
+			body:     `{"title": "T", "author": "A", "published_year": "2001"}`,
+			expected: []helper.FieldError{{Field: "published_year", Code: helper.CodeInvalidType}},
+		},
+		{
+			name:   "update checks lengths and range",
+			method: "PUT",
+			path:   fmt.Sprintf("/api/v1/books/%d", created.ID),
+			body:   fmt.Sprintf(`{"title": %q, "published_year": -1}`, longTitle),
+			expected: []helper.FieldError{
+				{Field: "published_year", Code: helper.CodeOutOfRange},
+				{Field: "title", Code: helper.CodeTooLong},
+			},
+		},
+		{
+			name:   "merge reports source and keep fields",
+			method: "POST",
+			path:   fmt.Sprintf("/api/v1/books/%d/merge", created.ID),
+			body:   `{"keep": {"title": "both", "isbn": "source"}}`,
+			expected: []helper.FieldError{
+				{Field: "source_id", Code: helper.CodeRequired},
+				{Field: "keep.isbn", Code: helper.CodeUnknown},
+				{Field: "keep.title", Code: helper.CodeInvalid},
+			},
+		},
+		{
+			name:   "list reports every bad parameter",
+			method: "GET",
+			path:   "/api/v1/books?decade=nineties&facet_limit=many",
+			expected: []helper.FieldError{
+				{Field: "decade", Code: helper.CodeInvalid},
+				{Field: "facet_limit", Code: helper.CodeInvalid},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+
+			rr := httptest.NewRecorder()
+			suite.server.Router.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusBadRequest, rr.Code, rr.Body.String())
+
+			var response helper.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			require.Len(t, response.Errors, len(tt.expected), rr.Body.String())
+			for i, want := range tt.expected {
+				assert.Equal(t, want.Field, response.Errors[i].Field)
+				assert.Equal(t, want.Code, response.Errors[i].Code)
+				assert.NotEmpty(t, response.Errors[i].Message)
+			}
+		})
+	}
+
+	t.Run("process url reports url and operation", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/api/v1/process-url", strings.NewReader(`{"operation": "shorten"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		require.Len(t, response.Errors, 2)
+		assert.Equal(t, "url", response.Errors[0].Field)
+		assert.Equal(t, helper.CodeRequired, response.Errors[0].Code)
+		assert.Equal(t, "operation", response.Errors[1].Field)
+	})
+}
+
+func TestProblemDetails(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, path, body, accept string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-Id", "test-request")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("envelope stays the default", func(t *testing.T) {
+		for _, accept := range []string{"", "*/*", "application/json", "application/json, application/problem+json;q=0.5"} {
+			rr := do("GET", "/api/v1/books/999999", "", accept)
+			require.Equal(t, http.StatusNotFound, rr.Code)
+			assert.Equal(t, "application/json", rr.Header().Get("Content-Type"), accept)
+
+			var response helper.Response
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, http.StatusNotFound, response.Code)
+		}
+	})
+
+	t.Run("not found as problem", func(t *testing.T) {
+		rr := do("GET", "/api/v1/books/999999", "", "application/problem+json, application/json")
+		require.Equal(t, http.StatusNotFound, rr.Code)
+		assert.Equal(t, helper.MediaTypeProblem, rr.Header().Get("Content-Type"))
+
+		var problem helper.Problem
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+		assert.Equal(t, "about:blank", problem.Type)
+		assert.Equal(t, "Not Found", problem.Title)
+		assert.Equal(t, http.StatusNotFound, problem.Status)
+		assert.NotEmpty(t, problem.Detail)
+		assert.Equal(t, "/api/v1/books/999999", problem.Instance)
+		assert.Equal(t, "test-request", problem.RequestID)
+	})
+
+	t.Run("validation errors as problem", func(t *testing.T) {
+		rr := do("POST", "/api/v1/books", `{}`, "application/problem+json")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, helper.MediaTypeProblem, rr.Header().Get("Content-Type"))
+
+		var problem helper.Problem
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+		assert.Equal(t, http.StatusBadRequest, problem.Status)
+		assert.Len(t, problem.Errors, 3)
+	})
+
+	t.Run("process url errors share the same path", func(t *testing.T) {
+		rr := do("POST", "/api/v1/process-url", `{"url": "ftp://example.com"}`, "application/problem+json")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, helper.MediaTypeProblem, rr.Header().Get("Content-Type"))
+
+		var problem helper.Problem
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+		assert.Equal(t, "/api/v1/process-url", problem.Instance)
+		require.NotEmpty(t, problem.Errors)
+		assert.Equal(t, "url", problem.Errors[0].Field)
+	})
+}
+
+func TestContentNegotiation(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, path string, body []byte, contentType, accept string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("create from XML and MessagePack", func(t *testing.T) {
+		rr := do("POST", "/api/v1/books", []byte(`<book><title>Dune</title><author>Frank Herbert</author><published_year>1965</published_year></book>`), "application/xml", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		packed, err := msgpack.Marshal(map[string]interface{}{"title": "Emma", "author": "Jane Austen", "published_year": 1815})
+		require.NoError(t, err)
+		rr = do("POST", "/api/v1/books", packed, "application/msgpack", "")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+		rr = do("POST", "/api/v1/books", []byte("title=Dune"), "application/x-www-form-urlencoded", "")
+		assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+	})
+
+	t.Run("update from XML", func(t *testing.T) {
+		rr := do("GET", "/api/v1/books?author=Frank%20Herbert", nil, "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var list struct {
+			Data []book.Book `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		require.Len(t, list.Data, 1)
+
+		rr = do("PUT", fmt.Sprintf("/api/v1/books/%d", list.Data[0].ID), []byte(`<book><title>Dune Messiah</title></book>`), "application/xml", "application/xml")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "application/xml; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), "<title>Dune Messiah</title>")
+	})
+
+	t.Run("list as CSV, XML and MessagePack", func(t *testing.T) {
+		rr := do("GET", "/api/v1/books?fields=title,author", nil, "", "text/csv")
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, []string{"id", "author", "title"}, records[0])
+
+		rr = do("GET", "/api/v1/books", nil, "", "application/xml")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "<author>Jane Austen</author>")
+
+		rr = do("GET", "/api/v1/books", nil, "", "application/msgpack")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var packed struct {
+			Data []map[string]interface{} `msgpack:"data"`
+		}
+		require.NoError(t, msgpack.Unmarshal(rr.Body.Bytes(), &packed))
+		assert.Len(t, packed.Data, 2)
+	})
+
+	t.Run("406 when nothing matches", func(t *testing.T) {
+		rr := do("POST", "/api/v1/books", []byte(`{"title": "Unread", "author": "Nobody", "published_year": 2000}`), "application/json", "image/png")
+		assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+
+		rr = do("GET", "/api/v1/books?author=Nobody", nil, "", "")
+		require.Equal(t, http.StatusOK, rr.Code)
+		var list struct {
+			Data []book.Book `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		assert.Empty(t, list.Data, "a rejected request must not create the book")
+
+		rr = do("GET", "/api/v1/stats/books", nil, "", "text/csv")
+		assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/api/v1/books", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	countBooks := func(author string) int {
+		var count int
+		require.NoError(t, suite.db.Get(&count, "SELECT count(*) FROM books WHERE author = $1", author))
+		return count
+	}
+
+	body := `{"title": "Retried Book", "author": "Flaky Network", "published_year": 2020}`
+
+	first := post("key-1", body)
+	require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := post("key-1", body)
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	assert.Equal(t, 1, countBooks("Flaky Network"))
+
+	reused := post("key-1", `{"title": "Other Book", "author": "Flaky Network", "published_year": 2021}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+	assert.Equal(t, 1, countBooks("Flaky Network"))
+
+	// Client errors are replayed too, so a retry cannot turn them into a
+	// different outcome.
+	invalid := post("key-2", `{}`)
+	require.Equal(t, http.StatusBadRequest, invalid.Code)
+	replayed := post("key-2", `{}`)
+	assert.Equal(t, http.StatusBadRequest, replayed.Code)
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+
+	t.Run("concurrent requests with one key create one book", func(t *testing.T) {
+		concurrentBody := `{"title": "Concurrent Book", "author": "Many Retries", "published_year": 2022}`
+		const requests = 10
+		codes := make(chan int, requests)
+		var wg sync.WaitGroup
+		for i := 0; i < requests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- post("key-3", concurrentBody).Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		for code := range codes {
+			assert.Contains(t, []int{http.StatusOK, http.StatusConflict}, code)
+		}
+		assert.Equal(t, 1, countBooks("Many Retries"))
+		assert.Equal(t, http.StatusOK, post("key-3", concurrentBody).Code)
+	})
+
+	t.Run("expired keys run again", func(t *testing.T) {
+		_, err := suite.db.Exec("UPDATE idempotency_keys SET expires_at = NOW() - INTERVAL '1 second' WHERE key = 'key-1'")
+		require.NoError(t, err)
+
+		rr := post("key-1", body)
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 2, countBooks("Flaky Network"))
+	})
+}
+
+// signedToken returns an HS256 token for subject, valid for an hour.
+func signedToken(t *testing.T, secret, issuer, subject string) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": subject,
+		"iss": issuer,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return signed
+}
+
+// doAuthorized serves a request with an optional bearer token.
+func (suite *HTTPTestSuite) doAuthorized(t *testing.T, method, path, body, bearer string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	rr := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBearerAuthentication(t *testing.T) {
+	secret := "integration-test-secret-of-32-bytes!"
+	t.Setenv("AUTH_HS256_SECRET", secret)
+	t.Setenv("AUTH_ISSUER", "https://issuer.test")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	body := `{"title": "Guarded Book", "author": "Gatekeeper", "published_year": 2020}`
+
+	// Reads stay anonymous.
+	assert.Equal(t, http.StatusOK, suite.doAuthorized(t, "GET", "/api/v1/books", "", "").Code)
+
+	anonymous := suite.doAuthorized(t, "POST", "/api/v1/books", body, "")
+	assert.Equal(t, http.StatusUnauthorized, anonymous.Code)
+	assert.Equal(t, "Bearer", anonymous.Header().Get("WWW-Authenticate"))
+
+	wrongIssuer := suite.doAuthorized(t, "POST", "/api/v1/books", body, signedToken(t, secret, "https://other.test", "user-1"))
+	assert.Equal(t, http.StatusUnauthorized, wrongIssuer.Code)
+	assert.Contains(t, wrongIssuer.Header().Get("WWW-Authenticate"), "invalid_token")
+
+	// A bad token is refused even where none is needed.
+	assert.Equal(t, http.StatusUnauthorized, suite.doAuthorized(t, "GET", "/api/v1/books", "", "forged").Code)
+
+	valid := suite.doAuthorized(t, "GET", "/api/v1/books", "", signedToken(t, secret, "https://issuer.test", "user-1"))
+	assert.Equal(t, http.StatusOK, valid.Code, valid.Body.String())
+}
+
+func TestRoleBasedAccess(t *testing.T) {
+	secret := "integration-test-secret-of-32-bytes!"
+	t.Setenv("AUTH_HS256_SECRET", secret)
+	t.Setenv("AUTH_ADMIN_SUBJECTS", "root")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	admin := signedToken(t, secret, "", "root")
+	editor := signedToken(t, secret, "", "editor-1")
+	viewer := signedToken(t, secret, "", "viewer-1")
+	do := func(method, path, body, bearer string) *httptest.ResponseRecorder {
+		return suite.doAuthorized(t, method, path, body, bearer)
+	}
+
+	body := `{"title": "Curated Book", "author": "Librarian", "published_year": 2020}`
+
+	// Principals without a role are viewers: they may read but not write.
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/books", "", viewer).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/books", body, viewer).Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/saved-searches", "", viewer).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/saved-searches", `{"name": "Curated", "query": "curated"}`, viewer).Code)
+	assert.Equal(t, http.StatusForbidden, do("PUT", "/api/v1/roles/editor-1", `{"role": "editor"}`, editor).Code)
+
+	invalid := do("PUT", "/api/v1/roles/editor-1", `{"role": "owner"}`, admin)
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	assigned := do("PUT", "/api/v1/roles/editor-1", `{"role": "editor"}`, admin)
+	require.Equal(t, http.StatusOK, assigned.Code, assigned.Body.String())
+
+	created := do("POST", "/api/v1/books", body, editor)
+	require.Equal(t, http.StatusOK, created.Code, created.Body.String())
+	var resp struct {
+		Data book.Book `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(created.Body.Bytes(), &resp))
+	path := fmt.Sprintf("/api/v1/books/%d", resp.Data.ID)
+
+	updated := do("PUT", path, `{"title": "Curated Book, Revised", "author": "Librarian", "published_year": 2021}`, editor)
+	assert.Equal(t, http.StatusOK, updated.Code, updated.Body.String())
+
+	// Only admins remove books.
+	assert.Equal(t, http.StatusForbidden, do("DELETE", path, "", editor).Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", path, "", admin).Code)
+
+	list := do("GET", "/api/v1/roles", "", admin)
+	require.Equal(t, http.StatusOK, list.Code)
+	var roles struct {
+		Data []rbac.Assignment `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(list.Body.Bytes(), &roles))
+	require.Len(t, roles.Data, 1)
+	assert.Equal(t, rbac.RoleEditor, roles.Data[0].Role)
+	assert.Equal(t, "root", roles.Data[0].AssignedBy)
+
+	// Revoking the role makes the editor a viewer again.
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v1/roles/editor-1", "", admin).Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v1/roles/editor-1", "", admin).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/books", body, editor).Code)
+}
+
+func TestOpenAccess(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		return suite.doAuthorized(t, method, path, body, "")
+	}
+
+	// No one can sign in, so anonymous callers keep full access to books.
+	created := do("POST", "/api/v1/books", `{"title": "Open Book", "author": "Anyone", "published_year": 2020}`)
+	require.Equal(t, http.StatusOK, created.Code, created.Body.String())
+	var resp struct {
+		Data book.Book `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(created.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/api/v1/books/%d", resp.Data.ID), "").Code)
+
+	// But administration stays closed to them.
+	for _, route := range []struct{ method, path, body string }{
+		{"GET", "/api/v1/roles", ""},
+		{"PUT", "/api/v1/roles/anyone", `{"role": "admin"}`},
+		{"POST", "/api/v1/api-keys", `{"name": "anyone", "scopes": ["books:write"]}`},
+		{"GET", "/api/v1/api-keys", ""},
+		{"POST", "/api/v1/tenants", `{"slug": "anyone", "name": "Anyone"}`},
+		{"GET", "/api/v1/tenants", ""},
+		{"GET", "/api/v1/usage", ""},
+		{"PUT", "/api/v1/usage/quotas/anonymous", `{"limit_requests": 1}`},
+	} {
+		assert.Equal(t, http.StatusUnauthorized, do(route.method, route.path, route.body).Code, "%s %s", route.method, route.path)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	secret := "integration-test-secret-of-32-bytes!"
+	t.Setenv("AUTH_HS256_SECRET", secret)
+	t.Setenv("AUTH_ADMIN_SUBJECTS", "root")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	admin := signedToken(t, secret, "", "root")
+	withKey := func(method, path, body, header, value string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, value)
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	var resp struct {
+		Data apikey.APIKey `json:"data"`
+	}
+
+	// Only admins manage keys, and never with a key.
+	assert.Equal(t, http.StatusUnauthorized, suite.doAuthorized(t, "POST", "/api/v1/api-keys", `{"name": "partner", "scopes": ["books:read"]}`, "").Code)
+	assert.Equal(t, http.StatusForbidden, suite.doAuthorized(t, "POST", "/api/v1/api-keys", `{"name": "partner", "scopes": ["books:read"]}`, signedToken(t, secret, "", "viewer-1")).Code)
+	assert.Equal(t, http.StatusBadRequest, suite.doAuthorized(t, "POST", "/api/v1/api-keys", `{"name": "partner", "scopes": ["books:admin"]}`, admin).Code)
+
+	issued := suite.doAuthorized(t, "POST", "/api/v1/api-keys", `{"name": "partner", "scopes": ["books:read"]}`, admin)
+	require.Equal(t, http.StatusOK, issued.Code, issued.Body.String())
+	assert.Equal(t, "no-store", issued.Header().Get("Cache-Control"))
+	require.NoError(t, json.Unmarshal(issued.Body.Bytes(), &resp))
+	key := resp.Data.Key
+	require.NotEmpty(t, key)
+	assert.NotContains(t, issued.Body.String(), "key_hash")
+
+	var stored int
+	require.NoError(t, suite.db.Get(&stored, "SELECT COUNT(*) FROM api_keys WHERE key_hash = $1", apikey.Hash(key)))
+	assert.Equal(t, 1, stored)
+	require.NoError(t, suite.db.Get(&stored, "SELECT COUNT(*) FROM api_keys WHERE key_hash = $1", key))
+	assert.Equal(t, 0, stored)
+
+	// Keys are limited to their scopes, whatever the role of their issuer.
+	assert.Equal(t, http.StatusOK, withKey("GET", "/api/v1/books", "", "X-API-Key", key).Code)
+	assert.Equal(t, http.StatusOK, withKey("GET", "/api/v1/books", "", "Authorization", "ApiKey "+key).Code)
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/api/v1/books", `{"title": "Partner Book", "author": "Partner", "published_year": 2020}`, "X-API-Key", key).Code)
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/api/v1/process-url", `{"url": "https://byfood.com/", "operation": "all"}`, "X-API-Key", key).Code)
+	assert.Equal(t, http.StatusForbidden, withKey("GET", "/api/v1/api-keys", "", "X-API-Key", key).Code)
+
+	invalid := withKey("GET", "/api/v1/books", "", "X-API-Key", key+"x")
+	assert.Equal(t, http.StatusUnauthorized, invalid.Code)
+	assert.Equal(t, "ApiKey", invalid.Header().Get("WWW-Authenticate"))
+
+	// Both keys work during the rotation overlap.
+	rotated := suite.doAuthorized(t, "POST", fmt.Sprintf("/api/v1/api-keys/%d/rotate", resp.Data.ID), `{"overlap": "1h"}`, admin)
+	require.Equal(t, http.StatusOK, rotated.Code, rotated.Body.String())
+	oldID := resp.Data.ID
+	require.NoError(t, json.Unmarshal(rotated.Body.Bytes(), &resp))
+	replacement := resp.Data.Key
+	assert.NotEqual(t, key, replacement)
+	assert.Equal(t, http.StatusOK, withKey("GET", "/api/v1/books", "", "X-API-Key", key).Code)
+	assert.Equal(t, http.StatusOK, withKey("GET", "/api/v1/books", "", "X-API-Key", replacement).Code)
+
+	// Usage is counted per key and written by the flusher.
+	require.NoError(t, suite.server.apiKeyService.FlushUsage(context.Background()))
+	list := suite.doAuthorized(t, "GET", "/api/v1/api-keys", "", admin)
+	require.Equal(t, http.StatusOK, list.Code)
+	assert.NotContains(t, list.Body.String(), key)
+	var all struct {
+		Data []apikey.APIKey `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(list.Body.Bytes(), &all))
+	require.Len(t, all.Data, 2)
+	assert.Equal(t, int64(6), all.Data[0].RequestCount)
+	assert.NotNil(t, all.Data[0].LastUsedAt)
+	assert.NotNil(t, all.Data[0].ExpiresAt)
+	assert.Equal(t, int64(1), all.Data[1].RequestCount)
+
+	// Revoked keys stop working at once.
+	assert.Equal(t, http.StatusOK, suite.doAuthorized(t, "DELETE", fmt.Sprintf("/api/v1/api-keys/%d", oldID), "", admin).Code)
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", "/api/v1/books", "", "X-API-Key", key).Code)
+	assert.Equal(t, http.StatusNotFound, suite.doAuthorized(t, "DELETE", fmt.Sprintf("/api/v1/api-keys/%d", oldID), "", admin).Code)
+	assert.Equal(t, http.StatusConflict, suite.doAuthorized(t, "POST", fmt.Sprintf("/api/v1/api-keys/%d/rotate", oldID), "", admin).Code)
+}
+
+// mailbox collects the password reset tokens mailed to each address.
+type mailbox map[string][]string
+
+func (m mailbox) SendPasswordReset(ctx context.Context, to, token string) error {
+	m[to] = append(m[to], token)
+	return nil
+}
+
+func TestLocalAccounts(t *testing.T) {
+	t.Setenv("AUTH_LOCAL_ACCOUNTS", "true")
+	t.Setenv("AUTH_ADMIN_SUBJECTS", "user:1")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	mail := mailbox{}
+	suite.server.userService.Mailer = mail
+
+	// do serves a request carrying the session cookie and CSRF token, when
+	// given.
+	do := func(method, path, body, session, csrf string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if session != "" {
+			req.AddCookie(&http.Cookie{Name: user.SessionCookie, Value: session})
+		}
+		if csrf != "" {
+			req.Header.Set(user.CSRFHeader, csrf)
+		}
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	login := func(email, password string) (*httptest.ResponseRecorder, string, string) {
+		rr := do("POST", "/api/v1/auth/login", fmt.Sprintf(`{"email": %q, "password": %q}`, email, password), "", "")
+		if rr.Code != http.StatusOK {
+			return rr, "", ""
+		}
+		var resp struct {
+			Data user.Session `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == user.SessionCookie {
+				assert.True(t, cookie.HttpOnly)
+				assert.True(t, cookie.Secure)
+				return rr, cookie.Value, resp.Data.CSRFToken
+			}
+		}
+		t.Fatal("login did not set the session cookie")
+		return nil, "", ""
+	}
+
+	registered := do("POST", "/api/v1/auth/register", `{"email": "Admin@Example.com", "password": "correct horse"}`, "", "")
+	require.Equal(t, http.StatusOK, registered.Code, registered.Body.String())
+	assert.NotContains(t, registered.Body.String(), "password")
+	assert.Equal(t, http.StatusConflict, do("POST", "/api/v1/auth/register", `{"email": "admin@example.com", "password": "another horse"}`, "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/auth/register", `{"email": "reader@example.com", "password": "short"}`, "", "").Code)
+	require.Equal(t, http.StatusOK, do("POST", "/api/v1/auth/register", `{"email": "reader@example.com", "password": "correct horse"}`, "", "").Code)
+
+	var stored string
+	require.NoError(t, suite.db.Get(&stored, "SELECT password_hash FROM users WHERE email = 'admin@example.com'"))
+	assert.True(t, strings.HasPrefix(stored, "$argon2id$"))
+
+	rr, _, _ := login("admin@example.com", "wrong horse")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	rr, session, csrf := login("admin@example.com", "correct horse")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+
+	current := do("GET", "/api/v1/auth/session", "", session, "")
+	require.Equal(t, http.StatusOK, current.Code)
+	assert.Contains(t, current.Body.String(), "admin@example.com")
+
+	// Cookie-authenticated writes need the CSRF token.
+	body := `{"title": "Session Book", "author": "Cookie Monster", "published_year": 2020}`
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/books", body, "", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/books", body, session, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/books", body, session, "forged").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/books", body, session, csrf).Code)
+
+	// Roles apply to local accounts by their subject.
+	_, readerSession, readerCSRF := login("reader@example.com", "correct horse")
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/books", "", readerSession, "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/books", body, readerSession, readerCSRF).Code)
+
+	// Repeated failures lock the account, even against the right password.
+	for i := 0; i < 4; i++ {
+		rr, _, _ := login("reader@example.com", "wrong horse")
+		require.Equal(t, http.StatusUnauthorized, rr.Code)
+	}
+	locked, _, _ := login("reader@example.com", "wrong horse")
+	assert.Equal(t, http.StatusTooManyRequests, locked.Code)
+	assert.NotEmpty(t, locked.Header().Get("Retry-After"))
+	locked, _, _ = login("reader@example.com", "correct horse")
+	assert.Equal(t, http.StatusTooManyRequests, locked.Code)
+
+	// A reset unlocks the account and signs it out everywhere.
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/auth/password-reset", `{"email": "nobody@example.com"}`, "", "").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/auth/password-reset", `{"email": "reader@example.com"}`, "", "").Code)
+	require.Len(t, mail["reader@example.com"], 1)
+	require.Len(t, mail, 1)
+	token := mail["reader@example.com"][0]
+
+	reset := do("POST", "/api/v1/auth/password-reset/confirm", fmt.Sprintf(`{"token": %q, "password": "battery staple"}`, token), "", "")
+	require.Equal(t, http.StatusOK, reset.Code, reset.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v1/auth/password-reset/confirm", fmt.Sprintf(`{"token": %q, "password": "battery staple"}`, token), "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/auth/session", "", readerSession, "").Code)
+	rr, _, _ = login("reader@example.com", "battery staple")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Logging out ends the session and clears the cookie.
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/auth/logout", "", session, "").Code)
+	loggedOut := do("POST", "/api/v1/auth/logout", "", session, csrf)
+	require.Equal(t, http.StatusOK, loggedOut.Code)
+	assert.Contains(t, loggedOut.Header().Get("Set-Cookie"), "Max-Age=0")
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v1/auth/session", "", session, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/books", body, session, csrf).Code)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := oidctest.NewProvider("books", "s3cret")
+	defer provider.Close()
+
+	t.Setenv("OIDC_ISSUER", provider.Issuer())
+	t.Setenv("OIDC_CLIENT_ID", "books")
+	t.Setenv("OIDC_CLIENT_SECRET", "s3cret")
+	t.Setenv("OIDC_REDIRECT_URL", "http://localhost:8080/api/v1/auth/oidc/callback")
+	t.Setenv("OIDC_ROLE_MAP", "librarians=editor,library-admins=admin")
+	t.Setenv("OIDC_POST_LOGIN_URL", "/app")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, path, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	cookie := func(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+	// begin starts a login and has the provider approve it, returning the
+	// state cookie and the callback path it redirects back to.
+	begin := func() (*http.Cookie, string) {
+		rr := do("GET", "/api/v1/auth/oidc/login", "")
+		require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+		state := cookie(rr, oidc.StateCookie)
+		require.NotNil(t, state)
+		assert.True(t, state.HttpOnly)
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(rr.Header().Get("Location"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		back, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		return state, back.RequestURI()
+	}
+
+	provider.SetUser("alice", map[string]interface{}{"email": "alice@example.com", "email_verified": true, "groups": []string{"librarians"}})
+	state, callback := begin()
+	rr := do("GET", callback, "", state)
+	require.Equal(t, http.StatusFound, rr.Code, rr.Body.String())
+	assert.Equal(t, "/app", rr.Header().Get("Location"))
+	session := cookie(rr, user.SessionCookie)
+	require.NotNil(t, session)
+	assert.True(t, session.HttpOnly)
+
+	// The state works once.
+	assert.Equal(t, http.StatusBadRequest, do("GET", callback, "", state).Code)
+
+	current := do("GET", "/api/v1/auth/session", "", session)
+	require.Equal(t, http.StatusOK, current.Code)
+	var resp struct {
+		Data user.Session `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(current.Body.Bytes(), &resp))
+	assert.Equal(t, "alice@example.com", resp.Data.User.Email)
+
+	// The librarians group maps to editor, who may add books.
+	body := `{"title": "Federated Book", "author": "Ida Provider", "published_year": 2021}`
+	write := func(csrf string) int {
+		req, err := http.NewRequest("POST", "/api/v1/books", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(user.CSRFHeader, csrf)
+		req.AddCookie(session)
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusForbidden, write(""))
+	assert.Equal(t, http.StatusOK, write(resp.Data.CSRFToken))
+
+	var assigned rbac.Assignment
+	require.NoError(t, suite.db.Get(&assigned, "SELECT subject, role, assigned_by, created_at, updated_at FROM role_assignments WHERE subject = $1", resp.Data.User.Subject()))
+	assert.Equal(t, rbac.RoleEditor, assigned.Role)
+
+	// A callback without the browser's state cookie is rejected.
+	_, callback = begin()
+	other, _ := begin()
+	assert.Equal(t, http.StatusBadRequest, do("GET", callback, "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("GET", callback, "", other).Code)
+
+	// So is an ID token minted for another login.
+	provider.SetNonce("nonce-of-another-login")
+	state, callback = begin()
+	assert.Equal(t, http.StatusUnauthorized, do("GET", callback, "", state).Code)
+	provider.SetNonce("")
+
+	// Password login stays off without AUTH_LOCAL_ACCOUNTS.
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/auth/login", `{"email": "alice@example.com", "password": ""}`).Code)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	secret := "integration-test-secret-of-32-bytes!"
+	t.Setenv("AUTH_HS256_SECRET", secret)
+	t.Setenv("AUTH_ADMIN_SUBJECTS", "root")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	admin := signedToken(t, secret, "", "root")
+	// do serves a request for the tenant slug, naming none when it is empty,
+	// authenticated by header when it is set.
+	do := func(method, path, body, slug, header, value string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if slug != "" {
+			req.Header.Set(tenant.Header, slug)
+		}
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	asAdmin := func(method, path, body, slug string) *httptest.ResponseRecorder {
+		return do(method, path, body, slug, "Authorization", "Bearer "+admin)
+	}
+	dataOf := func(rr *httptest.ResponseRecorder, v interface{}) {
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &helper.Response{Data: v}), rr.Body.String())
+	}
+
+	var current tenant.Tenant
+	rr := do("GET", "/api/v1/tenant", "", "", "", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	dataOf(rr, &current)
+	assert.Equal(t, tenant.DefaultSlug, current.Slug)
+	assert.Contains(t, rr.Header().Values("Vary"), tenant.Header)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v1/books", "", "initech", "", "").Code)
+
+	// Only admins of the default tenant manage tenants.
+	acmeBody := `{"slug": "acme", "name": "Acme"}`
+	assert.Equal(t, http.StatusUnauthorized, do("POST", "/api/v1/tenants", acmeBody, "", "", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/tenants", acmeBody, "", "Authorization", "Bearer "+signedToken(t, secret, "", "viewer-1")).Code)
+	assert.Equal(t, http.StatusBadRequest, asAdmin("POST", "/api/v1/tenants", `{"slug": "Not A Slug!", "name": "Acme"}`, "").Code)
+	var acme, globex tenant.Tenant
+	rr = asAdmin("POST", "/api/v1/tenants", acmeBody, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	dataOf(rr, &acme)
+	rr = asAdmin("POST", "/api/v1/tenants", `{"slug": "globex", "name": "Globex"}`, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	dataOf(rr, &globex)
+	assert.Equal(t, http.StatusConflict, asAdmin("POST", "/api/v1/tenants", acmeBody, "").Code)
+	assert.Equal(t, http.StatusForbidden, asAdmin("GET", "/api/v1/tenants", "", "acme").Code)
+	var tenants []tenant.Tenant
+	rr = asAdmin("GET", "/api/v1/tenants", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	dataOf(rr, &tenants)
+	assert.Len(t, tenants, 3)
+
+	// Books, and everything derived from them, stay within their tenant.
+	var acmeBook book.Book
+	rr = asAdmin("POST", "/api/v1/books", `{"title": "Roadrunner Traps", "author": "Wile Coyote", "published_year": 1949}`, "acme")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	dataOf(rr, &acmeBook)
+	acmePath := fmt.Sprintf("/api/v1/books/%d", acmeBook.ID)
+	require.Equal(t, http.StatusOK, asAdmin("POST", "/api/v1/books", `{"title": "Roadrunner Traps", "author": "Wile Coyote", "published_year": 1949}`, "globex").Code)
+
+	var books []book.Book
+	rr = do("GET", "/api/v1/books", "", "acme", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	dataOf(rr, &books)
+	require.Len(t, books, 1)
+	assert.Equal(t, acmeBook.ID, books[0].ID)
+
+	assert.Equal(t, http.StatusOK, do("GET", acmePath, "", "acme", "", "").Code)
+	for _, slug := range []string{"globex", ""} {
+		assert.Equal(t, http.StatusNotFound, do("GET", acmePath, "", slug, "", "").Code, slug)
+		assert.Equal(t, http.StatusNotFound, asAdmin("PUT", acmePath, `{"title": "Stolen"}`, slug).Code, slug)
+		assert.Equal(t, http.StatusNotFound, asAdmin("DELETE", acmePath, "", slug).Code, slug)
+		assert.Equal(t, http.StatusNotFound, do("GET", acmePath+"/similar", "", slug, "", "").Code, slug)
+	}
+	rr = do("POST", "/api/v1/books/batch", fmt.Sprintf(`{"ids": [%d]}`, acmeBook.ID), "globex", "", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NotContains(t, rr.Body.String(), "Wile Coyote")
+
+	for _, path := range []string{"/api/v1/books/search?q=roadrunner", "/api/v1/books/suggest?prefix=roadr", "/api/v1/books/duplicates?threshold=0.5"} {
+		rr = do("GET", path, "", "", "", "")
+		require.Equal(t, http.StatusOK, rr.Code, path)
+		assert.NotContains(t, rr.Body.String(), "Roadrunner", path)
+	}
+	rr = do("GET", "/api/v1/books/suggest?prefix=roadr", "", "acme", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Roadrunner Traps")
+	rr = do("GET", "/api/v1/books/duplicates?threshold=0.5", "", "acme", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "Roadrunner", "copies in different tenants are not duplicates")
+
+	var stats book.Stats
+	rr = do("GET", "/api/v1/stats/books", "", "acme", "", "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	dataOf(rr, &stats)
+	assert.Equal(t, int64(1), stats.Total)
+
+	// Saved searches are per tenant.
+	var search savedsearch.SavedSearch
+	rr = asAdmin("POST", "/api/v1/saved-searches", `{"name": "Traps", "query": "roadrunner"}`, "acme")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	dataOf(rr, &search)
+	searchPath := fmt.Sprintf("/api/v1/saved-searches/%d", search.ID)
+	assert.Equal(t, http.StatusOK, do("GET", searchPath, "", "acme", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", searchPath, "", "globex", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", searchPath+"/new", "", "globex", "", "").Code)
+	rr = do("GET", "/api/v1/saved-searches", "", "globex", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "Traps")
+
+	// Tenants may reuse each other's idempotency keys.
+	post := func(slug string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/api/v1/books", strings.NewReader(`{"title": "Shared Key", "author": "Retrier", "published_year": 2020}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+admin)
+		req.Header.Set("Idempotency-Key", "shared-key")
+		req.Header.Set(tenant.Header, slug)
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+	require.Equal(t, http.StatusOK, post("acme").Code)
+	replayed := post("acme")
+	assert.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	other := post("globex")
+	require.Equal(t, http.StatusOK, other.Code)
+	assert.Empty(t, other.Header().Get("Idempotent-Replayed"))
+	var count int
+	require.NoError(t, suite.db.Get(&count, "SELECT count(*) FROM books WHERE author = 'Retrier'"))
+	assert.Equal(t, 2, count)
+
+	// Roles are granted per tenant.
+	editor := signedToken(t, secret, "", "editor-1")
+	require.Equal(t, http.StatusOK, asAdmin("PUT", "/api/v1/roles/editor-1", `{"role": "editor"}`, "acme").Code)
+	body := `{"title": "Edited", "author": "Editor", "published_year": 2020}`
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/books", body, "acme", "Authorization", "Bearer "+editor).Code)
+	assert.Equal(t, http.StatusForbidden, do("POST", "/api/v1/books", body, "globex", "Authorization", "Bearer "+editor).Code)
+
+	// API keys are bound to the tenant they were issued in.
+	var issued apikey.APIKey
+	rr = asAdmin("POST", "/api/v1/api-keys", `{"name": "acme-sync", "scopes": ["books:read"]}`, "acme")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	dataOf(rr, &issued)
+	rr = do("GET", "/api/v1/tenant", "", "", "X-API-Key", issued.Key)
+	require.Equal(t, http.StatusOK, rr.Code)
+	dataOf(rr, &current)
+	assert.Equal(t, "acme", current.Slug)
+	assert.Equal(t, http.StatusOK, do("GET", acmePath, "", "acme", "X-API-Key", issued.Key).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/books", "", "globex", "X-API-Key", issued.Key).Code)
+	rr = asAdmin("GET", "/api/v1/api-keys", "", "globex")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "acme-sync")
+
+	// Tokens carrying the tenant claim are bound to it.
+	claimed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":    "globex-user",
+		"tenant": "globex",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/books", "", "", "Authorization", "Bearer "+claimed).Code)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v1/books", "", "acme", "Authorization", "Bearer "+claimed).Code)
+
+	// Renames show at once; slugs never change.
+	rr = asAdmin("PUT", fmt.Sprintf("/api/v1/tenants/%d", acme.ID), `{"slug": "acme-corp", "name": "Acme Corporation"}`, "")
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	rr = do("GET", "/api/v1/tenant", "", "acme", "", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	dataOf(rr, &current)
+	assert.Equal(t, "Acme Corporation", current.Name)
+	assert.Equal(t, "acme", current.Slug)
+	assert.Equal(t, http.StatusNotFound, asAdmin("PUT", "/api/v1/tenants/999", `{"name": "Nobody"}`, "").Code)
+}
+
+func TestRateLimits(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "GET /api/v1/books=3/1h, POST /api/v1/process-url=2/1h:1")
+	t.Setenv("RATE_LIMIT_STORE", "postgres")
+	t.Setenv("RATE_LIMIT_TRUST_FORWARDED", "true")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, path, body, forwardedFor string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		rr := do("GET", "/api/v1/books", "", "203.0.113.1")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "3", rr.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(remaining), rr.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "3;w=3600", rr.Header().Get("RateLimit-Policy"))
+	}
+	limited := do("GET", "/api/v1/books", "", "203.0.113.1")
+	require.Equal(t, http.StatusTooManyRequests, limited.Code)
+	retryAfter, err := strconv.Atoi(limited.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 1200, retryAfter, 5)
+	assert.Equal(t, "0", limited.Header().Get("RateLimit-Remaining"))
+
+	// Clients and routes have buckets of their own.
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/books", "", "203.0.113.2").Code)
+	unlimited := do("GET", "/api/v1/books/1", "", "203.0.113.1")
+	assert.NotEqual(t, http.StatusTooManyRequests, unlimited.Code)
+	assert.Empty(t, unlimited.Header().Get("RateLimit-Limit"))
+
+	process := `{"url": "https://byfood.com/", "operation": "all"}`
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/process-url", process, "203.0.113.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("POST", "/api/v1/process-url", process, "203.0.113.1").Code)
+
+	var buckets int
+	require.NoError(t, suite.db.Get(&buckets, "SELECT count(*) FROM rate_limit_buckets"))
+	assert.Equal(t, 3, buckets)
+}
+
+func TestUsageMetering(t *testing.T) {
+	secret := "integration-test-secret-of-32-bytes!"
+	t.Setenv("AUTH_HS256_SECRET", secret)
+	t.Setenv("AUTH_ADMIN_SUBJECTS", "root")
+
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	admin := signedToken(t, secret, "", "root")
+	partner := signedToken(t, secret, "", "partner-1")
+
+	// Only admins see usage and manage quotas.
+	assert.Equal(t, http.StatusForbidden, suite.doAuthorized(t, "GET", "/api/v1/usage", "", partner).Code)
+	assert.Equal(t, http.StatusBadRequest, suite.doAuthorized(t, "PUT", "/api/v1/usage/quotas/partner-1", `{"warn_requests": 3, "limit_requests": 3}`, admin).Code)
+	set := suite.doAuthorized(t, "PUT", "/api/v1/usage/quotas/partner-1", `{"warn_requests": 2, "limit_requests": 3}`, admin)
+	require.Equal(t, http.StatusOK, set.Code, set.Body.String())
+
+	first := suite.doAuthorized(t, "GET", "/api/v1/books", "", partner)
+	require.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "3", first.Header().Get("X-Quota-Limit"))
+	assert.Equal(t, "2", first.Header().Get("X-Quota-Remaining"))
+	assert.Empty(t, first.Header().Get("X-Quota-Warning"))
+
+	// Counts survive the flush, which writes them to the hourly rollups.
+	require.NoError(t, suite.server.usageService.Flush(context.Background()))
+	second := suite.doAuthorized(t, "GET", "/api/v1/books", "", partner)
+	require.Equal(t, http.StatusOK, second.Code)
+	assert.NotEmpty(t, second.Header().Get("X-Quota-Warning"))
+	assert.NotEqual(t, http.StatusTooManyRequests, suite.doAuthorized(t, "GET", "/api/v1/books/1", "", partner).Code)
+
+	blocked := suite.doAuthorized(t, "GET", "/api/v1/books", "", partner)
+	require.Equal(t, http.StatusTooManyRequests, blocked.Code)
+	assert.NotEmpty(t, blocked.Header().Get("Retry-After"))
+	assert.Equal(t, "0", blocked.Header().Get("X-Quota-Remaining"))
+
+	require.NoError(t, suite.server.usageService.Flush(context.Background()))
+	var requests int64
+	require.NoError(t, suite.db.Get(&requests, "SELECT SUM(requests) FROM usage_hourly WHERE consumer = 'partner-1'"))
+	assert.Equal(t, int64(3), requests)
+
+	report := suite.doAuthorized(t, "GET", "/api/v1/usage?consumer=partner-1", "", admin)
+	require.Equal(t, http.StatusOK, report.Code, report.Body.String())
+	var resp struct {
+		Data []usage.Rollup `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(report.Body.Bytes(), &resp))
+	require.Len(t, resp.Data, 2)
+	assert.Equal(t, "GET /api/v1/books", resp.Data[0].Endpoint)
+	assert.Equal(t, int64(2), resp.Data[0].Requests)
+	assert.Positive(t, resp.Data[0].ResponseBytes)
+	assert.Equal(t, "GET /api/v1/books/{id}", resp.Data[1].Endpoint)
+	assert.Equal(t, int64(1), resp.Data[1].Requests)
+
+	req, err := http.NewRequest("GET", "/api/v1/usage?interval=day", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	req.Header.Set("Accept", "text/csv")
+	export := httptest.NewRecorder()
+	suite.server.Router.ServeHTTP(export, req)
+	require.Equal(t, http.StatusOK, export.Code, export.Body.String())
+	assert.True(t, strings.HasPrefix(export.Body.String(), "period,consumer,endpoint,requests,request_bytes,response_bytes\n"), export.Body.String())
+	assert.Contains(t, export.Body.String(), ",partner-1,GET /api/v1/books,2,")
+
+	// Without its quota the consumer is no longer limited.
+	assert.Equal(t, http.StatusOK, suite.doAuthorized(t, "DELETE", "/api/v1/usage/quotas/partner-1", "", admin).Code)
+	unlimited := suite.doAuthorized(t, "GET", "/api/v1/books", "", partner)
+	assert.Equal(t, http.StatusOK, unlimited.Code)
+	assert.Empty(t, unlimited.Header().Get("X-Quota-Limit"))
+}
+
+// recordingNotifier collects the matches pushed by the saved search
+// evaluator.
+type recordingNotifier struct {
+	notified map[int64][]string
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, search *savedsearch.SavedSearch, books []book.Book) error {
+	for _, b := range books {
+		n.notified[search.ID] = append(n.notified[search.ID], b.Title)
+	}
+	return nil
+}
+
+func TestSavedSearchNewMatches(t *testing.T) {
+	suite := setupHTTPTestSuite(t)
+	defer suite.tearDown(t)
+
+	do := func(method, url string, body interface{}) (*httptest.ResponseRecorder, helper.Response) {
+		var reqBody bytes.Buffer
+		if body != nil {
+			require.NoError(t, json.NewEncoder(&reqBody).Encode(body))
+		}
+		req, err := http.NewRequest(method, url, &reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		suite.server.Router.ServeHTTP(rr, req)
+
+		var response helper.Response
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		return rr, response
+	}
+	newTitles := func(id string) []string {
+		rr, response := do("GET", "/api/v1/saved-searches/"+id+"/new", nil)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		titles := []string{}
+		for _, b := range response.Data.(map[string]interface{})["books"].([]interface{}) {
+			titles = append(titles, b.(map[string]interface{})["title"].(string))
+		}
+		return titles
+	}
+
+	rr, _ := do("POST", "/api/v1/saved-searches", savedsearch.SavedSearch{Query: "ramen"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "name is required")
+	rr, _ = do("POST", "/api/v1/saved-searches", savedsearch.SavedSearch{Name: "Ramen", NotifyEmail: "not an email"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "notify_email must be valid")
+
+	rr, response := do("POST", "/api/v1/saved-searches", savedsearch.SavedSearch{
+		Name:   "Recent ramen",
+		Query:  "ramen",
+		Filter: savedsearch.Filter{Decades: []int{2010}},
+	})
+	require.Equal(t, http.StatusOK, rr.Code)
+	id := strconv.FormatFloat(response.Data.(map[string]interface{})["id"].(float64), 'f', 0, 64)
+
+	assert.Empty(t, newTitles(id), "books created before the search was saved are not new")
+
+	var bookIDs []string
+	for _, b := range []book.Book{
+		{Title: "Ramen Obsession", Author: "Aiko Mori", PublishedYear: 2017},
+		{Title: "Ramen Classics", Author: "Botan Sato", PublishedYear: 1995},
+		{Title: "Sushi Basics", Author: "Chie Ito", PublishedYear: 2012},
+	} {
+		rr, response := do("POST", "/api/v1/books", b)
+		require.Equal(t, http.StatusOK, rr.Code)
+		bookIDs = append(bookIDs, strconv.FormatFloat(response.Data.(map[string]interface{})["id"].(float64), 'f', 0, 64))
+	}
+
+	assert.Equal(t, []string{"Ramen Obsession"}, newTitles(id))
+	assert.Empty(t, newTitles(id), "matches are returned once")
+
+	rr, _ = do("PUT", "/api/v1/books/"+bookIDs[2], book.Book{Title: "Sushi and Ramen", PublishedYear: 2013})
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"Sushi and Ramen"}, newTitles(id), "updated books are new again")
+
+	// The evaluator keeps its own cursor, so it still sees every match
+	// since the search was saved.
+	notifier := &recordingNotifier{notified: map[int64][]string{}}
+	suite.server.savedSearchService.Notifier = notifier
+
+	notified, err := suite.server.savedSearchService.Evaluate(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, notified)
+	searchID, err := strconv.ParseInt(id, 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Ramen Obsession", "Sushi and Ramen"}, notifier.notified[searchID])
+
+	notified, err = suite.server.savedSearchService.Evaluate(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, notified, "nothing changed since the last evaluation")
+
+	rr, _ = do("DELETE", "/api/v1/saved-searches/"+id, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr, _ = do("GET", "/api/v1/saved-searches/"+id+"/new", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr, _ = do("GET", "/api/v1/saved-searches/abc/new", nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
 func TestProcessURL(t *testing.T) {
 	suite := setupHTTPTestSuite(t)
 	defer suite.tearDown(t)
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"byfood-interview/apikey"
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"byfood-interview/user"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// TokenVerifier validates bearer tokens.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*auth.Principal, error)
+}
+
+// Authenticate puts the principal of a request's bearer token on its
+// context. Requests without a token continue anonymously; it is up to each
+// route to Require more. Requests an API key already authenticated pass
+// through. A token that is present but invalid is rejected with 401 on
+// every route, so a client never silently loses its identity.
+func Authenticate(verifier TokenVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" || auth.PrincipalFrom(r.Context()) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			scheme, token, _ := strings.Cut(header, " ")
+			token = strings.TrimSpace(token)
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				unauthorized(w, r, `Bearer error="invalid_request"`, "authorization header must be a bearer token")
+				return
+			}
+
+			principal, err := verifier.Verify(r.Context(), token)
+			if err != nil {
+				log.Ctx(r.Context()).Info().Err(err).Msg("rejected bearer token")
+				unauthorized(w, r, `Bearer error="invalid_token"`, auth.ErrInvalidToken.Error())
+				return
+			}
+
+			ctx := auth.WithPrincipal(r.Context(), principal)
+			log.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("subject", principal.Subject)
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Require rejects requests whose principal does not satisfy access: with
+// 401 when there is none and 403 when it lacks a required claim.
+func Require(access auth.Access) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := access.Check(auth.PrincipalFrom(r.Context()))
+			switch {
+			case err == nil:
+				next.ServeHTTP(w, r)
+			case errors.Is(err, auth.ErrMissingToken):
+				unauthorized(w, r, "Bearer", err.Error())
+			default:
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+				helper.WriteResponse(w, r, helper.NewErrForbidden(err.Error()), nil)
+			}
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, challenge, message string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	helper.WriteResponse(w, r, helper.NewErrUnauthorized(message), nil)
+}
+
+// KeyAuthenticator validates API keys.
+type KeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*auth.Principal, error)
+}
+
+// AuthenticateAPIKey puts the principal of an API key sent as
+// "Authorization: ApiKey <key>" or in X-API-Key on the request context. It
+// must run before Authenticate, which leaves requests it authenticated
+// alone. An invalid key is rejected with 401.
+func AuthenticateAPIKey(keys KeyAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-API-Key")
+			if scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "ApiKey") {
+				key = strings.TrimSpace(value)
+			}
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := keys.Authenticate(r.Context(), key)
+			if err != nil {
+				if !errors.Is(err, apikey.ErrInvalidKey) {
+					helper.WriteResponse(w, r, err, nil)
+					return
+				}
+				unauthorized(w, r, "ApiKey", err.Error())
+				return
+			}
+
+			ctx := auth.WithPrincipal(r.Context(), principal)
+			log.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("subject", principal.Subject)
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// SessionAuthenticator looks up the sessions of local accounts.
+type SessionAuthenticator interface {
+	Session(ctx context.Context, token string) (*user.Session, error)
+}
+
+// AuthenticateSession puts the principal of the session cookie on the
+// request context, unless an API key or bearer token already
+// authenticated the request. Browsers send cookies on their own, so writes
+// must also carry the session's CSRF token in X-CSRF-Token or get 403. An
+// unknown or expired session is ignored and the request continues
+// anonymously.
+func AuthenticateSession(sessions SessionAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie(user.SessionCookie)
+			if err != nil || cookie.Value == "" || auth.PrincipalFrom(r.Context()) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			session, err := sessions.Session(r.Context(), cookie.Value)
+			if err != nil {
+				if !errors.Is(err, user.ErrInvalidSession) {
+					helper.WriteResponse(w, r, err, nil)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !safeMethod(r.Method) && !session.ValidCSRF(r.Header.Get(user.CSRFHeader)) {
+				helper.WriteResponse(w, r, helper.NewErrForbidden("missing or invalid CSRF token"), nil)
+				return
+			}
+
+			principal := session.Principal()
+			ctx := auth.WithPrincipal(r.Context(), principal)
+			log.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("subject", principal.Subject)
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Authorize rejects requests whose caller may not perform action, for
+// routes that have no service of their own to consult the policy.
+func Authorize(authorizer rbac.Authorizer, action rbac.Action) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := authorizer.Authorize(r.Context(), action); err != nil {
+				helper.WriteResponse(w, r, err, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"byfood-interview/apikey"
+	"byfood-interview/auth"
+	"byfood-interview/user"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeVerifier accepts the tokens it maps to principals.
+type fakeVerifier map[string]*auth.Principal
+
+func (f fakeVerifier) Verify(ctx context.Context, token string) (*auth.Principal, error) {
+	if p, ok := f[token]; ok {
+		return p, nil
+	}
+	return nil, auth.ErrInvalidToken
+}
+
+func TestAuthenticate(t *testing.T) {
+	verifier := fakeVerifier{
+		"reader": {Subject: "reader", Claims: map[string]interface{}{"scope": "books:read"}},
+		"admin":  {Subject: "admin", Claims: map[string]interface{}{"scope": "books:read books:admin"}},
+	}
+
+	var seen *auth.Principal
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.PrincipalFrom(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	routes := map[string]http.Handler{
+		"anonymous":     ok,
+		"authenticated": Require(auth.Authenticated)(ok),
+		"admin":         Require(auth.RequireClaim("scope", "books:admin"))(ok),
+	}
+
+	cases := []struct {
+		route         string
+		authorization string
+		status        int
+		challenge     string
+		subject       string
+	}{
+		{"anonymous", "", http.StatusNoContent, "", ""},
+		{"anonymous", "Bearer reader", http.StatusNoContent, "", "reader"},
+		{"anonymous", "Bearer forged", http.StatusUnauthorized, `Bearer error="invalid_token"`, ""},
+		{"anonymous", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, `Bearer error="invalid_request"`, ""},
+		{"authenticated", "", http.StatusUnauthorized, "Bearer", ""},
+		{"authenticated", "bearer reader", http.StatusNoContent, "", "reader"},
+		{"admin", "Bearer reader", http.StatusForbidden, `Bearer error="insufficient_scope"`, ""},
+		{"admin", "Bearer admin", http.StatusNoContent, "", "admin"},
+	}
+	for _, tc := range cases {
+		seen = nil
+		r := httptest.NewRequest(http.MethodGet, "/books", nil)
+		if tc.authorization != "" {
+			r.Header.Set("Authorization", tc.authorization)
+		}
+		w := httptest.NewRecorder()
+		Authenticate(verifier)(routes[tc.route]).ServeHTTP(w, r)
+
+		if w.Code != tc.status || w.Header().Get("WWW-Authenticate") != tc.challenge {
+			t.Errorf("%s %q: got %d %q, want %d %q", tc.route, tc.authorization,
+				w.Code, w.Header().Get("WWW-Authenticate"), tc.status, tc.challenge)
+			continue
+		}
+		if tc.subject != "" && (seen == nil || seen.Subject != tc.subject) {
+			t.Errorf("%s %q: expected principal %q on the context, got %+v", tc.route, tc.authorization, tc.subject, seen)
+		}
+	}
+}
+
+// fakeKeys accepts the API keys it maps to principals.
+type fakeKeys map[string]*auth.Principal
+
+func (f fakeKeys) Authenticate(ctx context.Context, key string) (*auth.Principal, error) {
+	if p, ok := f[key]; ok {
+		return p, nil
+	}
+	return nil, apikey.ErrInvalidKey
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	keys := fakeKeys{"partner-key": {Subject: "api-key:1", Scopes: []string{"books:read"}}}
+	verifier := fakeVerifier{"reader": {Subject: "reader"}}
+
+	var seen *auth.Principal
+	handler := AuthenticateAPIKey(keys)(Authenticate(verifier)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.PrincipalFrom(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	cases := []struct {
+		header, value string
+		status        int
+		challenge     string
+		subject       string
+	}{
+		{"X-API-Key", "partner-key", http.StatusNoContent, "", "api-key:1"},
+		{"Authorization", "ApiKey partner-key", http.StatusNoContent, "", "api-key:1"},
+		{"Authorization", "apikey partner-key", http.StatusNoContent, "", "api-key:1"},
+		{"Authorization", "Bearer reader", http.StatusNoContent, "", "reader"},
+		{"X-API-Key", "revoked-key", http.StatusUnauthorized, "ApiKey", ""},
+		{"Authorization", "ApiKey revoked-key", http.StatusUnauthorized, "ApiKey", ""},
+	}
+	for _, tc := range cases {
+		seen = nil
+		r := httptest.NewRequest(http.MethodGet, "/books", nil)
+		r.Header.Set(tc.header, tc.value)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.status || w.Header().Get("WWW-Authenticate") != tc.challenge {
+			t.Errorf("%s %q: got %d %q, want %d %q", tc.header, tc.value,
+				w.Code, w.Header().Get("WWW-Authenticate"), tc.status, tc.challenge)
+			continue
+		}
+		if tc.subject != "" && (seen == nil || seen.Subject != tc.subject) {
+			t.Errorf("%s %q: expected principal %q on the context, got %+v", tc.header, tc.value, tc.subject, seen)
+		}
+	}
+}
+
+// fakeSessions accepts the session tokens it maps to sessions.
+type fakeSessions map[string]*user.Session
+
+func (f fakeSessions) Session(ctx context.Context, token string) (*user.Session, error) {
+	if s, ok := f[token]; ok {
+		return s, nil
+	}
+	return nil, user.ErrInvalidSession
+}
+
+func TestAuthenticateSession(t *testing.T) {
+	sessions := fakeSessions{"signed-in": {CSRFToken: "csrf", User: &user.User{ID: 7, Email: "reader@example.com"}}}
+	keys := fakeKeys{"partner-key": {Subject: "api-key:1", Scopes: []string{"books:read"}}}
+
+	var seen *auth.Principal
+	handler := AuthenticateAPIKey(keys)(AuthenticateSession(sessions)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.PrincipalFrom(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	cases := []struct {
+		name, method, cookie, csrf, apiKey string
+		status                             int
+		subject                            string
+	}{
+		{"read", http.MethodGet, "signed-in", "", "", http.StatusNoContent, "user:7"},
+		{"write", http.MethodPost, "signed-in", "csrf", "", http.StatusNoContent, "user:7"},
+		{"write without CSRF token", http.MethodPost, "signed-in", "", "", http.StatusForbidden, ""},
+		{"write with wrong CSRF token", http.MethodDelete, "signed-in", "other", "", http.StatusForbidden, ""},
+		{"expired session", http.MethodPost, "expired", "", "", http.StatusNoContent, ""},
+		{"API key wins", http.MethodPost, "signed-in", "", "partner-key", http.StatusNoContent, "api-key:1"},
+	}
+	for _, tc := range cases {
+		seen = nil
+		r := httptest.NewRequest(tc.method, "/books", nil)
+		r.AddCookie(&http.Cookie{Name: user.SessionCookie, Value: tc.cookie})
+		if tc.csrf != "" {
+			r.Header.Set(user.CSRFHeader, tc.csrf)
+		}
+		if tc.apiKey != "" {
+			r.Header.Set("X-API-Key", tc.apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: got %d want %d", tc.name, w.Code, tc.status)
+			continue
+		}
+		if tc.subject == "" && w.Code == http.StatusNoContent && seen != nil {
+			t.Errorf("%s: expected no principal, got %+v", tc.name, seen)
+		}
+		if tc.subject != "" && (seen == nil || seen.Subject != tc.subject) {
+			t.Errorf("%s: expected principal %q, got %+v", tc.name, tc.subject, seen)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/idempotency"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IdempotencyService claims, completes and releases Idempotency-Keys.
+type IdempotencyService interface {
+	Begin(ctx context.Context, req *idempotency.Record) (acquired, replay *idempotency.Record, err error)
+	Complete(ctx context.Context, rec *idempotency.Record) error
+	Release(ctx context.Context, rec *idempotency.Record) error
+}
+
+// replayedHeader marks a response replayed from an earlier request.
+const replayedHeader = "Idempotent-Replayed"
+
+// Idempotency makes POST requests sent with an Idempotency-Key header safe
+// to retry. The first request with a key runs and its response is stored;
+// retries with the same request from the same principal get that response
+// replayed. Server errors and authentication failures are not stored, so a
+// retry after one runs the request again, and neither are responses marked
+// Cache-Control: no-store, such as those carrying secrets. Bodies are read
+// whole to fingerprint them, so they are held to helper.MaxBodySize.
+func Idempotency(service IdempotencyService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := helper.ReadBody(w, r)
+			if err != nil {
+				helper.WriteResponse(w, r, err, nil)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// A key only replays to the principal that sent it; anyone
+			// else reusing it is told it belongs to another request.
+			var subject string
+			if principal := auth.PrincipalFrom(r.Context()); principal != nil {
+				subject = principal.Subject
+			}
+
+			acquired, replay, err := service.Begin(r.Context(), &idempotency.Record{
+				Key:         key,
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				Fingerprint: idempotency.Fingerprint(subject, r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"), body),
+			})
+			if err != nil {
+				if helper.StatusCode(err) == http.StatusConflict {
+					w.Header().Set("Retry-After", "1")
+				}
+				helper.WriteResponse(w, r, err, nil)
+				return
+			}
+			if replay != nil {
+				writeReplay(w, replay)
+				return
+			}
+
+			// Store the outcome even when the client has gone away, since
+			// that is exactly when it will retry.
+			ctx := context.WithoutCancel(r.Context())
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			finished := false
+			defer func() {
+				if !finished {
+					if err := service.Release(ctx, acquired); err != nil {
+						log.Ctx(ctx).Error().Err(err).Msg("failed to release idempotency key")
+					}
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+
+			if !storable(rec.status) || strings.Contains(rec.Header().Get("Cache-Control"), "no-store") {
+				return
+			}
+			acquired.StatusCode = &rec.status
+			acquired.Header = idempotency.Header(storedHeader(rec.Header()))
+			acquired.Body = rec.body.Bytes()
+			if err := service.Complete(ctx, acquired); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msg("failed to store idempotent response")
+				return
+			}
+			finished = true
+		})
+	}
+}
+
+// storable reports whether a response with status may be replayed. A
+// request refused for its credentials can succeed when retried with better
+// ones, so like server errors those responses are not kept.
+func storable(status int) bool {
+	return status < http.StatusInternalServerError &&
+		status != http.StatusUnauthorized && status != http.StatusForbidden
+}
+
+// storedHeader returns the response headers to replay, leaving out those
+// that belong to the request that produced them.
+func storedHeader(h http.Header) http.Header {
+	stored := h.Clone()
+	stored.Del("X-Request-Id")
+	return stored
+}
+
+func writeReplay(w http.ResponseWriter, rec *idempotency.Record) {
+	for name, values := range rec.Header {
+		if _, set := w.Header()[name]; set {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set(replayedHeader, strconv.FormatBool(true))
+	w.WriteHeader(*rec.StatusCode)
+	if _, err := w.Write(rec.Body); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+// recorder passes a response through while keeping its status and body.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
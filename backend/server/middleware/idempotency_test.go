@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/idempotency"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryIdempotency is an in-memory IdempotencyService.
+type memoryIdempotency struct {
+	mu       sync.Mutex
+	records  map[string]*idempotency.Record
+	released int
+}
+
+func (m *memoryIdempotency) Begin(ctx context.Context, req *idempotency.Record) (*idempotency.Record, *idempotency.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.records[req.Key]
+	switch {
+	case !ok:
+		m.records[req.Key] = req
+		return req, nil, nil
+	case existing.Fingerprint != req.Fingerprint:
+		return nil, nil, helper.NewErrUnprocessableEntity(idempotency.ErrKeyReused.Error())
+	case existing.StatusCode == nil:
+		return nil, nil, helper.NewErrConflict(idempotency.ErrInFlight.Error())
+	default:
+		return nil, existing, nil
+	}
+}
+
+func (m *memoryIdempotency) Complete(ctx context.Context, rec *idempotency.Record) error {
+	return nil
+}
+
+func (m *memoryIdempotency) Release(ctx context.Context, rec *idempotency.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, rec.Key)
+	m.released++
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	service := &memoryIdempotency{records: map[string]*idempotency.Record{}}
+	calls := 0
+	status := http.StatusCreated
+	handler := Idempotency(service)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Location", "/books/1")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+
+	do := func(method, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/books", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := do(http.MethodPost, "a", "{}")
+	if first.Code != http.StatusCreated || calls != 1 {
+		t.Fatalf("first request: status %d, calls %d", first.Code, calls)
+	}
+
+	retry := do(http.MethodPost, "a", "{}")
+	if calls != 1 {
+		t.Fatalf("retry ran the handler again")
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != `{"id": 1}` ||
+		retry.Header().Get("Location") != "/books/1" || retry.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("unexpected replay: %d %v %s", retry.Code, retry.Header(), retry.Body.String())
+	}
+
+	if reused := do(http.MethodPost, "a", `{"other": true}`); reused.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for a reused key, got %d", reused.Code)
+	}
+
+	service.records["busy"] = &idempotency.Record{Key: "busy", Fingerprint: idempotency.Fingerprint("", http.MethodPost, "/books", "", []byte("{}"))}
+	if busy := do(http.MethodPost, "busy", "{}"); busy.Code != http.StatusConflict || busy.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 409 with Retry-After for an in-flight key, got %d", busy.Code)
+	}
+
+	status = http.StatusInternalServerError
+	do(http.MethodPost, "b", "{}")
+	if service.released != 1 {
+		t.Fatalf("expected a server error to release its key")
+	}
+	if _, ok := service.records["b"]; ok {
+		t.Fatalf("released key still stored")
+	}
+
+	calls = 0
+	do(http.MethodPost, "", "{}")
+	do(http.MethodPut, "a", "{}")
+	if calls != 2 {
+		t.Fatalf("requests without a key or not POST must pass through, got %d calls", calls)
+	}
+}
+
+func TestIdempotencyIsPerPrincipal(t *testing.T) {
+	service := &memoryIdempotency{records: map[string]*idempotency.Record{}}
+	calls := 0
+	handler := Idempotency(service)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"secret": "of ` + auth.PrincipalFrom(r.Context()).Subject + `"}`))
+	}))
+
+	do := func(subject string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api-keys", strings.NewReader("{}"))
+		r.Header.Set("Idempotency-Key", "shared")
+		r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: subject}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	do("alice")
+	if replay := do("alice"); replay.Header().Get(replayedHeader) != "true" {
+		t.Fatalf("expected the same principal to get the replay, got %d", replay.Code)
+	}
+	other := do("mallory")
+	if other.Code != http.StatusUnprocessableEntity || strings.Contains(other.Body.String(), "alice") {
+		t.Fatalf("expected another principal to be refused the replay, got %d %s", other.Code, other.Body.String())
+	}
+	if calls != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyLimitsBody(t *testing.T) {
+	service := &memoryIdempotency{records: map[string]*idempotency.Record{}}
+	handler := Idempotency(service)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler ran with an oversized body")
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(strings.Repeat("x", helper.MaxBodySize+1)))
+	r.Header.Set("Idempotency-Key", "big")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+	if len(service.records) != 0 {
+		t.Fatalf("expected no key claimed, got %v", service.records)
+	}
+}
@@ -13,6 +13,7 @@ func Logger(next http.Handler) http.Handler {
 		if r.Header.Get("X-Request-Id") == "" {
 			r.Header.Set("X-Request-Id", uuid.New().String())
 		}
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
 
 		ctx := r.Context()
 
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"byfood-interview/helper"
+	"net/http"
+)
+
+// Negotiate rejects requests whose Accept header allows none of the response
+// formats with 406 before they reach a handler, so nothing is changed for a
+// response the client cannot read.
+func Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := helper.NegotiateFormat(r); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/ratelimit"
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RateLimiter decides whether a client may make another request.
+type RateLimiter interface {
+	Allow(ctx context.Context, method, route, client string) *ratelimit.Decision
+}
+
+// RateLimit refuses requests beyond the rate their client is allowed for
+// the route with 429 and Retry-After. Clients are told apart by their
+// principal, so each API key, user and token subject has its own limits,
+// and anonymous clients by IP address. With trustForwarded the address is
+// the last one in X-Forwarded-For, as appended by a reverse proxy in front
+// of the server; it must be set only behind one, or clients could choose
+// their address. Limited responses carry the RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers. It
+// must run after the authentication middlewares.
+func RateLimit(limiter RateLimiter, trustForwarded bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision := limiter.Allow(r.Context(), r.Method, routeTemplate(r), rateLimitClient(r, trustForwarded))
+			if decision == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+			w.Header().Set("RateLimit-Policy", decision.Policy)
+			if !decision.Allowed {
+				helper.WriteResponse(w, r, helper.NewErrTooManyRequests("rate limit exceeded, retry later", decision.RetryAfter), nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// routeTemplate returns the template of the route r matched, such as
+// "/api/v1/books/{id}", or its path when it matched none.
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// rateLimitClient names the client of r for its buckets.
+func rateLimitClient(r *http.Request, trustForwarded bool) string {
+	if p := auth.PrincipalFrom(r.Context()); p != nil {
+		return "subject:" + p.Subject
+	}
+	return "ip:" + clientIP(r, trustForwarded)
+}
+
+func clientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if last := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(last) != nil {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/ratelimit"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeLimiter allows each client a fixed number of requests per route and
+// records what it was asked about.
+type fakeLimiter struct {
+	allowed int
+	counts  map[string]int
+	routes  []string
+}
+
+func (f *fakeLimiter) Allow(ctx context.Context, method, route, client string) *ratelimit.Decision {
+	f.routes = append(f.routes, method+" "+route)
+	if route == "/unlimited" {
+		return nil
+	}
+	f.counts[client]++
+	remaining := f.allowed - f.counts[client]
+	return &ratelimit.Decision{
+		Allowed:    remaining >= 0,
+		Limit:      f.allowed,
+		Remaining:  max(remaining, 0),
+		Reset:      1500 * time.Millisecond,
+		RetryAfter: 250 * time.Millisecond,
+		Policy:     "2;w=1",
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := &fakeLimiter{allowed: 2, counts: map[string]int{}}
+	router := mux.NewRouter()
+	router.Use(RateLimit(limiter, false))
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+	router.HandleFunc("/books/{id}", ok)
+	router.HandleFunc("/unlimited", ok)
+
+	serve := func(remoteAddr string, p *auth.Principal) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/books/7", nil)
+		r.RemoteAddr = remoteAddr
+		if p != nil {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 1; i >= 0; i-- {
+		w := serve("192.0.2.1:1234", nil)
+		if w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Remaining") != strconv.Itoa(i) {
+			t.Fatalf("expected request allowed with %d remaining, got %d %v", i, w.Code, w.Header())
+		}
+		if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Reset") != "2" || w.Header().Get("RateLimit-Policy") != "2;w=1" {
+			t.Errorf("unexpected rate limit headers %v", w.Header())
+		}
+	}
+	refused := serve("192.0.2.1:5678", nil)
+	if refused.Code != http.StatusTooManyRequests || refused.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After, got %d %v", refused.Code, refused.Header())
+	}
+
+	// Other addresses and principals have buckets of their own, and a
+	// principal is limited whatever address it calls from.
+	if w := serve("192.0.2.2:1234", nil); w.Code != http.StatusNoContent {
+		t.Errorf("expected another address to be allowed, got %d", w.Code)
+	}
+	key := &auth.Principal{Subject: "api-key:1"}
+	if w := serve("192.0.2.1:1234", key); w.Code != http.StatusNoContent {
+		t.Errorf("expected a principal to be allowed from a limited address, got %d", w.Code)
+	}
+	if limiter.counts["subject:api-key:1"] != 1 {
+		t.Errorf("expected the principal to be limited by subject, got %v", limiter.counts)
+	}
+
+	if limiter.routes[0] != "GET /books/{id}" {
+		t.Errorf("expected limits looked up by route template, got %q", limiter.routes[0])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unlimited", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("expected routes without a rule to pass without headers, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/books", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+
+	if ip := clientIP(r, false); ip != "10.0.0.1" {
+		t.Errorf("expected X-Forwarded-For ignored by default, got %s", ip)
+	}
+	if ip := clientIP(r, true); ip != "198.51.100.7" {
+		t.Errorf("expected the address appended by the proxy, got %s", ip)
+	}
+	r.Header.Set("X-Forwarded-For", "not an address")
+	if ip := clientIP(r, true); ip != "10.0.0.1" {
+		t.Errorf("expected a malformed X-Forwarded-For ignored, got %s", ip)
+	}
+}
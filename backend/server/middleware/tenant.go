@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/tenant"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// TenantResolver looks tenants up by slug and by ID.
+type TenantResolver interface {
+	Resolve(ctx context.Context, slug string) (*tenant.Tenant, error)
+	ResolveID(ctx context.Context, id int64) (*tenant.Tenant, error)
+}
+
+// ResolveTenant puts the tenant of a request on its context. The request
+// names it in the X-Tenant header or, when baseDomain is set, as the
+// subdomain it was sent to; without either it gets the default tenant.
+// Credentials bound to a tenant, API keys and bearer tokens carrying the
+// claim named claim, act for that tenant whatever the request names, and
+// get 403 when it names another. It must run after the authentication
+// middlewares. An unknown tenant is rejected with 404.
+func ResolveTenant(resolver TenantResolver, baseDomain, claim string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", tenant.Header)
+
+			slug := strings.ToLower(strings.TrimSpace(r.Header.Get(tenant.Header)))
+			if slug == "" && baseDomain != "" {
+				slug = tenant.SlugFromHost(r.Host, baseDomain)
+			}
+
+			current, err := boundTenant(r.Context(), resolver, auth.PrincipalFrom(r.Context()), claim)
+			switch {
+			case err != nil:
+				helper.WriteResponse(w, r, err, nil)
+				return
+			case current != nil:
+				if slug != "" && slug != current.Slug {
+					helper.WriteResponse(w, r, helper.NewErrForbidden("credentials are bound to another tenant"), nil)
+					return
+				}
+			default:
+				if slug == "" {
+					slug = tenant.DefaultSlug
+				}
+				current, err = resolver.Resolve(r.Context(), slug)
+				if errors.Is(err, tenant.ErrUnknownTenant) {
+					helper.WriteResponse(w, r, helper.NewErrNotFound("tenant not found"), nil)
+					return
+				}
+				if err != nil {
+					helper.WriteResponse(w, r, err, nil)
+					return
+				}
+			}
+
+			ctx := tenant.WithTenant(r.Context(), current)
+			log.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("tenant", current.Slug)
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// TenantConn runs the statements of a request on one connection of db,
+// scoped to the tenant of the request once when the request first needs it,
+// instead of in a transaction per statement that sets the tenant again. The
+// connection goes back to the pool once the request is served. It must run
+// after ResolveTenant.
+func TenantConn(db *sqlx.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantID, err := tenant.IDFrom(r.Context())
+			if err != nil {
+				helper.WriteResponse(w, r, err, nil)
+				return
+			}
+
+			ctx, release := internalDb.WithTenantConn(r.Context(), db, tenantID)
+			defer release()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// boundTenant returns the tenant p is bound to, or nil when it may act for
+// any tenant. A binding to a tenant that does not exist is refused.
+func boundTenant(ctx context.Context, resolver TenantResolver, p *auth.Principal, claim string) (*tenant.Tenant, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	var (
+		bound *tenant.Tenant
+		err   error
+	)
+	if p.TenantID != 0 {
+		bound, err = resolver.ResolveID(ctx, p.TenantID)
+	} else if slug, _ := p.Claims[claim].(string); claim != "" && slug != "" {
+		bound, err = resolver.Resolve(ctx, strings.ToLower(slug))
+	} else {
+		return nil, nil
+	}
+	if errors.Is(err, tenant.ErrUnknownTenant) {
+		return nil, helper.NewErrForbidden("credentials are bound to an unknown tenant")
+	}
+	return bound, err
+}
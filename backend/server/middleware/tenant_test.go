@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/tenant"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeTenants resolves the tenants it holds.
+type fakeTenants []*tenant.Tenant
+
+func (f fakeTenants) Resolve(ctx context.Context, slug string) (*tenant.Tenant, error) {
+	for _, t := range f {
+		if t.Slug == slug {
+			return t, nil
+		}
+	}
+	return nil, tenant.ErrUnknownTenant
+}
+
+func (f fakeTenants) ResolveID(ctx context.Context, id int64) (*tenant.Tenant, error) {
+	for _, t := range f {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, tenant.ErrUnknownTenant
+}
+
+func TestResolveTenant(t *testing.T) {
+	tenants := fakeTenants{
+		{ID: tenant.DefaultID, Slug: tenant.DefaultSlug},
+		{ID: 2, Slug: "acme"},
+		{ID: 3, Slug: "globex"},
+	}
+
+	var seen *tenant.Tenant
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = tenant.From(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := ResolveTenant(tenants, "books.example.com", "tenant")(ok)
+
+	keyOfAcme := &auth.Principal{Subject: "api-key:1", TenantID: 2}
+	tokenOfGlobex := &auth.Principal{Subject: "sam", Claims: map[string]interface{}{"tenant": "globex"}}
+	orphan := &auth.Principal{Subject: "api-key:2", TenantID: 9}
+
+	cases := []struct {
+		name      string
+		host      string
+		header    string
+		principal *auth.Principal
+		status    int
+		tenant    string
+	}{
+		{"default", "books.example.com", "", nil, http.StatusNoContent, tenant.DefaultSlug},
+		{"header", "books.example.com", "acme", nil, http.StatusNoContent, "acme"},
+		{"header case", "books.example.com", " ACME ", nil, http.StatusNoContent, "acme"},
+		{"subdomain", "globex.books.example.com:8080", "", nil, http.StatusNoContent, "globex"},
+		{"header over subdomain", "globex.books.example.com", "acme", nil, http.StatusNoContent, "acme"},
+		{"unknown header", "books.example.com", "initech", nil, http.StatusNotFound, ""},
+		{"unknown subdomain", "initech.books.example.com", "", nil, http.StatusNotFound, ""},
+		{"foreign host", "acme.other.com", "", nil, http.StatusNoContent, tenant.DefaultSlug},
+		{"unbound principal", "books.example.com", "globex", &auth.Principal{Subject: "ops"}, http.StatusNoContent, "globex"},
+		{"bound key", "books.example.com", "", keyOfAcme, http.StatusNoContent, "acme"},
+		{"bound key same tenant", "books.example.com", "acme", keyOfAcme, http.StatusNoContent, "acme"},
+		{"bound key other tenant", "books.example.com", "globex", keyOfAcme, http.StatusForbidden, ""},
+		{"bound key other subdomain", "globex.books.example.com", "", keyOfAcme, http.StatusForbidden, ""},
+		{"bound token", "books.example.com", "", tokenOfGlobex, http.StatusNoContent, "globex"},
+		{"bound token other tenant", "books.example.com", "default", tokenOfGlobex, http.StatusForbidden, ""},
+		{"bound to unknown tenant", "books.example.com", "", orphan, http.StatusForbidden, ""},
+	}
+	for _, tc := range cases {
+		seen = nil
+		r := httptest.NewRequest(http.MethodGet, "/books", nil)
+		r.Host = tc.host
+		if tc.header != "" {
+			r.Header.Set(tenant.Header, tc.header)
+		}
+		if tc.principal != nil {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), tc.principal))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.status)
+			continue
+		}
+		if tc.tenant != "" && (seen == nil || seen.Slug != tc.tenant) {
+			t.Errorf("%s: expected tenant %q on the context, got %+v", tc.name, tc.tenant, seen)
+		}
+		if w.Header().Get("Vary") == "" {
+			t.Errorf("%s: expected the response to vary by %s", tc.name, tenant.Header)
+		}
+	}
+}
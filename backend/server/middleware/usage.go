@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/usage"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UsageMeter counts the requests of consumers and checks them against their
+// quotas.
+type UsageMeter interface {
+	Check(ctx context.Context, consumer string) *usage.Status
+	Record(ctx context.Context, consumer, endpoint string, counts usage.Counts)
+}
+
+// MeterUsage counts every request, with the bytes read of its body and
+// written of its response, under its consumer and endpoint, the method and
+// route template. Consumers are principals, named by their subject, or
+// usage.Anonymous. A consumer past its monthly quota is refused with 429 and
+// Retry-After until the month ends; responses to consumers with a quota
+// carry X-Quota-Remaining and X-Quota-Reset along with X-Quota-Limit, and
+// X-Quota-Warning once they pass its warning threshold. Refused requests
+// are not counted. It must run after ResolveTenant.
+func MeterUsage(meter UsageMeter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			consumer := usage.Anonymous
+			if p := auth.PrincipalFrom(r.Context()); p != nil {
+				consumer = p.Subject
+			}
+
+			if status := meter.Check(r.Context(), consumer); status != nil {
+				resetIn := time.Until(status.Reset)
+				if status.Limit > 0 {
+					w.Header().Set("X-Quota-Limit", strconv.FormatInt(status.Limit, 10))
+					w.Header().Set("X-Quota-Remaining", strconv.FormatInt(status.Remaining(), 10))
+				}
+				w.Header().Set("X-Quota-Reset", strconv.Itoa(ceilSeconds(resetIn)))
+				if status.Blocked {
+					helper.WriteResponse(w, r, helper.NewErrTooManyRequests("monthly usage quota exhausted", resetIn), nil)
+					return
+				}
+				if status.Warning {
+					w.Header().Set("X-Quota-Warning", strconv.FormatInt(status.Used, 10)+" requests used this month")
+				}
+			}
+
+			body := &countingReader{ReadCloser: r.Body}
+			if r.Body != nil {
+				r.Body = body
+			}
+			cw := &countingWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+
+			meter.Record(r.Context(), consumer, r.Method+" "+routeTemplate(r), usage.Counts{
+				Requests:      1,
+				RequestBytes:  body.n,
+				ResponseBytes: cw.n,
+			})
+		})
+	}
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written of a response body.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.n += int64(n)
+	return n, err
+}
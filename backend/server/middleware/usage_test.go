@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/usage"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeMeter records what it is asked to count and reports the statuses it
+// is given per consumer.
+type fakeMeter struct {
+	statuses map[string]*usage.Status
+	recorded map[string]usage.Counts
+}
+
+func (f *fakeMeter) Check(ctx context.Context, consumer string) *usage.Status {
+	return f.statuses[consumer]
+}
+
+func (f *fakeMeter) Record(ctx context.Context, consumer, endpoint string, counts usage.Counts) {
+	sum := f.recorded[consumer+" "+endpoint]
+	sum.Add(counts)
+	f.recorded[consumer+" "+endpoint] = sum
+}
+
+func TestMeterUsage(t *testing.T) {
+	reset := time.Now().Add(90 * time.Second)
+	meter := &fakeMeter{
+		statuses: map[string]*usage.Status{
+			"api-key:1": {Limit: 10, Used: 9, Warning: true, Reset: reset},
+			"api-key:2": {Limit: 10, Used: 10, Blocked: true, Reset: reset},
+		},
+		recorded: map[string]usage.Counts{},
+	}
+	router := mux.NewRouter()
+	router.Use(MeterUsage(meter))
+	router.HandleFunc("/books/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello"))
+	})
+
+	serve := func(p *auth.Principal) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, "/books/7", strings.NewReader(`{"title":"x"}`))
+		if p != nil {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(nil); w.Code != http.StatusOK || w.Header().Get("X-Quota-Limit") != "" {
+		t.Fatalf("expected anonymous requests served without quota headers, got %d %v", w.Code, w.Header())
+	}
+	if got := meter.recorded["anonymous PUT /books/{id}"]; got != (usage.Counts{Requests: 1, RequestBytes: 13, ResponseBytes: 5}) {
+		t.Errorf("expected the request and its bytes counted by route template, got %+v", meter.recorded)
+	}
+
+	w := serve(&auth.Principal{Subject: "api-key:1"})
+	if w.Code != http.StatusOK || w.Header().Get("X-Quota-Limit") != "10" || w.Header().Get("X-Quota-Remaining") != "1" ||
+		w.Header().Get("X-Quota-Reset") != "90" || w.Header().Get("X-Quota-Warning") == "" {
+		t.Fatalf("expected a warned request served with quota headers, got %d %v", w.Code, w.Header())
+	}
+
+	w = serve(&auth.Principal{Subject: "api-key:2"})
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "90" || w.Header().Get("X-Quota-Remaining") != "0" {
+		t.Fatalf("expected 429 with Retry-After past the quota, got %d %v", w.Code, w.Header())
+	}
+	if _, ok := meter.recorded["api-key:2 PUT /books/{id}"]; ok {
+		t.Error("expected refused requests not to be counted")
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"byfood-interview/auth"
 	_ "byfood-interview/docs"
 	"byfood-interview/process-url/handler"
+	"byfood-interview/rbac"
 	"byfood-interview/server/middleware"
 	"net/http"
 
@@ -14,6 +16,25 @@ func (s *Server) routes() {
 	s.Router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
 
 	api := s.Router.PathPrefix("/api/v1/").Subrouter()
+	api.Use(middleware.Negotiate)
+	api.Use(middleware.AuthenticateAPIKey(s.apiKeyService))
+	if s.verifier != nil {
+		api.Use(middleware.Authenticate(s.verifier))
+	}
+	if s.sessions() {
+		api.Use(middleware.AuthenticateSession(s.userService))
+	}
+	if s.rateLimitService != nil {
+		api.Use(middleware.RateLimit(s.rateLimitService, s.trustForwarded))
+	}
+	api.Use(middleware.ResolveTenant(s.tenantService, s.tenantBaseDomain, s.tenantClaim))
+	api.Use(middleware.TenantConn(s.DB))
+	api.Use(middleware.MeterUsage(s.usageService))
+	api.Use(middleware.Idempotency(s.idempotencyService))
+
+	// admin guards the administrative routes, which unlike those behind
+	// s.require are never open to anonymous callers.
+	admin := middleware.Require(auth.Authenticated)
 
 	api.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -21,12 +42,84 @@ func (s *Server) routes() {
 	}).Methods(http.MethodGet)
 
 	// book routes
-	api.HandleFunc("/books", s.BookHandler.CreateBook()).Methods(http.MethodPost)
+	api.Handle("/books", s.require(auth.Authenticated, s.BookHandler.CreateBook())).Methods(http.MethodPost)
+	api.HandleFunc("/books/batch", s.BookHandler.BatchGetBooks()).Methods(http.MethodPost)
+	api.HandleFunc("/books/suggest", s.BookHandler.SuggestBooks()).Methods(http.MethodGet)
+	api.HandleFunc("/books/search", s.BookHandler.SearchBooks()).Methods(http.MethodGet)
+	api.HandleFunc("/books/changes", s.BookHandler.GetBookChanges()).Methods(http.MethodGet)
+	api.HandleFunc("/books/duplicates", s.BookHandler.GetDuplicateBooks()).Methods(http.MethodGet)
+	api.Handle("/books/{id}/merge", s.require(auth.Authenticated, s.BookHandler.MergeBook())).Methods(http.MethodPost)
+	api.HandleFunc("/books/{id}/similar", s.BookHandler.GetSimilarBooks()).Methods(http.MethodGet)
 	api.HandleFunc("/books/{id}", s.BookHandler.GetBookByID()).Methods(http.MethodGet)
 	api.HandleFunc("/books", s.BookHandler.GetAllBooks()).Methods(http.MethodGet)
-	api.HandleFunc("/books/{id}", s.BookHandler.UpdateBook()).Methods(http.MethodPut)
-	api.HandleFunc("/books/{id}", s.BookHandler.DeleteBook()).Methods(http.MethodDelete)
+	api.Handle("/books/{id}", s.require(auth.Authenticated, s.BookHandler.UpdateBook())).Methods(http.MethodPut)
+	api.Handle("/books/{id}", s.require(auth.Authenticated, s.BookHandler.DeleteBook())).Methods(http.MethodDelete)
+
+	// stats routes
+	api.HandleFunc("/stats/books", s.BookHandler.GetBookStats()).Methods(http.MethodGet)
+
+	// saved search routes
+	api.Handle("/saved-searches", s.require(auth.Authenticated, s.SavedSearchHandler.CreateSavedSearch())).Methods(http.MethodPost)
+	api.HandleFunc("/saved-searches", s.SavedSearchHandler.GetSavedSearches()).Methods(http.MethodGet)
+	api.HandleFunc("/saved-searches/{id}/new", s.SavedSearchHandler.GetNewMatches()).Methods(http.MethodGet)
+	api.HandleFunc("/saved-searches/{id}", s.SavedSearchHandler.GetSavedSearchByID()).Methods(http.MethodGet)
+	api.Handle("/saved-searches/{id}", s.require(auth.Authenticated, s.SavedSearchHandler.DeleteSavedSearch())).Methods(http.MethodDelete)
+
+	// role routes
+	api.Handle("/roles", admin(s.RoleHandler.GetRoles())).Methods(http.MethodGet)
+	api.Handle("/roles/{subject}", admin(s.RoleHandler.AssignRole())).Methods(http.MethodPut)
+	api.Handle("/roles/{subject}", admin(s.RoleHandler.RevokeRole())).Methods(http.MethodDelete)
+
+	// API key routes
+	api.Handle("/api-keys", admin(s.APIKeyHandler.IssueAPIKey())).Methods(http.MethodPost)
+	api.Handle("/api-keys", admin(s.APIKeyHandler.GetAPIKeys())).Methods(http.MethodGet)
+	api.Handle("/api-keys/{id}/rotate", admin(s.APIKeyHandler.RotateAPIKey())).Methods(http.MethodPost)
+	api.Handle("/api-keys/{id}", admin(s.APIKeyHandler.RevokeAPIKey())).Methods(http.MethodDelete)
+
+	// tenant routes
+	api.HandleFunc("/tenant", s.TenantHandler.GetCurrentTenant()).Methods(http.MethodGet)
+	api.Handle("/tenants", admin(s.TenantHandler.CreateTenant())).Methods(http.MethodPost)
+	api.Handle("/tenants", admin(s.TenantHandler.GetTenants())).Methods(http.MethodGet)
+	api.Handle("/tenants/{id}", admin(s.TenantHandler.GetTenantByID())).Methods(http.MethodGet)
+	api.Handle("/tenants/{id}", admin(s.TenantHandler.UpdateTenant())).Methods(http.MethodPut)
+
+	// usage routes
+	api.Handle("/usage", admin(s.UsageHandler.GetUsageReport())).Methods(http.MethodGet)
+	api.Handle("/usage/quotas", admin(s.UsageHandler.GetQuotas())).Methods(http.MethodGet)
+	api.Handle("/usage/quotas/{consumer}", admin(s.UsageHandler.SetQuota())).Methods(http.MethodPut)
+	api.Handle("/usage/quotas/{consumer}", admin(s.UsageHandler.DeleteQuota())).Methods(http.MethodDelete)
+
+	// session routes
+	if s.sessions() {
+		api.HandleFunc("/auth/logout", s.UserHandler.Logout()).Methods(http.MethodPost)
+		api.HandleFunc("/auth/session", s.UserHandler.GetSession()).Methods(http.MethodGet)
+	}
+
+	// local account routes
+	if s.localAccounts {
+		api.HandleFunc("/auth/register", s.UserHandler.Register()).Methods(http.MethodPost)
+		api.HandleFunc("/auth/login", s.UserHandler.Login()).Methods(http.MethodPost)
+		api.HandleFunc("/auth/password-reset", s.UserHandler.RequestPasswordReset()).Methods(http.MethodPost)
+		api.HandleFunc("/auth/password-reset/confirm", s.UserHandler.ResetPassword()).Methods(http.MethodPost)
+	}
+
+	// single sign-on routes
+	if s.singleSignOn {
+		api.HandleFunc("/auth/oidc/login", s.OIDCHandler.Login()).Methods(http.MethodGet)
+		api.HandleFunc("/auth/oidc/callback", s.OIDCHandler.Callback()).Methods(http.MethodGet)
+	}
 
 	// URL cleanup routes
-	api.HandleFunc("/process-url", handler.ProcessURLHandler()).Methods(http.MethodPost)
+	api.Handle("/process-url", middleware.Authorize(s.rbacService, rbac.ActionProcessURL)(handler.ProcessURLHandler())).Methods(http.MethodPost)
+}
+
+// require wraps h so that only callers with access reach it. Routes that do
+// not use it are anonymous. Which role a caller needs is up to the services
+// and their policy. Without configured keys, local accounts or single
+// sign-on there is no one to authenticate, so access is not enforced.
+func (s *Server) require(access auth.Access, h http.Handler) http.Handler {
+	if s.verifier == nil && !s.sessions() {
+		return h
+	}
+	return middleware.Require(access)(h)
 }
@@ -1,12 +1,40 @@
 package server
 
 import (
+	apiKeyHandler "byfood-interview/apikey/handler"
+	apiKeyServices "byfood-interview/apikey/services"
+	apiKeyStores "byfood-interview/apikey/stores"
+	"byfood-interview/auth"
 	"byfood-interview/book/handler"
 	"byfood-interview/book/services"
 	"byfood-interview/book/stores"
+	idempotencyServices "byfood-interview/idempotency/services"
+	idempotencyStores "byfood-interview/idempotency/stores"
+	internalDb "byfood-interview/internal/db"
+	oidcHandler "byfood-interview/oidc/handler"
+	oidcServices "byfood-interview/oidc/services"
+	oidcStores "byfood-interview/oidc/stores"
+	rateLimitServices "byfood-interview/ratelimit/services"
+	"byfood-interview/rbac"
+	rbacHandler "byfood-interview/rbac/handler"
+	rbacServices "byfood-interview/rbac/services"
+	rbacStores "byfood-interview/rbac/stores"
+	savedSearchHandler "byfood-interview/savedsearch/handler"
+	savedSearchServices "byfood-interview/savedsearch/services"
+	savedSearchStores "byfood-interview/savedsearch/stores"
+	tenantHandler "byfood-interview/tenant/handler"
+	tenantServices "byfood-interview/tenant/services"
+	tenantStores "byfood-interview/tenant/stores"
+	usageHandler "byfood-interview/usage/handler"
+	usageServices "byfood-interview/usage/services"
+	usageStores "byfood-interview/usage/stores"
+	userHandler "byfood-interview/user/handler"
+	userServices "byfood-interview/user/services"
+	userStores "byfood-interview/user/stores"
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -19,30 +47,247 @@ type BookHandler interface {
 	CreateBook() http.HandlerFunc
 	GetBookByID() http.HandlerFunc
 	GetAllBooks() http.HandlerFunc
+	BatchGetBooks() http.HandlerFunc
 	UpdateBook() http.HandlerFunc
 	DeleteBook() http.HandlerFunc
+	GetDuplicateBooks() http.HandlerFunc
+	MergeBook() http.HandlerFunc
+	SearchBooks() http.HandlerFunc
+	SuggestBooks() http.HandlerFunc
+	GetSimilarBooks() http.HandlerFunc
+	GetBookStats() http.HandlerFunc
+	GetBookChanges() http.HandlerFunc
+}
+
+type SavedSearchHandler interface {
+	CreateSavedSearch() http.HandlerFunc
+	GetSavedSearches() http.HandlerFunc
+	GetSavedSearchByID() http.HandlerFunc
+	DeleteSavedSearch() http.HandlerFunc
+	GetNewMatches() http.HandlerFunc
+}
+
+type RoleHandler interface {
+	GetRoles() http.HandlerFunc
+	AssignRole() http.HandlerFunc
+	RevokeRole() http.HandlerFunc
+}
+
+type APIKeyHandler interface {
+	IssueAPIKey() http.HandlerFunc
+	GetAPIKeys() http.HandlerFunc
+	RevokeAPIKey() http.HandlerFunc
+	RotateAPIKey() http.HandlerFunc
+}
+
+type TenantHandler interface {
+	CreateTenant() http.HandlerFunc
+	GetTenants() http.HandlerFunc
+	GetTenantByID() http.HandlerFunc
+	UpdateTenant() http.HandlerFunc
+	GetCurrentTenant() http.HandlerFunc
+}
+
+type UsageHandler interface {
+	GetUsageReport() http.HandlerFunc
+	GetQuotas() http.HandlerFunc
+	SetQuota() http.HandlerFunc
+	DeleteQuota() http.HandlerFunc
+}
+
+type UserHandler interface {
+	Register() http.HandlerFunc
+	Login() http.HandlerFunc
+	Logout() http.HandlerFunc
+	GetSession() http.HandlerFunc
+	RequestPasswordReset() http.HandlerFunc
+	ResetPassword() http.HandlerFunc
+}
+
+type OIDCHandler interface {
+	Login() http.HandlerFunc
+	Callback() http.HandlerFunc
 }
 
 type Server struct {
 	Router *mux.Router
 	DB     *sqlx.DB
 
-	BookHandler BookHandler
+	BookHandler        BookHandler
+	SavedSearchHandler SavedSearchHandler
+	RoleHandler        RoleHandler
+	APIKeyHandler      APIKeyHandler
+	TenantHandler      TenantHandler
+	UsageHandler       UsageHandler
+	UserHandler        UserHandler
+	OIDCHandler        OIDCHandler
+
+	bookService        *services.Book
+	savedSearchService *savedSearchServices.SavedSearch
+	idempotencyService *idempotencyServices.Idempotency
+	rbacService        *rbacServices.RBAC
+	apiKeyService      *apiKeyServices.APIKey
+	tenantService      *tenantServices.Tenant
+	rateLimitService   *rateLimitServices.RateLimit
+	usageService       *usageServices.Usage
+	userService        *userServices.User
+	verifier           *auth.Verifier
+	// trustForwarded takes client addresses from X-Forwarded-For.
+	trustForwarded bool
+	// tenantBaseDomain is the domain whose subdomains name tenants.
+	tenantBaseDomain string
+	// tenantClaim is the bearer token claim binding a token to a tenant.
+	tenantClaim string
+	// localAccounts enables registration and login with passwords.
+	localAccounts bool
+	// singleSignOn enables login through an OpenID Connect provider.
+	singleSignOn bool
+}
+
+// sessions reports whether anyone can sign in to a session cookie.
+func (s *Server) sessions() bool {
+	return s.localAccounts || s.singleSignOn
 }
 
 func NewServer(migrationPath string) *Server {
 	fmt.Println("Initializing server...")
 
 	db := db(migrationPath)
+	verifier := authVerifier()
+	localAccounts := envBool("AUTH_LOCAL_ACCOUNTS")
+	provider := oidcClient()
+
+	// Without bearer token keys, local accounts or single sign-on there is
+	// no one to sign in, so anonymous callers may do anything with books
+	// but nothing more; API keys stay limited to their scopes.
+	policy := rbac.DefaultPolicy
+	if verifier == nil && !localAccounts && provider == nil {
+		policy = rbac.OpenPolicy
+	}
+	rbacService := rbacServices.RBAC{
+		RoleRepository: rbacStores.NewRole(db),
+		Policy:         policy,
+		Admins:         envList("AUTH_ADMIN_SUBJECTS"),
+	}
+
+	apiKeyService := apiKeyServices.APIKey{
+		APIKeyRepository: apiKeyStores.NewAPIKey(db),
+		Transactor:       internalDb.NewTransactor(db),
+		Authorizer:       &rbacService,
+	}
+
+	userService := userServices.User{
+		UserRepository:       userStores.NewUser(db),
+		SessionRepository:    userStores.NewSession(db),
+		ResetTokenRepository: userStores.NewResetToken(db),
+		Transactor:           internalDb.NewTransactor(db),
+		Mailer:               passwordResetMailer(),
+		Hasher:               passwordHasher(),
+		SessionTTL:           envDuration("SESSION_TTL", userServices.DefaultSessionTTL),
+		MaxFailedLogins:      envInt("LOGIN_MAX_FAILURES", userServices.DefaultMaxFailedLogins),
+		LockoutDuration:      envDuration("LOGIN_LOCKOUT_DURATION", userServices.DefaultLockoutDuration),
+		ResetTokenTTL:        envDuration("PASSWORD_RESET_TTL", userServices.DefaultResetTokenTTL),
+	}
+
+	oidcService := oidcServices.OIDC{
+		LoginRepository:    oidcStores.NewLogin(db),
+		IdentityRepository: oidcStores.NewIdentity(db),
+		UserRepository:     userStores.NewUser(db),
+		RoleRepository:     rbacStores.NewRole(db),
+		Sessions:           &userService,
+		Transactor:         internalDb.NewTransactor(db),
+		RoleClaim:          os.Getenv("OIDC_ROLE_CLAIM"),
+		RoleMap:            oidcRoleMap(),
+	}
+	if provider != nil {
+		oidcService.Provider = provider
+	}
+
+	secureCookies := !envBool("SESSION_COOKIE_INSECURE")
 
 	bookService := services.Book{
 		BookRepository: stores.NewBook(db),
+		Transactor:     internalDb.NewTransactor(db),
+		SearchConfig:   searchConfig(),
+		SuggestIndex:   services.NewSuggestIndex(),
+		SimilarIndex:   services.NewSimilarIndex(),
+
+		MaterializedStats: envBool("STATS_MATERIALIZED_VIEWS"),
+		Authorizer:        &rbacService,
+	}
+
+	if err := bookService.BackfillSearchTokens(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to backfill book search tokens")
+	}
+
+	if err := bookService.BuildSuggestIndex(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to build book suggest index")
+	}
+	log.Info().Int("books", bookService.SuggestIndex.Len()).Msg("book suggest index built")
+
+	if err := bookService.RebuildSimilarIndex(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to build similar books index")
+	}
+
+	savedSearchService := savedSearchServices.SavedSearch{
+		SavedSearchRepository: savedSearchStores.NewSavedSearch(db),
+		BookMatcher:           &bookService,
+		Transactor:            internalDb.NewTransactor(db),
+		Notifier:              savedSearchNotifier(),
+		Authorizer:            &rbacService,
+	}
+
+	idempotencyService := idempotencyServices.Idempotency{
+		IdempotencyRepository: idempotencyStores.NewIdempotency(db),
+		TTL:                   envDuration("IDEMPOTENCY_KEY_TTL", idempotencyServices.DefaultTTL),
+	}
+
+	tenantService := tenantServices.Tenant{
+		TenantRepository: tenantStores.NewTenant(db),
+		Authorizer:       &rbacService,
+		CacheTTL:         envDuration("TENANT_CACHE_TTL", tenantServices.DefaultCacheTTL),
+	}
+
+	usageService := usageServices.Usage{
+		UsageRepository: usageStores.NewUsage(db),
+		QuotaRepository: usageStores.NewQuota(db),
+		Authorizer:      &rbacService,
+	}
+
+	if err := usageService.Refresh(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to load usage quotas")
 	}
 
 	srv := &Server{
-		Router:      mux.NewRouter(),
-		DB:          db,
-		BookHandler: &handler.Handler{Service: &bookService},
+		Router:             mux.NewRouter(),
+		DB:                 db,
+		BookHandler:        &handler.Handler{Service: &bookService},
+		SavedSearchHandler: &savedSearchHandler.Handler{Service: &savedSearchService},
+		RoleHandler:        &rbacHandler.Handler{Service: &rbacService},
+		APIKeyHandler:      &apiKeyHandler.Handler{Service: &apiKeyService},
+		TenantHandler:      &tenantHandler.Handler{Service: &tenantService},
+		UsageHandler:       &usageHandler.Handler{Service: &usageService},
+		UserHandler:        &userHandler.Handler{Service: &userService, SecureCookies: secureCookies},
+		OIDCHandler: &oidcHandler.Handler{
+			Service:       &oidcService,
+			SecureCookies: secureCookies,
+			PostLoginURL:  os.Getenv("OIDC_POST_LOGIN_URL"),
+		},
+		bookService:        &bookService,
+		savedSearchService: &savedSearchService,
+		idempotencyService: &idempotencyService,
+		rbacService:        &rbacService,
+		apiKeyService:      &apiKeyService,
+		tenantService:      &tenantService,
+		rateLimitService:   rateLimitService(db),
+		usageService:       &usageService,
+		userService:        &userService,
+		verifier:           verifier,
+		trustForwarded:     envBool("RATE_LIMIT_TRUST_FORWARDED"),
+		tenantBaseDomain:   os.Getenv("TENANT_BASE_DOMAIN"),
+		tenantClaim:        tenantClaim(),
+		localAccounts:      localAccounts,
+		singleSignOn:       provider != nil,
 	}
 
 	srv.routes()
@@ -62,6 +307,19 @@ func (s *Server) Run(ctx context.Context, port string) error {
 
 	fmt.Println("Server started")
 
+	go s.bookService.RefreshSimilarIndex(ctx, envDuration("SIMILAR_INDEX_REFRESH_INTERVAL", defaultSimilarIndexRefreshInterval))
+	go s.bookService.RefreshStats(ctx, envDuration("STATS_REFRESH_INTERVAL", defaultStatsRefreshInterval))
+	go s.savedSearchService.RunEvaluator(ctx, envDuration("SAVED_SEARCH_EVALUATION_INTERVAL", defaultSavedSearchEvaluationInterval))
+	go s.idempotencyService.PurgeExpired(ctx, envDuration("IDEMPOTENCY_PURGE_INTERVAL", defaultIdempotencyPurgeInterval))
+	go s.apiKeyService.RunUsageFlusher(ctx, envDuration("API_KEY_USAGE_FLUSH_INTERVAL", defaultAPIKeyUsageFlushInterval))
+	go s.usageService.RunFlusher(ctx, envDuration("USAGE_FLUSH_INTERVAL", defaultUsageFlushInterval))
+	if s.rateLimitService != nil {
+		go s.rateLimitService.PurgeFull(ctx, envDuration("RATE_LIMIT_PURGE_INTERVAL", defaultRateLimitPurgeInterval))
+	}
+	if s.sessions() {
+		go s.userService.PurgeExpiredSessions(ctx, envDuration("SESSION_PURGE_INTERVAL", defaultSessionPurgeInterval))
+	}
+
 	log.Info().Msgf("server serving on port %s ", port)
 
 	go func() {
@@ -97,11 +355,19 @@ func (s *Server) Run(ctx context.Context, port string) error {
 	return err
 }
 
+// cors allows the origins in CORS_ALLOWED_ORIGINS, or any origin when it is
+// unset. Browsers only send the session cookie cross-origin to an origin
+// that is listed.
 func (s *Server) cors() *cors.Cors {
+	allowedOrigins := envList("CORS_ALLOWED_ORIGINS")
+	if len(allowedOrigins) == 0 {
+		allowedOrigins = []string{"*"}
+	}
 	return cors.New(cors.Options{
-		AllowedOrigins:     []string{"*"},
+		AllowedOrigins:     allowedOrigins,
 		AllowedMethods:     []string{"POST", "GET", "PUT", "DELETE", "HEAD", "OPTIONS"},
-		AllowedHeaders:     []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization"},
+		AllowedHeaders:     []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Idempotency-Key", "X-API-Key", "X-Tenant"},
+		ExposedHeaders:     []string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "X-Quota-Limit", "X-Quota-Remaining", "X-Quota-Reset", "X-Quota-Warning"},
 		MaxAge:             60, // 1 minutes
 		AllowCredentials:   true,
 		OptionsPassthrough: false,
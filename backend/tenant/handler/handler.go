@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/tenant"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type TenantService interface {
+	Create(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error)
+	GetByID(ctx context.Context, id int64) (*tenant.Tenant, error)
+	GetAll(ctx context.Context) ([]tenant.Tenant, error)
+	Update(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error)
+	Current(ctx context.Context) (*tenant.Tenant, error)
+}
+
+type Handler struct {
+	Service TenantService
+}
+
+// CreateTenant godoc
+// @Summary Create a tenant
+// @Description Create a tenant with an empty catalog. The slug names it in the X-Tenant header and as a subdomain and cannot change. Admins of the default tenant only.
+// @Tags tenants
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param request body tenant.Tenant true "Slug and name"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=tenant.Tenant}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 409 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/tenants [post]
+// CreateTenant handles creating a tenant
+func (h *Handler) CreateTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request tenant.Tenant
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.Create(r.Context(), &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// GetTenants godoc
+// @Summary List tenants
+// @Description List every tenant. Admins of the default tenant only.
+// @Tags tenants
+// @Produce json,xml,application/msgpack,text/csv
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=[]tenant.Tenant}
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/tenants [get]
+// GetTenants handles listing tenants
+func (h *Handler) GetTenants() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := h.Service.GetAll(r.Context())
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// GetTenantByID godoc
+// @Summary Get a tenant
+// @Description Get a tenant by ID. Admins of the default tenant only.
+// @Tags tenants
+// @Produce json,xml,application/msgpack
+// @Param id path int true "Tenant ID"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=tenant.Tenant}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/tenants/{id} [get]
+// GetTenantByID handles getting a tenant by ID
+func (h *Handler) GetTenantByID() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid tenant ID"), nil)
+			return
+		}
+
+		data, err := h.Service.GetByID(r.Context(), id)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// UpdateTenant godoc
+// @Summary Rename a tenant
+// @Description Change the name of a tenant; its slug stays. Admins of the default tenant only.
+// @Tags tenants
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param id path int true "Tenant ID"
+// @Param request body tenant.Tenant true "New name"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=tenant.Tenant}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/tenants/{id} [put]
+// UpdateTenant handles renaming a tenant
+func (h *Handler) UpdateTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrValidation("id", helper.CodeInvalid, "invalid tenant ID"), nil)
+			return
+		}
+
+		var request tenant.Tenant
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+		request.ID = id
+
+		data, err := h.Service.Update(r.Context(), &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// GetCurrentTenant godoc
+// @Summary Get the current tenant
+// @Description Get the tenant the request was resolved to: the one its credentials are bound to, else the one named by the X-Tenant header or the subdomain, else the default tenant.
+// @Tags tenants
+// @Produce json,xml,application/msgpack
+// @Param X-Tenant header string false "Slug of the tenant"
+// @Success 200 {object} helper.Response{data=tenant.Tenant}
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/tenant [get]
+// GetCurrentTenant handles getting the current tenant
+func (h *Handler) GetCurrentTenant() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := h.Service.Current(r.Context())
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
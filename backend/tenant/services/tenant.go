@@ -0,0 +1,217 @@
+package services
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"byfood-interview/tenant"
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type TenantRepository interface {
+	Create(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error)
+	GetByID(ctx context.Context, id int64) (*tenant.Tenant, error)
+	GetBySlug(ctx context.Context, slug string) (*tenant.Tenant, error)
+	GetAll(ctx context.Context) ([]tenant.Tenant, error)
+	Update(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error)
+}
+
+// DefaultCacheTTL is how long Resolve trusts a tenant it looked up when
+// CacheTTL is not set.
+const DefaultCacheTTL = time.Minute
+
+type cachedTenant struct {
+	tenant    *tenant.Tenant
+	expiresAt time.Time
+}
+
+// Tenant manages tenants and resolves the tenant of each request. Tenants
+// are managed by the admins of the default tenant, who operate the whole
+// service; the admins of other tenants manage only their own roles and keys.
+type Tenant struct {
+	TenantRepository TenantRepository
+	// Authorizer guards the tenant management operations.
+	Authorizer rbac.Authorizer
+	// CacheTTL is how long Resolve and ResolveID trust a tenant they looked
+	// up, so that most requests do not read it.
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedTenant
+	now   func() time.Time
+}
+
+func (s *Tenant) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *Tenant) cacheTTL() time.Duration {
+	if s.CacheTTL > 0 {
+		return s.CacheTTL
+	}
+	return DefaultCacheTTL
+}
+
+// authorize checks that the caller on ctx may manage tenants, which it may
+// when it acts for the default tenant and is one of its admins.
+func (s *Tenant) authorize(ctx context.Context) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	current := tenant.From(ctx)
+	if current == nil {
+		ctx = tenant.WithTenant(ctx, &tenant.Tenant{ID: tenant.DefaultID, Slug: tenant.DefaultSlug})
+	} else if current.ID != tenant.DefaultID {
+		return helper.NewErrForbidden("tenants are managed from the default tenant")
+	}
+	return s.Authorizer.Authorize(ctx, rbac.ActionManageTenants)
+}
+
+func (s *Tenant) Create(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error) {
+	log := log.Ctx(ctx).With().Str("service", "tenant").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := data.Validate(); err != nil {
+		return nil, err
+	}
+
+	created, err := s.TenantRepository.Create(ctx, data)
+	if err != nil {
+		if errors.Is(err, tenant.ErrSlugTaken) {
+			return nil, helper.NewErrConflict(err.Error())
+		}
+		log.Error().Err(err).Msg("failed to create tenant")
+		return nil, err
+	}
+	log.Info().Int64("tenant_id", created.ID).Str("slug", created.Slug).Msg("tenant created")
+	return created, nil
+}
+
+func (s *Tenant) GetByID(ctx context.Context, id int64) (*tenant.Tenant, error) {
+	log := log.Ctx(ctx).With().Str("service", "tenant").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	data, err := s.TenantRepository.GetByID(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrNotFound(tenant.ErrUnknownTenant.Error())
+		}
+		log.Error().Err(err).Msg("failed to get tenant by ID")
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *Tenant) GetAll(ctx context.Context) ([]tenant.Tenant, error) {
+	log := log.Ctx(ctx).With().Str("service", "tenant").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	tenants, err := s.TenantRepository.GetAll(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get tenants")
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// Update renames the tenant data.ID.
+func (s *Tenant) Update(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error) {
+	log := log.Ctx(ctx).With().Str("service", "tenant").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := data.ValidateUpdate(); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.TenantRepository.Update(ctx, data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, helper.NewErrNotFound(tenant.ErrUnknownTenant.Error())
+		}
+		log.Error().Err(err).Msg("failed to update tenant")
+		return nil, err
+	}
+	s.forget(updated)
+	return updated, nil
+}
+
+// Current returns the tenant of the request on ctx.
+func (s *Tenant) Current(ctx context.Context) (*tenant.Tenant, error) {
+	current := tenant.From(ctx)
+	if current == nil {
+		return nil, tenant.ErrNoTenant
+	}
+	return current, nil
+}
+
+// Resolve returns the tenant with slug, or tenant.ErrUnknownTenant.
+func (s *Tenant) Resolve(ctx context.Context, slug string) (*tenant.Tenant, error) {
+	return s.resolve(ctx, "slug:"+slug, func() (*tenant.Tenant, error) {
+		return s.TenantRepository.GetBySlug(ctx, slug)
+	})
+}
+
+// ResolveID returns the tenant id, or tenant.ErrUnknownTenant.
+func (s *Tenant) ResolveID(ctx context.Context, id int64) (*tenant.Tenant, error) {
+	return s.resolve(ctx, "id:"+strconv.FormatInt(id, 10), func() (*tenant.Tenant, error) {
+		return s.TenantRepository.GetByID(ctx, id)
+	})
+}
+
+// resolve returns the cached tenant under key, or looks it up with get and
+// caches it. Unknown tenants are not cached, so a new tenant is usable at
+// once.
+func (s *Tenant) resolve(ctx context.Context, key string, get func() (*tenant.Tenant, error)) (*tenant.Tenant, error) {
+	now := s.clock()
+
+	s.mu.Lock()
+	cached, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.tenant, nil
+	}
+
+	data, err := get()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, tenant.ErrUnknownTenant
+		}
+		log.Ctx(ctx).Error().Err(err).Str("service", "tenant").Msg("failed to resolve tenant")
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.cache == nil {
+		s.cache = map[string]cachedTenant{}
+	}
+	s.cache[key] = cachedTenant{tenant: data, expiresAt: now.Add(s.cacheTTL())}
+	s.mu.Unlock()
+	return data, nil
+}
+
+// forget drops the cached copies of t after it changed.
+func (s *Tenant) forget(t *tenant.Tenant) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cache, "slug:"+t.Slug)
+	delete(s.cache, "id:"+strconv.FormatInt(t.ID, 10))
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"byfood-interview/tenant"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// memoryTenants is an in-memory TenantRepository that counts its reads.
+type memoryTenants struct {
+	tenants []tenant.Tenant
+	reads   int
+}
+
+func (m *memoryTenants) Create(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error) {
+	for _, t := range m.tenants {
+		if t.Slug == data.Slug {
+			return nil, tenant.ErrSlugTaken
+		}
+	}
+	created := *data
+	created.ID = int64(len(m.tenants) + 1)
+	m.tenants = append(m.tenants, created)
+	return &created, nil
+}
+
+func (m *memoryTenants) GetByID(ctx context.Context, id int64) (*tenant.Tenant, error) {
+	m.reads++
+	for _, t := range m.tenants {
+		if t.ID == id {
+			return &t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryTenants) GetBySlug(ctx context.Context, slug string) (*tenant.Tenant, error) {
+	m.reads++
+	for _, t := range m.tenants {
+		if t.Slug == slug {
+			return &t, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryTenants) GetAll(ctx context.Context) ([]tenant.Tenant, error) {
+	return m.tenants, nil
+}
+
+func (m *memoryTenants) Update(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error) {
+	for i, t := range m.tenants {
+		if t.ID == data.ID {
+			m.tenants[i].Name = data.Name
+			updated := m.tenants[i]
+			return &updated, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// recordingAuthorizer allows every action and records the tenant it was
+// asked under.
+type recordingAuthorizer struct {
+	tenantID int64
+}
+
+func (a *recordingAuthorizer) Authorize(ctx context.Context, action rbac.Action) error {
+	a.tenantID, _ = tenant.IDFrom(ctx)
+	return nil
+}
+
+func TestResolveCachesTenants(t *testing.T) {
+	repo := &memoryTenants{tenants: []tenant.Tenant{{ID: 1, Slug: "default", Name: "Default"}}}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	service := &Tenant{TenantRepository: repo, CacheTTL: time.Minute, now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := service.Resolve(ctx, "default"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if repo.reads != 1 {
+		t.Fatalf("expected one read while cached, got %d", repo.reads)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := service.Resolve(ctx, "default"); err != nil || repo.reads != 2 {
+		t.Fatalf("expected a read once the cache expired, got %d reads, %v", repo.reads, err)
+	}
+
+	if _, err := service.Resolve(ctx, "acme"); !errors.Is(err, tenant.ErrUnknownTenant) {
+		t.Fatalf("expected ErrUnknownTenant, got %v", err)
+	}
+	if _, err := service.Create(ctx, &tenant.Tenant{Slug: "acme", Name: "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := service.Resolve(ctx, "acme"); err != nil || got.Name != "Acme" {
+		t.Fatalf("expected a new tenant to resolve at once, got %+v, %v", got, err)
+	}
+	if got, err := service.ResolveID(ctx, 2); err != nil || got.Slug != "acme" {
+		t.Fatalf("ResolveID = %+v, %v", got, err)
+	}
+
+	if _, err := service.Update(ctx, &tenant.Tenant{ID: 2, Name: "Acme Books"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, resolve := range []func() (*tenant.Tenant, error){
+		func() (*tenant.Tenant, error) { return service.Resolve(ctx, "acme") },
+		func() (*tenant.Tenant, error) { return service.ResolveID(ctx, 2) },
+	} {
+		if got, err := resolve(); err != nil || got.Name != "Acme Books" {
+			t.Fatalf("expected the rename to drop the cached tenant, got %+v, %v", got, err)
+		}
+	}
+}
+
+func TestManageTenants(t *testing.T) {
+	repo := &memoryTenants{tenants: []tenant.Tenant{{ID: 1, Slug: "default", Name: "Default"}}}
+	authorizer := &recordingAuthorizer{}
+	service := &Tenant{TenantRepository: repo, Authorizer: authorizer}
+
+	if _, err := service.Create(context.Background(), &tenant.Tenant{Slug: "acme", Name: "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if authorizer.tenantID != tenant.DefaultID {
+		t.Fatalf("expected the caller authorized as part of the default tenant, got tenant %d", authorizer.tenantID)
+	}
+
+	_, err := service.Create(context.Background(), &tenant.Tenant{Slug: "ACME", Name: "Other"})
+	if helper.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 for a taken slug, got %v", err)
+	}
+
+	acme := tenant.WithTenant(context.Background(), &tenant.Tenant{ID: 2, Slug: "acme"})
+	if _, err := service.GetAll(acme); helper.StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("expected 403 acting for another tenant, got %v", err)
+	}
+
+	if _, err := service.Update(context.Background(), &tenant.Tenant{ID: 9, Name: "Nobody"}); helper.StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("expected 404 renaming an unknown tenant, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/tenant"
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+const tenantColumns = "id, slug, name, created_at, updated_at"
+
+type Tenant struct {
+	db *sqlx.DB
+}
+
+func NewTenant(db *sqlx.DB) *Tenant {
+	return &Tenant{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *Tenant) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+// Create inserts the tenant. It returns tenant.ErrSlugTaken when the slug is
+// in use.
+func (s *Tenant) Create(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error) {
+	var created tenant.Tenant
+	query := "INSERT INTO tenants (slug, name) VALUES ($1, $2) RETURNING " + tenantColumns
+	if err := s.conn(ctx).GetContext(ctx, &created, query, data.Slug, data.Name); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, tenant.ErrSlugTaken
+		}
+		log.Error().Err(err).Msg("failed to insert tenant")
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (s *Tenant) GetByID(ctx context.Context, id int64) (*tenant.Tenant, error) {
+	var data tenant.Tenant
+	query := "SELECT " + tenantColumns + " FROM tenants WHERE id = $1"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, id); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *Tenant) GetBySlug(ctx context.Context, slug string) (*tenant.Tenant, error) {
+	var data tenant.Tenant
+	query := "SELECT " + tenantColumns + " FROM tenants WHERE slug = $1"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, slug); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *Tenant) GetAll(ctx context.Context) ([]tenant.Tenant, error) {
+	tenants := []tenant.Tenant{}
+	query := "SELECT " + tenantColumns + " FROM tenants ORDER BY id"
+	if err := s.conn(ctx).SelectContext(ctx, &tenants, query); err != nil {
+		return nil, err
+	}
+	return tenants, nil
+}
+
+// Update renames a tenant and returns the stored row. It returns
+// sql.ErrNoRows when there is no such tenant.
+func (s *Tenant) Update(ctx context.Context, data *tenant.Tenant) (*tenant.Tenant, error) {
+	var updated tenant.Tenant
+	query := "UPDATE tenants SET name = $2, updated_at = NOW() WHERE id = $1 RETURNING " + tenantColumns
+	if err := s.conn(ctx).GetContext(ctx, &updated, query, data.ID, data.Name); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
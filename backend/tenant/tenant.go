@@ -0,0 +1,128 @@
+// Package tenant partitions the catalog between tenants. Every request runs
+// for exactly one tenant, resolved by the server, and every tenant-owned row
+// is scoped to the tenant on the context of the call that reads or writes it.
+package tenant
+
+import (
+	"byfood-interview/helper"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrNoTenant is returned by stores called without a tenant on the
+	// context. It is a programming error, never the caller's.
+	ErrNoTenant      = errors.New("no tenant on context")
+	ErrUnknownTenant = errors.New("tenant not found")
+	ErrSlugTaken     = errors.New("a tenant with this slug already exists")
+)
+
+// The default tenant owns every row written before tenants existed and
+// serves requests that name no tenant.
+const (
+	DefaultID   int64 = 1
+	DefaultSlug       = "default"
+)
+
+// Header names the tenant of a request by its slug.
+const Header = "X-Tenant"
+
+// MaxNameLength is the longest tenant name accepted.
+const MaxNameLength = 255
+
+// slugPattern accepts DNS labels, so that every slug can be a subdomain.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Tenant is an isolated catalog. Slug names it in the X-Tenant header and
+// as a subdomain.
+type Tenant struct {
+	ID        int64     `json:"id" db:"id"`
+	Slug      string    `json:"slug" db:"slug"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Validate normalizes the slug and name of a tenant to be created and
+// reports every problem with them as a *helper.ErrValidation.
+func (t *Tenant) Validate() error {
+	t.Slug = strings.ToLower(strings.TrimSpace(t.Slug))
+	t.Name = strings.TrimSpace(t.Name)
+
+	var v helper.Validator
+	if t.Slug == "" {
+		v.Add("slug", helper.CodeRequired, "slug is required")
+	} else {
+		v.Check(ValidSlug(t.Slug), "slug", helper.CodeInvalid, "slug must be 1 to 63 lowercase letters, digits or inner hyphens")
+	}
+	t.validateName(&v)
+	return v.Err()
+}
+
+// ValidateUpdate is Validate for an update, which may only rename a tenant:
+// slugs are part of URLs and tokens, so they never change.
+func (t *Tenant) ValidateUpdate() error {
+	t.Name = strings.TrimSpace(t.Name)
+
+	var v helper.Validator
+	t.validateName(&v)
+	return v.Err()
+}
+
+func (t *Tenant) validateName(v *helper.Validator) {
+	v.Check(t.Name != "", "name", helper.CodeRequired, "name is required")
+	v.Check(utf8.RuneCountInString(t.Name) <= MaxNameLength, "name", helper.CodeTooLong,
+		fmt.Sprintf("name must be at most %d characters", MaxNameLength))
+}
+
+// ValidSlug reports whether slug is a valid tenant slug.
+func ValidSlug(slug string) bool {
+	return slugPattern.MatchString(slug)
+}
+
+// SlugFromHost returns the slug of a request to host, the label directly
+// below baseDomain, or "" when host is baseDomain itself or outside it.
+func SlugFromHost(host, baseDomain string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	baseDomain = strings.ToLower(strings.Trim(baseDomain, "."))
+	if baseDomain == "" {
+		return ""
+	}
+
+	label, ok := strings.CutSuffix(host, "."+baseDomain)
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+type tenantKey struct{}
+
+// WithTenant returns ctx carrying t.
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// From returns the tenant on ctx, or nil when there is none.
+func From(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(tenantKey{}).(*Tenant)
+	return t
+}
+
+// IDFrom returns the ID of the tenant on ctx, or ErrNoTenant.
+func IDFrom(ctx context.Context) (int64, error) {
+	t := From(ctx)
+	if t == nil {
+		return 0, ErrNoTenant
+	}
+	return t.ID, nil
+}
@@ -0,0 +1,81 @@
+package tenant
+
+import (
+	"byfood-interview/helper"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := &Tenant{Slug: " Acme-Books ", Name: " Acme Books "}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected a valid tenant, got %v", err)
+	}
+	if valid.Slug != "acme-books" || valid.Name != "Acme Books" {
+		t.Errorf("expected the slug and name normalized, got %q %q", valid.Slug, valid.Name)
+	}
+
+	cases := []struct {
+		tenant Tenant
+		field  string
+	}{
+		{Tenant{Name: "Acme"}, "slug"},
+		{Tenant{Slug: "-acme", Name: "Acme"}, "slug"},
+		{Tenant{Slug: "acme-", Name: "Acme"}, "slug"},
+		{Tenant{Slug: "acme.books", Name: "Acme"}, "slug"},
+		{Tenant{Slug: strings.Repeat("a", 64), Name: "Acme"}, "slug"},
+		{Tenant{Slug: "acme"}, "name"},
+		{Tenant{Slug: "acme", Name: strings.Repeat("a", MaxNameLength+1)}, "name"},
+	}
+	for _, tc := range cases {
+		err := tc.tenant.Validate()
+		var verr *helper.ErrValidation
+		if !errors.As(err, &verr) {
+			t.Errorf("%+v: expected a validation error, got %v", tc.tenant, err)
+			continue
+		}
+		if len(verr.Errors) != 1 || verr.Errors[0].Field != tc.field {
+			t.Errorf("%+v: expected a problem with %s, got %+v", tc.tenant, tc.field, verr.Errors)
+		}
+	}
+
+	rename := &Tenant{Name: "Acme"}
+	if err := rename.ValidateUpdate(); err != nil {
+		t.Errorf("expected a rename without a slug to be valid, got %v", err)
+	}
+}
+
+func TestSlugFromHost(t *testing.T) {
+	cases := []struct {
+		host, baseDomain, slug string
+	}{
+		{"acme.books.example.com", "books.example.com", "acme"},
+		{"ACME.Books.Example.com:8080", "books.example.com", "acme"},
+		{"acme.books.example.com.", ".books.example.com", "acme"},
+		{"books.example.com", "books.example.com", ""},
+		{"a.acme.books.example.com", "books.example.com", ""},
+		{"acme.other.com", "books.example.com", ""},
+		{"acmebooks.example.com", "books.example.com", ""},
+		{"acme.books.example.com", "", ""},
+	}
+	for _, tc := range cases {
+		if got := SlugFromHost(tc.host, tc.baseDomain); got != tc.slug {
+			t.Errorf("SlugFromHost(%q, %q) = %q, want %q", tc.host, tc.baseDomain, got, tc.slug)
+		}
+	}
+}
+
+func TestIDFrom(t *testing.T) {
+	if _, err := IDFrom(context.Background()); !errors.Is(err, ErrNoTenant) {
+		t.Fatalf("expected ErrNoTenant without a tenant, got %v", err)
+	}
+	ctx := WithTenant(context.Background(), &Tenant{ID: 7, Slug: "acme"})
+	if id, err := IDFrom(ctx); err != nil || id != 7 {
+		t.Fatalf("IDFrom = %d, %v", id, err)
+	}
+	if From(ctx).Slug != "acme" {
+		t.Fatalf("expected the tenant on the context, got %+v", From(ctx))
+	}
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/usage"
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type UsageService interface {
+	Report(ctx context.Context, q usage.ReportQuery) ([]usage.Rollup, error)
+	GetQuotas(ctx context.Context) ([]usage.Quota, error)
+	SetQuota(ctx context.Context, data *usage.Quota) (*usage.Quota, error)
+	DeleteQuota(ctx context.Context, consumer string) error
+}
+
+type Handler struct {
+	Service UsageService
+}
+
+// SetQuotaRequest is the body of SetQuota.
+type SetQuotaRequest struct {
+	WarnRequests  int64 `json:"warn_requests" example:"80000"`
+	LimitRequests int64 `json:"limit_requests" example:"100000"`
+}
+
+// GetUsageReport godoc
+// @Summary Report API usage
+// @Description Sum the requests, request bytes and response bytes of each consumer of each endpoint by hour, day or month (UTC). Consumers are principals, named by their token subject such as api-key:12, or anonymous. Usage is written in batches, so the last minute or so may be missing. Ask for text/csv to export it. Admins only.
+// @Tags usage
+// @Produce json,xml,application/msgpack,text/csv
+// @Param from query string false "Start of the report, as a date (2006-01-02) or RFC 3339 time (default start of the month of to)"
+// @Param to query string false "End of the report, as a date or RFC 3339 time (default now)"
+// @Param consumer query string false "Only the usage of this consumer"
+// @Param interval query string false "Period to sum by: hour, day or month (default month)"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=[]usage.Rollup}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/usage [get]
+// GetUsageReport handles reporting API usage
+func (h *Handler) GetUsageReport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		q := usage.ReportQuery{Consumer: values.Get("consumer"), Interval: values.Get("interval")}
+
+		var err error
+		if v := values.Get("from"); v != "" {
+			if q.From, err = helper.ParseTime(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("from", helper.CodeInvalid, "invalid from"), nil)
+				return
+			}
+		}
+		if v := values.Get("to"); v != "" {
+			if q.To, err = helper.ParseTime(v); err != nil {
+				helper.WriteResponse(w, r, helper.NewErrValidation("to", helper.CodeInvalid, "invalid to"), nil)
+				return
+			}
+		}
+
+		data, err := h.Service.Report(r.Context(), q)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// GetQuotas godoc
+// @Summary List usage quotas
+// @Description List the monthly request quotas of consumers. Consumers without one are not limited. Admins only.
+// @Tags usage
+// @Produce json,xml,application/msgpack,text/csv
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=[]usage.Quota}
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/usage/quotas [get]
+// GetQuotas handles listing usage quotas
+func (h *Handler) GetQuotas() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := h.Service.GetQuotas(r.Context())
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// SetQuota godoc
+// @Summary Set the usage quota of a consumer
+// @Description Set the monthly request quota of a consumer, replacing the one it had. Past warn_requests its responses carry X-Quota-Warning; past limit_requests it is refused with 429 until the month ends. Either may be 0 for no such threshold. Admins only.
+// @Tags usage
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param consumer path string true "Token subject of the consumer, such as api-key:12"
+// @Param request body SetQuotaRequest true "Monthly thresholds"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=usage.Quota}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/usage/quotas/{consumer} [put]
+// SetQuota handles setting the usage quota of a consumer
+func (h *Handler) SetQuota() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request SetQuotaRequest
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.SetQuota(r.Context(), &usage.Quota{
+			Consumer:      mux.Vars(r)["consumer"],
+			WarnRequests:  request.WarnRequests,
+			LimitRequests: request.LimitRequests,
+		})
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// DeleteQuota godoc
+// @Summary Delete the usage quota of a consumer
+// @Description Remove the monthly request quota of a consumer, leaving its usage unlimited. Admins only.
+// @Tags usage
+// @Produce json,xml,application/msgpack
+// @Param consumer path string true "Token subject of the consumer"
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{}
+// @Failure 401 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 404 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/usage/quotas/{consumer} [delete]
+// DeleteQuota handles deleting the usage quota of a consumer
+func (h *Handler) DeleteQuota() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Service.DeleteQuota(r.Context(), mux.Vars(r)["consumer"]); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, "Usage quota deleted successfully")
+	}
+}
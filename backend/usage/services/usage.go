@@ -0,0 +1,340 @@
+package services
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"byfood-interview/rbac"
+	"byfood-interview/tenant"
+	"byfood-interview/usage"
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type UsageRepository interface {
+	Add(ctx context.Context, counts map[usage.Key]usage.Counts) error
+	RequestsByConsumer(ctx context.Context, from, to time.Time) (map[usage.Consumer]int64, error)
+	Report(ctx context.Context, q usage.ReportQuery) ([]usage.Rollup, error)
+}
+
+type QuotaRepository interface {
+	GetAll(ctx context.Context) ([]usage.Quota, error)
+	GetAllTenants(ctx context.Context) ([]usage.Quota, error)
+	Upsert(ctx context.Context, data *usage.Quota) (*usage.Quota, error)
+	Delete(ctx context.Context, consumer string) error
+}
+
+// Usage meters the requests of every consumer and enforces their monthly
+// quotas without touching the database on the way: requests are counted in
+// memory and written by Flush, which also refreshes the monthly totals and
+// quotas that Check decides on. Each replica thus sees the requests of the
+// others as of its last flush, and a consumer may overrun its quota by what
+// the other replicas counted since.
+type Usage struct {
+	UsageRepository UsageRepository
+	QuotaRepository QuotaRepository
+	// Authorizer guards the reports and quota management.
+	Authorizer rbac.Authorizer
+
+	mu sync.Mutex
+	// pending is counted since the last flush.
+	pending map[usage.Key]usage.Counts
+	// month is the month the requests below are counted in: stored as of
+	// the last refresh, flushing while a flush writes them, and unstored
+	// since.
+	month    time.Time
+	stored   map[usage.Consumer]int64
+	flushing map[usage.Consumer]int64
+	unstored map[usage.Consumer]int64
+	quotas   map[usage.Consumer]usage.Quota
+	warned   map[usage.Consumer]bool
+	now      func() time.Time
+}
+
+func (s *Usage) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *Usage) authorize(ctx context.Context) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	return s.Authorizer.Authorize(ctx, rbac.ActionManageUsage)
+}
+
+// rollover starts counting the month of now over when it is a new one.
+// s.mu must be held.
+func (s *Usage) rollover(now time.Time) {
+	if month := usage.Month(now); !month.Equal(s.month) {
+		s.month = month
+		s.stored, s.flushing, s.unstored, s.warned = nil, nil, nil, nil
+	}
+}
+
+// used returns the requests of c this month. s.mu must be held.
+func (s *Usage) used(c usage.Consumer) int64 {
+	return s.stored[c] + s.flushing[c] + s.unstored[c]
+}
+
+// Record counts a request of consumer to endpoint in the tenant on ctx.
+func (s *Usage) Record(ctx context.Context, consumer, endpoint string, counts usage.Counts) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("service", "usage").Msg("failed to record usage")
+		return
+	}
+	c := usage.Consumer{TenantID: tenantID, Subject: consumer}
+	now := s.clock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollover(now)
+	s.add(usage.Key{Consumer: c, Endpoint: endpoint, Hour: usage.Hour(now)}, counts)
+	addTo(&s.unstored, c, counts.Requests)
+}
+
+// add counts requests under key. s.mu must be held.
+func (s *Usage) add(key usage.Key, counts usage.Counts) {
+	if s.pending == nil {
+		s.pending = make(map[usage.Key]usage.Counts)
+	}
+	c := s.pending[key]
+	c.Add(counts)
+	s.pending[key] = c
+}
+
+func addTo(m *map[usage.Consumer]int64, c usage.Consumer, n int64) {
+	if *m == nil {
+		*m = make(map[usage.Consumer]int64)
+	}
+	(*m)[c] += n
+}
+
+// Check returns where consumer in the tenant on ctx stands against its
+// monthly quota with one more request, or nil when it has no quota.
+func (s *Usage) Check(ctx context.Context, consumer string) *usage.Status {
+	log := log.Ctx(ctx).With().Str("service", "usage").Logger()
+
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil
+	}
+	c := usage.Consumer{TenantID: tenantID, Subject: consumer}
+	now := s.clock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	quota, ok := s.quotas[c]
+	if !ok {
+		return nil
+	}
+	s.rollover(now)
+	status := quota.Check(s.used(c), now)
+
+	switch {
+	case status.Blocked:
+		log.Info().Str("consumer", consumer).Int64("limit", quota.LimitRequests).Msg("usage quota exceeded")
+	case status.Warning && !s.warned[c]:
+		if s.warned == nil {
+			s.warned = make(map[usage.Consumer]bool)
+		}
+		s.warned[c] = true
+		log.Warn().Str("consumer", consumer).Int64("warn", quota.WarnRequests).Msg("usage quota warning threshold reached")
+	}
+	return &status
+}
+
+// Flush writes the usage counted since the last flush in one batch and then
+// refreshes the monthly totals and quotas. Usage that fails to be written is
+// kept for the next flush.
+func (s *Usage) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	pending, month := s.pending, s.month
+	s.pending = nil
+	s.flushing, s.unstored = s.unstored, nil
+	s.mu.Unlock()
+
+	if err := s.UsageRepository.Add(ctx, pending); err != nil {
+		s.mu.Lock()
+		for key, counts := range pending {
+			s.add(key, counts)
+		}
+		if s.month.Equal(month) {
+			for c, n := range s.flushing {
+				addTo(&s.unstored, c, n)
+			}
+			s.flushing = nil
+		}
+		s.mu.Unlock()
+		return err
+	}
+	return s.Refresh(ctx)
+}
+
+// Refresh reads the monthly totals of every consumer and the quotas of
+// every tenant, as Check needs them.
+func (s *Usage) Refresh(ctx context.Context) error {
+	month := usage.Month(s.clock())
+	totals, err := s.UsageRepository.RequestsByConsumer(ctx, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		// What was flushed is still counted until the next refresh.
+		s.mu.Lock()
+		for c, n := range s.flushing {
+			addTo(&s.stored, c, n)
+		}
+		s.flushing = nil
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	s.rollover(month)
+	s.stored, s.flushing = totals, nil
+	s.mu.Unlock()
+
+	quotas, err := s.QuotaRepository.GetAllTenants(ctx)
+	if err != nil {
+		return err
+	}
+	byConsumer := make(map[usage.Consumer]usage.Quota, len(quotas))
+	for _, q := range quotas {
+		byConsumer[usage.Consumer{TenantID: q.TenantID, Subject: q.Consumer}] = q
+	}
+
+	s.mu.Lock()
+	s.quotas = byConsumer
+	s.mu.Unlock()
+	return nil
+}
+
+// RunFlusher flushes usage every interval until ctx is cancelled, and once
+// more then so counts are not lost on shutdown.
+func (s *Usage) RunFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Flush(context.WithoutCancel(ctx)); err != nil {
+				log.Error().Err(err).Msg("failed to flush usage on shutdown")
+			}
+			return
+		case <-ticker.C:
+			if err := s.Flush(ctx); err != nil {
+				log.Error().Err(err).Msg("failed to flush usage")
+			}
+		}
+	}
+}
+
+// Report sums the usage of the tenant on ctx selected by q. It covers the
+// requests flushed so far, which lag behind by up to a flush interval.
+func (s *Usage) Report(ctx context.Context, q usage.ReportQuery) ([]usage.Rollup, error) {
+	log := log.Ctx(ctx).With().Str("service", "usage").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	if q.Interval == "" {
+		q.Interval = usage.IntervalMonth
+	}
+	if q.To.IsZero() {
+		q.To = s.clock()
+	}
+	if q.From.IsZero() {
+		q.From = usage.Month(q.To)
+	}
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	rollups, err := s.UsageRepository.Report(ctx, q)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to report usage")
+		return nil, err
+	}
+	return rollups, nil
+}
+
+// GetQuotas lists the quotas of the tenant on ctx.
+func (s *Usage) GetQuotas(ctx context.Context) ([]usage.Quota, error) {
+	log := log.Ctx(ctx).With().Str("service", "usage").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	quotas, err := s.QuotaRepository.GetAll(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get usage quotas")
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// SetQuota sets the quota of data.Consumer, replacing any it had. It applies
+// at once on this replica and from their next refresh on the others.
+func (s *Usage) SetQuota(ctx context.Context, data *usage.Quota) (*usage.Quota, error) {
+	log := log.Ctx(ctx).With().Str("service", "usage").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if err := data.Validate(); err != nil {
+		return nil, err
+	}
+	if principal := auth.PrincipalFrom(ctx); principal != nil {
+		data.UpdatedBy = principal.Subject
+	}
+
+	saved, err := s.QuotaRepository.Upsert(ctx, data)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to set usage quota")
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.quotas == nil {
+		s.quotas = make(map[usage.Consumer]usage.Quota)
+	}
+	c := usage.Consumer{TenantID: saved.TenantID, Subject: saved.Consumer}
+	s.quotas[c] = *saved
+	delete(s.warned, c)
+	s.mu.Unlock()
+
+	log.Info().Str("consumer", saved.Consumer).Int64("warn", saved.WarnRequests).Int64("limit", saved.LimitRequests).Msg("usage quota set")
+	return saved, nil
+}
+
+// DeleteQuota removes the quota of consumer, leaving its usage unlimited.
+func (s *Usage) DeleteQuota(ctx context.Context, consumer string) error {
+	log := log.Ctx(ctx).With().Str("service", "usage").Logger()
+
+	if err := s.authorize(ctx); err != nil {
+		return err
+	}
+
+	if err := s.QuotaRepository.Delete(ctx, consumer); err != nil {
+		if err == sql.ErrNoRows {
+			return helper.NewErrNotFound("usage quota not found")
+		}
+		log.Error().Err(err).Msg("failed to delete usage quota")
+		return err
+	}
+
+	if tenantID, err := tenant.IDFrom(ctx); err == nil {
+		s.mu.Lock()
+		delete(s.quotas, usage.Consumer{TenantID: tenantID, Subject: consumer})
+		s.mu.Unlock()
+	}
+	log.Info().Str("consumer", consumer).Msg("usage quota deleted")
+	return nil
+}
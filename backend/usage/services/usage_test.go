@@ -0,0 +1,222 @@
+package services
+
+import (
+	"byfood-interview/internal/testutil"
+	"byfood-interview/tenant"
+	"byfood-interview/usage"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// memoryUsage is an in-memory UsageRepository that counts its batches.
+type memoryUsage struct {
+	rollups map[usage.Key]usage.Counts
+	batches int
+	failAdd bool
+}
+
+func (m *memoryUsage) Add(ctx context.Context, counts map[usage.Key]usage.Counts) error {
+	if m.failAdd {
+		return errors.New("database unavailable")
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+	m.batches++
+	if m.rollups == nil {
+		m.rollups = map[usage.Key]usage.Counts{}
+	}
+	for key, c := range counts {
+		sum := m.rollups[key]
+		sum.Add(c)
+		m.rollups[key] = sum
+	}
+	return nil
+}
+
+func (m *memoryUsage) RequestsByConsumer(ctx context.Context, from, to time.Time) (map[usage.Consumer]int64, error) {
+	totals := map[usage.Consumer]int64{}
+	for key, c := range m.rollups {
+		if !key.Hour.Before(from) && key.Hour.Before(to) {
+			totals[key.Consumer] += c.Requests
+		}
+	}
+	return totals, nil
+}
+
+func (m *memoryUsage) Report(ctx context.Context, q usage.ReportQuery) ([]usage.Rollup, error) {
+	return nil, nil
+}
+
+// memoryQuotas is an in-memory QuotaRepository.
+type memoryQuotas struct {
+	quotas []usage.Quota
+}
+
+func (m *memoryQuotas) GetAll(ctx context.Context) ([]usage.Quota, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var quotas []usage.Quota
+	for _, q := range m.quotas {
+		if q.TenantID == tenantID {
+			quotas = append(quotas, q)
+		}
+	}
+	return quotas, nil
+}
+
+func (m *memoryQuotas) GetAllTenants(ctx context.Context) ([]usage.Quota, error) {
+	return m.quotas, nil
+}
+
+func (m *memoryQuotas) Upsert(ctx context.Context, data *usage.Quota) (*usage.Quota, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	saved := *data
+	saved.TenantID = tenantID
+	for i, q := range m.quotas {
+		if q.TenantID == tenantID && q.Consumer == data.Consumer {
+			m.quotas[i] = saved
+			return &saved, nil
+		}
+	}
+	m.quotas = append(m.quotas, saved)
+	return &saved, nil
+}
+
+func (m *memoryQuotas) Delete(ctx context.Context, consumer string) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+	for i, q := range m.quotas {
+		if q.TenantID == tenantID && q.Consumer == consumer {
+			m.quotas = append(m.quotas[:i], m.quotas[i+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func newService() (*Usage, *memoryUsage, *memoryQuotas, *testutil.Clock) {
+	rollups, quotas := &memoryUsage{}, &memoryQuotas{}
+	c := testutil.NewClock(time.Date(2024, 1, 31, 22, 30, 0, 0, time.UTC))
+	return &Usage{UsageRepository: rollups, QuotaRepository: quotas, now: c.Now}, rollups, quotas, c
+}
+
+func withTenant(id int64) context.Context {
+	return tenant.WithTenant(context.Background(), &tenant.Tenant{ID: id})
+}
+
+func TestFlush(t *testing.T) {
+	service, repo, _, c := newService()
+	ctx := withTenant(1)
+
+	service.Record(ctx, "api-key:1", "GET /api/v1/books", usage.Counts{Requests: 1, ResponseBytes: 100})
+	service.Record(ctx, "api-key:1", "GET /api/v1/books", usage.Counts{Requests: 1, ResponseBytes: 50})
+	service.Record(ctx, "api-key:1", "POST /api/v1/books", usage.Counts{Requests: 1, RequestBytes: 30, ResponseBytes: 80})
+
+	repo.failAdd = true
+	if err := service.Flush(ctx); err == nil {
+		t.Fatal("expected the failed flush to be reported")
+	}
+	repo.failAdd = false
+	c.Advance(time.Hour)
+	service.Record(ctx, "api-key:1", "GET /api/v1/books", usage.Counts{Requests: 1, ResponseBytes: 10})
+	if err := service.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.batches != 1 {
+		t.Fatalf("expected usage written in one batch, got %d", repo.batches)
+	}
+	first := time.Date(2024, 1, 31, 22, 0, 0, 0, time.UTC)
+	consumer := usage.Consumer{TenantID: 1, Subject: "api-key:1"}
+	want := map[usage.Key]usage.Counts{
+		{Consumer: consumer, Endpoint: "GET /api/v1/books", Hour: first}:                {Requests: 2, ResponseBytes: 150},
+		{Consumer: consumer, Endpoint: "POST /api/v1/books", Hour: first}:               {Requests: 1, RequestBytes: 30, ResponseBytes: 80},
+		{Consumer: consumer, Endpoint: "GET /api/v1/books", Hour: first.Add(time.Hour)}: {Requests: 1, ResponseBytes: 10},
+	}
+	if len(repo.rollups) != len(want) {
+		t.Fatalf("expected %d rollups, got %v", len(want), repo.rollups)
+	}
+	for key, counts := range want {
+		if repo.rollups[key] != counts {
+			t.Errorf("rollup %+v = %+v, want %+v", key, repo.rollups[key], counts)
+		}
+	}
+
+	if err := service.Flush(ctx); err != nil || repo.batches != 1 {
+		t.Fatalf("expected an idle flush to write nothing, got %d batches (%v)", repo.batches, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	service, repo, _, c := newService()
+	c.Set(time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC))
+	acme, other := withTenant(2), withTenant(3)
+	endpoint := "GET /api/v1/books"
+
+	if status := service.Check(acme, "api-key:7"); status != nil {
+		t.Fatalf("expected no status without a quota, got %+v", status)
+	}
+	if _, err := service.SetQuota(acme, &usage.Quota{Consumer: "api-key:7", WarnRequests: 2, LimitRequests: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(ctx context.Context) *usage.Status {
+		status := service.Check(ctx, "api-key:7")
+		if status == nil || !status.Blocked {
+			service.Record(ctx, "api-key:7", endpoint, usage.Counts{Requests: 1})
+		}
+		return status
+	}
+
+	if status := serve(acme); status.Warning || status.Remaining() != 2 {
+		t.Fatalf("expected the first request allowed quietly, got %+v", status)
+	}
+	if err := service.Flush(acme); err != nil {
+		t.Fatal(err)
+	}
+	if status := serve(acme); !status.Warning || status.Blocked || status.Used != 2 {
+		t.Fatalf("expected a warning at the second request across the flush, got %+v", status)
+	}
+	serve(acme)
+	if status := serve(acme); !status.Blocked || status.Used != 3 {
+		t.Fatalf("expected the fourth request blocked, got %+v", status)
+	}
+	if status := serve(other); status != nil {
+		t.Fatalf("expected the quota to apply to its tenant only, got %+v", status)
+	}
+
+	// A restarted replica learns the quota and usage from the database.
+	if err := service.Flush(acme); err != nil {
+		t.Fatal(err)
+	}
+	restarted := &Usage{UsageRepository: repo, QuotaRepository: service.QuotaRepository, now: c.Now}
+	if err := restarted.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status := restarted.Check(acme, "api-key:7"); status == nil || !status.Blocked {
+		t.Fatalf("expected the refreshed replica to block, got %+v", status)
+	}
+
+	c.Set(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	if status := serve(acme); status.Blocked || status.Used != 1 {
+		t.Fatalf("expected the quota to start over with the month, got %+v", status)
+	}
+
+	if err := service.DeleteQuota(acme, "api-key:7"); err != nil {
+		t.Fatal(err)
+	}
+	if status := service.Check(acme, "api-key:7"); status != nil {
+		t.Fatalf("expected no status once the quota is deleted, got %+v", status)
+	}
+}
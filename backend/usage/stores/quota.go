@@ -0,0 +1,86 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/tenant"
+	"byfood-interview/usage"
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const quotaColumns = "tenant_id, consumer, warn_requests, limit_requests, updated_by, created_at, updated_at"
+
+// Quota stores the monthly quotas of consumers. Every call but
+// GetAllTenants reads and writes the quotas of the tenant on its context.
+type Quota struct {
+	db *sqlx.DB
+}
+
+func NewQuota(db *sqlx.DB) *Quota {
+	return &Quota{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *Quota) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+func (s *Quota) GetAll(ctx context.Context) ([]usage.Quota, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := []usage.Quota{}
+	query := "SELECT " + quotaColumns + " FROM usage_quotas WHERE tenant_id = $1 ORDER BY consumer"
+	if err := s.conn(ctx).SelectContext(ctx, &quotas, query, tenantID); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// GetAllTenants returns the quotas of every tenant.
+func (s *Quota) GetAllTenants(ctx context.Context) ([]usage.Quota, error) {
+	quotas := []usage.Quota{}
+	query := "SELECT " + quotaColumns + " FROM usage_quotas"
+	if err := s.conn(ctx).SelectContext(ctx, &quotas, query); err != nil {
+		log.Error().Err(err).Msg("failed to get usage quotas of every tenant")
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// Upsert sets the quota of data.Consumer, replacing any it had.
+func (s *Quota) Upsert(ctx context.Context, data *usage.Quota) (*usage.Quota, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var saved usage.Quota
+	query := `INSERT INTO usage_quotas (tenant_id, consumer, warn_requests, limit_requests, updated_by) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (tenant_id, consumer) DO UPDATE SET warn_requests = EXCLUDED.warn_requests,
+		limit_requests = EXCLUDED.limit_requests, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+	RETURNING ` + quotaColumns
+	if err := s.conn(ctx).GetContext(ctx, &saved, query, tenantID, data.Consumer, data.WarnRequests, data.LimitRequests, data.UpdatedBy); err != nil {
+		log.Error().Err(err).Msg("failed to upsert usage quota")
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// Delete removes the quota of consumer. It returns sql.ErrNoRows when there
+// is none.
+func (s *Quota) Delete(ctx context.Context, consumer string) error {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return err
+	}
+
+	var deleted string
+	query := "DELETE FROM usage_quotas WHERE tenant_id = $1 AND consumer = $2 RETURNING consumer"
+	return s.conn(ctx).GetContext(ctx, &deleted, query, tenantID, consumer)
+}
@@ -0,0 +1,116 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/tenant"
+	"byfood-interview/usage"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+// Usage stores the hourly usage rollups of every tenant. Add and
+// RequestsByConsumer span tenants, for the flusher; Report reads the tenant
+// on its context.
+type Usage struct {
+	db *sqlx.DB
+}
+
+func NewUsage(db *sqlx.DB) *Usage {
+	return &Usage{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *Usage) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+// Add adds counts to their rollups in a single statement, creating the
+// rollups missing.
+func (s *Usage) Add(ctx context.Context, counts map[usage.Key]usage.Counts) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	var (
+		tenantIDs, requests, requestBytes, responseBytes []int64
+		consumers, endpoints, hours                      []string
+	)
+	for key, c := range counts {
+		tenantIDs = append(tenantIDs, key.TenantID)
+		consumers = append(consumers, key.Subject)
+		endpoints = append(endpoints, key.Endpoint)
+		hours = append(hours, key.Hour.UTC().Format(time.RFC3339))
+		requests = append(requests, c.Requests)
+		requestBytes = append(requestBytes, c.RequestBytes)
+		responseBytes = append(responseBytes, c.ResponseBytes)
+	}
+
+	query := `INSERT INTO usage_hourly (tenant_id, consumer, endpoint, hour, requests, request_bytes, response_bytes)
+	SELECT * FROM unnest($1::INTEGER[], $2::VARCHAR[], $3::VARCHAR[], $4::TIMESTAMPTZ[], $5::BIGINT[], $6::BIGINT[], $7::BIGINT[])
+	ON CONFLICT (tenant_id, consumer, endpoint, hour) DO UPDATE SET
+		requests = usage_hourly.requests + EXCLUDED.requests,
+		request_bytes = usage_hourly.request_bytes + EXCLUDED.request_bytes,
+		response_bytes = usage_hourly.response_bytes + EXCLUDED.response_bytes`
+	_, err := s.conn(ctx).ExecContext(ctx, query,
+		pq.Array(tenantIDs), pq.Array(consumers), pq.Array(endpoints), pq.Array(hours),
+		pq.Array(requests), pq.Array(requestBytes), pq.Array(responseBytes))
+	if err != nil {
+		log.Error().Err(err).Int("rollups", len(counts)).Msg("failed to add usage")
+		return err
+	}
+	return nil
+}
+
+// RequestsByConsumer sums the requests of every consumer of every tenant
+// during the hours from from until to.
+func (s *Usage) RequestsByConsumer(ctx context.Context, from, to time.Time) (map[usage.Consumer]int64, error) {
+	var rows []struct {
+		TenantID int64  `db:"tenant_id"`
+		Consumer string `db:"consumer"`
+		Requests int64  `db:"requests"`
+	}
+	query := `SELECT tenant_id, consumer, SUM(requests)::BIGINT AS requests
+	FROM usage_hourly WHERE hour >= $1 AND hour < $2
+	GROUP BY tenant_id, consumer`
+	if err := s.conn(ctx).SelectContext(ctx, &rows, query, from, to); err != nil {
+		log.Error().Err(err).Msg("failed to sum usage by consumer")
+		return nil, err
+	}
+
+	totals := make(map[usage.Consumer]int64, len(rows))
+	for _, row := range rows {
+		totals[usage.Consumer{TenantID: row.TenantID, Subject: row.Consumer}] = row.Requests
+	}
+	return totals, nil
+}
+
+// Report sums the rollups of the tenant on ctx selected by q by consumer,
+// endpoint and period, oldest period first.
+func (s *Usage) Report(ctx context.Context, q usage.ReportQuery) ([]usage.Rollup, error) {
+	tenantID, err := tenant.IDFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{tenantID, q.Interval, usage.Hour(q.From), q.To}
+	query := `SELECT date_trunc($2, hour AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS period, consumer, endpoint,
+		SUM(requests)::BIGINT AS requests, SUM(request_bytes)::BIGINT AS request_bytes, SUM(response_bytes)::BIGINT AS response_bytes
+	FROM usage_hourly WHERE tenant_id = $1 AND hour >= $3 AND hour < $4`
+	if q.Consumer != "" {
+		args = append(args, q.Consumer)
+		query += " AND consumer = $5"
+	}
+	query += " GROUP BY period, consumer, endpoint ORDER BY period, consumer, endpoint"
+
+	rollups := []usage.Rollup{}
+	if err := s.conn(ctx).SelectContext(ctx, &rollups, query, args...); err != nil {
+		log.Error().Err(err).Msg("failed to report usage")
+		return nil, err
+	}
+	return rollups, nil
+}
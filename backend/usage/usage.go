@@ -0,0 +1,172 @@
+// Package usage meters the requests of each API consumer for billing. Every
+// request is counted in memory under its consumer, endpoint and hour, and
+// the counts are written to hourly rollups in batches; monthly quotas warn
+// consumers nearing them and block those past them.
+package usage
+
+import (
+	"byfood-interview/helper"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Anonymous is the consumer of requests made without credentials.
+const Anonymous = "anonymous"
+
+// Consumer is a principal, named by its token subject, of one tenant.
+type Consumer struct {
+	TenantID int64
+	Subject  string
+}
+
+// Key identifies an hourly rollup: the requests of a consumer to an
+// endpoint, a method and route template such as "GET /api/v1/books/{id}",
+// during the hour starting at Hour.
+type Key struct {
+	Consumer
+	Endpoint string
+	Hour     time.Time
+}
+
+// Counts is what is metered of a set of requests.
+type Counts struct {
+	Requests      int64
+	RequestBytes  int64
+	ResponseBytes int64
+}
+
+// Add adds the counts of other to c.
+func (c *Counts) Add(other Counts) {
+	c.Requests += other.Requests
+	c.RequestBytes += other.RequestBytes
+	c.ResponseBytes += other.ResponseBytes
+}
+
+// Hour is the start of the hour containing t, in UTC.
+func Hour(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
+
+// Month is the start of the month containing t, in UTC. Quotas are reset at
+// the start of every month.
+func Month(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Intervals for grouping a report.
+const (
+	IntervalHour  = "hour"
+	IntervalDay   = "day"
+	IntervalMonth = "month"
+)
+
+// MaxHourlyReportRange is the longest range reported by the hour.
+const MaxHourlyReportRange = 31 * 24 * time.Hour
+
+// ReportQuery selects the rollups of a report: those of the hours from the
+// one containing From until To, of Consumer or of every consumer, summed by
+// Interval.
+type ReportQuery struct {
+	From     time.Time
+	To       time.Time
+	Consumer string
+	Interval string
+}
+
+func (q *ReportQuery) Validate() error {
+	var v helper.Validator
+	switch q.Interval {
+	case IntervalHour, IntervalDay, IntervalMonth:
+	default:
+		v.Add("interval", helper.CodeInvalid,
+			fmt.Sprintf("interval must be one of: %s, %s, %s", IntervalHour, IntervalDay, IntervalMonth))
+	}
+	v.Check(q.From.Before(q.To), "from", helper.CodeOutOfRange, "from must be before to")
+	if q.Interval == IntervalHour {
+		v.Check(q.To.Sub(q.From) <= MaxHourlyReportRange, "from", helper.CodeOutOfRange, "from and to must span at most 31 days by the hour")
+	}
+	return v.Err()
+}
+
+// Rollup is the usage of a consumer of an endpoint during the period of a
+// report starting at Period.
+type Rollup struct {
+	Period        time.Time `json:"period" db:"period"`
+	Consumer      string    `json:"consumer" db:"consumer"`
+	Endpoint      string    `json:"endpoint" db:"endpoint"`
+	Requests      int64     `json:"requests" db:"requests"`
+	RequestBytes  int64     `json:"request_bytes" db:"request_bytes"`
+	ResponseBytes int64     `json:"response_bytes" db:"response_bytes"`
+}
+
+// MaxConsumerLength is the longest consumer a quota can be set for.
+const MaxConsumerLength = 255
+
+// Quota caps the requests a consumer makes in a month. Past WarnRequests
+// its responses carry a warning; past LimitRequests it is refused. Either
+// may be 0 for no such threshold.
+type Quota struct {
+	TenantID      int64     `json:"-" db:"tenant_id"`
+	Consumer      string    `json:"consumer" db:"consumer"`
+	WarnRequests  int64     `json:"warn_requests" db:"warn_requests"`
+	LimitRequests int64     `json:"limit_requests" db:"limit_requests"`
+	UpdatedBy     string    `json:"updated_by" db:"updated_by"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (q *Quota) Validate() error {
+	q.Consumer = strings.TrimSpace(q.Consumer)
+
+	var v helper.Validator
+	v.Check(q.Consumer != "", "consumer", helper.CodeRequired, "consumer is required")
+	v.Check(len(q.Consumer) <= MaxConsumerLength, "consumer", helper.CodeTooLong, "consumer must be at most 255 characters")
+	v.Check(q.WarnRequests >= 0, "warn_requests", helper.CodeOutOfRange, "warn_requests must not be negative")
+	v.Check(q.LimitRequests >= 0, "limit_requests", helper.CodeOutOfRange, "limit_requests must not be negative")
+	v.Check(q.WarnRequests > 0 || q.LimitRequests > 0, "limit_requests", helper.CodeRequired, "warn_requests or limit_requests is required")
+	if q.WarnRequests > 0 && q.LimitRequests > 0 {
+		v.Check(q.WarnRequests < q.LimitRequests, "warn_requests", helper.CodeOutOfRange, "warn_requests must be below limit_requests")
+	}
+	return v.Err()
+}
+
+// Check returns where a consumer of q that made used requests this month
+// stands when it makes one more at now.
+func (q *Quota) Check(used int64, now time.Time) Status {
+	status := Status{
+		Limit: q.LimitRequests,
+		Used:  used,
+		Reset: Month(now).AddDate(0, 1, 0),
+	}
+	if q.LimitRequests > 0 && used >= q.LimitRequests {
+		status.Blocked = true
+		return status
+	}
+	status.Used++
+	status.Warning = q.WarnRequests > 0 && status.Used >= q.WarnRequests
+	return status
+}
+
+// Status is where a consumer stands against its monthly quota.
+type Status struct {
+	// Limit is the monthly limit, or 0 when there is none.
+	Limit int64
+	// Used counts the requests made this month, the current one included
+	// unless it is blocked.
+	Used    int64
+	Warning bool
+	Blocked bool
+	// Reset is when the month ends and the quota starts over.
+	Reset time.Time
+}
+
+// Remaining is how many more requests may be made this month, or -1 when
+// there is no limit.
+func (s Status) Remaining() int64 {
+	if s.Limit == 0 {
+		return -1
+	}
+	return max(s.Limit-s.Used, 0)
+}
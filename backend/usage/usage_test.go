@@ -0,0 +1,87 @@
+package usage
+
+import (
+	"byfood-interview/helper"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	now := time.Date(2024, 2, 10, 15, 30, 0, 0, time.UTC)
+	quota := Quota{WarnRequests: 8, LimitRequests: 10}
+
+	cases := []struct {
+		used    int64
+		want    Status
+		remains int64
+	}{
+		{used: 0, want: Status{Limit: 10, Used: 1}, remains: 9},
+		{used: 7, want: Status{Limit: 10, Used: 8, Warning: true}, remains: 2},
+		{used: 9, want: Status{Limit: 10, Used: 10, Warning: true}, remains: 0},
+		{used: 10, want: Status{Limit: 10, Used: 10, Blocked: true}, remains: 0},
+		{used: 12, want: Status{Limit: 10, Used: 12, Blocked: true}, remains: 0},
+	}
+	for _, c := range cases {
+		c.want.Reset = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+		got := quota.Check(c.used, now)
+		if got != c.want {
+			t.Errorf("Check(%d) = %+v, want %+v", c.used, got, c.want)
+		}
+		if got.Remaining() != c.remains {
+			t.Errorf("Check(%d).Remaining() = %d, want %d", c.used, got.Remaining(), c.remains)
+		}
+	}
+
+	soft := Quota{WarnRequests: 5}
+	if got := soft.Check(1000, now); got.Blocked || !got.Warning || got.Remaining() != -1 {
+		t.Errorf("expected a quota without limit to only warn, got %+v", got)
+	}
+}
+
+func TestQuotaValidate(t *testing.T) {
+	valid := Quota{Consumer: " api-key:3 ", WarnRequests: 80, LimitRequests: 100}
+	if err := valid.Validate(); err != nil || valid.Consumer != "api-key:3" {
+		t.Fatalf("expected a valid quota, got %v (%q)", err, valid.Consumer)
+	}
+
+	for name, q := range map[string]Quota{
+		"no consumer":   {WarnRequests: 1},
+		"no threshold":  {Consumer: "user:1"},
+		"negative":      {Consumer: "user:1", LimitRequests: -1},
+		"warn at limit": {Consumer: "user:1", WarnRequests: 100, LimitRequests: 100},
+	} {
+		var verr *helper.ErrValidation
+		if err := q.Validate(); !errors.As(err, &verr) {
+			t.Errorf("%s: expected a validation error, got %v", name, err)
+		}
+	}
+}
+
+func TestReportQueryValidate(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		q     ReportQuery
+		valid bool
+	}{
+		{ReportQuery{From: from, To: from.AddDate(1, 0, 0), Interval: IntervalMonth}, true},
+		{ReportQuery{From: from, To: from.AddDate(0, 0, 31), Interval: IntervalHour}, true},
+		{ReportQuery{From: from, To: from.AddDate(0, 0, 32), Interval: IntervalHour}, false},
+		{ReportQuery{From: from, To: from, Interval: IntervalDay}, false},
+		{ReportQuery{From: from, To: from.AddDate(0, 1, 0), Interval: "week"}, false},
+	} {
+		if err := c.q.Validate(); (err == nil) != c.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", c.q, err, c.valid)
+		}
+	}
+}
+
+func TestMonth(t *testing.T) {
+	at := time.Date(2024, 12, 31, 23, 30, 0, 0, time.FixedZone("", -2*3600))
+	if got, want := Month(at), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Month(%v) = %v, want %v", at, got, want)
+	}
+	if got, want := Hour(at), time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Hour(%v) = %v, want %v", at, got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/user"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type UserService interface {
+	Register(ctx context.Context, req *user.Credentials) (*user.User, error)
+	Login(ctx context.Context, req *user.Credentials) (*user.Session, error)
+	Logout(ctx context.Context, token string) error
+	Session(ctx context.Context, token string) (*user.Session, error)
+	RequestPasswordReset(ctx context.Context, req *user.PasswordResetRequest) error
+	ResetPassword(ctx context.Context, req *user.NewPasswordRequest) error
+}
+
+type Handler struct {
+	Service UserService
+	// SecureCookies marks the session cookie Secure, so browsers only send
+	// it over HTTPS. Only turn it off for local development.
+	SecureCookies bool
+}
+
+func (h *Handler) setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, user.NewSessionCookie(token, expires, h.SecureCookies))
+}
+
+// Register godoc
+// @Summary Register an account
+// @Description Create a local account that signs in with its email and password. New accounts hold the viewer role.
+// @Tags auth
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param request body user.Credentials true "Email and password of at least 8 characters"
+// @Success 200 {object} helper.Response{data=user.User}
+// @Failure 400 {object} helper.Response
+// @Failure 409 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/register [post]
+// Register handles registering an account
+func (h *Handler) Register() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request user.Credentials
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.Register(r.Context(), &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// Login godoc
+// @Summary Log in
+// @Description Start a session kept in an HttpOnly cookie. Writes made with the cookie must send the returned csrf_token in the X-CSRF-Token header. Repeated failures lock the account for a while.
+// @Tags auth
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param request body user.Credentials true "Email and password"
+// @Success 200 {object} helper.Response{data=user.Session}
+// @Failure 400 {object} helper.Response
+// @Failure 401 {object} helper.Response
+// @Failure 429 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/login [post]
+// Login handles logging in
+func (h *Handler) Login() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request user.Credentials
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		data, err := h.Service.Login(r.Context(), &request)
+		if err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		// The response starts a session; keep it out of every cache, the
+		// idempotency store included.
+		w.Header().Set("Cache-Control", "no-store")
+		h.setSessionCookie(w, data.Token, data.ExpiresAt)
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// Logout godoc
+// @Summary Log out
+// @Description End the session of the cookie and clear it. Requires the X-CSRF-Token header.
+// @Tags auth
+// @Produce json,xml,application/msgpack
+// @Param X-CSRF-Token header string true "CSRF token of the session"
+// @Success 200 {object} helper.Response
+// @Failure 403 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/logout [post]
+// Logout handles logging out
+func (h *Handler) Logout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(user.SessionCookie); err == nil {
+			if err := h.Service.Logout(r.Context(), cookie.Value); err != nil {
+				helper.WriteResponse(w, r, err, nil)
+				return
+			}
+		}
+
+		h.setSessionCookie(w, "", time.Time{})
+		helper.WriteResponse(w, r, nil, nil)
+	}
+}
+
+// GetSession godoc
+// @Summary Get the current session
+// @Description Get the user and CSRF token of the session cookie, for clients that lost the token returned at login.
+// @Tags auth
+// @Produce json,xml,application/msgpack
+// @Success 200 {object} helper.Response{data=user.Session}
+// @Failure 401 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/session [get]
+// GetSession handles getting the current session
+func (h *Handler) GetSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(user.SessionCookie)
+		if err != nil {
+			helper.WriteResponse(w, r, helper.NewErrUnauthorized(user.ErrInvalidSession.Error()), nil)
+			return
+		}
+
+		data, err := h.Service.Session(r.Context(), cookie.Value)
+		if err != nil {
+			if errors.Is(err, user.ErrInvalidSession) {
+				err = helper.NewErrUnauthorized(err.Error())
+			}
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		helper.WriteResponse(w, r, nil, data)
+	}
+}
+
+// RequestPasswordReset godoc
+// @Summary Request a password reset
+// @Description Mail a single-use reset token to the account of the email. The response is the same whether or not there is one.
+// @Tags auth
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param request body user.PasswordResetRequest true "Email of the account"
+// @Success 200 {object} helper.Response
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/password-reset [post]
+// RequestPasswordReset handles requesting a password reset
+func (h *Handler) RequestPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request user.PasswordResetRequest
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		if err := h.Service.RequestPasswordReset(r.Context(), &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, nil)
+	}
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Set a new password with a mailed reset token. The account is unlocked and signed out everywhere.
+// @Tags auth
+// @Accept json
+// @Produce json,xml,application/msgpack
+// @Param request body user.NewPasswordRequest true "Reset token and new password"
+// @Success 200 {object} helper.Response
+// @Failure 400 {object} helper.Response
+// @Failure 500 {object} helper.Response
+// @Router /api/v1/auth/password-reset/confirm [post]
+// ResetPassword handles resetting a password
+func (h *Handler) ResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request user.NewPasswordRequest
+		if err := helper.DecodeJSON(r.Body, &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		if err := h.Service.ResetPassword(r.Context(), &request); err != nil {
+			helper.WriteResponse(w, r, err, nil)
+			return
+		}
+
+		helper.WriteResponse(w, r, nil, nil)
+	}
+}
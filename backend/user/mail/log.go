@@ -0,0 +1,17 @@
+// Package mail delivers password reset tokens.
+package mail
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Log writes reset tokens to the context logger at debug level. It is the
+// fallback when no mail server is configured, for development only.
+type Log struct{}
+
+func (Log) SendPasswordReset(ctx context.Context, to, token string) error {
+	log.Ctx(ctx).Debug().Str("to", to).Str("token", token).Msg("password reset token")
+	return nil
+}
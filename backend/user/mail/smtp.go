@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"net/url"
+	"time"
+)
+
+// SMTP mails password reset tokens.
+type SMTP struct {
+	// Addr is the host:port of the mail server.
+	Addr string
+	From string
+	// Auth is optional. net/smtp only sends credentials over TLS or to
+	// localhost.
+	Auth smtp.Auth
+	// ResetURL is the page that sets the new password. The token is added
+	// to it as the token query parameter. Without it the bare token is
+	// mailed.
+	ResetURL string
+}
+
+func (m *SMTP) SendPasswordReset(ctx context.Context, to, token string) error {
+	msg, err := m.message(to, token)
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, msg); err != nil {
+		return fmt.Errorf("send password reset: %w", err)
+	}
+	return nil
+}
+
+func (m *SMTP) message(to, token string) ([]byte, error) {
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Reset your password"))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+
+	msg.WriteString("Someone asked to reset the password of your account. If it was not you, ignore this mail.\r\n\r\n")
+	if m.ResetURL == "" {
+		fmt.Fprintf(&msg, "Your reset token is:\r\n\r\n%s\r\n", token)
+		return msg.Bytes(), nil
+	}
+
+	link, err := url.Parse(m.ResetURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse reset URL: %w", err)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	fmt.Fprintf(&msg, "Set a new password at:\r\n\r\n%s\r\n", link)
+	return msg.Bytes(), nil
+}
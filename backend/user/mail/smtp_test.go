@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestSMTPMessage(t *testing.T) {
+	m := &SMTP{From: "books@example.com", ResetURL: "https://books.example.com/reset-password?lang=en"}
+
+	msg, err := m.message("reader@example.com", "tok-en_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	if parsed.Header.Get("To") != "reader@example.com" || parsed.Header.Get("Subject") == "" {
+		t.Fatalf("unexpected headers %v", parsed.Header)
+	}
+	if want := "https://books.example.com/reset-password?lang=en&token=tok-en_1\r\n"; !strings.Contains(string(msg), want) {
+		t.Fatalf("missing reset link %q in:\n%s", want, msg)
+	}
+
+	m.ResetURL = ""
+	msg, err = m.message("reader@example.com", "tok-en_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), "\r\ntok-en_1\r\n") {
+		t.Fatalf("missing bare token in:\n%s", msg)
+	}
+}
@@ -0,0 +1,69 @@
+package user
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap keeps the tests fast; the defaults take a noticeable fraction of a
+// second per hash.
+var cheap = Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+func TestHasher(t *testing.T) {
+	hashers := map[string]Hasher{
+		AlgArgon2id: {Algorithm: AlgArgon2id, Argon2: cheap},
+		AlgBcrypt:   {Algorithm: AlgBcrypt, BcryptCost: bcrypt.MinCost},
+	}
+	for name, h := range hashers {
+		hash, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if strings.Contains(hash, "correct horse") {
+			t.Fatalf("%s: hash contains the password", name)
+		}
+		if other, _ := h.Hash("correct horse"); other == hash {
+			t.Fatalf("%s: expected salted hashes to differ", name)
+		}
+
+		if ok, rehash, err := h.Verify(hash, "correct horse"); !ok || rehash || err != nil {
+			t.Errorf("%s: got %v %v %v for the right password", name, ok, rehash, err)
+		}
+		if ok, _, err := h.Verify(hash, "wrong horse"); ok || err != nil {
+			t.Errorf("%s: got %v %v for a wrong password", name, ok, err)
+		}
+	}
+}
+
+func TestHasherRehash(t *testing.T) {
+	argon := Hasher{Algorithm: AlgArgon2id, Argon2: cheap}
+	bcrypted := Hasher{Algorithm: AlgBcrypt, BcryptCost: bcrypt.MinCost}
+
+	hash, err := bcrypted.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, rehash, err := argon.Verify(hash, "correct horse"); !ok || !rehash || err != nil {
+		t.Fatalf("expected bcrypt hashes to verify and be upgraded, got %v %v %v", ok, rehash, err)
+	}
+
+	hash, err = argon.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stronger := Hasher{Algorithm: AlgArgon2id, Argon2: Argon2Params{Time: 2, Memory: 64, Threads: 1}}
+	if ok, rehash, err := stronger.Verify(hash, "correct horse"); !ok || !rehash || err != nil {
+		t.Fatalf("expected hashes of a lower cost to be upgraded, got %v %v %v", ok, rehash, err)
+	}
+}
+
+func TestHasherMalformed(t *testing.T) {
+	h := Hasher{Argon2: cheap}
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if ok, _, err := h.Verify(hash, "correct horse"); ok || err == nil {
+			t.Errorf("Verify(%q) = %v, %v, want an error", hash, ok, err)
+		}
+	}
+}
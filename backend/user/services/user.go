@@ -0,0 +1,375 @@
+package services
+
+import (
+	"byfood-interview/helper"
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/user"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults of the User service settings left zero.
+const (
+	DefaultSessionTTL      = 24 * time.Hour
+	DefaultMaxFailedLogins = 5
+	DefaultLockoutDuration = 15 * time.Minute
+	DefaultResetTokenTTL   = time.Hour
+)
+
+type UserRepository interface {
+	Create(ctx context.Context, data *user.User) (*user.User, error)
+	GetByID(ctx context.Context, id int64) (*user.User, error)
+	GetByEmail(ctx context.Context, email string) (*user.User, error)
+	RecordFailedLogin(ctx context.Context, id int64, maxFailures int, lockUntil time.Time) (*user.User, error)
+	ResetFailedLogins(ctx context.Context, id int64) error
+	UpdatePassword(ctx context.Context, id int64, hash string) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, data *user.Session) (*user.Session, error)
+	Get(ctx context.Context, id string) (*user.Session, error)
+	Delete(ctx context.Context, id string) error
+	DeleteByUser(ctx context.Context, userID int64) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type ResetTokenRepository interface {
+	Create(ctx context.Context, tokenHash string, userID int64, expiresAt time.Time) error
+	Consume(ctx context.Context, tokenHash string, at time.Time) (int64, error)
+	ConsumeAll(ctx context.Context, userID int64, at time.Time) error
+}
+
+// Mailer delivers password reset tokens.
+type Mailer interface {
+	SendPasswordReset(ctx context.Context, to, token string) error
+}
+
+// User registers local accounts, signs them in and out, and resets their
+// passwords.
+type User struct {
+	UserRepository       UserRepository
+	SessionRepository    SessionRepository
+	ResetTokenRepository ResetTokenRepository
+	Transactor           internalDb.TxRunner
+	Mailer               Mailer
+	Hasher               user.Hasher
+
+	// SessionTTL is how long a session lasts after login.
+	SessionTTL time.Duration
+	// MaxFailedLogins in a row lock an account for LockoutDuration.
+	MaxFailedLogins int
+	LockoutDuration time.Duration
+	// ResetTokenTTL is how long a mailed reset token works.
+	ResetTokenTTL time.Duration
+
+	now func() time.Time
+	// dummyHash is verified against when the email is unknown, so the
+	// response time does not tell which emails have accounts.
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func (s *User) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func (s *User) sessionTTL() time.Duration {
+	if s.SessionTTL <= 0 {
+		return DefaultSessionTTL
+	}
+	return s.SessionTTL
+}
+
+func (s *User) maxFailedLogins() int {
+	if s.MaxFailedLogins <= 0 {
+		return DefaultMaxFailedLogins
+	}
+	return s.MaxFailedLogins
+}
+
+func (s *User) lockoutDuration() time.Duration {
+	if s.LockoutDuration <= 0 {
+		return DefaultLockoutDuration
+	}
+	return s.LockoutDuration
+}
+
+func (s *User) resetTokenTTL() time.Duration {
+	if s.ResetTokenTTL <= 0 {
+		return DefaultResetTokenTTL
+	}
+	return s.ResetTokenTTL
+}
+
+func (s *User) Register(ctx context.Context, req *user.Credentials) (*user.User, error) {
+	log := log.Ctx(ctx).With().Str("service", "user").Logger()
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	hash, err := s.Hasher.Hash(req.Password)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to hash password")
+		return nil, err
+	}
+
+	created, err := s.UserRepository.Create(ctx, &user.User{Email: req.Email, PasswordHash: hash})
+	if err != nil {
+		if errors.Is(err, user.ErrEmailTaken) {
+			return nil, helper.NewErrConflict(err.Error())
+		}
+		log.Error().Err(err).Msg("failed to register user")
+		return nil, err
+	}
+	log.Info().Int64("user_id", created.ID).Msg("user registered")
+	return created, nil
+}
+
+// Login checks the credentials and starts a session. The returned session
+// carries the token for the session cookie. An account is locked for
+// LockoutDuration after MaxFailedLogins failures in a row.
+func (s *User) Login(ctx context.Context, req *user.Credentials) (*user.Session, error) {
+	log := log.Ctx(ctx).With().Str("service", "user").Logger()
+
+	invalid := helper.NewErrUnauthorized(user.ErrInvalidCredentials.Error())
+	now := s.clock()
+
+	account, err := s.UserRepository.GetByEmail(ctx, user.NormalizeEmail(req.Email))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.verifyDummy(req.Password)
+			return nil, invalid
+		}
+		log.Error().Err(err).Msg("failed to look up user")
+		return nil, err
+	}
+	if account.Locked(now) {
+		return nil, helper.NewErrTooManyRequests(user.ErrAccountLocked.Error(), account.LockedUntil.Sub(now))
+	}
+	if account.PasswordHash == "" {
+		// Accounts made by single sign-on have no password.
+		s.verifyDummy(req.Password)
+		return nil, invalid
+	}
+
+	ok, rehash, err := s.Hasher.Verify(account.PasswordHash, req.Password)
+	if err != nil {
+		log.Error().Err(err).Int64("user_id", account.ID).Msg("failed to verify password")
+		return nil, err
+	}
+	if !ok {
+		lockUntil := now.Add(s.lockoutDuration())
+		updated, err := s.UserRepository.RecordFailedLogin(ctx, account.ID, s.maxFailedLogins(), lockUntil)
+		if err != nil {
+			return nil, err
+		}
+		if updated.Locked(now) {
+			log.Warn().Int64("user_id", account.ID).Time("locked_until", lockUntil).Msg("account locked after failed logins")
+			return nil, helper.NewErrTooManyRequests(user.ErrAccountLocked.Error(), lockUntil.Sub(now))
+		}
+		return nil, invalid
+	}
+
+	if account.FailedLogins > 0 {
+		if err := s.UserRepository.ResetFailedLogins(ctx, account.ID); err != nil {
+			return nil, err
+		}
+	}
+	if rehash {
+		// Upgrade the hash to the configured algorithm and cost while the
+		// password is at hand. Failing to is not worth failing the login.
+		if hash, err := s.Hasher.Hash(req.Password); err == nil {
+			if err := s.UserRepository.UpdatePassword(ctx, account.ID, hash); err != nil {
+				log.Warn().Err(err).Int64("user_id", account.ID).Msg("failed to rehash password")
+			}
+		}
+	}
+
+	session, err := s.StartSession(ctx, account)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start session")
+		return nil, err
+	}
+	log.Info().Int64("user_id", account.ID).Msg("user logged in")
+	return session, nil
+}
+
+func (s *User) verifyDummy(password string) {
+	s.dummyOnce.Do(func() {
+		s.dummyHash, _ = s.Hasher.Hash("not the password of anyone")
+	})
+	_, _, _ = s.Hasher.Verify(s.dummyHash, password)
+}
+
+// StartSession signs account in. The returned session carries the token
+// for the session cookie.
+func (s *User) StartSession(ctx context.Context, account *user.User) (*user.Session, error) {
+	token, err := user.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	csrf, err := user.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.SessionRepository.Create(ctx, &user.Session{
+		ID:        user.HashToken(token),
+		UserID:    account.ID,
+		CSRFToken: csrf,
+		ExpiresAt: s.clock().Add(s.sessionTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	session.User = account
+	session.Token = token
+	return session, nil
+}
+
+// Session returns the live session of token with its user, or
+// user.ErrInvalidSession.
+func (s *User) Session(ctx context.Context, token string) (*user.Session, error) {
+	session, err := s.SessionRepository.Get(ctx, user.HashToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, user.ErrInvalidSession
+		}
+		log.Ctx(ctx).Error().Err(err).Str("service", "user").Msg("failed to look up session")
+		return nil, err
+	}
+	if !s.clock().Before(session.ExpiresAt) {
+		return nil, user.ErrInvalidSession
+	}
+
+	session.User, err = s.UserRepository.GetByID(ctx, session.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, user.ErrInvalidSession
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// Logout ends the session of token. Ending an unknown session is not an
+// error.
+func (s *User) Logout(ctx context.Context, token string) error {
+	if err := s.SessionRepository.Delete(ctx, user.HashToken(token)); err != nil {
+		return err
+	}
+	log.Ctx(ctx).Info().Str("service", "user").Msg("user logged out")
+	return nil
+}
+
+// RequestPasswordReset mails a reset token to the account of req.Email. It
+// succeeds whether or not there is one, so it does not tell which emails
+// have accounts.
+func (s *User) RequestPasswordReset(ctx context.Context, req *user.PasswordResetRequest) error {
+	log := log.Ctx(ctx).With().Str("service", "user").Logger()
+
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	account, err := s.UserRepository.GetByEmail(ctx, req.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Info().Msg("password reset requested for unknown email")
+			return nil
+		}
+		return err
+	}
+
+	if account.PasswordHash == "" {
+		log.Info().Int64("user_id", account.ID).Msg("password reset requested for single sign-on account")
+		return nil
+	}
+
+	token, err := user.NewToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := s.clock().Add(s.resetTokenTTL())
+	if err := s.ResetTokenRepository.Create(ctx, user.HashToken(token), account.ID, expiresAt); err != nil {
+		return err
+	}
+	if err := s.Mailer.SendPasswordReset(ctx, account.Email, token); err != nil {
+		// Failing the request would tell that the account exists.
+		log.Error().Err(err).Int64("user_id", account.ID).Msg("failed to send password reset")
+		return nil
+	}
+	log.Info().Int64("user_id", account.ID).Msg("password reset sent")
+	return nil
+}
+
+// ResetPassword sets a new password with a mailed token. It unlocks the
+// account and ends all its sessions.
+func (s *User) ResetPassword(ctx context.Context, req *user.NewPasswordRequest) error {
+	log := log.Ctx(ctx).With().Str("service", "user").Logger()
+
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	hash, err := s.Hasher.Hash(req.Password)
+	if err != nil {
+		return err
+	}
+
+	now := s.clock()
+	var userID int64
+	err = internalDb.Within(ctx, s.Transactor, func(ctx context.Context) error {
+		userID, err = s.ResetTokenRepository.Consume(ctx, user.HashToken(req.Token), now)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return helper.NewErrValidation("token", helper.CodeInvalid, user.ErrInvalidResetToken.Error())
+			}
+			return err
+		}
+		if err := s.ResetTokenRepository.ConsumeAll(ctx, userID, now); err != nil {
+			return err
+		}
+		if err := s.UserRepository.UpdatePassword(ctx, userID, hash); err != nil {
+			return err
+		}
+		return s.SessionRepository.DeleteByUser(ctx, userID)
+	})
+	if err != nil {
+		if helper.StatusCode(err) != http.StatusBadRequest {
+			log.Error().Err(err).Msg("failed to reset password")
+		}
+		return err
+	}
+	log.Info().Int64("user_id", userID).Msg("password reset")
+	return nil
+}
+
+// PurgeExpiredSessions deletes expired sessions every interval until ctx is
+// cancelled.
+func (s *User) PurgeExpiredSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.SessionRepository.DeleteExpired(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to purge expired sessions")
+				continue
+			}
+			log.Debug().Int64("deleted", deleted).Msg("expired sessions purged")
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"byfood-interview/helper"
+	"byfood-interview/internal/testutil"
+	"byfood-interview/user"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// memoryUsers is an in-memory UserRepository.
+type memoryUsers struct {
+	users []*user.User
+}
+
+func (m *memoryUsers) Create(ctx context.Context, data *user.User) (*user.User, error) {
+	if _, err := m.GetByEmail(ctx, data.Email); err == nil {
+		return nil, user.ErrEmailTaken
+	}
+	created := *data
+	created.ID = int64(len(m.users) + 1)
+	m.users = append(m.users, &created)
+	copied := created
+	return &copied, nil
+}
+
+func (m *memoryUsers) GetByID(ctx context.Context, id int64) (*user.User, error) {
+	return testutil.Find(m.users, func(u *user.User) bool { return u.ID == id })
+}
+
+func (m *memoryUsers) GetByEmail(ctx context.Context, email string) (*user.User, error) {
+	return testutil.Find(m.users, func(u *user.User) bool { return u.Email == email })
+}
+
+func (m *memoryUsers) RecordFailedLogin(ctx context.Context, id int64, maxFailures int, lockUntil time.Time) (*user.User, error) {
+	for _, u := range m.users {
+		if u.ID == id {
+			u.FailedLogins++
+			if u.FailedLogins >= maxFailures {
+				u.FailedLogins = 0
+				u.LockedUntil = &lockUntil
+			}
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryUsers) ResetFailedLogins(ctx context.Context, id int64) error {
+	for _, u := range m.users {
+		if u.ID == id {
+			u.FailedLogins = 0
+		}
+	}
+	return nil
+}
+
+func (m *memoryUsers) UpdatePassword(ctx context.Context, id int64, hash string) error {
+	for _, u := range m.users {
+		if u.ID == id {
+			u.PasswordHash, u.FailedLogins, u.LockedUntil = hash, 0, nil
+		}
+	}
+	return nil
+}
+
+// memorySessions is an in-memory SessionRepository.
+type memorySessions map[string]user.Session
+
+func (m memorySessions) Create(ctx context.Context, data *user.Session) (*user.Session, error) {
+	m[data.ID] = *data
+	copied := *data
+	return &copied, nil
+}
+
+func (m memorySessions) Get(ctx context.Context, id string) (*user.Session, error) {
+	s, ok := m[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &s, nil
+}
+
+func (m memorySessions) Delete(ctx context.Context, id string) error {
+	delete(m, id)
+	return nil
+}
+
+func (m memorySessions) DeleteByUser(ctx context.Context, userID int64) error {
+	for id, s := range m {
+		if s.UserID == userID {
+			delete(m, id)
+		}
+	}
+	return nil
+}
+
+func (m memorySessions) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+// memoryResets is an in-memory ResetTokenRepository.
+type memoryResets map[string]*resetToken
+
+type resetToken struct {
+	userID    int64
+	expiresAt time.Time
+	used      bool
+}
+
+func (m memoryResets) Create(ctx context.Context, tokenHash string, userID int64, expiresAt time.Time) error {
+	m[tokenHash] = &resetToken{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (m memoryResets) Consume(ctx context.Context, tokenHash string, at time.Time) (int64, error) {
+	t, ok := m[tokenHash]
+	if !ok || t.used || !at.Before(t.expiresAt) {
+		return 0, sql.ErrNoRows
+	}
+	t.used = true
+	return t.userID, nil
+}
+
+func (m memoryResets) ConsumeAll(ctx context.Context, userID int64, at time.Time) error {
+	for _, t := range m {
+		if t.userID == userID {
+			t.used = true
+		}
+	}
+	return nil
+}
+
+// outbox records the reset tokens mailed to each address.
+type outbox map[string][]string
+
+func (o outbox) SendPasswordReset(ctx context.Context, to, token string) error {
+	o[to] = append(o[to], token)
+	return nil
+}
+
+type fixture struct {
+	service  *User
+	users    *memoryUsers
+	sessions memorySessions
+	mail     outbox
+	clock    *testutil.Clock
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{
+		users:    &memoryUsers{},
+		sessions: memorySessions{},
+		mail:     outbox{},
+		clock:    testutil.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)),
+	}
+	f.service = &User{
+		UserRepository:       f.users,
+		SessionRepository:    f.sessions,
+		ResetTokenRepository: memoryResets{},
+		Mailer:               f.mail,
+		Hasher:               user.Hasher{Argon2: user.Argon2Params{Time: 1, Memory: 64, Threads: 1}},
+		MaxFailedLogins:      3,
+		LockoutDuration:      10 * time.Minute,
+		now:                  f.clock.Now,
+	}
+	if _, err := f.service.Register(context.Background(), &user.Credentials{Email: "reader@example.com", Password: "correct horse"}); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *fixture) login(password string) (*user.Session, error) {
+	return f.service.Login(context.Background(), &user.Credentials{Email: "Reader@example.com", Password: password})
+}
+
+func TestRegister(t *testing.T) {
+	f := newFixture(t)
+
+	_, err := f.service.Register(context.Background(), &user.Credentials{Email: "READER@example.com", Password: "another horse"})
+	if helper.StatusCode(err) != http.StatusConflict {
+		t.Fatalf("expected 409 registering a taken email, got %v", err)
+	}
+	if f.users.users[0].PasswordHash == "correct horse" {
+		t.Fatal("password stored in plaintext")
+	}
+}
+
+func TestLoginAndSession(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	if _, err := f.login("wrong horse"); helper.StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a wrong password, got %v", err)
+	}
+	if _, err := f.service.Login(ctx, &user.Credentials{Email: "nobody@example.com", Password: "correct horse"}); helper.StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown email, got %v", err)
+	}
+
+	session, err := f.login("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token == "" || session.CSRFToken == "" || session.User.Email != "reader@example.com" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	if _, stored := f.sessions[session.Token]; stored {
+		t.Fatal("session token stored in plaintext")
+	}
+	if f.users.users[0].FailedLogins != 0 {
+		t.Fatalf("expected a successful login to reset failures, got %d", f.users.users[0].FailedLogins)
+	}
+
+	current, err := f.service.Session(ctx, session.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal := current.Principal(); principal.Subject != "user:1" || principal.Scopes != nil {
+		t.Fatalf("unexpected principal %+v", principal)
+	}
+
+	f.clock.Set(session.ExpiresAt)
+	if _, err := f.service.Session(ctx, session.Token); !errors.Is(err, user.ErrInvalidSession) {
+		t.Fatalf("expected the session to expire, got %v", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	session, err := f.login("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.service.Logout(ctx, session.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.service.Session(ctx, session.Token); !errors.Is(err, user.ErrInvalidSession) {
+		t.Fatalf("expected the session to end, got %v", err)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	f := newFixture(t)
+
+	for i := 0; i < 2; i++ {
+		if _, err := f.login("wrong horse"); helper.StatusCode(err) != http.StatusUnauthorized {
+			t.Fatalf("failure %d: expected 401, got %v", i+1, err)
+		}
+	}
+	_, err := f.login("wrong horse")
+	var tooMany *helper.ErrTooManyRequests
+	if !errors.As(err, &tooMany) || tooMany.RetryAfter != 10*time.Minute {
+		t.Fatalf("expected the third failure to lock the account, got %v", err)
+	}
+
+	// The right password does not help while locked.
+	f.clock.Advance(5 * time.Minute)
+	if _, err := f.login("correct horse"); !errors.As(err, &tooMany) || tooMany.RetryAfter != 5*time.Minute {
+		t.Fatalf("expected 429 while locked, got %v", err)
+	}
+
+	f.clock.Advance(5 * time.Minute)
+	if _, err := f.login("correct horse"); err != nil {
+		t.Fatalf("expected the lock to lift, got %v", err)
+	}
+}
+
+func TestSingleSignOnAccountHasNoPassword(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	if _, err := f.users.Create(ctx, &user.User{Email: "sso@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := f.service.Login(ctx, &user.Credentials{Email: "sso@example.com", Password: ""})
+	if helper.StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected 401 logging in without a password, got %v", err)
+	}
+	if err := f.service.RequestPasswordReset(ctx, &user.PasswordResetRequest{Email: "sso@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.mail) != 0 {
+		t.Fatalf("expected no reset mail for a single sign-on account, got %v", f.mail)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	session, err := f.login("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.service.RequestPasswordReset(ctx, &user.PasswordResetRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("expected unknown emails to be accepted silently, got %v", err)
+	}
+	if err := f.service.RequestPasswordReset(ctx, &user.PasswordResetRequest{Email: "Reader@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.service.RequestPasswordReset(ctx, &user.PasswordResetRequest{Email: "reader@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	tokens := f.mail["reader@example.com"]
+	if len(f.mail) != 1 || len(tokens) != 2 {
+		t.Fatalf("unexpected mail %v", f.mail)
+	}
+
+	err = f.service.ResetPassword(ctx, &user.NewPasswordRequest{Token: "forged", Password: "battery staple"})
+	if helper.StatusCode(err) != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a forged token, got %v", err)
+	}
+	if err := f.service.ResetPassword(ctx, &user.NewPasswordRequest{Token: tokens[1], Password: "battery staple"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other sessions and tokens stop working.
+	if _, err := f.service.Session(ctx, session.Token); !errors.Is(err, user.ErrInvalidSession) {
+		t.Fatalf("expected the reset to end existing sessions, got %v", err)
+	}
+	for _, token := range tokens {
+		err := f.service.ResetPassword(ctx, &user.NewPasswordRequest{Token: token, Password: "another horse"})
+		if helper.StatusCode(err) != http.StatusBadRequest {
+			t.Fatalf("expected used and superseded tokens to be refused, got %v", err)
+		}
+	}
+
+	if _, err := f.login("correct horse"); helper.StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected the old password to stop working, got %v", err)
+	}
+	if _, err := f.login("battery staple"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+type ResetToken struct {
+	db *sqlx.DB
+}
+
+func NewResetToken(db *sqlx.DB) *ResetToken {
+	return &ResetToken{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *ResetToken) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+func (s *ResetToken) Create(ctx context.Context, tokenHash string, userID int64, expiresAt time.Time) error {
+	query := "INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES ($1, $2, $3)"
+	if _, err := s.conn(ctx).ExecContext(ctx, query, tokenHash, userID, expiresAt); err != nil {
+		log.Error().Err(err).Msg("failed to insert password reset token")
+		return err
+	}
+	return nil
+}
+
+// Consume marks the token used and returns its user. It returns
+// sql.ErrNoRows when the token is unknown, used or expired at at.
+func (s *ResetToken) Consume(ctx context.Context, tokenHash string, at time.Time) (int64, error) {
+	var userID int64
+	query := `UPDATE password_resets SET used_at = $2
+	WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2 RETURNING user_id`
+	if err := s.conn(ctx).GetContext(ctx, &userID, query, tokenHash, at); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// ConsumeAll marks every unused token of the user used, so older reset
+// mails stop working once the password has changed.
+func (s *ResetToken) ConsumeAll(ctx context.Context, userID int64, at time.Time) error {
+	query := "UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL"
+	if _, err := s.conn(ctx).ExecContext(ctx, query, userID, at); err != nil {
+		log.Error().Err(err).Msg("failed to consume password reset tokens")
+		return err
+	}
+	return nil
+}
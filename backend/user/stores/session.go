@@ -0,0 +1,73 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/user"
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const sessionColumns = "id, user_id, csrf_token, created_at, expires_at"
+
+type Session struct {
+	db *sqlx.DB
+}
+
+func NewSession(db *sqlx.DB) *Session {
+	return &Session{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *Session) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+func (s *Session) Create(ctx context.Context, data *user.Session) (*user.Session, error) {
+	var created user.Session
+	query := "INSERT INTO sessions (id, user_id, csrf_token, expires_at) VALUES ($1, $2, $3, $4) RETURNING " + sessionColumns
+	if err := s.conn(ctx).GetContext(ctx, &created, query, data.ID, data.UserID, data.CSRFToken, data.ExpiresAt); err != nil {
+		log.Error().Err(err).Msg("failed to insert session")
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (s *Session) Get(ctx context.Context, id string) (*user.Session, error) {
+	var data user.Session
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE id = $1"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, id); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *Session) Delete(ctx context.Context, id string) error {
+	if _, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM sessions WHERE id = $1", id); err != nil {
+		log.Error().Err(err).Msg("failed to delete session")
+		return err
+	}
+	return nil
+}
+
+// DeleteByUser signs the user out everywhere.
+func (s *Session) DeleteByUser(ctx context.Context, userID int64) error {
+	if _, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM sessions WHERE user_id = $1", userID); err != nil {
+		log.Error().Err(err).Msg("failed to delete sessions of user")
+		return err
+	}
+	return nil
+}
+
+// DeleteExpired removes the sessions whose expiry has passed and returns
+// how many there were.
+func (s *Session) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := s.conn(ctx).ExecContext(ctx, "DELETE FROM sessions WHERE expires_at <= NOW()")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete expired sessions")
+		return 0, err
+	}
+	return result.RowsAffected()
+}
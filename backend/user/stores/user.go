@@ -0,0 +1,100 @@
+package stores
+
+import (
+	internalDb "byfood-interview/internal/db"
+	"byfood-interview/user"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rs/zerolog/log"
+)
+
+const userColumns = "id, email, password_hash, failed_logins, locked_until, created_at, updated_at"
+
+type User struct {
+	db *sqlx.DB
+}
+
+func NewUser(db *sqlx.DB) *User {
+	return &User{db: db}
+}
+
+// conn returns the transaction carried on ctx, if any, so store calls join
+// it transparently.
+func (s *User) conn(ctx context.Context) internalDb.Querier {
+	return internalDb.Conn(ctx, s.db)
+}
+
+// Create inserts the user. It returns user.ErrEmailTaken when the email is
+// already registered.
+func (s *User) Create(ctx context.Context, data *user.User) (*user.User, error) {
+	var created user.User
+	query := "INSERT INTO users (email, password_hash) VALUES ($1, $2) RETURNING " + userColumns
+	if err := s.conn(ctx).GetContext(ctx, &created, query, data.Email, data.PasswordHash); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, user.ErrEmailTaken
+		}
+		log.Error().Err(err).Msg("failed to insert user")
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (s *User) GetByID(ctx context.Context, id int64) (*user.User, error) {
+	var data user.User
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, id); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *User) GetByEmail(ctx context.Context, email string) (*user.User, error) {
+	var data user.User
+	query := "SELECT " + userColumns + " FROM users WHERE email = $1"
+	if err := s.conn(ctx).GetContext(ctx, &data, query, email); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// RecordFailedLogin counts a failed login. The failure that reaches
+// maxFailures locks the account until lockUntil and starts the count again.
+// The count is updated in place so concurrent failures are all counted.
+func (s *User) RecordFailedLogin(ctx context.Context, id int64, maxFailures int, lockUntil time.Time) (*user.User, error) {
+	var data user.User
+	query := `UPDATE users SET
+		failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+		locked_until = CASE WHEN failed_logins + 1 >= $2 THEN $3 ELSE locked_until END
+	WHERE id = $1 RETURNING ` + userColumns
+	if err := s.conn(ctx).GetContext(ctx, &data, query, id, maxFailures, lockUntil); err != nil {
+		log.Error().Err(err).Msg("failed to record failed login")
+		return nil, err
+	}
+	return &data, nil
+}
+
+// ResetFailedLogins clears the failed logins counted since the last
+// success.
+func (s *User) ResetFailedLogins(ctx context.Context, id int64) error {
+	if _, err := s.conn(ctx).ExecContext(ctx, "UPDATE users SET failed_logins = 0 WHERE id = $1", id); err != nil {
+		log.Error().Err(err).Msg("failed to reset failed logins")
+		return err
+	}
+	return nil
+}
+
+// UpdatePassword replaces the password hash and unlocks the account.
+func (s *User) UpdatePassword(ctx context.Context, id int64, hash string) error {
+	query := `UPDATE users SET password_hash = $2, failed_logins = 0, locked_until = NULL, updated_at = NOW()
+	WHERE id = $1`
+	if _, err := s.conn(ctx).ExecContext(ctx, query, id, hash); err != nil {
+		log.Error().Err(err).Msg("failed to update password")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Package user holds local accounts that sign in with a password and are
+// remembered by a server-side session carried in a cookie.
+package user
+
+import (
+	"byfood-interview/auth"
+	"byfood-interview/helper"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrInvalidSession     = errors.New("invalid or expired session")
+	ErrInvalidResetToken  = errors.New("invalid or expired password reset token")
+	ErrEmailTaken         = errors.New("an account with this email already exists")
+	ErrAccountLocked      = errors.New("account locked after repeated failed logins, try again later")
+)
+
+// SessionCookie is the name of the cookie carrying the session token.
+const SessionCookie = "session"
+
+// CSRFHeader carries the CSRF token of the session on writes authenticated
+// by the session cookie.
+const CSRFHeader = "X-CSRF-Token"
+
+const (
+	MaxEmailLength    = 255
+	MinPasswordLength = 8
+	// MaxPasswordLength is in bytes, the most bcrypt hashes, so switching
+	// algorithms never locks anyone out.
+	MaxPasswordLength = 72
+)
+
+type User struct {
+	ID           int64      `json:"id" db:"id"`
+	Email        string     `json:"email" db:"email"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	FailedLogins int        `json:"-" db:"failed_logins"`
+	LockedUntil  *time.Time `json:"-" db:"locked_until"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Subject is the principal subject of the user, which roles are assigned
+// to.
+func (u *User) Subject() string {
+	return fmt.Sprintf("user:%d", u.ID)
+}
+
+// Locked reports whether logins are refused at now.
+func (u *User) Locked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+// Session is a signed-in user. Only a hash of its token is stored; Token
+// holds the plaintext when the session is created.
+type Session struct {
+	ID        string    `json:"-" db:"id"`
+	UserID    int64     `json:"-" db:"user_id"`
+	CSRFToken string    `json:"csrf_token" db:"csrf_token"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	User      *User     `json:"user" db:"-"`
+	Token     string    `json:"-" db:"-"`
+}
+
+// Principal returns the principal of requests made in the session.
+func (s *Session) Principal() *auth.Principal {
+	return &auth.Principal{
+		Subject: s.User.Subject(),
+		Claims:  map[string]interface{}{"email": s.User.Email},
+	}
+}
+
+// ValidCSRF reports whether token is the CSRF token of the session.
+func (s *Session) ValidCSRF(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+// NewSessionCookie returns the cookie carrying a session token until
+// expires, or clearing it when token is empty. secure keeps browsers from
+// sending it over plain HTTP.
+func NewSessionCookie(token string, expires time.Time, secure bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expires
+	}
+	return cookie
+}
+
+// NewToken returns a random token for a session, CSRF check or password
+// reset.
+func NewToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// HashToken returns the stored form of a token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeEmail returns email in the form accounts are stored under.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Credentials are the body of registration and login requests.
+type Credentials struct {
+	Email    string `json:"email" example:"reader@example.com"`
+	Password string `json:"password" example:"correct horse battery staple"`
+}
+
+// Validate checks the credentials of a new account.
+func (c *Credentials) Validate() error {
+	c.Email = NormalizeEmail(c.Email)
+
+	var v helper.Validator
+	validateEmail(&v, c.Email)
+	validatePassword(&v, c.Password)
+	return v.Err()
+}
+
+// PasswordResetRequest asks for a reset token to be mailed to Email.
+type PasswordResetRequest struct {
+	Email string `json:"email" example:"reader@example.com"`
+}
+
+func (r *PasswordResetRequest) Validate() error {
+	r.Email = NormalizeEmail(r.Email)
+
+	var v helper.Validator
+	validateEmail(&v, r.Email)
+	return v.Err()
+}
+
+// NewPasswordRequest sets a new password with a mailed reset token.
+type NewPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (r *NewPasswordRequest) Validate() error {
+	var v helper.Validator
+	v.Check(r.Token != "", "token", helper.CodeRequired, "token is required")
+	validatePassword(&v, r.Password)
+	return v.Err()
+}
+
+func validateEmail(v *helper.Validator, email string) {
+	if email == "" {
+		v.Add("email", helper.CodeRequired, "email is required")
+		return
+	}
+	address, err := mail.ParseAddress(email)
+	v.Check(err == nil && address.Address == email, "email", helper.CodeInvalid, "email must be a valid address")
+	v.Check(len(email) <= MaxEmailLength, "email", helper.CodeTooLong,
+		fmt.Sprintf("email must be at most %d characters", MaxEmailLength))
+}
+
+func validatePassword(v *helper.Validator, password string) {
+	v.Check(utf8.RuneCountInString(password) >= MinPasswordLength, "password", helper.CodeTooShort,
+		fmt.Sprintf("password must be at least %d characters", MinPasswordLength))
+	v.Check(len(password) <= MaxPasswordLength, "password", helper.CodeTooLong,
+		fmt.Sprintf("password must be at most %d bytes", MaxPasswordLength))
+}
@@ -0,0 +1,41 @@
+package user
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCredentialsValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		creds Credentials
+		ok    bool
+	}{
+		{"valid", Credentials{Email: " Reader@Example.com ", Password: "correct horse"}, true},
+		{"missing email", Credentials{Password: "correct horse"}, false},
+		{"invalid email", Credentials{Email: "Reader <reader@example.com>", Password: "correct horse"}, false},
+		{"short password", Credentials{Email: "reader@example.com", Password: "horse"}, false},
+		{"long password", Credentials{Email: "reader@example.com", Password: strings.Repeat("h", MaxPasswordLength+1)}, false},
+	}
+	for _, tc := range cases {
+		err := tc.creds.Validate()
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+
+	creds := Credentials{Email: " Reader@Example.com ", Password: "correct horse"}
+	if err := creds.Validate(); err != nil || creds.Email != "reader@example.com" {
+		t.Fatalf("expected the email normalized, got %q (%v)", creds.Email, err)
+	}
+}
+
+func TestSessionValidCSRF(t *testing.T) {
+	session := &Session{CSRFToken: "csrf-token"}
+	if !session.ValidCSRF("csrf-token") || session.ValidCSRF("") || session.ValidCSRF("other") {
+		t.Fatal("unexpected CSRF check result")
+	}
+	if (&Session{}).ValidCSRF("") {
+		t.Fatal("an empty token must never match")
+	}
+}